@@ -1,20 +1,32 @@
 package api
 
 import (
+	"errors"
+	"time"
+
 	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
 	"github.com/jenlesamuel/magcoin/transaction"
 	"github.com/jenlesamuel/magcoin/wallet"
 )
 
 type API struct {
 	blockIterator *blockchain.BlockIterator
-	walletManager *wallet.WalletManager
+	wallets       *wallet.Registry
+	mempool       *transaction.MemPool
+}
+
+// MemPoolInfo summarizes the transactions waiting in the mempool
+type MemPoolInfo struct {
+	Count int
+	Size  int // bytes
 }
 
-func NewAPI(bi *blockchain.BlockIterator, wm *wallet.WalletManager) *API {
+func NewAPI(bi *blockchain.BlockIterator, wallets *wallet.Registry, mp *transaction.MemPool) *API {
 	return &API{
 		blockIterator: bi,
-		walletManager: wm,
+		wallets:       wallets,
+		mempool:       mp,
 	}
 }
 
@@ -22,6 +34,451 @@ func (api *API) GetIterator() *blockchain.BlockIterator {
 	return api.blockIterator
 }
 
-func (api *API) CreateTransaction(amount uint64, receiverAddress string) (*transaction.Transaction, error) {
-	return api.walletManager.CreateTransaction(amount, receiverAddress)
+// Creates a payment funded by the coins the named selection strategy picks, paying
+// feeRate maglia per byte. An empty strategy is the default one.
+func (api *API) CreateTransaction(
+	walletName string,
+	amount uint64,
+	receiverAddress string,
+	coinSelection string,
+	feeRate uint64,
+) (*transaction.Transaction, error) {
+	selector, err := wallet.CoinSelectorByName(coinSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.CreateTransaction(amount, receiverAddress, selector, feeRate)
+}
+
+// Returns an unsigned payment from the wallet, to sign elsewhere. The
+// transaction is not added to the mempool.
+func (api *API) CreateUnsignedTransaction(
+	walletName string,
+	amount uint64,
+	receiverAddress string,
+	coinSelection string,
+	feeRate uint64,
+) (*transaction.PartiallySignedTransaction, error) {
+	selector, err := wallet.CoinSelectorByName(coinSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.CreateUnsignedTransaction(amount, receiverAddress, selector, feeRate)
+}
+
+// Returns an unsigned payment from a multisig address, for its signers to pass around
+func (api *API) CreateUnsignedMultiSigTransaction(
+	amount uint64,
+	multiSigAddress string,
+	receiverAddress string,
+	redeemScript []byte,
+) (*transaction.PartiallySignedTransaction, error) {
+	return api.wallets.Default().CreateUnsignedMultiSigTransaction(amount, multiSigAddress, receiverAddress, redeemScript)
+}
+
+// Adds the signatures of the wallet's keys to psbt, returning their number
+func (api *API) SignPartiallySignedTransaction(walletName string, psbt *transaction.PartiallySignedTransaction) (int, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return 0, err
+	}
+
+	return wm.SignPartiallySignedTransaction(psbt)
+}
+
+// Adds the transaction of a fully signed psbt to the mempool
+func (api *API) FinalizePartiallySignedTransaction(psbt *transaction.PartiallySignedTransaction) (*transaction.Transaction, error) {
+	return api.wallets.Default().FinalizePartiallySignedTransaction(psbt)
+}
+
+func (api *API) GetMemPoolInfo() *MemPoolInfo {
+	return &MemPoolInfo{
+		Count: api.mempool.Count(),
+		Size:  api.mempool.Size(),
+	}
+}
+
+// Returns a page of mempool entries and the total number of entries
+func (api *API) ListMemPool(offset, limit int) ([]*transaction.MemPoolEntry, int) {
+	return api.mempool.List(offset, limit)
+}
+
+func (api *API) GetMemPoolFeeHistogram() []*transaction.FeeBucket {
+	return api.mempool.FeeHistogram(transaction.DefaultFeeRateBuckets)
+}
+
+func (api *API) GetMemPoolEntry(trxHashHex string) (*transaction.MemPoolEntry, error) {
+	entry := api.mempool.GetEntry(trxHashHex)
+	if entry == nil {
+		return nil, errors.New("transaction not found in mempool")
+	}
+
+	return entry, nil
+}
+
+// Returns the mempool transaction spending the given outpoint, if any
+func (api *API) GetMemPoolSpender(outpointHash []byte, outpointIndex int) *transaction.MemPoolEntry {
+	return api.mempool.GetTransactionSpending(outpointHash, outpointIndex)
+}
+
+// Returns the mempool transactions paying to address
+func (api *API) GetMemPoolByAddress(address string) ([]*transaction.MemPoolEntry, error) {
+	lockingScript, err := script.PayToAddress(address)
+	if err != nil {
+		return nil, errors.New(wallet.ErrInvalidAddress)
+	}
+
+	return api.mempool.GetTransactionsPaying(lockingScript), nil
+}
+
+// Returns the public key of the wallet, to share with the other signers of a multisig address
+func (api *API) GetPublicKey(walletName string) ([]byte, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.GetPublicKey()
+}
+
+func (api *API) CreateMultiSigAddress(m int, publicKeys [][]byte, scriptHash bool) (string, error) {
+	return api.wallets.Default().CreateMultiSigAddress(m, publicKeys, scriptHash)
+}
+
+func (api *API) CreateScriptHashAddress(redeemScript []byte) (string, error) {
+	return api.wallets.Default().CreateScriptHashAddress(redeemScript)
+}
+
+// Returns an unsigned transaction paying amount from a multisig address to receiverAddress.
+// redeemScript is only needed for pay to script hash multisig addresses.
+func (api *API) CreateMultiSigTransaction(
+	walletName string,
+	amount uint64,
+	multiSigAddress string,
+	receiverAddress string,
+	redeemScript []byte,
+) (*transaction.Transaction, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.CreateMultiSigTransaction(amount, multiSigAddress, receiverAddress, redeemScript)
+}
+
+// Adds the wallet's signature to a multisig transaction. Returns true once the
+// transaction is fully signed and in the mempool.
+func (api *API) SignMultiSigTransaction(walletName string, trx *transaction.Transaction) (bool, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return false, err
+	}
+
+	return wm.SignMultiSigTransaction(trx)
+}
+
+// Returns a random secret preimage and its hash lock, to start an atomic swap
+func (api *API) NewHTLCSecret() ([]byte, []byte, error) {
+	return wallet.NewHTLCSecret()
+}
+
+// Returns the transaction funding a hash time locked contract and the contract's redeem script
+func (api *API) CreateHTLC(walletName string, amount uint64, receiverAddress string, hashLock []byte, lockTime int64) (*transaction.Transaction, []byte, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return wm.CreateHTLC(amount, receiverAddress, hashLock, lockTime)
+}
+
+// Returns the pay to script hash address of a contract's redeem script
+func (api *API) GetHTLCAddress(redeemScript []byte) (string, error) {
+	return api.wallets.Default().CreateScriptHashAddress(redeemScript)
+}
+
+func (api *API) ClaimHTLC(walletName string, redeemScript, preimage []byte, fee uint64) (*transaction.Transaction, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.ClaimHTLC(redeemScript, preimage, fee)
+}
+
+func (api *API) RefundHTLC(walletName string, redeemScript []byte, fee uint64) (*transaction.Transaction, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.RefundHTLC(redeemScript, fee)
+}
+
+// Returns the preimage revealed by a transaction claiming the contract of redeemScript
+func (api *API) FindHTLCPreimage(redeemScript []byte) ([]byte, error) {
+	return api.wallets.Default().FindHTLCPreimage(redeemScript)
+}
+
+// Records data, such as a document hash, in a transaction paid by the wallet
+func (api *API) CreateDataTransaction(walletName string, data []byte, feeRate uint64) (*transaction.Transaction, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.CreateDataTransaction(data, feeRate)
+}
+
+// Returns the block that first recorded data and the merkle proof of the recording transaction
+func (api *API) FindNotarization(data []byte) (*wallet.Notarization, error) {
+	return api.wallets.Default().FindNotarization(data)
+}
+
+// Returns the mempool transaction recording data, or nil
+func (api *API) FindPendingDataTransaction(data []byte) *transaction.Transaction {
+	return api.wallets.Default().FindPendingDataTransaction(data)
+}
+
+// Returns a wallet address that has not been handed out before
+func (api *API) NewReceiveAddress(walletName string) (string, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return "", err
+	}
+
+	return wm.NewReceiveAddress()
+}
+
+// Returns the extended public key deriving the wallet's addresses
+func (api *API) GetAccountPublicKey(walletName string) (string, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return "", err
+	}
+
+	return wm.GetAccountPublicKey()
+}
+
+// Recovers the wallet's used addresses from the chain, returning their number and the wallet balance
+func (api *API) Rescan(walletName string) (int, int64, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return wm.Rescan()
+}
+
+// Returns the balance of the wallet, counting outputs with at least
+// minConfirmations as confirmed
+func (api *API) GetBalances(walletName string, minConfirmations int) (*wallet.Balance, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.GetBalances(minConfirmations)
+}
+
+// Returns the balance of any address, counting outputs with at least
+// minConfirmations as confirmed
+func (api *API) GetAddressBalance(address string, minConfirmations int) (*wallet.Balance, error) {
+	return api.wallets.Default().GetAddressBalance(address, minConfirmations)
+}
+
+// Returns the payments the wallet received, pending ones first, then newest first
+func (api *API) ListIncomingPayments(walletName string) ([]*wallet.IncomingPayment, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.ListIncomingPayments()
+}
+
+// Returns a page of the wallet's transactions selected by filter, unconfirmed
+// ones first, then newest first, with the number of selected transactions
+func (api *API) ListHistory(walletName string, filter *wallet.HistoryFilter, offset, limit int) ([]*wallet.HistoryEntry, int, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return wm.ListHistory(filter, offset, limit)
+}
+
+// Returns the wallet's transaction whose hex hash is id
+func (api *API) GetHistoryEntry(walletName, id string) (*wallet.HistoryEntry, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.GetHistoryEntry(id)
+}
+
+// Labels the wallet's transaction whose hex hash is id
+func (api *API) SetTransactionLabel(walletName, id, label string) error {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return err
+	}
+
+	return wm.SetTransactionLabel(id, label)
+}
+
+// Creates the wallet seed from a new mnemonic of words words, which is returned
+func (api *API) CreateWallet(walletName string, words int, passphrase string) (string, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return "", err
+	}
+
+	return wm.CreateWallet(words, passphrase)
+}
+
+// Rebuilds the wallet from its mnemonic, returning the used addresses found and the wallet balance
+func (api *API) RestoreWallet(walletName string, mnemonic, passphrase string) (int, int64, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return wm.RestoreWallet(mnemonic, passphrase)
+}
+
+// Reports whether the wallet keys are locked
+func (api *API) IsWalletLocked(walletName string) (bool, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return false, err
+	}
+
+	return wm.IsLocked(), nil
+}
+
+// Unlocks the wallet keys, until timeout elapses if positive
+func (api *API) UnlockWallet(walletName string, passphrase string, timeout time.Duration) error {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return err
+	}
+
+	return wm.Unlock(passphrase, timeout)
+}
+
+// Unlocks the wallet keys with its key agent, if one is running, and reports
+// whether it did
+func (api *API) UnlockWalletFromAgent(walletName string) (bool, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return false, err
+	}
+
+	return wm.UnlockFromAgent()
+}
+
+// Starts executable as the key agent keeping the unlocked wallet keys in memory
+// for the commands to come
+func (api *API) StartWalletKeyAgent(walletName string, executable string) error {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return err
+	}
+
+	return wm.StartKeyAgent(executable)
+}
+
+func (api *API) LockWallet(walletName string) error {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return err
+	}
+
+	return wm.Lock()
+}
+
+// Returns the time the wallet locks again, or the zero time
+func (api *API) GetWalletUnlockedUntil(walletName string) (time.Time, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return wm.UnlockedUntil(), nil
+}
+
+func (api *API) ChangeWalletPassphrase(walletName string, oldPassphrase, newPassphrase string) error {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return err
+	}
+
+	return wm.ChangePassphrase(oldPassphrase, newPassphrase)
+}
+
+// Creates a wallet called walletName whose keys passphrase encrypts, and loads it
+func (api *API) NewWallet(walletName, passphrase string) error {
+	_, err := api.wallets.Create(walletName, passphrase)
+	return err
+}
+
+// Creates a watch-only wallet called walletName from an extended public key,
+// addresses or both, and scans the chain for its funds. Returns the used
+// addresses found and the wallet balance.
+func (api *API) NewWatchOnlyWallet(walletName, accountPublicKey string, addresses []string) (int, int64, error) {
+	wm, err := api.wallets.CreateWatchOnly(walletName, accountPublicKey, addresses)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return wm.Rescan()
+}
+
+// Reports whether the wallet only watches addresses and cannot sign
+func (api *API) IsWatchOnlyWallet(walletName string) (bool, error) {
+	wm, err := api.wallets.Get(walletName)
+	if err != nil {
+		return false, err
+	}
+
+	return wm.IsWatchOnly(), nil
+}
+
+// Reports whether a wallet called walletName exists
+func (api *API) WalletExists(walletName string) bool {
+	return api.wallets.Exists(walletName)
+}
+
+// Loads a wallet, which is then loaded on every start until it is unloaded
+func (api *API) LoadWallet(walletName string) error {
+	_, err := api.wallets.Load(walletName)
+	return err
+}
+
+func (api *API) UnloadWallet(walletName string) error {
+	return api.wallets.Unload(walletName)
+}
+
+// Returns the names of the wallets on disk and of the loaded wallets
+func (api *API) ListWallets() ([]string, []string, error) {
+	names, err := api.wallets.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return names, api.wallets.Loaded(), nil
 }
@@ -2,9 +2,11 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"math/big"
+	"sort"
 	"time"
 
 	"github.com/jenlesamuel/magcoin/share"
@@ -13,7 +15,13 @@ import (
 
 const MaxBlockSize = 5
 
-var ErrMaxBlockSizeExceeded = errors.New("maximum block size exceeded")
+// Version of the binary encoding written by Encode. Decoders reject other versions.
+const EncodingVersion byte = 1
+
+var (
+	ErrMaxBlockSizeExceeded  = errors.New("maximum block size exceeded")
+	ErrCoinbaseExceedsReward = errors.New("coinbase pays more than the block subsidy and fees")
+)
 
 type Block struct {
 	Version      []byte //4 bytes
@@ -25,15 +33,67 @@ type Block struct {
 	Transactions []*transaction.Transaction
 }
 
+// Decodes a block encoded with Encode. Trailing bytes are rejected.
 func DecodeToBlock(data []byte) (*Block, error) {
 	r := bytes.NewReader(data)
 
-	decoder := gob.NewDecoder(r)
+	version, err := share.ReadFixedBytes(r, 1, "encoding version")
+	if err != nil {
+		return nil, err
+	}
+
+	if version[0] != EncodingVersion {
+		return nil, fmt.Errorf("unsupported block encoding version %d", version[0])
+	}
+
 	block := new(Block)
-	if err := decoder.Decode(block); err != nil {
+
+	if block.Version, err = share.ReadFixedBytes(r, 4, "version"); err != nil {
+		return nil, err
+	}
+
+	if block.PreviousHash, err = share.ReadFixedBytes(r, 32, "previous hash"); err != nil {
+		return nil, err
+	}
+
+	if block.MerkleRoot, err = share.ReadFixedBytes(r, 32, "merkle root"); err != nil {
 		return nil, err
 	}
 
+	if block.Nonce, err = share.ReadFixedBytes(r, 4, "nonce"); err != nil {
+		return nil, err
+	}
+
+	if block.Target, err = share.ReadVarBytes(r, 32, "target"); err != nil {
+		return nil, err
+	}
+
+	if block.Timestamp, err = share.ReadFixedBytes(r, 8, "timestamp"); err != nil {
+		return nil, err
+	}
+
+	trxCount, err := share.ReadVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read transaction count: %w", err)
+	}
+
+	if trxCount > MaxBlockSize+1 {
+		return nil, fmt.Errorf("transaction count %d exceeds maximum block size", trxCount)
+	}
+
+	block.Transactions = make([]*transaction.Transaction, 0, trxCount)
+	for i := uint64(0); i < trxCount; i++ {
+		trx, err := transaction.DecodeTransactionFrom(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode transaction %d: %w", i, err)
+		}
+		block.Transactions = append(block.Transactions, trx)
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after block", r.Len())
+	}
+
 	return block, nil
 }
 
@@ -54,12 +114,57 @@ func (block *Block) HeaderHash() []byte {
 	return block.HeaderHashWithNonce(block.Nonce)
 }
 
+// Returns the canonical binary encoding of the block:
+//
+//	encoding version (1 byte)
+//	version (4 bytes)
+//	previous hash (32 bytes)
+//	merkle root (32 bytes)
+//	nonce (4 bytes)
+//	target (var bytes)
+//	timestamp (8 bytes)
+//	transaction count (var int) followed by each encoded transaction
 func (block *Block) Encode() ([]byte, error) {
 	buff := new(bytes.Buffer)
+	empty := make([]byte, 0)
+
+	if _, err := buff.Write([]byte{EncodingVersion}); err != nil {
+		return empty, err
+	}
+
+	fields := []struct {
+		value  []byte
+		length int
+		name   string
+	}{
+		{block.Version, 4, "version"},
+		{block.PreviousHash, 32, "previous hash"},
+		{block.MerkleRoot, 32, "merkle root"},
+		{block.Nonce, 4, "nonce"},
+	}
+
+	for _, field := range fields {
+		if err := share.WriteFixedBytes(buff, field.value, field.length, field.name); err != nil {
+			return empty, err
+		}
+	}
 
-	encoder := gob.NewEncoder(buff)
-	if err := encoder.Encode(block); err != nil {
-		return make([]byte, 0), err
+	if err := share.WriteVarBytes(buff, block.Target); err != nil {
+		return empty, err
+	}
+
+	if err := share.WriteFixedBytes(buff, block.Timestamp, 8, "timestamp"); err != nil {
+		return empty, err
+	}
+
+	if err := share.WriteVarInt(buff, uint64(len(block.Transactions))); err != nil {
+		return empty, err
+	}
+
+	for idx, trx := range block.Transactions {
+		if err := trx.EncodeTo(buff); err != nil {
+			return empty, fmt.Errorf("could not encode transaction %d: %w", idx, err)
+		}
 	}
 
 	return buff.Bytes(), nil
@@ -75,17 +180,90 @@ func (block *Block) AddTransaction(trx *transaction.Transaction) error {
 	return nil
 }
 
+// Commits the header to the block's transactions and searches a nonce meeting
+// the proof of work target
 func (block *Block) Mine() bool {
+	block.MerkleRoot = block.CalculateMerkleRoot()
+
 	pow := NewProofOfWork(block)
 	return pow.Run()
 }
 
-func (block *Block) Validate() error {
+// Checks the block against the consensus rules. view holds the unspent outputs
+// of the chain the block extends.
+func (block *Block) Validate(view transaction.UTXOView) error {
 	//TODO: validate other consensus rukes for block
 	if !block.validatePOW() {
 		return errors.New("proof of work validation failed")
 	}
 
+	if !bytes.Equal(block.MerkleRoot, block.CalculateMerkleRoot()) {
+		return ErrMerkleRootMismatch
+	}
+
+	if err := block.validateTransactions(view); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Checks the lock times, amounts and scripts of every transaction and that the
+// coinbase pays at most the block subsidy and the fees. Transactions may spend
+// outputs of earlier transactions of the block. The Schnorr signatures of all
+// transactions are checked together in a single batch.
+func (block *Block) validateTransactions(view transaction.UTXOView) error {
+	batch := share.NewSchnorrBatch()
+	blockView := newBlockView(view)
+	height := view.Height() + 1
+
+	blockTime, err := share.BytesToInt64(block.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	fees, coinbaseTotal := uint64(0), uint64(0)
+	for idx, trx := range block.Transactions {
+		if err := trx.CheckLocks(blockView, height, blockTime); err != nil {
+			return fmt.Errorf("transaction %d: %w", idx, err)
+		}
+
+		if trx.IsCoinbase() {
+			total, err := trx.OutputTotal()
+			if err != nil {
+				return fmt.Errorf("transaction %d: %w", idx, err)
+			}
+			coinbaseTotal += total
+		} else {
+			prevOutputs, err := trx.ResolveInputs(blockView)
+			if err != nil {
+				return fmt.Errorf("transaction %d: %w", idx, err)
+			}
+
+			fee, err := trx.Fee(prevOutputs)
+			if err != nil {
+				return fmt.Errorf("transaction %d: %w", idx, err)
+			}
+			fees += fee
+
+			if err := trx.VerifyScripts(prevOutputs, 0, batch); err != nil {
+				return fmt.Errorf("transaction %d: %w", idx, err)
+			}
+		}
+
+		if err := blockView.connect(trx); err != nil {
+			return fmt.Errorf("transaction %d: %w", idx, err)
+		}
+	}
+
+	if reward := uint64(transaction.BlockSubsidy) + fees; coinbaseTotal > reward {
+		return fmt.Errorf("%w: %d > %d maglia", ErrCoinbaseExceedsReward, coinbaseTotal, reward)
+	}
+
+	if err := batch.Verify(); err != nil {
+		return fmt.Errorf("%w: %s", transaction.ErrInvalidSignature, err)
+	}
+
 	return nil
 }
 
@@ -104,11 +282,19 @@ func (block *Block) IsGenesis() bool {
 
 type BlockManager struct {
 	transactionManager *transaction.TransactionManager
+	policy             *transaction.Policy
 }
 
-func NewBlockManager(tm *transaction.TransactionManager) *BlockManager {
+// Returns a BlockManager that only mines transactions that are standard under policy.
+// A nil policy means the default policy.
+func NewBlockManager(tm *transaction.TransactionManager, policy *transaction.Policy) *BlockManager {
+	if policy == nil {
+		policy = transaction.DefaultPolicy()
+	}
+
 	return &BlockManager{
 		transactionManager: tm,
+		policy:             policy,
 	}
 }
 
@@ -118,6 +304,10 @@ func (bm *BlockManager) CreateBlock(previousHash []byte, coinbaseData string) (*
 		return nil, err
 	}
 
+	if err = bm.policy.CheckTransaction(coinbase); err != nil {
+		return nil, err
+	}
+
 	timestamp := time.Now().Unix()
 
 	return &Block{
@@ -129,6 +319,71 @@ func (bm *BlockManager) CreateBlock(previousHash []byte, coinbaseData string) (*
 	}, nil
 }
 
+// Adds the standard mempool transactions to block, highest fee rate first, until
+// the block is full. A transaction is only added after the mempool transactions
+// it spends from.
+func (bm *BlockManager) AddMemPoolTransactions(block *Block, mempool *transaction.MemPool) error {
+	entries, _ := mempool.List(0, 0)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].FeeRate() > entries[j].FeeRate()
+	})
+
+	included := make(map[string]struct{})
+	skipped := make(map[string]struct{})
+	for progress := true; progress; {
+		progress = false
+
+		for _, entry := range entries {
+			if _, done := included[entry.ID]; done {
+				continue
+			}
+			if _, done := skipped[entry.ID]; done {
+				continue
+			}
+
+			if !bm.parentsIncluded(entry.Transaction, mempool, included) {
+				continue
+			}
+
+			if err := bm.policy.CheckTransaction(entry.Transaction); err != nil {
+				skipped[entry.ID] = struct{}{}
+				continue
+			}
+
+			if err := block.AddTransaction(entry.Transaction); err != nil {
+				if err == ErrMaxBlockSizeExceeded {
+					return nil
+				}
+				return err
+			}
+
+			included[entry.ID] = struct{}{}
+			progress = true
+		}
+	}
+
+	return nil
+}
+
+func (bm *BlockManager) parentsIncluded(
+	trx *transaction.Transaction,
+	mempool *transaction.MemPool,
+	included map[string]struct{},
+) bool {
+	for _, input := range trx.Input {
+		parentID := hex.EncodeToString(input.OutpointHash)
+		if mempool.GetEntry(parentID) == nil {
+			continue // parent is confirmed
+		}
+
+		if _, done := included[parentID]; !done {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Creates the first block in the blockchain
 func (bm *BlockManager) GenesisBlock() (*Block, error) {
 	coinbase, err := bm.transactionManager.CreateCoinbaseTransaction("MagCoin: Bitcoin Parody 0x1F923")
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/transaction"
+	"github.com/stretchr/testify/assert"
+)
+
+// Adds trxs to mempool on top of the chain
+func addToMemPool(t *testing.T, chain *testChain, mempool *transaction.MemPool, trxs ...*transaction.Transaction) {
+	utxos, err := BuildUTXOSet(chain.blockchain.Iterator())
+	assert.NoError(t, err)
+
+	for _, trx := range trxs {
+		assert.NoError(t, mempool.AddTransaction(hex.EncodeToString(trx.ID), trx, utxos))
+	}
+}
+
+// Returns the ids of the transactions of block after the coinbase
+func minedIDs(block *Block) [][]byte {
+	ids := make([][]byte, 0, len(block.Transactions)-1)
+	for _, trx := range block.Transactions[1:] {
+		ids = append(ids, trx.ID)
+	}
+
+	return ids
+}
+
+func TestAddMemPoolTransactions(t *testing.T) {
+	t.Run("should add the highest fee rates first until the block is full", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		rewards := []*transaction.Transaction{genesis.Transactions[0]}
+		for i := 0; i < MaxBlockSize+1; i++ {
+			rewards = append(rewards, chain.mine(t).Transactions[0])
+		}
+
+		// One spend per reward, the later rewards paying more fees
+		spends := make([]*transaction.Transaction, 0, len(rewards))
+		for idx, reward := range rewards {
+			spends = append(spends, chain.spend(t, reward, int64(1_000*(idx+1))))
+		}
+
+		mempool := transaction.NewMemPool(nil)
+		addToMemPool(t, chain, mempool, spends...)
+
+		block, err := chain.blockManager.CreateBlock(chain.blockchain.LastBlockHeaderHash, "assembled block")
+		assert.NoError(t, err)
+		assert.NoError(t, chain.blockManager.AddMemPoolTransactions(block, mempool))
+
+		// The spends paying the lowest fee rates are left out
+		assert.Equal(t, [][]byte{spends[6].ID, spends[5].ID, spends[4].ID, spends[3].ID, spends[2].ID}, minedIDs(block))
+		assert.Len(t, block.Transactions, MaxBlockSize+1)
+	})
+
+	t.Run("should add a transaction after the mempool transaction it spends", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		parent := chain.spend(t, genesis.Transactions[0], 1_000)
+		child := chain.spend(t, parent, 100_000)
+
+		mempool := transaction.NewMemPool(nil)
+		addToMemPool(t, chain, mempool, parent)
+		addToMemPool(t, chain, mempool, child)
+
+		block, err := chain.blockManager.CreateBlock(chain.blockchain.LastBlockHeaderHash, "assembled block")
+		assert.NoError(t, err)
+		assert.NoError(t, chain.blockManager.AddMemPoolTransactions(block, mempool))
+
+		assert.Equal(t, [][]byte{parent.ID, child.ID}, minedIDs(block))
+
+		// The block is valid on top of the chain
+		utxos, err := BuildUTXOSet(chain.blockchain.Iterator())
+		assert.NoError(t, err)
+		assert.True(t, block.Mine())
+		assert.NoError(t, block.Validate(utxos))
+	})
+
+	t.Run("should skip transactions the policy of the block manager rejects and their children", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+		first := chain.mine(t)
+
+		// dust leaves 1,000 maglia, which the mempool relays but the block
+		// manager's policy deems dust
+		reward := int64(5_000_000_000)
+		dust := chain.spend(t, genesis.Transactions[0], reward-1_000)
+		dustChild := chain.spend(t, dust, 0)
+		standard := chain.spend(t, first.Transactions[0], 1_000)
+
+		mempool := transaction.NewMemPool(nil)
+		addToMemPool(t, chain, mempool, dust, standard)
+		addToMemPool(t, chain, mempool, dustChild)
+
+		policy := transaction.DefaultPolicy()
+		policy.DustThreshold = 2_000
+		blockManager := NewBlockManager(chain.blockManager.transactionManager, policy)
+
+		block, err := blockManager.CreateBlock(chain.blockchain.LastBlockHeaderHash, "assembled block")
+		assert.NoError(t, err)
+		assert.NoError(t, blockManager.AddMemPoolTransactions(block, mempool))
+
+		assert.Equal(t, [][]byte{standard.ID}, minedIDs(block))
+	})
+}
@@ -1,15 +1,26 @@
 package blockchain
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
 	"fmt"
 
 	"github.com/dgraph-io/badger"
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/transaction"
 )
 
 const (
 	LastBlockHeaderHash = "last_block_header_hash"
 )
 
+var ErrDataNotFound = errors.New("no transaction on the chain carries the data")
+
+// Blocks stored by versions before the binary block encoding commit to
+// transactions without scripts, so they cannot be re-encoded
+var ErrGobEncodedBlocks = errors.New("the block database holds gob encoded blocks written by an earlier version, which cannot be converted")
+
 type Blockchain struct {
 	DB                  *badger.DB
 	LastBlockHeaderHash []byte
@@ -64,6 +75,10 @@ func LoadBlockchain(db *badger.DB, genesisBlock *Block) (*Blockchain, error) {
 		return nil, fmt.Errorf("could not initialize blockchain from db: %s", err)
 	}
 
+	if err = checkBlockEncoding(db, lastBlockHeaderHash); err != nil {
+		return nil, err
+	}
+
 	blockChain := &Blockchain{
 		DB:                  db,
 		LastBlockHeaderHash: lastBlockHeaderHash,
@@ -72,11 +87,46 @@ func LoadBlockchain(db *badger.DB, genesisBlock *Block) (*Blockchain, error) {
 	return blockChain, nil
 }
 
+// Returns ErrGobEncodedBlocks if the block with headerHash was stored gob
+// encoded. Blocks of a chain are all encoded alike, so checking the tip is enough.
+func checkBlockEncoding(db *badger.DB, headerHash []byte) error {
+	return db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(headerHash)
+		if err != nil {
+			return fmt.Errorf("could not fetch chain tip: %s", err)
+		}
+
+		return item.Value(func(value []byte) error {
+			if _, err := DecodeToBlock(value); err != nil && isGobEncodedBlock(value) {
+				return fmt.Errorf("%w; move the block database away to start a new chain", ErrGobEncodedBlocks)
+			}
+			return nil
+		})
+	})
+}
+
+// Reports whether data holds a block as versions before the binary block
+// encoding stored them
+func isGobEncodedBlock(data []byte) bool {
+	var legacy struct {
+		PreviousHash []byte
+		MerkleRoot   []byte
+		Nonce        []byte
+	}
+
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy) == nil && len(legacy.PreviousHash) == 32
+}
+
 func (bc *Blockchain) AddBlock(block *Block) error {
 
 	var err error
 
-	if err = block.Validate(); err != nil {
+	utxos, err := bc.utxoView()
+	if err != nil {
+		return fmt.Errorf("could not load utxo set for chain tip: %s", err)
+	}
+
+	if err = block.Validate(utxos); err != nil {
 		return err
 	}
 
@@ -95,7 +145,7 @@ func (bc *Blockchain) AddBlock(block *Block) error {
 			return err
 		}
 
-		return nil
+		return connectStoredUTXOs(txn, utxos, block, blockHeaderHash)
 	})
 
 	if err != nil {
@@ -107,6 +157,90 @@ func (bc *Blockchain) AddBlock(block *Block) error {
 	return nil
 }
 
+// Removes the last block from the active chain and returns it. The block data is
+// kept in the db, only the chain tip moves back to the block's parent and the
+// stored unspent outputs are reverted with the block's undo data.
+func (bc *Blockchain) DisconnectTip() (*Block, error) {
+	tip, err := bc.Iterator().Next()
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch chain tip: %s", err)
+	}
+
+	if tip.IsGenesis() {
+		return nil, errors.New("cannot disconnect the genesis block")
+	}
+
+	err = bc.DB.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte(LastBlockHeaderHash), tip.PreviousHash[:]); err != nil {
+			return err
+		}
+
+		return disconnectStoredUTXOs(txn, tip)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not persist last block hash to db: %s", err)
+	}
+
+	bc.LastBlockHeaderHash = tip.PreviousHash
+
+	return tip, nil
+}
+
+// Disconnects the last n blocks and puts their non-coinbase transactions back
+// into the mempool when they are still valid on the new tip. Transactions that
+// now conflict with the chain or the mempool are dropped, returned and reported
+// through the mempool's event subscribers. If a block cannot be disconnected,
+// the blocks disconnected before it are returned with the error, their
+// transactions put back all the same.
+func (bc *Blockchain) DisconnectBlocks(n int, mempool *transaction.MemPool) ([]*Block, []*transaction.Transaction, error) {
+	disconnected := make([]*Block, 0, n)
+
+	var err error
+	for i := 0; i < n; i++ {
+		var block *Block
+		if block, err = bc.DisconnectTip(); err != nil {
+			break
+		}
+
+		disconnected = append(disconnected, block)
+	}
+
+	dropped, readdErr := bc.readdTransactions(disconnected, mempool)
+	if readdErr != nil && err == nil {
+		err = readdErr
+	}
+
+	return disconnected, dropped, err
+}
+
+// Puts the non-coinbase transactions of disconnected, tip first, back into the
+// mempool on top of the current tip and returns the ones the mempool dropped
+func (bc *Blockchain) readdTransactions(disconnected []*Block, mempool *transaction.MemPool) ([]*transaction.Transaction, error) {
+	if len(disconnected) == 0 {
+		return nil, nil
+	}
+
+	utxos, err := BuildUTXOSet(bc.Iterator())
+	if err != nil {
+		return nil, fmt.Errorf("could not build utxo set for new tip: %s", err)
+	}
+
+	// Re-admit from the oldest disconnected block so that parents enter the
+	// mempool before the transactions spending them
+	trxs := make([]*transaction.Transaction, 0)
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, trx := range disconnected[i].Transactions {
+			if trx.IsCoinbase() {
+				continue
+			}
+			trxs = append(trxs, trx)
+		}
+	}
+
+	return mempool.ReaddTransactions(trxs, utxos), nil
+}
+
 func (bc *Blockchain) Iterator() *BlockIterator {
 	return &BlockIterator{
 		DB:          bc.DB,
@@ -153,3 +287,38 @@ func (iterator *BlockIterator) Next() (*Block, error) {
 
 	return next, nil
 }
+
+// Returns the earliest block holding a transaction with a data carrier output
+// carrying data, the transaction and the number of confirmations of the block
+func (bc *Blockchain) FindDataTransaction(data []byte) (*Block, *transaction.Transaction, int, error) {
+	var found *Block
+	var foundTrx *transaction.Transaction
+	foundDepth := 0
+
+	iterator := bc.Iterator()
+	for depth := 1; ; depth++ {
+		block, err := iterator.Next()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		// Blocks are visited from the tip down, so a later match is an earlier block
+		for _, trx := range block.Transactions {
+			for _, output := range trx.Output {
+				if carried := script.ExtractNullData(output.LockingScript); carried != nil && bytes.Equal(carried, data) {
+					found, foundTrx, foundDepth = block, trx, depth
+				}
+			}
+		}
+
+		if block.IsGenesis() {
+			break
+		}
+	}
+
+	if found == nil {
+		return nil, nil, 0, ErrDataNotFound
+	}
+
+	return found, foundTrx, foundDepth, nil
+}
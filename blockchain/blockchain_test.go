@@ -0,0 +1,414 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+	"github.com/stretchr/testify/assert"
+)
+
+// testChain is a chain in a temporary database whose block rewards pay key
+type testChain struct {
+	blockchain   *Blockchain
+	blockManager *BlockManager
+	key          *share.KeyManager
+	mined        int
+}
+
+func newTestChain(t *testing.T) *testChain {
+	dataDir := t.TempDir()
+
+	keystore, err := share.OpenKeystore(dataDir)
+	assert.NoError(t, err)
+	assert.NoError(t, keystore.Create("passphrase"))
+
+	key, err := share.LoadKeyManager(keystore, share.RegtestParams)
+	assert.NoError(t, err)
+
+	db, err := badger.Open(badger.DefaultOptions(filepath.Join(dataDir, "blocks")).WithLogger(nil))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	blockManager := NewBlockManager(transaction.NewTransactionManager(key), nil)
+	genesis, err := blockManager.GenesisBlock()
+	assert.NoError(t, err)
+
+	bc, err := LoadBlockchain(db, genesis)
+	assert.NoError(t, err)
+
+	return &testChain{blockchain: bc, blockManager: blockManager, key: key}
+}
+
+// Returns a mined block holding trxs on top of the chain, without adding it
+func (chain *testChain) newBlock(t *testing.T, trxs ...*transaction.Transaction) *Block {
+	// Coinbases of the same millisecond only differ by their data
+	chain.mined++
+	block, err := chain.blockManager.CreateBlock(chain.blockchain.LastBlockHeaderHash, fmt.Sprintf("test block %d", chain.mined))
+	assert.NoError(t, err)
+
+	for _, trx := range trxs {
+		assert.NoError(t, block.AddTransaction(trx))
+	}
+
+	assert.True(t, block.Mine())
+
+	return block
+}
+
+// Mines a block holding trxs on top of the chain
+func (chain *testChain) mine(t *testing.T, trxs ...*transaction.Transaction) *Block {
+	block := chain.newBlock(t, trxs...)
+	assert.NoError(t, chain.blockchain.AddBlock(block))
+
+	return block
+}
+
+// Returns a transaction signed by the chain's key spending the first output of
+// previous, which pays the key, back to the key less fee
+func (chain *testChain) spend(t *testing.T, previous *transaction.Transaction, fee int64) *transaction.Transaction {
+	amount, err := share.BytesToInt64(previous.Output[0].Amount)
+	assert.NoError(t, err)
+
+	return chain.pay(t, previous, amount-fee)
+}
+
+// Returns a transaction signed by the chain's key spending the first output of
+// previous, which pays the key, to one output per amount locked like it
+func (chain *testChain) pay(t *testing.T, previous *transaction.Transaction, amounts ...int64) *transaction.Transaction {
+	lockingScript := previous.Output[0].LockingScript
+
+	outputs := make([]*transaction.TrxOutput, 0, len(amounts))
+	for _, amount := range amounts {
+		outputs = append(outputs, &transaction.TrxOutput{Amount: share.Int64ToBytes(amount), LockingScript: lockingScript})
+	}
+
+	trx, err := transaction.NewTransaction(
+		[]*transaction.TrxInput{{
+			OutpointHash:  previous.ID,
+			OutpointIndex: share.IntToBytes(0),
+			Sequence:      share.IntToBytes(int(transaction.DefaultSequence)),
+		}},
+		outputs,
+	)
+	assert.NoError(t, err)
+
+	hash, err := trx.SignatureHash()
+	assert.NoError(t, err)
+	signature, err := chain.key.Sign(hash[:])
+	assert.NoError(t, err)
+	publicKey, err := share.GetPublicKeyBytes(chain.key.PublicKey)
+	assert.NoError(t, err)
+
+	trx.Input[0].UnlockingScript, err = script.PayToPubKeyHashUnlock(signature.Bytes(), publicKey)
+	assert.NoError(t, err)
+
+	return trx
+}
+
+// Replaces the coinbase of block with one paying amount
+func setCoinbaseAmount(t *testing.T, block *Block, amount int64) {
+	coinbase := block.Transactions[0]
+	output := &transaction.TrxOutput{Amount: share.Int64ToBytes(amount), LockingScript: coinbase.Output[0].LockingScript}
+
+	trx, err := transaction.NewCoinbaseTransaction(coinbase.Input, []*transaction.TrxOutput{output})
+	assert.NoError(t, err)
+	block.Transactions[0] = trx
+}
+
+// Checks that the unspent outputs stored next to the chain are the ones its
+// blocks leave unspent
+func assertStoredUTXOs(t *testing.T, bc *Blockchain) {
+	expected, err := BuildUTXOSet(bc.Iterator())
+	assert.NoError(t, err)
+
+	stored := make(map[string]*UTXOEntry)
+	assert.NoError(t, bc.DB.View(func(txn *badger.Txn) error {
+		prefix := []byte(utxoKeyPrefix)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			entry, err := decodeUTXOEntryFrom(bytes.NewReader(value))
+			if err != nil {
+				return err
+			}
+			stored[string(it.Item().Key()[len(prefix):])] = entry
+		}
+
+		height, tip, err := readUTXOTip(txn)
+		assert.Equal(t, expected.Height(), height)
+		assert.Equal(t, bc.LastBlockHeaderHash, tip)
+
+		return err
+	}))
+
+	assert.Equal(t, expected.entries, stored)
+}
+
+func TestAddBlock(t *testing.T) {
+	reward := transaction.BlockSubsidy
+
+	t.Run("should accept a coinbase claiming the subsidy and the fees", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		block := chain.newBlock(t)
+		block.Transactions = append(block.Transactions, chain.spend(t, genesis.Transactions[0], 1_000))
+		setCoinbaseAmount(t, block, reward+1_000)
+		assert.True(t, block.Mine())
+
+		assert.NoError(t, chain.blockchain.AddBlock(block))
+	})
+
+	t.Run("should reject a coinbase paying more than the subsidy and the fees", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+		tip := chain.blockchain.LastBlockHeaderHash
+
+		block := chain.newBlock(t)
+		block.Transactions = append(block.Transactions, chain.spend(t, genesis.Transactions[0], 1_000))
+		setCoinbaseAmount(t, block, reward+1_001)
+		assert.True(t, block.Mine())
+
+		assert.ErrorIs(t, chain.blockchain.AddBlock(block), ErrCoinbaseExceedsReward)
+		assert.Equal(t, tip, chain.blockchain.LastBlockHeaderHash)
+	})
+
+	t.Run("should reject transactions paying more than they spend", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		block := chain.newBlock(t, chain.spend(t, genesis.Transactions[0], -1))
+		assert.ErrorIs(t, chain.blockchain.AddBlock(block), transaction.ErrOutputsExceedInputs)
+	})
+
+	t.Run("should keep the stored unspent outputs in step with the chain", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		// The child spends an output created in its own block, which never
+		// reaches the stored set
+		first := chain.mine(t)
+		parent := chain.spend(t, genesis.Transactions[0], 1_000)
+		child := chain.spend(t, parent, 1_000)
+		chain.mine(t, parent, child)
+		assertStoredUTXOs(t, chain.blockchain)
+
+		chain.mine(t, chain.spend(t, first.Transactions[0], 1_000))
+		assertStoredUTXOs(t, chain.blockchain)
+
+		for i := 0; i < 2; i++ {
+			_, err := chain.blockchain.DisconnectTip()
+			assert.NoError(t, err)
+			assertStoredUTXOs(t, chain.blockchain)
+		}
+
+		// The outputs restored on disconnect can be spent again
+		chain.mine(t, chain.spend(t, genesis.Transactions[0], 2_000))
+		assertStoredUTXOs(t, chain.blockchain)
+	})
+
+	t.Run("should rebuild the unspent outputs of a chain stored without them", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+		chain.mine(t)
+
+		assert.NoError(t, chain.blockchain.DB.Update(func(txn *badger.Txn) error {
+			if err := deleteStoredUTXOs(txn); err != nil {
+				return err
+			}
+			return txn.Delete([]byte(utxoTipKey))
+		}))
+
+		chain.mine(t, chain.spend(t, genesis.Transactions[0], 1_000))
+		assertStoredUTXOs(t, chain.blockchain)
+
+		// A tip disconnected without undo data leaves the set to be rebuilt
+		assert.NoError(t, chain.blockchain.DB.Update(func(txn *badger.Txn) error {
+			return txn.Delete(undoKey(chain.blockchain.LastBlockHeaderHash))
+		}))
+		_, err = chain.blockchain.DisconnectTip()
+		assert.NoError(t, err)
+
+		chain.mine(t, chain.spend(t, genesis.Transactions[0], 2_000))
+		assertStoredUTXOs(t, chain.blockchain)
+	})
+
+	t.Run("should reject negative amounts even when the total is in range", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		block := chain.newBlock(t, chain.pay(t, genesis.Transactions[0], 1_000_000_000_000, -1_000_000_000_000+reward-1_000))
+		assert.ErrorIs(t, chain.blockchain.AddBlock(block), transaction.ErrAmountOutOfRange)
+	})
+}
+
+func TestDisconnectBlocks(t *testing.T) {
+	t.Run("should move the tip back but never past the genesis block", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesisHash := chain.blockchain.LastBlockHeaderHash
+
+		block := chain.mine(t)
+
+		tip, err := chain.blockchain.DisconnectTip()
+		assert.NoError(t, err)
+		assert.Equal(t, block.HeaderHash(), tip.HeaderHash())
+		assert.Equal(t, genesisHash, chain.blockchain.LastBlockHeaderHash)
+
+		_, err = chain.blockchain.DisconnectTip()
+		assert.Error(t, err)
+		assert.Equal(t, genesisHash, chain.blockchain.LastBlockHeaderHash)
+	})
+
+	t.Run("should re-admit the transactions still valid and drop the conflicting ones", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		first := chain.mine(t)
+
+		// readmitted spends the genesis reward, which stays on the chain;
+		// orphaned spends the reward of the first block, which leaves it
+		readmitted := chain.spend(t, genesis.Transactions[0], 1_000)
+		orphaned := chain.spend(t, first.Transactions[0], 1_000)
+		chain.mine(t, readmitted, orphaned)
+
+		mempool := transaction.NewMemPool(nil)
+		events := make(map[string]transaction.MemPoolEventType)
+		mempool.Subscribe(func(event *transaction.MemPoolEvent) {
+			events[event.ID] = event.Type
+		})
+
+		// Only two blocks are above the genesis block, so the third fails
+		disconnected, dropped, err := chain.blockchain.DisconnectBlocks(3, mempool)
+		assert.Error(t, err)
+		assert.Len(t, disconnected, 2)
+		assert.Equal(t, []*transaction.Transaction{orphaned}, dropped)
+		assert.Equal(t, genesis.HeaderHash(), chain.blockchain.LastBlockHeaderHash)
+
+		// The blocks disconnected before the error still had their
+		// transactions put back
+		readmittedID, orphanedID := hex.EncodeToString(readmitted.ID), hex.EncodeToString(orphaned.ID)
+		assert.Equal(t, map[string]transaction.MemPoolEventType{
+			readmittedID: transaction.EventTransactionReadded,
+			orphanedID:   transaction.EventTransactionConflicted,
+		}, events)
+		assert.NotNil(t, mempool.GetEntry(readmittedID))
+		assert.Nil(t, mempool.GetEntry(orphanedID))
+		assert.Equal(t, readmittedID, mempool.GetTransactionSpending(genesis.Transactions[0].ID, 0).ID)
+	})
+
+	t.Run("should drop a transaction conflicting with the mempool", func(t *testing.T) {
+		chain := newTestChain(t)
+		genesis, err := chain.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		utxos, err := BuildUTXOSet(chain.blockchain.Iterator())
+		assert.NoError(t, err)
+		mempool := transaction.NewMemPool(nil)
+
+		// The mempool holds a spend of the genesis reward when another spend of
+		// it is mined elsewhere
+		pending := chain.spend(t, genesis.Transactions[0], 2_000)
+		assert.NoError(t, mempool.AddTransaction(hex.EncodeToString(pending.ID), pending, utxos))
+
+		mined := chain.spend(t, genesis.Transactions[0], 1_000)
+		chain.mine(t, mined)
+
+		disconnected, dropped, err := chain.blockchain.DisconnectBlocks(1, mempool)
+		assert.NoError(t, err)
+		assert.Len(t, disconnected, 1)
+		assert.Equal(t, []*transaction.Transaction{mined}, dropped)
+
+		assert.Nil(t, mempool.GetEntry(hex.EncodeToString(mined.ID)))
+		assert.Equal(t, hex.EncodeToString(pending.ID), mempool.GetTransactionSpending(genesis.Transactions[0].ID, 0).ID)
+	})
+}
+
+// Blocks and transactions as versions before the binary block encoding stored
+// them with gob
+type gobBlock struct {
+	Version      []byte
+	PreviousHash []byte
+	MerkleRoot   []byte
+	Nonce        []byte
+	Target       []byte
+	Timestamp    []byte
+	Transactions []*gobTransaction
+}
+
+type gobTransaction struct {
+	ID     []byte
+	Input  []*struct{ OutpointHash, OutpointIndex, SigOrData, PublicKey []byte }
+	Output []*struct{ Amount, PublicKeyHash []byte }
+}
+
+func TestLoadBlockchain(t *testing.T) {
+	t.Run("should load the tip of a stored chain", func(t *testing.T) {
+		chain := newTestChain(t)
+		block := chain.mine(t)
+
+		genesis, err := chain.blockManager.GenesisBlock()
+		assert.NoError(t, err)
+
+		bc, err := LoadBlockchain(chain.blockchain.DB, genesis)
+		assert.NoError(t, err)
+		assert.Equal(t, block.HeaderHash(), bc.LastBlockHeaderHash)
+
+		data, err := block.Encode()
+		assert.NoError(t, err)
+		assert.False(t, isGobEncodedBlock(data))
+	})
+
+	t.Run("should refuse a database of gob encoded blocks", func(t *testing.T) {
+		db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+		assert.NoError(t, err)
+		defer db.Close()
+
+		legacy := &gobBlock{
+			Version:      share.IntToBytes(1),
+			PreviousHash: make([]byte, 32),
+			MerkleRoot:   bytes.Repeat([]byte{0x01}, 32),
+			Nonce:        share.IntToBytes(7),
+			Target:       []byte{0x0F, 0xFF},
+			Timestamp:    share.Int64ToBytes(1_700_000_000),
+			Transactions: []*gobTransaction{{ID: bytes.Repeat([]byte{0x02}, 32)}},
+		}
+		buff := new(bytes.Buffer)
+		assert.NoError(t, gob.NewEncoder(buff).Encode(legacy))
+
+		headerHash := bytes.Repeat([]byte{0x03}, 32)
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			if err := txn.Set(headerHash, buff.Bytes()); err != nil {
+				return err
+			}
+			return txn.Set([]byte(LastBlockHeaderHash), headerHash)
+		}))
+
+		chain := newTestChain(t)
+		genesis, err := chain.blockManager.GenesisBlock()
+		assert.NoError(t, err)
+
+		_, err = LoadBlockchain(db, genesis)
+		assert.ErrorIs(t, err, ErrGobEncodedBlocks)
+	})
+}
@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/jenlesamuel/magcoin/share"
+)
+
+var (
+	ErrMerkleRootMismatch    = errors.New("merkle root does not match the block's transactions")
+	ErrTransactionNotInBlock = errors.New("transaction is not in the block")
+)
+
+// MerkleProof shows that a transaction is part of a block by the hashes needed
+// to rebuild the block's merkle root from the transaction ID
+type MerkleProof struct {
+	TransactionID []byte
+	Index         int      // position of the transaction in the block
+	Siblings      [][]byte // sibling hash at every level, from the leaves up
+}
+
+// Returns the merkle root of hashes: pairs of hashes are double SHA-256 hashed
+// level by level, the last hash of an odd level being paired with itself.
+// The root of no hashes is 32 zero bytes.
+func MerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return make([]byte, 32)
+	}
+
+	level := hashes
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+
+	return level[0]
+}
+
+// Returns the proof that hashes[index] is part of the merkle root of hashes
+func NewMerkleProof(hashes [][]byte, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(hashes) {
+		return nil, fmt.Errorf("merkle leaf index %d out of range", index)
+	}
+
+	proof := &MerkleProof{
+		TransactionID: hashes[index],
+		Index:         index,
+		Siblings:      make([][]byte, 0),
+	}
+
+	level, position := hashes, index
+	for len(level) > 1 {
+		sibling := position ^ 1
+		if sibling == len(level) {
+			sibling = position
+		}
+		proof.Siblings = append(proof.Siblings, level[sibling])
+
+		level, position = nextMerkleLevel(level), position/2
+	}
+
+	return proof, nil
+}
+
+// Reports whether the proof rebuilds root
+func (proof *MerkleProof) Verify(root []byte) bool {
+	hash, position := proof.TransactionID, proof.Index
+	for _, sibling := range proof.Siblings {
+		if position%2 == 0 {
+			hash = hashMerklePair(hash, sibling)
+		} else {
+			hash = hashMerklePair(sibling, hash)
+		}
+		position /= 2
+	}
+
+	return position == 0 && bytes.Equal(hash, root)
+}
+
+func nextMerkleLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		next = append(next, hashMerklePair(level[i], right))
+	}
+
+	return next
+}
+
+func hashMerklePair(left, right []byte) []byte {
+	hash := share.DoubleSha256(bytes.Join([][]byte{left, right}, []byte{}))
+	return hash[:]
+}
+
+// Returns the IDs of the block's transactions, the leaves of its merkle tree
+func (block *Block) transactionIDs() [][]byte {
+	ids := make([][]byte, 0, len(block.Transactions))
+	for _, trx := range block.Transactions {
+		ids = append(ids, trx.ID)
+	}
+
+	return ids
+}
+
+// Returns the merkle root of the block's transaction IDs
+func (block *Block) CalculateMerkleRoot() []byte {
+	return MerkleRoot(block.transactionIDs())
+}
+
+// Returns the proof that the transaction with id is part of the block
+func (block *Block) MerkleProof(id []byte) (*MerkleProof, error) {
+	for idx, trx := range block.Transactions {
+		if bytes.Equal(trx.ID, id) {
+			return NewMerkleProof(block.transactionIDs(), idx)
+		}
+	}
+
+	return nil, ErrTransactionNotInBlock
+}
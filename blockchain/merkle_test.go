@@ -0,0 +1,44 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleProof(t *testing.T) {
+	hashes := make([][]byte, 0)
+	for i := byte(1); i <= 5; i++ {
+		hashes = append(hashes, bytes.Repeat([]byte{i}, 32))
+	}
+
+	t.Run("should prove every leaf of an odd sized tree", func(t *testing.T) {
+		root := MerkleRoot(hashes)
+
+		for idx := range hashes {
+			proof, err := NewMerkleProof(hashes, idx)
+			assert.NoError(t, err)
+			assert.Len(t, proof.Siblings, 3)
+			assert.True(t, proof.Verify(root))
+		}
+	})
+
+	t.Run("should reject a proof for another root or position", func(t *testing.T) {
+		proof, err := NewMerkleProof(hashes, 2)
+		assert.NoError(t, err)
+
+		assert.False(t, proof.Verify(MerkleRoot(hashes[:4])))
+
+		proof.Index = 3
+		assert.False(t, proof.Verify(MerkleRoot(hashes)))
+	})
+
+	t.Run("should use the only hash as root", func(t *testing.T) {
+		assert.Equal(t, hashes[0], MerkleRoot(hashes[:1]))
+
+		proof, err := NewMerkleProof(hashes[:1], 0)
+		assert.NoError(t, err)
+		assert.True(t, proof.Verify(hashes[0]))
+	})
+}
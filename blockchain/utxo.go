@@ -0,0 +1,205 @@
+package blockchain
+
+import (
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+// UTXOEntry is an unspent transaction output together with where it was confirmed
+type UTXOEntry struct {
+	TransactionHash []byte
+	Index           int
+	Output          *transaction.TrxOutput
+	Height          int
+	Time            int64 // timestamp of the confirming block
+	IsCoinbase      bool
+}
+
+// UTXOSet holds every unspent transaction output of a chain, keyed by outpoint
+type UTXOSet struct {
+	entries map[string]*UTXOEntry
+	height  int
+}
+
+// Builds the set of unspent outputs of the chain ending at the iterator's current block
+func BuildUTXOSet(iterator *BlockIterator) (*UTXOSet, error) {
+	blocks := make([]*Block, 0)
+
+	for {
+		block, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block)
+
+		if block.IsGenesis() {
+			break
+		}
+	}
+
+	set := &UTXOSet{
+		entries: make(map[string]*UTXOEntry),
+		height:  len(blocks) - 1,
+	}
+
+	// Blocks are visited from the tip down, so every spend of an output is seen
+	// before the output itself
+	spent := make(map[string]struct{})
+	for i, block := range blocks {
+		height := len(blocks) - 1 - i
+
+		blockTime, err := share.BytesToInt64(block.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, trx := range block.Transactions {
+			if trx.IsCoinbase() {
+				continue
+			}
+
+			for _, input := range trx.Input {
+				outIdx, err := input.OutputIndex()
+				if err != nil {
+					return nil, err
+				}
+				spent[transaction.OutpointKey(input.OutpointHash, outIdx)] = struct{}{}
+			}
+		}
+
+		for _, trx := range block.Transactions {
+			for idx, output := range trx.Output {
+				if script.IsUnspendable(output.LockingScript) {
+					continue
+				}
+
+				key := transaction.OutpointKey(trx.ID, idx)
+				if _, isSpent := spent[key]; isSpent {
+					continue
+				}
+
+				set.entries[key] = &UTXOEntry{
+					TransactionHash: trx.ID,
+					Index:           idx,
+					Output:          output,
+					Height:          height,
+					Time:            blockTime,
+					IsCoinbase:      trx.IsCoinbase(),
+				}
+			}
+		}
+	}
+
+	return set, nil
+}
+
+// Returns the height of the block the set was built up to
+func (set *UTXOSet) Height() int {
+	return set.height
+}
+
+func (set *UTXOSet) GetEntry(hash []byte, index int) *UTXOEntry {
+	return set.entries[transaction.OutpointKey(hash, index)]
+}
+
+// Returns the unspent output at index of the transaction with hash, or nil if
+// it does not exist or has been spent
+func (set *UTXOSet) GetOutput(hash []byte, index int) *transaction.TrxOutput {
+	entry := set.GetEntry(hash, index)
+	if entry == nil {
+		return nil
+	}
+
+	return entry.Output
+}
+
+func (set *UTXOSet) GetOutputConfirmation(hash []byte, index int) (int, int64, bool) {
+	entry := set.GetEntry(hash, index)
+	if entry == nil {
+		return 0, 0, false
+	}
+
+	return entry.Height, entry.Time, true
+}
+
+// Returns every unspent output of the set, in no particular order
+func (set *UTXOSet) Entries() []*UTXOEntry {
+	entries := make([]*UTXOEntry, 0, len(set.entries))
+	for _, entry := range set.entries {
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// blockView is a UTXO view of a chain extended with the transactions of a block
+// connected so far
+type blockView struct {
+	base    transaction.UTXOView
+	outputs map[string]*transaction.TrxOutput
+	spent   map[string]struct{}
+}
+
+func newBlockView(base transaction.UTXOView) *blockView {
+	return &blockView{
+		base:    base,
+		outputs: make(map[string]*transaction.TrxOutput),
+		spent:   make(map[string]struct{}),
+	}
+}
+
+func (view *blockView) GetOutput(hash []byte, index int) *transaction.TrxOutput {
+	key := transaction.OutpointKey(hash, index)
+	if _, isSpent := view.spent[key]; isSpent {
+		return nil
+	}
+
+	if output, exists := view.outputs[key]; exists {
+		return output
+	}
+
+	return view.base.GetOutput(hash, index)
+}
+
+// Outputs of the block's own transactions are not confirmed yet, so only the
+// outputs of the base view have a confirmation
+func (view *blockView) GetOutputConfirmation(hash []byte, index int) (int, int64, bool) {
+	key := transaction.OutpointKey(hash, index)
+	if _, isSpent := view.spent[key]; isSpent {
+		return 0, 0, false
+	}
+
+	if _, exists := view.outputs[key]; exists {
+		return 0, 0, false
+	}
+
+	return view.base.GetOutputConfirmation(hash, index)
+}
+
+func (view *blockView) Height() int {
+	return view.base.Height()
+}
+
+// Marks the outputs spent by trx as spent and adds the outputs of trx to the view
+func (view *blockView) connect(trx *transaction.Transaction) error {
+	if !trx.IsCoinbase() {
+		for _, input := range trx.Input {
+			outIdx, err := input.OutputIndex()
+			if err != nil {
+				return err
+			}
+			view.spent[transaction.OutpointKey(input.OutpointHash, outIdx)] = struct{}{}
+		}
+	}
+
+	for idx, output := range trx.Output {
+		if script.IsUnspendable(output.LockingScript) {
+			continue
+		}
+		view.outputs[transaction.OutpointKey(trx.ID, idx)] = output
+	}
+
+	return nil
+}
@@ -0,0 +1,397 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+// The unspent outputs of the active chain are stored next to the blocks so that
+// adding a block only applies the block's changes instead of rebuilding the set
+// from every block. The set is keyed by outpoint; the undo data of a block holds
+// the entries it spent so that disconnecting it can restore them.
+const (
+	utxoTipKey    = "utxo_tip" // height (4 bytes) and header hash of the block the set is up to date with
+	utxoKeyPrefix = "utxo:"
+	undoKeyPrefix = "undo:"
+)
+
+func utxoKey(outpoint string) []byte {
+	return []byte(utxoKeyPrefix + outpoint)
+}
+
+func undoKey(headerHash []byte) []byte {
+	return append([]byte(undoKeyPrefix), headerHash...)
+}
+
+// storedUTXOView is a UTXO view over the unspent outputs stored in the db
+type storedUTXOView struct {
+	db     *badger.DB
+	height int
+}
+
+// Returns the stored entry of outpoint, or nil if it is not stored
+func (view *storedUTXOView) entry(outpoint string) (*UTXOEntry, error) {
+	var entry *UTXOEntry
+
+	err := view.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(utxoKey(outpoint))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(value []byte) error {
+			entry, err = decodeUTXOEntryFrom(bytes.NewReader(value))
+			return err
+		})
+	})
+
+	return entry, err
+}
+
+// An entry that cannot be read is reported missing, which fails the validation
+// of any transaction spending it
+func (view *storedUTXOView) GetOutput(hash []byte, index int) *transaction.TrxOutput {
+	entry, err := view.entry(transaction.OutpointKey(hash, index))
+	if err != nil || entry == nil {
+		return nil
+	}
+
+	return entry.Output
+}
+
+func (view *storedUTXOView) GetOutputConfirmation(hash []byte, index int) (int, int64, bool) {
+	entry, err := view.entry(transaction.OutpointKey(hash, index))
+	if err != nil || entry == nil {
+		return 0, 0, false
+	}
+
+	return entry.Height, entry.Time, true
+}
+
+func (view *storedUTXOView) Height() int {
+	return view.height
+}
+
+// Returns a view of the stored unspent outputs of the chain ending at the tip,
+// rebuilding them from the blocks when they are missing or were left behind by
+// a block disconnected without undo data
+func (bc *Blockchain) utxoView() (*storedUTXOView, error) {
+	var height int
+	var tip []byte
+
+	err := bc.DB.View(func(txn *badger.Txn) error {
+		var err error
+		height, tip, err = readUTXOTip(txn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(tip, bc.LastBlockHeaderHash) {
+		return &storedUTXOView{db: bc.DB, height: height}, nil
+	}
+
+	set, err := BuildUTXOSet(bc.Iterator())
+	if err != nil {
+		return nil, err
+	}
+
+	err = bc.DB.Update(func(txn *badger.Txn) error {
+		if err := deleteStoredUTXOs(txn); err != nil {
+			return err
+		}
+
+		for _, entry := range set.entries {
+			if err := setUTXOEntry(txn, entry); err != nil {
+				return err
+			}
+		}
+
+		return writeUTXOTip(txn, set.height, bc.LastBlockHeaderHash)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not persist utxo set: %s", err)
+	}
+
+	return &storedUTXOView{db: bc.DB, height: set.height}, nil
+}
+
+// Returns the height and header hash of the block the stored set is up to date
+// with, or a nil hash if no set is stored
+func readUTXOTip(txn *badger.Txn) (int, []byte, error) {
+	item, err := txn.Get([]byte(utxoTipKey))
+	if err == badger.ErrKeyNotFound {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(value) != 4+32 {
+		return 0, nil, fmt.Errorf("invalid utxo tip length %d", len(value))
+	}
+
+	return int(binary.BigEndian.Uint32(value[:4])), value[4:], nil
+}
+
+func writeUTXOTip(txn *badger.Txn, height int, headerHash []byte) error {
+	return txn.Set([]byte(utxoTipKey), append(share.IntToBytes(height), headerHash...))
+}
+
+func deleteStoredUTXOs(txn *badger.Txn) error {
+	prefix := []byte(utxoKeyPrefix)
+	keys := make([][]byte, 0)
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setUTXOEntry(txn *badger.Txn, entry *UTXOEntry) error {
+	buff := new(bytes.Buffer)
+	if err := entry.encodeTo(buff); err != nil {
+		return err
+	}
+
+	return txn.Set(utxoKey(transaction.OutpointKey(entry.TransactionHash, entry.Index)), buff.Bytes())
+}
+
+// Applies the outputs block with headerHash spends and creates to the stored set
+// view is over, and stores the spent entries as the block's undo data
+func connectStoredUTXOs(txn *badger.Txn, view *storedUTXOView, block *Block, headerHash []byte) error {
+	changes := newBlockView(view)
+	for _, trx := range block.Transactions {
+		if err := changes.connect(trx); err != nil {
+			return err
+		}
+	}
+
+	blockTime, err := share.BytesToInt64(block.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	// Outputs created and spent by the block never reach the stored set
+	spent := make([]string, 0, len(changes.spent))
+	for outpoint := range changes.spent {
+		if _, created := changes.outputs[outpoint]; !created {
+			spent = append(spent, outpoint)
+		}
+	}
+	sort.Strings(spent)
+
+	undo := new(bytes.Buffer)
+	if err := share.WriteVarInt(undo, uint64(len(spent))); err != nil {
+		return err
+	}
+
+	for _, outpoint := range spent {
+		entry, err := view.entry(outpoint)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return fmt.Errorf("output %s is missing or already spent", outpoint)
+		}
+
+		if err := entry.encodeTo(undo); err != nil {
+			return err
+		}
+
+		if err := txn.Delete(utxoKey(outpoint)); err != nil {
+			return err
+		}
+	}
+
+	for _, trx := range block.Transactions {
+		for idx, output := range trx.Output {
+			outpoint := transaction.OutpointKey(trx.ID, idx)
+			if _, created := changes.outputs[outpoint]; !created {
+				continue
+			}
+			if _, isSpent := changes.spent[outpoint]; isSpent {
+				continue
+			}
+
+			err := setUTXOEntry(txn, &UTXOEntry{
+				TransactionHash: trx.ID,
+				Index:           idx,
+				Output:          output,
+				Height:          view.height + 1,
+				Time:            blockTime,
+				IsCoinbase:      trx.IsCoinbase(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := txn.Set(undoKey(headerHash), undo.Bytes()); err != nil {
+		return err
+	}
+
+	return writeUTXOTip(txn, view.height+1, headerHash)
+}
+
+// Reverts the changes the tip block made to the stored set using its undo data.
+// A set that is not up to date with the tip, or a tip without undo data, leaves
+// the set behind the chain, to be rebuilt by the next utxoView.
+func disconnectStoredUTXOs(txn *badger.Txn, tip *Block) error {
+	headerHash := tip.HeaderHash()
+
+	height, utxoTip, err := readUTXOTip(txn)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(utxoTip, headerHash) {
+		return nil
+	}
+
+	item, err := txn.Get(undoKey(headerHash))
+	if err == badger.ErrKeyNotFound {
+		return txn.Delete([]byte(utxoTipKey))
+	}
+	if err != nil {
+		return err
+	}
+
+	undo, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+
+	for _, trx := range tip.Transactions {
+		for idx := range trx.Output {
+			if err := txn.Delete(utxoKey(transaction.OutpointKey(trx.ID, idx))); err != nil {
+				return err
+			}
+		}
+	}
+
+	r := bytes.NewReader(undo)
+	count, err := share.ReadVarInt(r)
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		entry, err := decodeUTXOEntryFrom(r)
+		if err != nil {
+			return fmt.Errorf("could not decode undo entry %d: %w", i, err)
+		}
+
+		if err := setUTXOEntry(txn, entry); err != nil {
+			return err
+		}
+	}
+
+	if err := txn.Delete(undoKey(headerHash)); err != nil {
+		return err
+	}
+
+	return writeUTXOTip(txn, height-1, tip.PreviousHash)
+}
+
+// Writes the entry as:
+//
+//	transaction hash (32 bytes)
+//	index (var int)
+//	height (var int)
+//	time (8 bytes)
+//	coinbase flag (1 byte)
+//	output
+func (entry *UTXOEntry) encodeTo(w io.Writer) error {
+	if err := share.WriteFixedBytes(w, entry.TransactionHash, 32, "transaction hash"); err != nil {
+		return err
+	}
+
+	if err := share.WriteVarInt(w, uint64(entry.Index)); err != nil {
+		return err
+	}
+
+	if err := share.WriteVarInt(w, uint64(entry.Height)); err != nil {
+		return err
+	}
+
+	coinbase := byte(0)
+	if entry.IsCoinbase {
+		coinbase = 1
+	}
+
+	if _, err := w.Write(append(share.Int64ToBytes(entry.Time), coinbase)); err != nil {
+		return err
+	}
+
+	return entry.Output.EncodeTo(w)
+}
+
+func decodeUTXOEntryFrom(r io.Reader) (*UTXOEntry, error) {
+	var err error
+	entry := new(UTXOEntry)
+
+	if entry.TransactionHash, err = share.ReadFixedBytes(r, 32, "transaction hash"); err != nil {
+		return nil, err
+	}
+
+	index, err := share.ReadVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read index: %w", err)
+	}
+
+	height, err := share.ReadVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read height: %w", err)
+	}
+	entry.Index, entry.Height = int(index), int(height)
+
+	timeAndFlag, err := share.ReadFixedBytes(r, 9, "time")
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Time, err = share.BytesToInt64(timeAndFlag[:8]); err != nil {
+		return nil, err
+	}
+
+	switch timeAndFlag[8] {
+	case 0:
+	case 1:
+		entry.IsCoinbase = true
+	default:
+		return nil, errors.New("invalid coinbase flag")
+	}
+
+	if entry.Output, err = transaction.DecodeTrxOutputFrom(r); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
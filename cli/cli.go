@@ -1,16 +1,23 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jenlesamuel/magcoin/api"
+	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
 	"github.com/jenlesamuel/magcoin/share"
 	"github.com/jenlesamuel/magcoin/transaction"
+	"github.com/jenlesamuel/magcoin/wallet"
 )
 
 const (
@@ -38,6 +45,64 @@ func (cli *CommandLine) printHelp() {
 
 		publish				print all the blocks in the blockchain
 		create-transaction  creates a standard transaction i.e a non-coinbase transaction
+		mempool				list the transactions waiting in the mempool
+		public-key			print the public key of the wallet
+		multisig-address	create an address whose outputs need M of N signatures to spend
+		multisig-create		create an unsigned transaction spending from a multisig address
+		multisig-sign		add the wallet's signature to a multisig transaction
+		script-address		print the pay to script hash address of a redeem script
+		htlc-create			lock an amount in a contract the receiver claims with a secret before a lock time
+		htlc-claim			claim a contract with its secret
+		htlc-refund			take back the amount of a contract once its lock time is reached
+		htlc-secret			print the secret revealed by the claim of a contract
+		notarize <file>		record the SHA-256 hash of a file on the chain, or show the block that recorded it
+		receive-address		print a new address of the wallet to receive a payment
+		rescan				recover the wallet's addresses used on the chain and print its balance
+		xpub				print the extended public key deriving the wallet's addresses
+		balance				print the confirmed, unconfirmed and immature balance of the wallet
+		incoming			list the payments the wallet received, pending ones first
+		history				list the transactions of the wallet with their confirmations, or label one
+		tx create-unsigned	create an unsigned payment from the wallet or a multisig address
+		tx sign				add the wallet's signatures to a partially signed transaction
+		tx finalize			send a fully signed transaction
+		wallet create		create the wallet seed and print its mnemonic
+		wallet restore		rebuild the wallet from its mnemonic and recover its funds
+		wallet create-watch-only	create a wallet tracking an extended public key or addresses, without private keys
+		wallet load			load a wallet, now and on every start
+		wallet unload		stop loading a wallet
+		wallet list			list the wallets and whether they are loaded
+		wallet unlock		keep the wallet keys unlocked for a while, e.g. -timeout 15m
+		wallet lock			lock the wallet keys again
+		wallet change-passphrase	change the passphrase encrypting the wallet keys
+
+		Wallet keys are encrypted in the data directory, ~/.magcoin or the value of
+		MAGCOIN_DATA_DIR. Commands that sign ask for the passphrase, or read it from
+		MAGCOIN_PASSPHRASE, unless wallet unlock started a key agent: a background
+		process holding the unlocked keys until the timeout elapses or wallet lock.
+		The unlocked keys are never written to disk.
+
+		Wallet commands use the default wallet, which receives block rewards, unless
+		-wallet <name> selects another one. wallet create and wallet restore create
+		the named wallet if it does not exist. They ask for the optional mnemonic
+		passphrase, and wallet restore for the mnemonic, or read them from
+		MAGCOIN_MNEMONIC_PASSPHRASE and MAGCOIN_MNEMONIC.
+
+		A watch-only wallet, created with -xpub <extended public key> and/or
+		-addresses <address,...>, shows balances and payments but cannot sign:
+		tx create-unsigned creates a transaction to sign elsewhere.
+
+		Partially signed transactions carry the outputs they spend and the
+		signatures collected so far, in a file given with -out and -in or as base64
+		text given with -psbt. An offline machine holding the keys, or each signer of
+		a multisig address, adds its signatures with tx sign; once the transaction
+		has enough signatures, tx finalize sends it.
+
+		history lists what each wallet transaction moved in or out of the wallet,
+		with its fee, counterparties and confirmations. Filter it with -direction,
+		-address or -unconfirmed and page through it with -offset and -limit;
+		-id <hash> -set-label <text> labels a transaction. Transactions the wallet
+		sends are recorded even if they leave the mempool without being mined, and
+		shown as conflicted once another transaction spending their inputs is.
 	`)
 }
 
@@ -61,29 +126,986 @@ func (cli *CommandLine) Exec() {
 			log.Panic(err)
 		}
 		log.Printf("Transaction Created: %+v", trx)
+	case "mempool":
+		if err := cli.execMemPool(); err != nil {
+			log.Panic(err)
+		}
+	case "public-key":
+		if err := cli.execPublicKey(); err != nil {
+			log.Panic(err)
+		}
+	case "multisig-address":
+		if err := cli.execMultiSigAddress(); err != nil {
+			log.Panic(err)
+		}
+	case "multisig-create":
+		if err := cli.execMultiSigCreate(); err != nil {
+			log.Panic(err)
+		}
+	case "multisig-sign":
+		if err := cli.execMultiSigSign(); err != nil {
+			log.Panic(err)
+		}
+	case "script-address":
+		if err := cli.execScriptAddress(); err != nil {
+			log.Panic(err)
+		}
+	case "htlc-create":
+		if err := cli.execHTLCCreate(); err != nil {
+			log.Panic(err)
+		}
+	case "htlc-claim":
+		if err := cli.execHTLCClaim(); err != nil {
+			log.Panic(err)
+		}
+	case "htlc-refund":
+		if err := cli.execHTLCRefund(); err != nil {
+			log.Panic(err)
+		}
+	case "htlc-secret":
+		if err := cli.execHTLCSecret(); err != nil {
+			log.Panic(err)
+		}
+	case "notarize":
+		if err := cli.execNotarize(); err != nil {
+			log.Panic(err)
+		}
+	case "receive-address":
+		if err := cli.execReceiveAddress(); err != nil {
+			log.Panic(err)
+		}
+	case "rescan":
+		if err := cli.execRescan(); err != nil {
+			log.Panic(err)
+		}
+	case "xpub":
+		if err := cli.execXPub(); err != nil {
+			log.Panic(err)
+		}
+	case "balance":
+		if err := cli.execBalance(); err != nil {
+			log.Panic(err)
+		}
+	case "incoming":
+		if err := cli.execIncoming(); err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		if err := cli.execHistory(); err != nil {
+			log.Panic(err)
+		}
+	case "tx":
+		if err := cli.execTx(); err != nil {
+			log.Panic(err)
+		}
+	case "wallet":
+		if err := cli.execWallet(); err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printHelp()
 	}
 }
 
+// Defines the -wallet flag selecting the wallet a command uses
+func walletFlag() *string {
+	return flag.String("wallet", wallet.DefaultWalletName, "name of the wallet to use")
+}
+
+func (cli *CommandLine) execPublicKey() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+
+	flag.Parse()
+
+	publicKey, err := cli.api.GetPublicKey(*walletName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Public Key: %x", publicKey)
+	return nil
+}
+
+func (cli *CommandLine) execReceiveAddress() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+
+	flag.Parse()
+
+	address, err := cli.api.NewReceiveAddress(*walletName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Address: %s", address)
+	return nil
+}
+
+func (cli *CommandLine) execRescan() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+
+	flag.Parse()
+
+	found, balance, err := cli.api.Rescan(*walletName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Used Addresses: %d", found)
+	log.Printf("Balance: %d maglia", balance)
+	return nil
+}
+
+func (cli *CommandLine) execXPub() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+
+	flag.Parse()
+
+	xpub, err := cli.api.GetAccountPublicKey(*walletName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Extended Public Key: %s", xpub)
+	return nil
+}
+
+func (cli *CommandLine) execBalance() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+	minConfirmations := flag.Int("min-confirmations", wallet.DefaultMinConfirmations, "confirmations an output needs to count as confirmed")
+	address := flag.String("address", "", "print the balance of address instead of the wallet")
+
+	flag.Parse()
+
+	var balance *wallet.Balance
+	var err error
+	if strings.TrimSpace(*address) != "" {
+		balance, err = cli.api.GetAddressBalance(strings.TrimSpace(*address), *minConfirmations)
+	} else {
+		balance, err = cli.api.GetBalances(*walletName, *minConfirmations)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Confirmed: %d maglia\t(%d confirmations or more)", balance.Confirmed, balance.MinConfirmations)
+	log.Printf("Confirming: %d maglia", balance.Confirming)
+	log.Printf("Immature rewards: %d maglia", balance.Immature)
+	log.Printf("Unconfirmed incoming: %d maglia", balance.UnconfirmedIncoming)
+	log.Printf("Unconfirmed outgoing: %d maglia", balance.UnconfirmedOutgoing)
+	log.Printf("Balance: %d maglia", balance.Total())
+	return nil
+}
+
+func (cli *CommandLine) execIncoming() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+
+	flag.Parse()
+
+	payments, err := cli.api.ListIncomingPayments(*walletName)
+	if err != nil {
+		return err
+	}
+
+	for _, payment := range payments {
+		status := fmt.Sprintf("%d confirmations", payment.Confirmations)
+		if payment.Height < 0 {
+			status = "pending"
+		}
+		if payment.IsCoinbase {
+			status += ", block reward"
+		}
+
+		log.Printf("%x:%d\t%s\t%d maglia\t%s", payment.TransactionHash, payment.Index, payment.Address, payment.Amount, status)
+	}
+
+	return nil
+}
+
+func (cli *CommandLine) execHistory() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+	offset := flag.Int("offset", 0, "number of transactions to skip")
+	limit := flag.Int("limit", 20, "maximum number of transactions to list, 0 lists all")
+	direction := flag.String(
+		"direction",
+		"",
+		fmt.Sprintf("list the transactions in one direction: %s, %s or %s", wallet.DirectionReceived, wallet.DirectionSent, wallet.DirectionSelf),
+	)
+	address := flag.String("address", "", "list the transactions paying to or from address")
+	unconfirmed := flag.Bool("unconfirmed", false, "list the transactions not on the chain yet")
+	id := flag.String("id", "", "hash of a single transaction to print")
+	label := flag.String("set-label", "", "label the transaction given with -id")
+
+	flag.Parse()
+
+	*id = strings.TrimSpace(*id)
+	if *id != "" {
+		if *label != "" {
+			if err := cli.api.SetTransactionLabel(*walletName, *id, *label); err != nil {
+				return err
+			}
+		}
+
+		entry, err := cli.api.GetHistoryEntry(*walletName, *id)
+		if err != nil {
+			return err
+		}
+
+		printHistoryEntry(entry)
+		return nil
+	}
+
+	if *label != "" {
+		return errors.New("give the transaction to label with -id")
+	}
+
+	filter := &wallet.HistoryFilter{
+		Direction:   strings.TrimSpace(*direction),
+		Address:     strings.TrimSpace(*address),
+		Unconfirmed: *unconfirmed,
+	}
+
+	entries, total, err := cli.api.ListHistory(*walletName, filter, *offset, *limit)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		printHistoryEntry(entry)
+	}
+	log.Printf("Showing %d of %d transactions", len(entries), total)
+
+	return nil
+}
+
+func printHistoryEntry(entry *wallet.HistoryEntry) {
+	status := fmt.Sprintf("%d confirmations", entry.Confirmations)
+	switch {
+	case entry.InMemPool:
+		status = "pending"
+	case entry.Conflicted:
+		status = "conflicted, another transaction spent its inputs"
+	case !entry.IsConfirmed():
+		status = "unconfirmed, not in the mempool"
+	}
+	if entry.IsCoinbase {
+		status += ", block reward"
+	}
+
+	line := fmt.Sprintf(
+		"%s\t%s\t%s\t%+d maglia\tFee: %d\t%s",
+		time.Unix(entry.Time, 0).UTC().Format(time.RFC3339),
+		entry.TransactionID,
+		entry.Direction,
+		entry.Amount,
+		entry.Fee,
+		status,
+	)
+	if len(entry.Addresses) > 0 {
+		line += "\t" + strings.Join(entry.Addresses, ",")
+	}
+	if entry.Label != "" {
+		line += fmt.Sprintf("\t%q", entry.Label)
+	}
+
+	log.Println(line)
+}
+
+// paymentFlags are the flags of the commands creating a payment from a wallet
+type paymentFlags struct {
+	walletName      *string
+	receiverAddress *string
+	amount          *uint64
+	coinSelection   *string
+	feeRate         *uint64
+}
+
+func definePaymentFlags() *paymentFlags {
+	return &paymentFlags{
+		walletName:      walletFlag(),
+		receiverAddress: flag.String("receiver-address", "", "address of the receiver"),
+		amount:          flag.Uint64("amount", 0, "amount to be sent to the receiver in maglia (100,000,000 maglia = 1 magcoin)"),
+		coinSelection: flag.String(
+			"coin-selection",
+			"",
+			fmt.Sprintf(
+				"coin selection strategy: %s, %s, %s or %s; by default an exact match without change, largest coins first otherwise",
+				wallet.BranchAndBoundSelection,
+				wallet.LargestFirstSelection,
+				wallet.SmallestFirstSelection,
+				wallet.RandomImproveSelection,
+			),
+		),
+		feeRate: flag.Uint64("fee-rate", wallet.DefaultFeeRate, "fee rate in maglia per byte"),
+	}
+}
+
+// Trims the parsed flags and checks the receiver and the amount
+func (flags *paymentFlags) validate() error {
+	*flags.receiverAddress = strings.TrimSpace(*flags.receiverAddress)
+	*flags.coinSelection = strings.TrimSpace(*flags.coinSelection)
+
+	if *flags.receiverAddress == "" {
+		return errors.New("receiver address cannot be empty")
+	}
+
+	if *flags.amount < 1 || *flags.amount > share.MAX_MAGLIA {
+		return fmt.Errorf("transaction amount should be minimum of 1 maglia and less than %d maglias (21 million magcoins)", share.MAX_MAGLIA)
+	}
+
+	return nil
+}
+
 func (cli *CommandLine) execCreateTransaction() (*transaction.Transaction, error) {
 	os.Args = os.Args[1:]
+	payment := definePaymentFlags()
+
+	flag.Parse()
+
+	if err := cli.unlockWallet(*payment.walletName); err != nil {
+		return nil, err
+	}
+
+	if err := payment.validate(); err != nil {
+		return nil, err
+	}
+
+	return cli.api.CreateTransaction(
+		*payment.walletName,
+		*payment.amount,
+		*payment.receiverAddress,
+		*payment.coinSelection,
+		*payment.feeRate,
+	)
+}
+
+func (cli *CommandLine) execWallet() error {
+	if len(os.Args) < 3 {
+		return errors.New("usage: wallet create|restore|create-watch-only|load|unload|list|unlock|lock|change-passphrase [arguments]")
+	}
+
+	command := os.Args[2]
+	os.Args = os.Args[2:]
+	walletName := walletFlag()
+
+	switch command {
+	case "create":
+		words := flag.Int("words", wallet.ShortMnemonicWords, "number of words of the mnemonic, 12 or 24")
+
+		flag.Parse()
+
+		if err := cli.openWallet(*walletName); err != nil {
+			return err
+		}
+
+		passphrase, err := readMnemonicPassphrase(true)
+		if err != nil {
+			return err
+		}
+
+		mnemonic, err := cli.api.CreateWallet(*walletName, *words, passphrase)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Mnemonic: %s", mnemonic)
+		log.Println("Write the mnemonic down and keep it safe: it restores every key of the wallet, together with the passphrase if one was set")
+	case "restore":
+		flag.Parse()
+
+		if err := cli.openWallet(*walletName); err != nil {
+			return err
+		}
+
+		mnemonic, err := readMnemonic()
+		if err != nil {
+			return err
+		}
+
+		passphrase, err := readMnemonicPassphrase(false)
+		if err != nil {
+			return err
+		}
+
+		found, balance, err := cli.api.RestoreWallet(*walletName, mnemonic, passphrase)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Used Addresses: %d", found)
+		log.Printf("Balance: %d maglia", balance)
+	case "create-watch-only":
+		xpub := flag.String("xpub", "", "extended public key of the account to watch, as printed by xpub")
+		addresses := flag.String("addresses", "", "comma separated addresses to watch")
+
+		flag.Parse()
+
+		watched := make([]string, 0)
+		for _, address := range strings.Split(*addresses, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				watched = append(watched, address)
+			}
+		}
+
+		found, balance, err := cli.api.NewWatchOnlyWallet(*walletName, strings.TrimSpace(*xpub), watched)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Watch-only wallet %s created", *walletName)
+		log.Printf("Used Addresses: %d", found)
+		log.Printf("Balance: %d maglia", balance)
+	case "load":
+		flag.Parse()
+
+		if err := cli.api.LoadWallet(*walletName); err != nil {
+			return err
+		}
+
+		log.Printf("Wallet %s loaded", *walletName)
+	case "unload":
+		flag.Parse()
+
+		if err := cli.api.UnloadWallet(*walletName); err != nil {
+			return err
+		}
+
+		log.Printf("Wallet %s unloaded", *walletName)
+	case "list":
+		flag.Parse()
+
+		names, loaded, err := cli.api.ListWallets()
+		if err != nil {
+			return err
+		}
+
+		isLoaded := make(map[string]bool)
+		for _, name := range loaded {
+			isLoaded[name] = true
+		}
+
+		for _, name := range names {
+			state := "unloaded"
+			if isLoaded[name] {
+				state = "loaded"
+			}
+			log.Printf("%s\t%s", name, state)
+		}
+	case "unlock":
+		timeout := flag.Duration("timeout", 15*time.Minute, "time the wallet stays unlocked, e.g. 30s, 15m or 1h")
+
+		flag.Parse()
+
+		if *timeout <= 0 {
+			return errors.New("timeout must be positive")
+		}
+
+		passphrase := os.Getenv(PassphraseEnv)
+		if passphrase == "" {
+			var err error
+			if passphrase, err = ReadPassphrase(fmt.Sprintf("Passphrase of wallet %s: ", *walletName)); err != nil {
+				return err
+			}
+		}
+
+		if err := cli.api.UnlockWallet(*walletName, passphrase, *timeout); err != nil {
+			return err
+		}
+
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		if err = cli.api.StartWalletKeyAgent(*walletName, executable); err != nil {
+			return err
+		}
+
+		until, err := cli.api.GetWalletUnlockedUntil(*walletName)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Wallet %s unlocked until %s", *walletName, until.Format(time.RFC1123))
+	case "lock":
+		flag.Parse()
+
+		if err := cli.api.LockWallet(*walletName); err != nil {
+			return err
+		}
+
+		log.Printf("Wallet %s locked", *walletName)
+	case "change-passphrase":
+		flag.Parse()
+
+		oldPassphrase, err := ReadPassphrase("Current passphrase: ")
+		if err != nil {
+			return err
+		}
+
+		newPassphrase, err := ReadNewPassphrase("New passphrase")
+		if err != nil {
+			return err
+		}
+
+		if err = cli.api.ChangeWalletPassphrase(*walletName, oldPassphrase, newPassphrase); err != nil {
+			return err
+		}
+
+		log.Printf("Passphrase of wallet %s changed, the wallet is locked", *walletName)
+	default:
+		return fmt.Errorf("unknown wallet command %q, use create, restore, create-watch-only, load, unload, list, unlock, lock or change-passphrase", command)
+	}
+
+	return nil
+}
+
+// Creates the wallet called walletName if it does not exist, asking for the
+// passphrase encrypting its keys, or unlocks it
+func (cli *CommandLine) openWallet(walletName string) error {
+	if cli.api.WalletExists(walletName) {
+		return cli.unlockWallet(walletName)
+	}
+
+	passphrase, err := ReadNewPassphrase(fmt.Sprintf("Choose a passphrase to encrypt the keys of wallet %s", walletName))
+	if err != nil {
+		return err
+	}
+
+	return cli.api.NewWallet(walletName, passphrase)
+}
+
+func (cli *CommandLine) execMemPool() error {
+	os.Args = os.Args[1:]
+	offset := flag.Int("offset", 0, "number of transactions to skip")
+	limit := flag.Int("limit", 20, "maximum number of transactions to list, 0 lists all")
+	histogram := flag.Bool("histogram", false, "print the number of transactions per fee rate bucket")
+	id := flag.String("id", "", "hash of a single transaction to print")
+	outpoint := flag.String("outpoint", "", "print the transaction spending <transaction hash>:<output index>")
+	address := flag.String("address", "", "list the transactions paying to address")
+
+	flag.Parse()
+
+	info := cli.api.GetMemPoolInfo()
+	log.Printf("Transactions: %d\tSize: %d bytes", info.Count, info.Size)
+
+	switch {
+	case *histogram:
+		log.Println("Fee rate (maglia/byte)\tTransactions\tSize")
+		for _, bucket := range cli.api.GetMemPoolFeeHistogram() {
+			log.Printf(">= %d\t%d\t%d", bucket.MinFeeRate, bucket.Count, bucket.Size)
+		}
+	case strings.TrimSpace(*id) != "":
+		entry, err := cli.api.GetMemPoolEntry(strings.TrimSpace(*id))
+		if err != nil {
+			return err
+		}
+		printMemPoolEntry(entry)
+	case strings.TrimSpace(*outpoint) != "":
+		hash, index, err := parseOutpoint(strings.TrimSpace(*outpoint))
+		if err != nil {
+			return err
+		}
+
+		entry := cli.api.GetMemPoolSpender(hash, index)
+		if entry == nil {
+			log.Printf("No mempool transaction spends %s", *outpoint)
+			return nil
+		}
+		printMemPoolEntry(entry)
+	case strings.TrimSpace(*address) != "":
+		entries, err := cli.api.GetMemPoolByAddress(strings.TrimSpace(*address))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			printMemPoolEntry(entry)
+		}
+	default:
+		entries, total := cli.api.ListMemPool(*offset, *limit)
+		for _, entry := range entries {
+			printMemPoolEntry(entry)
+		}
+		log.Printf("Showing %d of %d transactions", len(entries), total)
+	}
+
+	return nil
+}
+
+func (cli *CommandLine) execMultiSigAddress() error {
+	os.Args = os.Args[1:]
+	m := flag.Int("m", 0, "number of signatures required to spend")
+	keys := flag.String("keys", "", "comma separated hex public keys of the signers, in signing order")
+	scriptHash := flag.Bool("p2sh", false, "create a pay to script hash address of the multisig script")
+
+	flag.Parse()
+
+	publicKeys := make([][]byte, 0)
+	for _, key := range strings.Split(*keys, ",") {
+		publicKey, err := hex.DecodeString(strings.TrimSpace(key))
+		if err != nil {
+			return fmt.Errorf("invalid public key %q", key)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	address, err := cli.api.CreateMultiSigAddress(*m, publicKeys, *scriptHash)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Multisig Address: %s", address)
+
+	if *scriptHash {
+		// Spending needs the redeem script, which the address only commits to
+		redeemScript, err := script.MultiSigScript(*m, publicKeys)
+		if err != nil {
+			return err
+		}
+		log.Printf("Redeem Script: %x", redeemScript)
+	}
+
+	return nil
+}
+
+func (cli *CommandLine) execScriptAddress() error {
+	os.Args = os.Args[1:]
+	redeemScriptHex := flag.String("script", "", "hex encoded redeem script")
+
+	flag.Parse()
+
+	redeemScript, err := hex.DecodeString(strings.TrimSpace(*redeemScriptHex))
+	if err != nil {
+		return errors.New("redeem script is not valid hex")
+	}
+
+	address, err := cli.api.CreateScriptHashAddress(redeemScript)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Script: %s", script.Disassemble(redeemScript))
+	log.Printf("Script Hash Address: %s", address)
+	return nil
+}
+
+func (cli *CommandLine) execMultiSigCreate() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+	from := flag.String("from", "", "multisig address to spend from")
 	receiverAddress := flag.String("receiver-address", "", "address of the receiver")
 	amount := flag.Uint64("amount", 0, "amount to be sent to the receiver in maglia (100,000,000 maglia = 1 magcoin)")
+	redeemScriptHex := flag.String("redeem-script", "", "hex encoded multisig script of a pay to script hash address")
 
 	flag.Parse()
 
-	*receiverAddress = strings.TrimSpace(*receiverAddress)
+	if *amount < 1 || *amount > share.MAX_MAGLIA {
+		return fmt.Errorf("transaction amount should be minimum of 1 maglia and less than %d maglias (21 million magcoins)", share.MAX_MAGLIA)
+	}
+
+	redeemScript, err := hex.DecodeString(strings.TrimSpace(*redeemScriptHex))
+	if err != nil {
+		return errors.New("redeem script is not valid hex")
+	}
+
+	trx, err := cli.api.CreateMultiSigTransaction(
+		*walletName,
+		*amount,
+		strings.TrimSpace(*from),
+		strings.TrimSpace(*receiverAddress),
+		redeemScript,
+	)
+	if err != nil {
+		return err
+	}
+
+	return printPartialTransaction(trx)
+}
+
+func (cli *CommandLine) execMultiSigSign() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+	trxHex := flag.String("transaction", "", "hex encoded multisig transaction to sign")
+
+	flag.Parse()
+
+	if err := cli.unlockWallet(*walletName); err != nil {
+		return err
+	}
+
+	trxBytes, err := hex.DecodeString(strings.TrimSpace(*trxHex))
+	if err != nil {
+		return errors.New("transaction is not valid hex")
+	}
+
+	trx, err := transaction.DecodeTransaction(trxBytes)
+	if err != nil {
+		return err
+	}
+
+	complete, err := cli.api.SignMultiSigTransaction(*walletName, trx)
+	if err != nil {
+		return err
+	}
 
-	if *receiverAddress == "" {
-		return nil, errors.New("receiver address cannot be empty")
+	if complete {
+		log.Printf("Transaction fully signed and added to the mempool: %x", trx.ID)
+		return nil
+	}
+
+	return printPartialTransaction(trx)
+}
+
+func (cli *CommandLine) execHTLCCreate() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+	receiverAddress := flag.String("receiver-address", "", "address that can claim the contract with the secret")
+	amount := flag.Uint64("amount", 0, "amount to lock in the contract in maglia (100,000,000 maglia = 1 magcoin)")
+	hashLockHex := flag.String("hash", "", "hex SHA-256 hash of the secret; a new secret is generated if empty")
+	lockTime := flag.Int64("lock-time", 0, "block height, or unix timestamp from 500000000 on, from which the wallet can take the amount back")
+
+	flag.Parse()
+
+	if err := cli.unlockWallet(*walletName); err != nil {
+		return err
 	}
 
 	if *amount < 1 || *amount > share.MAX_MAGLIA {
-		return nil, fmt.Errorf("transaction amount should be minimum of 1 maglia and less than %d maglias (21 million magcoins)", share.MAX_MAGLIA)
+		return fmt.Errorf("transaction amount should be minimum of 1 maglia and less than %d maglias (21 million magcoins)", share.MAX_MAGLIA)
+	}
+
+	var preimage, hashLock []byte
+	var err error
+	if strings.TrimSpace(*hashLockHex) == "" {
+		// Starting a swap: the counterparty locks its side to the same hash lock
+		if preimage, hashLock, err = cli.api.NewHTLCSecret(); err != nil {
+			return err
+		}
+	} else if hashLock, err = hex.DecodeString(strings.TrimSpace(*hashLockHex)); err != nil {
+		return errors.New("hash is not valid hex")
+	}
+
+	trx, redeemScript, err := cli.api.CreateHTLC(*walletName, *amount, strings.TrimSpace(*receiverAddress), hashLock, *lockTime)
+	if err != nil {
+		return err
+	}
+
+	address, err := cli.api.GetHTLCAddress(redeemScript)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Funding Transaction: %x", trx.ID)
+	log.Printf("Contract Address: %s", address)
+	log.Printf("Contract: %s", script.Disassemble(redeemScript))
+	log.Printf("Redeem Script: %x", redeemScript)
+	log.Printf("Hash Lock: %x", hashLock)
+	if preimage != nil {
+		log.Printf("Secret: %x (keep it private until you claim the counterparty's contract)", preimage)
+	}
+
+	return nil
+}
+
+func (cli *CommandLine) execHTLCClaim() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+	redeemScriptHex := flag.String("redeem-script", "", "hex encoded redeem script of the contract")
+	preimageHex := flag.String("secret", "", "hex secret whose hash is the hash lock of the contract")
+	fee := flag.Uint64("fee", 1_000, "fee in maglia")
+
+	flag.Parse()
+
+	if err := cli.unlockWallet(*walletName); err != nil {
+		return err
+	}
+
+	redeemScript, err := hex.DecodeString(strings.TrimSpace(*redeemScriptHex))
+	if err != nil {
+		return errors.New("redeem script is not valid hex")
+	}
+
+	preimage, err := hex.DecodeString(strings.TrimSpace(*preimageHex))
+	if err != nil {
+		return errors.New("secret is not valid hex")
+	}
+
+	trx, err := cli.api.ClaimHTLC(*walletName, redeemScript, preimage, *fee)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Claim Transaction: %x", trx.ID)
+	return nil
+}
+
+func (cli *CommandLine) execHTLCRefund() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+	redeemScriptHex := flag.String("redeem-script", "", "hex encoded redeem script of the contract")
+	fee := flag.Uint64("fee", 1_000, "fee in maglia")
+
+	flag.Parse()
+
+	if err := cli.unlockWallet(*walletName); err != nil {
+		return err
+	}
+
+	redeemScript, err := hex.DecodeString(strings.TrimSpace(*redeemScriptHex))
+	if err != nil {
+		return errors.New("redeem script is not valid hex")
+	}
+
+	trx, err := cli.api.RefundHTLC(*walletName, redeemScript, *fee)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Refund Transaction: %x", trx.ID)
+	return nil
+}
+
+// Prints the secret the counterparty of a swap revealed by claiming the contract
+// the wallet funded, which claims the counterparty's contract on the other chain
+func (cli *CommandLine) execHTLCSecret() error {
+	os.Args = os.Args[1:]
+	redeemScriptHex := flag.String("redeem-script", "", "hex encoded redeem script of the claimed contract")
+
+	flag.Parse()
+
+	redeemScript, err := hex.DecodeString(strings.TrimSpace(*redeemScriptHex))
+	if err != nil {
+		return errors.New("redeem script is not valid hex")
+	}
+
+	preimage, err := cli.api.FindHTLCPreimage(redeemScript)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Secret: %x", preimage)
+	return nil
+}
+
+// Records the hash of a file on the chain. Once the recording transaction is
+// mined, running the command again prints the block and the merkle proof
+// showing when the file was recorded.
+func (cli *CommandLine) execNotarize() error {
+	os.Args = os.Args[1:]
+	walletName := walletFlag()
+	feeRate := flag.Uint64("fee-rate", wallet.DefaultFeeRate, "fee rate in maglia per byte")
+	verify := flag.Bool("verify", false, "only look up the file, do not record it")
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		return errors.New("usage: notarize [-fee-rate <maglia per byte>] [-verify] <file>")
+	}
+
+	document, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(document)
+
+	log.Printf("Document SHA-256: %x", digest)
+
+	notarization, err := cli.api.FindNotarization(digest[:])
+	if err == nil {
+		printNotarization(notarization)
+		return nil
+	}
+	if !errors.Is(err, blockchain.ErrDataNotFound) {
+		return err
+	}
+
+	if trx := cli.api.FindPendingDataTransaction(digest[:]); trx != nil {
+		log.Printf("Waiting to be mined in transaction %x", trx.ID)
+		return nil
+	}
+
+	if *verify {
+		return errors.New("document is not notarized")
+	}
+
+	if err := cli.unlockWallet(*walletName); err != nil {
+		return err
+	}
+
+	trx, err := cli.api.CreateDataTransaction(*walletName, digest[:], *feeRate)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Notarization Transaction: %x", trx.ID)
+	log.Println("Run the command again once the transaction is mined to get its proof")
+	return nil
+}
+
+func printNotarization(notarization *wallet.Notarization) {
+	log.Printf("Notarized in block %x at %s (%d confirmations)",
+		notarization.BlockHash,
+		time.Unix(notarization.BlockTime, 0).UTC().Format(time.RFC3339),
+		notarization.Confirmations,
+	)
+	log.Printf("Transaction: %x (position %d)", notarization.Transaction.ID, notarization.Proof.Index)
+	log.Printf("Merkle Root: %x", notarization.MerkleRoot)
+	for level, sibling := range notarization.Proof.Siblings {
+		log.Printf("Merkle Proof %d: %x", level, sibling)
+	}
+	log.Println("Merkle proof verified")
+}
+
+// Prints a partially signed transaction for the next signer
+func printPartialTransaction(trx *transaction.Transaction) error {
+	trxBytes, err := trx.Encode()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Transaction %x needs more signatures, pass it to the next signer:", trx.ID)
+	log.Printf("%x", trxBytes)
+
+	return nil
+}
+
+func printMemPoolEntry(entry *transaction.MemPoolEntry) {
+	log.Printf(
+		"Hash: %s\tFee: %d\tSize: %d\tFee rate: %d\tInputs: %d\tOutputs: %d\tReceived: %s",
+		entry.ID,
+		entry.Fee,
+		entry.Size,
+		entry.FeeRate(),
+		len(entry.Transaction.Input),
+		len(entry.Transaction.Output),
+		entry.Time.Format(time.RFC3339),
+	)
+}
+
+// Parses an outpoint written as <transaction hash hex>:<output index>
+func parseOutpoint(outpoint string) ([]byte, int, error) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("outpoint %q should be of the form <transaction hash>:<output index>", outpoint)
+	}
+
+	hash, err := hex.DecodeString(parts[0])
+	if err != nil || len(hash) != 32 {
+		return nil, 0, fmt.Errorf("invalid transaction hash %q", parts[0])
+	}
+
+	index, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid output index %q", parts[1])
 	}
 
-	return cli.api.CreateTransaction(*amount, *receiverAddress)
+	return hash, int(index), nil
 }
 
 func (cli *CommandLine) printBlockchain() error {
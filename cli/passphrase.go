@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jenlesamuel/magcoin/share"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Environment variable holding the wallet passphrase, for scripts that cannot
+// answer a prompt
+const PassphraseEnv = "MAGCOIN_PASSPHRASE"
+
+// Environment variables holding the mnemonic of wallet restore and the mnemonic
+// passphrase of wallet create and restore, for scripts. Secrets are never taken
+// as flags, which other users can read from the process list.
+const (
+	MnemonicEnv           = "MAGCOIN_MNEMONIC"
+	MnemonicPassphraseEnv = "MAGCOIN_MNEMONIC_PASSPHRASE"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// Runs the key agent started by wallet unlock, given the keystore directory as
+// argument, before main opens the database the agent does not need
+func RunKeyAgent() error {
+	if len(os.Args) != 3 {
+		return fmt.Errorf("usage: %s <keystore directory>", share.KeyAgentCommand)
+	}
+
+	return share.ServeKeyAgent(os.Args[2], os.Stdin, os.Stdout)
+}
+
+// Reads a passphrase from the terminal without echoing it, or a line of
+// standard input when it is not a terminal
+func ReadPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		passphrase, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(passphrase), err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Reads a new passphrase and its confirmation. The value of MAGCOIN_PASSPHRASE
+// is used without asking if it is set.
+func ReadNewPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	passphrase, err := ReadPassphrase(prompt + ": ")
+	if err != nil {
+		return "", err
+	}
+
+	confirmation, err := ReadPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	if passphrase != confirmation {
+		return "", errors.New("passphrases do not match")
+	}
+
+	return passphrase, nil
+}
+
+// Unlocks the wallet called walletName for the running command, asking for the passphrase unless
+// the wallet is unlocked, its key agent is running or MAGCOIN_PASSPHRASE is set
+func (cli *CommandLine) unlockWallet(walletName string) error {
+	locked, err := cli.api.IsWalletLocked(walletName)
+	if err != nil || !locked {
+		return err
+	}
+
+	if unlocked, err := cli.api.UnlockWalletFromAgent(walletName); err != nil || unlocked {
+		return err
+	}
+
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		if passphrase, err = ReadPassphrase(fmt.Sprintf("Passphrase of wallet %s: ", walletName)); err != nil {
+			return err
+		}
+	}
+
+	return cli.api.UnlockWallet(walletName, passphrase, 0)
+}
+
+// Reads the words of a mnemonic without echoing them. The value of
+// MAGCOIN_MNEMONIC is used without asking if it is set.
+func readMnemonic() (string, error) {
+	if mnemonic := os.Getenv(MnemonicEnv); mnemonic != "" {
+		return mnemonic, nil
+	}
+
+	return ReadPassphrase("Mnemonic words, separated by spaces: ")
+}
+
+// Reads the optional passphrase of a mnemonic, and its confirmation when
+// confirm is set. The value of MAGCOIN_MNEMONIC_PASSPHRASE is used without
+// asking if it is set, even empty, and an empty standard input means none.
+func readMnemonicPassphrase(confirm bool) (string, error) {
+	if passphrase, ok := os.LookupEnv(MnemonicPassphraseEnv); ok {
+		return passphrase, nil
+	}
+
+	passphrase, err := ReadPassphrase("Mnemonic passphrase, empty for none: ")
+	if errors.Is(err, io.EOF) {
+		return "", nil
+	}
+	if err != nil || passphrase == "" || !confirm {
+		return passphrase, err
+	}
+
+	confirmation, err := ReadPassphrase("Repeat the mnemonic passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	if passphrase != confirmation {
+		return "", errors.New("mnemonic passphrases do not match")
+	}
+
+	return passphrase, nil
+}
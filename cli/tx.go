@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+func (cli *CommandLine) execTx() error {
+	if len(os.Args) < 3 {
+		return errors.New("usage: tx create-unsigned|sign|finalize [arguments]")
+	}
+
+	command := os.Args[2]
+	os.Args = os.Args[2:]
+
+	switch command {
+	case "create-unsigned":
+		payment := definePaymentFlags()
+		multiSigAddress := flag.String("multisig-address", "", "spend from this multisig address instead of the wallet")
+		redeemScriptHex := flag.String("redeem-script", "", "hex encoded redeem script of a pay to script hash multisig address")
+		out := flag.String("out", "", "file to write the transaction to, instead of printing it as base64")
+
+		flag.Parse()
+
+		if err := payment.validate(); err != nil {
+			return err
+		}
+
+		var psbt *transaction.PartiallySignedTransaction
+		var err error
+		if *multiSigAddress == "" {
+			psbt, err = cli.api.CreateUnsignedTransaction(
+				*payment.walletName,
+				*payment.amount,
+				*payment.receiverAddress,
+				*payment.coinSelection,
+				*payment.feeRate,
+			)
+		} else {
+			redeemScript, decodeErr := hex.DecodeString(strings.TrimSpace(*redeemScriptHex))
+			if decodeErr != nil {
+				return errors.New("redeem script is not valid hex")
+			}
+
+			psbt, err = cli.api.CreateUnsignedMultiSigTransaction(
+				*payment.amount,
+				strings.TrimSpace(*multiSigAddress),
+				*payment.receiverAddress,
+				redeemScript,
+			)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = printPartiallySigned(psbt); err != nil {
+			return err
+		}
+
+		return writePartiallySigned(psbt, *out)
+	case "sign":
+		walletName := walletFlag()
+		in := flag.String("in", "", "file holding the partially signed transaction")
+		text := flag.String("psbt", "", "base64 partially signed transaction, instead of -in")
+		out := flag.String("out", "", "file to write the transaction to, instead of printing it as base64")
+
+		flag.Parse()
+
+		psbt, err := readPartiallySigned(*in, *text)
+		if err != nil {
+			return err
+		}
+
+		// Shown before the passphrase is asked, to review what is signed
+		if err = printPartiallySigned(psbt); err != nil {
+			return err
+		}
+
+		if err = cli.unlockWallet(*walletName); err != nil {
+			return err
+		}
+
+		signed, err := cli.api.SignPartiallySignedTransaction(*walletName, psbt)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Signatures added: %d", signed)
+		if psbt.IsComplete() {
+			log.Println("The transaction is fully signed, send it with tx finalize")
+		} else {
+			log.Println("The transaction needs more signatures, pass it to the next signer")
+		}
+
+		return writePartiallySigned(psbt, *out)
+	case "finalize":
+		in := flag.String("in", "", "file holding the partially signed transaction")
+		text := flag.String("psbt", "", "base64 partially signed transaction, instead of -in")
+
+		flag.Parse()
+
+		psbt, err := readPartiallySigned(*in, *text)
+		if err != nil {
+			return err
+		}
+
+		trx, err := cli.api.FinalizePartiallySignedTransaction(psbt)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Transaction %x added to the mempool", trx.ID)
+	default:
+		return fmt.Errorf("unknown tx command %q, use create-unsigned, sign or finalize", command)
+	}
+
+	return nil
+}
+
+// Reads a partially signed transaction from the file at path, holding its binary
+// or base64 encoding, or from text if path is empty
+func readPartiallySigned(path, text string) (*transaction.PartiallySignedTransaction, error) {
+	if path == "" {
+		if text == "" {
+			return nil, errors.New("give the partially signed transaction with -in or -psbt")
+		}
+		return transaction.ParsePartiallySignedTransaction(strings.TrimSpace(text))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	psbt, err := transaction.DecodePartiallySignedTransaction(data)
+	if errors.Is(err, transaction.ErrNotPartiallySigned) {
+		return transaction.ParsePartiallySignedTransaction(strings.TrimSpace(string(data)))
+	}
+
+	return psbt, err
+}
+
+// Writes the binary encoding of psbt to the file at path, or prints it as base64
+// if path is empty
+func writePartiallySigned(psbt *transaction.PartiallySignedTransaction, path string) error {
+	if path == "" {
+		text, err := psbt.Base64()
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Partially Signed Transaction: %s", text)
+		return nil
+	}
+
+	data, err := psbt.Encode()
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+
+	log.Printf("Partially signed transaction written to %s", path)
+	return nil
+}
+
+// Prints what a partially signed transaction spends and pays, and its signatures
+func printPartiallySigned(psbt *transaction.PartiallySignedTransaction) error {
+	log.Printf("Transaction: %x", psbt.Transaction.ID)
+
+	for idx, input := range psbt.Inputs {
+		outIdx, err := psbt.Transaction.Input[idx].OutputIndex()
+		if err != nil {
+			return err
+		}
+
+		amount, err := share.BytesToInt64(input.SpentOutput.Amount)
+		if err != nil {
+			return err
+		}
+
+		_, required := input.Signers()
+		log.Printf(
+			"Input %d: %x:%d\t%s\t%d maglia\t%d of %d signatures",
+			idx,
+			psbt.Transaction.Input[idx].OutpointHash,
+			outIdx,
+			script.ExtractAddress(input.SpentOutput.LockingScript),
+			amount,
+			len(input.Signatures),
+			required,
+		)
+	}
+
+	for idx, output := range psbt.Transaction.Output {
+		amount, err := share.BytesToInt64(output.Amount)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Output %d: %s\t%d maglia", idx, script.ExtractAddress(output.LockingScript), amount)
+	}
+
+	fee, err := psbt.Fee()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Fee: %d maglia", fee)
+	return nil
+}
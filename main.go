@@ -2,6 +2,8 @@ package main
 
 import (
 	"log"
+	"os"
+	"path/filepath"
 
 	"github.com/jenlesamuel/magcoin/api"
 	"github.com/jenlesamuel/magcoin/blockchain"
@@ -13,6 +15,15 @@ import (
 
 func main() {
 
+	// The key agent of wallet unlock only holds a key, and leaves the DB to the
+	// commands it serves
+	if len(os.Args) > 1 && os.Args[1] == share.KeyAgentCommand {
+		if err := cli.RunKeyAgent(); err != nil {
+			log.Fatalf("key agent: %s", err)
+		}
+		return
+	}
+
 	// Init DB
 	db, err := blockchain.InitDB()
 	if err != nil {
@@ -20,21 +31,72 @@ func main() {
 	}
 	defer db.Close()
 
+	// Init Keystore
+	dataDir, err := share.DataDir()
+	if err != nil {
+		log.Panicf("%s\n", err)
+	}
+
+	keystore, err := share.OpenKeystore(dataDir)
+	if err != nil {
+		log.Panicf("%s\n", err)
+	}
+
+	if !keystore.IsCreated() {
+		// Keys of versions before the data directory are moved in on first start
+		// and encrypted by the new keystore when loaded below
+		moved, err := share.MigrateLegacyKeyDir(share.LegacyKeyDir, dataDir,
+			share.AddressFilename,
+			transaction.PolicyFilename,
+			wallet.HDWalletFilename,
+			wallet.ImportedKeysDirname,
+			wallet.ChangeKeysDirname,
+		)
+		if err != nil {
+			log.Panicf("could not move the keys of %s: %s\n", share.LegacyKeyDir, err)
+		}
+		for _, name := range moved {
+			log.Printf("moved %s from %s to %s\n", name, share.LegacyKeyDir, dataDir)
+		}
+
+		passphrase, err := cli.ReadNewPassphrase("Choose a passphrase to encrypt the wallet keys")
+		if err != nil {
+			log.Panicf("%s\n", err)
+		}
+
+		if err = keystore.Create(passphrase); err != nil {
+			log.Panicf("%s\n", err)
+		}
+	}
+
+	// Init Network Params. Without a network, the stored key selects it.
+	var params *share.NetworkParams
+	if name := os.Getenv("MAGCOIN_NETWORK"); name != "" {
+		if params, err = share.ParamsByName(name); err != nil {
+			log.Panicf("%s\n", err)
+		}
+	}
+
 	// Init KeyManager
-	keysPath := "/tmp"
-	keymanager, err := share.LoadKeyManager(keysPath)
+	keymanager, err := share.LoadKeyManager(keystore, params)
+	if err != nil {
+		log.Panicf("%s\n", err)
+	}
+
+	// Init Policy
+	policy, err := transaction.LoadPolicy(filepath.Join(dataDir, transaction.PolicyFilename))
 	if err != nil {
 		log.Panicf("%s\n", err)
 	}
 
 	// Init Mempool
-	mempool := transaction.NewMemPool()
+	mempool := transaction.NewMemPool(policy)
 
 	// Init TransactionManager
 	transactionManager := transaction.NewTransactionManager(keymanager)
 
 	// Init BlockManager
-	blockManager := blockchain.NewBlockManager(transactionManager)
+	blockManager := blockchain.NewBlockManager(transactionManager, policy)
 
 	genesisBlock, err := blockManager.GenesisBlock()
 	if err != nil {
@@ -47,11 +109,23 @@ func main() {
 		log.Panicf("%s\n", err)
 	}
 
+	// Init KeyRing
+	keyring, err := wallet.LoadKeyRing(keymanager)
+	if err != nil {
+		log.Panicf("%s\n", err)
+	}
+
 	//Init Wallet Manager
-	walletManager := wallet.NewWalletManager(bc.Iterator(), keymanager, mempool)
+	walletManager := wallet.NewWalletManager(bc, keyring, mempool)
+
+	// Init Wallets, the default one and the ones loaded when the node last ran
+	wallets := wallet.NewRegistry(dataDir, walletManager)
+	if err = wallets.LoadOnStartup(); err != nil {
+		log.Panicf("%s\n", err)
+	}
 
 	// Init API
-	api := api.NewAPI(bc.Iterator(), walletManager)
+	api := api.NewAPI(bc.Iterator(), wallets, mempool)
 
 	// Run CLI
 	cli := cli.NewCommandLine(api)
@@ -0,0 +1,86 @@
+package script
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Builder assembles a script from opcodes and data pushes, always choosing the
+// smallest push opcode for the data
+type Builder struct {
+	script []byte
+	err    error
+}
+
+func NewBuilder() *Builder {
+	return &Builder{script: make([]byte, 0)}
+}
+
+func (b *Builder) AddOp(op byte) *Builder {
+	b.script = append(b.script, op)
+	return b
+}
+
+func (b *Builder) AddOps(ops ...byte) *Builder {
+	b.script = append(b.script, ops...)
+	return b
+}
+
+// Pushes data with the smallest possible push opcode
+func (b *Builder) AddData(data []byte) *Builder {
+	length := len(data)
+
+	switch {
+	case length == 0:
+		b.script = append(b.script, OP_0)
+	case length == 1 && data[0] >= 1 && data[0] <= 16:
+		b.script = append(b.script, OP_1+data[0]-1)
+	case length == 1 && data[0] == 0x81:
+		b.script = append(b.script, OP_1NEGATE)
+	case length <= int(OP_DATA_75):
+		b.script = append(b.script, byte(length))
+		b.script = append(b.script, data...)
+	case length <= 0xFF:
+		b.script = append(b.script, OP_PUSHDATA1, byte(length))
+		b.script = append(b.script, data...)
+	case length <= MaxStackItemSize:
+		lengthBytes := make([]byte, 2)
+		binary.LittleEndian.PutUint16(lengthBytes, uint16(length))
+		b.script = append(b.script, OP_PUSHDATA2)
+		b.script = append(b.script, lengthBytes...)
+		b.script = append(b.script, data...)
+	default:
+		b.err = errors.New("pushed data exceeds the maximum stack item size")
+	}
+
+	return b
+}
+
+// Pushes n as a script number, using OP_0 - OP_16 and OP_1NEGATE when possible
+func (b *Builder) AddInt64(n int64) *Builder {
+	switch {
+	case n == 0:
+		b.script = append(b.script, OP_0)
+	case n == -1:
+		b.script = append(b.script, OP_1NEGATE)
+	case n >= 1 && n <= 16:
+		b.script = append(b.script, OP_1+byte(n)-1)
+	default:
+		b.AddData(scriptNum(n).Bytes())
+	}
+
+	return b
+}
+
+// Returns the script or the first error met while building it
+func (b *Builder) Script() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if len(b.script) > MaxScriptSize {
+		return nil, errors.New("script exceeds the maximum script size")
+	}
+
+	return b.script, nil
+}
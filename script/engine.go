@@ -0,0 +1,596 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/jenlesamuel/magcoin/share"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// VerifyFlags turn on rules on top of the consensus rules, used for policy
+type VerifyFlags uint32
+
+const (
+	// Public keys and signatures passed to signature checks must be valid encodings
+	VerifyStrictEncoding VerifyFlags = 1 << iota
+)
+
+var (
+	ErrScriptFailed       = errors.New("script evaluated to false")
+	ErrVerifyFailed       = errors.New("verify operation failed")
+	ErrEarlyReturn        = errors.New("script executed OP_RETURN")
+	ErrStackUnderflow     = errors.New("not enough items on the stack")
+	ErrUnbalancedIf       = errors.New("unbalanced conditional")
+	ErrInvalidOpcode      = errors.New("invalid opcode")
+	ErrNullFail           = errors.New("signature check failed with a non empty signature")
+	ErrUnlockingNotPushes = errors.New("unlocking script is not push only")
+	ErrStrictEncoding     = errors.New("public key or signature is not strictly encoded")
+	ErrNegativeLockTime   = errors.New("negative lock time")
+	ErrUnsatisfiedLock    = errors.New("lock time requirement not satisfied")
+)
+
+// Lock time arguments may be up to 5 bytes long to reach past 2^31
+const maxLockTimeLength = 5
+
+// Checker checks the conditions a script puts on the transaction spending it:
+// signatures and lock times. The script engine does not know the transaction;
+// the checker does.
+type Checker interface {
+	// Reports whether signature is a valid signature by publicKey. When deferrable
+	// is set the script fails anyway if the signature is invalid, so the checker
+	// may postpone the verification, e.g. to batch it, and report true.
+	CheckSig(signature, publicKey []byte, deferrable bool) bool
+	// Reports whether the transaction lock time satisfies lockTime
+	CheckLockTime(lockTime int64) bool
+	// Reports whether the sequence of the spending input satisfies sequence
+	CheckSequence(sequence int64) bool
+}
+
+type engine struct {
+	stack    [][]byte
+	altStack [][]byte
+	checker  Checker
+	flags    VerifyFlags
+}
+
+// Runs the unlocking script and then the locking script on the resulting stack.
+// Returns nil if the locking script ends with a true value on top of the stack.
+//
+// When the locking script is a pay to script hash script, the last item pushed
+// by the unlocking script is the redeem script. Once the locking script checked
+// its hash, the redeem script runs on the other items pushed by the unlocking
+// script and must also end with a true value on top of the stack.
+func Verify(unlockingScript, lockingScript []byte, checker Checker, flags VerifyFlags) error {
+	if !IsPushOnly(unlockingScript) {
+		return ErrUnlockingNotPushes
+	}
+
+	vm := &engine{
+		stack:    make([][]byte, 0),
+		altStack: make([][]byte, 0),
+		checker:  checker,
+		flags:    flags,
+	}
+
+	if err := vm.execute(unlockingScript); err != nil {
+		return fmt.Errorf("unlocking script: %w", err)
+	}
+
+	var redeemStack [][]byte
+	if IsScriptHash(lockingScript) {
+		redeemStack = append([][]byte{}, vm.stack...)
+	}
+
+	if err := vm.execute(lockingScript); err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+
+	if !vm.succeeded() {
+		return ErrScriptFailed
+	}
+
+	if redeemStack == nil {
+		return nil
+	}
+
+	// The locking script succeeded, so the unlocking script pushed at least the redeem script
+	redeemScript := redeemStack[len(redeemStack)-1]
+	vm.stack = redeemStack[:len(redeemStack)-1]
+
+	if err := vm.execute(redeemScript); err != nil {
+		return fmt.Errorf("redeem script: %w", err)
+	}
+
+	if !vm.succeeded() {
+		return ErrScriptFailed
+	}
+
+	return nil
+}
+
+// Reports whether the stack ends with a true value
+func (vm *engine) succeeded() bool {
+	return len(vm.stack) != 0 && asBool(vm.stack[len(vm.stack)-1])
+}
+
+func (vm *engine) execute(script []byte) error {
+	if len(script) > MaxScriptSize {
+		return fmt.Errorf("script size %d exceeds maximum of %d", len(script), MaxScriptSize)
+	}
+
+	instructions, err := Parse(script)
+	if err != nil {
+		return err
+	}
+
+	// One entry per open IF, telling whether its current branch executes
+	conditions := make([]bool, 0)
+	opCount := 0
+	vm.altStack = vm.altStack[:0]
+
+	for _, instruction := range instructions {
+		executing := true
+		for _, condition := range conditions {
+			executing = executing && condition
+		}
+
+		if len(instruction.Data) > MaxStackItemSize {
+			return fmt.Errorf("push of %d bytes exceeds maximum of %d", len(instruction.Data), MaxStackItemSize)
+		}
+
+		if !isPushOp(instruction.Op) {
+			opCount++
+			if opCount > MaxOpsPerScript {
+				return fmt.Errorf("script exceeds %d operations", MaxOpsPerScript)
+			}
+		}
+
+		if !executing && !isConditionalOp(instruction.Op) {
+			continue
+		}
+
+		if conditions, err = vm.step(instruction, conditions, executing); err != nil {
+			return fmt.Errorf("%s: %w", OpcodeName(instruction.Op), err)
+		}
+
+		if len(vm.stack)+len(vm.altStack) > MaxStackSize {
+			return fmt.Errorf("stack size exceeds maximum of %d", MaxStackSize)
+		}
+	}
+
+	if len(conditions) != 0 {
+		return ErrUnbalancedIf
+	}
+
+	return nil
+}
+
+func (vm *engine) step(instruction *Instruction, conditions []bool, executing bool) ([]bool, error) {
+	op := instruction.Op
+
+	switch {
+	case op <= OP_PUSHDATA2:
+		vm.push(instruction.Data)
+		return conditions, nil
+	case op == OP_1NEGATE || (op >= OP_1 && op <= OP_16):
+		vm.push(smallIntValue(op).Bytes())
+		return conditions, nil
+	}
+
+	switch op {
+	case OP_NOP:
+
+	case OP_IF, OP_NOTIF:
+		branch := false
+		if executing {
+			item, err := vm.pop()
+			if err != nil {
+				return conditions, err
+			}
+			branch = asBool(item)
+			if op == OP_NOTIF {
+				branch = !branch
+			}
+		}
+		conditions = append(conditions, branch)
+
+	case OP_ELSE:
+		if len(conditions) == 0 {
+			return conditions, ErrUnbalancedIf
+		}
+		conditions[len(conditions)-1] = !conditions[len(conditions)-1]
+
+	case OP_ENDIF:
+		if len(conditions) == 0 {
+			return conditions, ErrUnbalancedIf
+		}
+		conditions = conditions[:len(conditions)-1]
+
+	case OP_VERIFY:
+		if err := vm.verify(); err != nil {
+			return conditions, err
+		}
+
+	case OP_RETURN:
+		return conditions, ErrEarlyReturn
+
+	case OP_TOALTSTACK:
+		item, err := vm.pop()
+		if err != nil {
+			return conditions, err
+		}
+		vm.altStack = append(vm.altStack, item)
+
+	case OP_FROMALTSTACK:
+		if len(vm.altStack) == 0 {
+			return conditions, ErrStackUnderflow
+		}
+		vm.push(vm.altStack[len(vm.altStack)-1])
+		vm.altStack = vm.altStack[:len(vm.altStack)-1]
+
+	case OP_DROP:
+		if _, err := vm.pop(); err != nil {
+			return conditions, err
+		}
+
+	case OP_DUP:
+		item, err := vm.peek(0)
+		if err != nil {
+			return conditions, err
+		}
+		vm.push(item)
+
+	case OP_NIP:
+		top, err := vm.pop()
+		if err != nil {
+			return conditions, err
+		}
+		if _, err = vm.pop(); err != nil {
+			return conditions, err
+		}
+		vm.push(top)
+
+	case OP_OVER:
+		item, err := vm.peek(1)
+		if err != nil {
+			return conditions, err
+		}
+		vm.push(item)
+
+	case OP_SWAP:
+		if len(vm.stack) < 2 {
+			return conditions, ErrStackUnderflow
+		}
+		n := len(vm.stack)
+		vm.stack[n-1], vm.stack[n-2] = vm.stack[n-2], vm.stack[n-1]
+
+	case OP_SIZE:
+		item, err := vm.peek(0)
+		if err != nil {
+			return conditions, err
+		}
+		vm.push(scriptNum(len(item)).Bytes())
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return conditions, err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return conditions, err
+		}
+		vm.push(fromBool(bytes.Equal(a, b)))
+
+		if op == OP_EQUALVERIFY {
+			if err := vm.verify(); err != nil {
+				return conditions, err
+			}
+		}
+
+	case OP_NOT:
+		item, err := vm.pop()
+		if err != nil {
+			return conditions, err
+		}
+		n, err := makeScriptNum(item, maxNumLength)
+		if err != nil {
+			return conditions, err
+		}
+		vm.push(fromBool(n == 0))
+
+	case OP_SHA256, OP_HASH160, OP_HASH256:
+		item, err := vm.pop()
+		if err != nil {
+			return conditions, err
+		}
+		vm.push(hashItem(op, item))
+
+	case OP_CHECKLOCKTIMEVERIFY, OP_CHECKSEQUENCEVERIFY:
+		if err := vm.checkLock(op); err != nil {
+			return conditions, err
+		}
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		if err := vm.checkSig(); err != nil {
+			return conditions, err
+		}
+
+		if op == OP_CHECKSIGVERIFY {
+			if err := vm.verify(); err != nil {
+				return conditions, err
+			}
+		}
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		if err := vm.checkMultiSig(); err != nil {
+			return conditions, err
+		}
+
+		if op == OP_CHECKMULTISIGVERIFY {
+			if err := vm.verify(); err != nil {
+				return conditions, err
+			}
+		}
+
+	default:
+		return conditions, fmt.Errorf("%w %#02x", ErrInvalidOpcode, op)
+	}
+
+	return conditions, nil
+}
+
+func (vm *engine) push(item []byte) {
+	vm.stack = append(vm.stack, item)
+}
+
+func (vm *engine) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+
+	item := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return item, nil
+}
+
+// Returns the item depth positions below the top of the stack
+func (vm *engine) peek(depth int) ([]byte, error) {
+	if len(vm.stack) <= depth {
+		return nil, ErrStackUnderflow
+	}
+
+	return vm.stack[len(vm.stack)-1-depth], nil
+}
+
+func (vm *engine) popInt() (int, error) {
+	item, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := makeScriptNum(item, maxNumLength)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// Pops the top item and fails unless it is true
+func (vm *engine) verify() error {
+	item, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	if !asBool(item) {
+		return ErrVerifyFailed
+	}
+
+	return nil
+}
+
+func hashItem(op byte, item []byte) []byte {
+	switch op {
+	case OP_SHA256:
+		hash := sha256.Sum256(item)
+		return hash[:]
+	case OP_HASH256:
+		hash := share.DoubleSha256(item)
+		return hash[:]
+	default:
+		return Hash160(item)
+	}
+}
+
+// Returns RIPEMD160(SHA256(data)), the hash public keys and scripts are committed to
+func Hash160(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	hasher := ripemd160.New()
+	hasher.Write(hash[:])
+
+	return hasher.Sum(nil)
+}
+
+func (vm *engine) checkEncoding(signature, publicKey []byte) error {
+	if vm.flags&VerifyStrictEncoding == 0 {
+		return nil
+	}
+
+	if len(publicKey) == share.SchnorrPublicKeyLength {
+		if _, err := share.ParseSchnorrPublicKey(publicKey); err != nil {
+			return fmt.Errorf("%w: %s", ErrStrictEncoding, err)
+		}
+		if len(signature) != 0 && len(signature) != share.SchnorrSignatureLength {
+			return fmt.Errorf("%w: %s", ErrStrictEncoding, share.ErrInvalidSchnorrSig)
+		}
+		return nil
+	}
+
+	key, err := share.ParsePublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrStrictEncoding, err)
+	}
+
+	if len(signature) == 0 {
+		return nil
+	}
+
+	parsed, err := share.SignatureFromBytes(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrStrictEncoding, err)
+	}
+
+	if !parsed.IsLowS(key.Curve) {
+		return fmt.Errorf("%w: %s", ErrStrictEncoding, share.ErrHighS)
+	}
+
+	return nil
+}
+
+// <lock time> OP_CHECKLOCKTIMEVERIFY and <sequence> OP_CHECKSEQUENCEVERIFY fail
+// unless the spending transaction is locked at least as long as the argument.
+// The argument stays on the stack, so they are usually followed by OP_DROP.
+func (vm *engine) checkLock(op byte) error {
+	item, err := vm.peek(0)
+	if err != nil {
+		return err
+	}
+
+	lock, err := makeScriptNum(item, maxLockTimeLength)
+	if err != nil {
+		return err
+	}
+
+	if lock < 0 {
+		return ErrNegativeLockTime
+	}
+
+	satisfied := false
+	if op == OP_CHECKLOCKTIMEVERIFY {
+		satisfied = vm.checker.CheckLockTime(int64(lock))
+	} else {
+		satisfied = vm.checker.CheckSequence(int64(lock))
+	}
+
+	if !satisfied {
+		return ErrUnsatisfiedLock
+	}
+
+	return nil
+}
+
+// <signature> <public key> OP_CHECKSIG
+//
+// An empty signature pushes false, a non empty signature must be valid.
+func (vm *engine) checkSig() error {
+	publicKey, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	signature, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	if err := vm.checkEncoding(signature, publicKey); err != nil {
+		return err
+	}
+
+	if len(signature) == 0 {
+		vm.push(fromBool(false))
+		return nil
+	}
+
+	if !vm.checker.CheckSig(signature, publicKey, true) {
+		return ErrNullFail
+	}
+
+	vm.push(fromBool(true))
+	return nil
+}
+
+// OP_0 <signature 1> ... <signature m> <m> <public key 1> ... <public key n> <n> OP_CHECKMULTISIG
+//
+// Signatures must be in the same order as the public keys they match. The extra
+// OP_0 is consumed as in Bitcoin, where an off by one bug made it required; it
+// must be empty here. Pushes false only if every signature is empty.
+func (vm *engine) checkMultiSig() error {
+	keyCount, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+
+	if keyCount < 0 || keyCount > MaxPublicKeysMulti {
+		return fmt.Errorf("invalid public key count %d", keyCount)
+	}
+
+	publicKeys := make([][]byte, keyCount)
+	for i := keyCount - 1; i >= 0; i-- {
+		if publicKeys[i], err = vm.pop(); err != nil {
+			return err
+		}
+	}
+
+	sigCount, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+
+	if sigCount < 0 || sigCount > keyCount {
+		return fmt.Errorf("invalid signature count %d for %d public keys", sigCount, keyCount)
+	}
+
+	signatures := make([][]byte, sigCount)
+	for i := sigCount - 1; i >= 0; i-- {
+		if signatures[i], err = vm.pop(); err != nil {
+			return err
+		}
+	}
+
+	dummy, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	if len(dummy) != 0 {
+		return errors.New("multisig dummy item is not empty")
+	}
+
+	success := true
+	keyIdx := 0
+	for _, signature := range signatures {
+		matched := false
+		for keyIdx < len(publicKeys) && !matched {
+			if err := vm.checkEncoding(signature, publicKeys[keyIdx]); err != nil {
+				return err
+			}
+
+			matched = len(signature) != 0 && vm.checker.CheckSig(signature, publicKeys[keyIdx], false)
+			keyIdx++
+		}
+
+		if !matched {
+			success = false
+			break
+		}
+	}
+
+	if !success {
+		for _, signature := range signatures {
+			if len(signature) != 0 {
+				return ErrNullFail
+			}
+		}
+	}
+
+	vm.push(fromBool(success))
+	return nil
+}
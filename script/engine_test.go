@@ -0,0 +1,207 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeChecker accepts a signature equal to "sig" followed by the public key and
+// lock times up to 100
+type fakeChecker struct{}
+
+func (fakeChecker) CheckSig(signature, publicKey []byte, deferrable bool) bool {
+	return bytes.Equal(signature, append([]byte("sig"), publicKey...))
+}
+
+func (fakeChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= 100
+}
+
+func (fakeChecker) CheckSequence(sequence int64) bool {
+	return sequence <= 100
+}
+
+func fakeSig(publicKey []byte) []byte {
+	return append([]byte("sig"), publicKey...)
+}
+
+func mustScript(t *testing.T, b *Builder) []byte {
+	s, err := b.Script()
+	assert.NoError(t, err)
+
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	publicKey := bytes.Repeat([]byte{0x02}, 33)
+	otherKey := bytes.Repeat([]byte{0x03}, 33)
+
+	t.Run("should unlock a pay to public key hash output", func(t *testing.T) {
+		locking, err := PayToPubKeyHash(Hash160(publicKey))
+		assert.NoError(t, err)
+		assert.Equal(t, Hash160(publicKey), ExtractPubKeyHash(locking))
+
+		unlocking, err := PayToPubKeyHashUnlock(fakeSig(publicKey), publicKey)
+		assert.NoError(t, err)
+		assert.NoError(t, Verify(unlocking, locking, fakeChecker{}, 0))
+
+		unlocking, err = PayToPubKeyHashUnlock(fakeSig(otherKey), otherKey)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, Verify(unlocking, locking, fakeChecker{}, 0), ErrVerifyFailed)
+	})
+
+	t.Run("should fail a non empty invalid signature", func(t *testing.T) {
+		locking := mustScript(t, NewBuilder().AddData(publicKey).AddOp(OP_CHECKSIG))
+
+		unlocking := mustScript(t, NewBuilder().AddData(fakeSig(otherKey)))
+		assert.ErrorIs(t, Verify(unlocking, locking, fakeChecker{}, 0), ErrNullFail)
+
+		unlocking = mustScript(t, NewBuilder().AddData([]byte{}))
+		assert.ErrorIs(t, Verify(unlocking, locking, fakeChecker{}, 0), ErrScriptFailed)
+	})
+
+	t.Run("should take the branch selected by OP_IF", func(t *testing.T) {
+		locking := mustScript(t, NewBuilder().
+			AddOp(OP_IF).AddInt64(2).AddOp(OP_ELSE).AddInt64(3).AddOp(OP_ENDIF).
+			AddInt64(3).AddOp(OP_EQUAL))
+
+		assert.ErrorIs(t, Verify([]byte{OP_1}, locking, fakeChecker{}, 0), ErrScriptFailed)
+		assert.NoError(t, Verify([]byte{OP_0}, locking, fakeChecker{}, 0))
+
+		assert.ErrorIs(t, Verify(nil, []byte{OP_1, OP_IF}, fakeChecker{}, 0), ErrUnbalancedIf)
+	})
+
+	t.Run("should unlock a 2 of 3 multisig with signatures in key order", func(t *testing.T) {
+		keys := [][]byte{publicKey, otherKey, bytes.Repeat([]byte{0x04}, 33)}
+
+		builder := NewBuilder().AddInt64(2)
+		for _, key := range keys {
+			builder.AddData(key)
+		}
+		locking := mustScript(t, builder.AddInt64(3).AddOp(OP_CHECKMULTISIG))
+
+		unlocking := mustScript(t, NewBuilder().AddOp(OP_0).AddData(fakeSig(keys[0])).AddData(fakeSig(keys[2])))
+		assert.NoError(t, Verify(unlocking, locking, fakeChecker{}, 0))
+
+		unlocking = mustScript(t, NewBuilder().AddOp(OP_0).AddData(fakeSig(keys[2])).AddData(fakeSig(keys[0])))
+		assert.ErrorIs(t, Verify(unlocking, locking, fakeChecker{}, 0), ErrNullFail)
+	})
+
+	t.Run("should run the redeem script of a pay to script hash output", func(t *testing.T) {
+		redeemScript := mustScript(t, NewBuilder().AddData(publicKey).AddOp(OP_CHECKSIG))
+		locking, err := PayToScriptHash(Hash160(redeemScript))
+		assert.NoError(t, err)
+		assert.Equal(t, ScriptHash, Classify(locking))
+
+		unlocking, err := PayToScriptHashUnlock(mustScript(t, NewBuilder().AddData(fakeSig(publicKey))), redeemScript)
+		assert.NoError(t, err)
+		assert.NoError(t, Verify(unlocking, locking, fakeChecker{}, 0))
+
+		// The redeem script must satisfy itself, not only match the hash
+		unlocking, err = PayToScriptHashUnlock(mustScript(t, NewBuilder().AddData([]byte{})), redeemScript)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, Verify(unlocking, locking, fakeChecker{}, 0), ErrScriptFailed)
+
+		other := mustScript(t, NewBuilder().AddOp(OP_1))
+		unlocking, err = PayToScriptHashUnlock(nil, other)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, Verify(unlocking, locking, fakeChecker{}, 0), ErrScriptFailed)
+	})
+
+	t.Run("should check lock time arguments with the checker", func(t *testing.T) {
+		locking := func(lock int64) []byte {
+			return mustScript(t, NewBuilder().AddInt64(lock).AddOps(OP_CHECKLOCKTIMEVERIFY, OP_DROP, OP_1))
+		}
+
+		assert.NoError(t, Verify(nil, locking(100), fakeChecker{}, 0))
+		assert.ErrorIs(t, Verify(nil, locking(101), fakeChecker{}, 0), ErrUnsatisfiedLock)
+		assert.ErrorIs(t, Verify(nil, locking(-1), fakeChecker{}, 0), ErrNegativeLockTime)
+		assert.ErrorIs(t, Verify(nil, []byte{OP_CHECKSEQUENCEVERIFY}, fakeChecker{}, 0), ErrStackUnderflow)
+	})
+
+	t.Run("should claim an HTLC with the preimage or refund it after the lock time", func(t *testing.T) {
+		preimage := bytes.Repeat([]byte{0x07}, HashLockLength)
+		hashLock := sha256.Sum256(preimage)
+
+		htlc := &HTLC{
+			HashLock:           hashLock[:],
+			ReceiverPubKeyHash: Hash160(publicKey),
+			RefundPubKeyHash:   Hash160(otherKey),
+			LockTime:           100,
+		}
+		redeemScript, err := HTLCScript(htlc)
+		assert.NoError(t, err)
+
+		extracted, err := ExtractHTLC(redeemScript)
+		assert.NoError(t, err)
+		assert.Equal(t, htlc, extracted)
+
+		locking, err := PayToScriptHash(Hash160(redeemScript))
+		assert.NoError(t, err)
+
+		claim := func(preimage []byte) []byte {
+			unlocking, err := HTLCClaimUnlock(fakeSig(publicKey), publicKey, preimage)
+			assert.NoError(t, err)
+			unlocking, err = PayToScriptHashUnlock(unlocking, redeemScript)
+			assert.NoError(t, err)
+			return unlocking
+		}
+
+		assert.NoError(t, Verify(claim(preimage), locking, fakeChecker{}, 0))
+		assert.Equal(t, preimage, ExtractHTLCPreimage(claim(preimage), htlc))
+		assert.ErrorIs(t, Verify(claim(bytes.Repeat([]byte{0x08}, HashLockLength)), locking, fakeChecker{}, 0), ErrVerifyFailed)
+
+		refund, err := HTLCRefundUnlock(fakeSig(otherKey), otherKey)
+		assert.NoError(t, err)
+		refund, err = PayToScriptHashUnlock(refund, redeemScript)
+		assert.NoError(t, err)
+		assert.NoError(t, Verify(refund, locking, fakeChecker{}, 0))
+		assert.Nil(t, ExtractHTLCPreimage(refund, htlc))
+
+		htlc.LockTime = 101
+		redeemScript, err = HTLCScript(htlc)
+		assert.NoError(t, err)
+		locking, err = PayToScriptHash(Hash160(redeemScript))
+		assert.NoError(t, err)
+		refund, err = HTLCRefundUnlock(fakeSig(otherKey), otherKey)
+		assert.NoError(t, err)
+		refund, err = PayToScriptHashUnlock(refund, redeemScript)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, Verify(refund, locking, fakeChecker{}, 0), ErrUnsatisfiedLock)
+	})
+
+	t.Run("should make OP_RETURN outputs unspendable", func(t *testing.T) {
+		locking := mustScript(t, NewBuilder().AddOp(OP_RETURN).AddData([]byte("data")))
+
+		assert.ErrorIs(t, Verify([]byte{OP_1}, locking, fakeChecker{}, 0), ErrEarlyReturn)
+	})
+
+	t.Run("should reject unlocking scripts that are not push only", func(t *testing.T) {
+		unlocking := []byte{OP_1, OP_DUP}
+
+		assert.ErrorIs(t, Verify(unlocking, []byte{OP_1}, fakeChecker{}, 0), ErrUnlockingNotPushes)
+	})
+
+	t.Run("should reject non minimally encoded numbers", func(t *testing.T) {
+		unlocking := mustScript(t, NewBuilder().AddData([]byte{0x01, 0x00}))
+
+		assert.ErrorIs(t, Verify(unlocking, []byte{OP_NOT}, fakeChecker{}, 0), ErrNonMinimalNumber)
+	})
+
+	t.Run("should reject malformed pushes", func(t *testing.T) {
+		assert.ErrorIs(t, Verify(nil, []byte{OP_DATA_1 + 4, 0x01}, fakeChecker{}, 0), ErrMalformedScript)
+	})
+}
+
+func TestScriptNum(t *testing.T) {
+	t.Run("should round trip numbers through their minimal encoding", func(t *testing.T) {
+		for _, n := range []int64{0, 1, -1, 127, 128, -128, 255, 256, -32768, 1 << 30} {
+			decoded, err := makeScriptNum(scriptNum(n).Bytes(), 5)
+			assert.NoError(t, err)
+			assert.Equal(t, scriptNum(n), decoded)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// HashLockLength is the size of an HTLC hash lock, the SHA-256 digest of the
+// secret preimage. Preimages are 32 bytes as well, which keeps the contract
+// compatible with the hash time locked contracts of other chains.
+const HashLockLength = 32
+
+var ErrInvalidHTLC = errors.New("invalid hash time locked contract")
+
+// HTLC holds the terms of a hash time locked contract: the receiver can spend
+// the output by revealing the preimage of HashLock, the refund key can spend it
+// once LockTime is reached
+type HTLC struct {
+	HashLock           []byte
+	ReceiverPubKeyHash []byte
+	RefundPubKeyHash   []byte
+	LockTime           int64 // block height or unix timestamp, as a transaction lock time
+}
+
+// Returns the script of the contract:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <hash lock> OP_EQUALVERIFY
+//	    OP_DUP OP_HASH160 <receiver public key hash>
+//	OP_ELSE
+//	    <lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_HASH160 <refund public key hash>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+//
+// It is meant to be the redeem script of a pay to script hash output.
+func HTLCScript(htlc *HTLC) ([]byte, error) {
+	if len(htlc.HashLock) != HashLockLength {
+		return nil, fmt.Errorf("%w: hash lock must be %d bytes", ErrInvalidHTLC, HashLockLength)
+	}
+
+	if len(htlc.ReceiverPubKeyHash) != PubKeyHashLength || len(htlc.RefundPubKeyHash) != PubKeyHashLength {
+		return nil, ErrInvalidPubKeyHash
+	}
+
+	if htlc.LockTime <= 0 || htlc.LockTime > 0xFFFFFFFF {
+		return nil, fmt.Errorf("%w: lock time %d out of range", ErrInvalidHTLC, htlc.LockTime)
+	}
+
+	return NewBuilder().
+		AddOps(OP_IF, OP_SIZE).AddInt64(HashLockLength).AddOps(OP_EQUALVERIFY, OP_SHA256).
+		AddData(htlc.HashLock).AddOp(OP_EQUALVERIFY).
+		AddOps(OP_DUP, OP_HASH160).AddData(htlc.ReceiverPubKeyHash).
+		AddOp(OP_ELSE).
+		AddInt64(htlc.LockTime).AddOps(OP_CHECKLOCKTIMEVERIFY, OP_DROP).
+		AddOps(OP_DUP, OP_HASH160).AddData(htlc.RefundPubKeyHash).
+		AddOp(OP_ENDIF).
+		AddOps(OP_EQUALVERIFY, OP_CHECKSIG).
+		Script()
+}
+
+// Returns the terms of a script built by HTLCScript
+func ExtractHTLC(script []byte) (*HTLC, error) {
+	instructions, err := Parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(instructions) != 20 {
+		return nil, ErrInvalidHTLC
+	}
+
+	lockTime := instructions[11]
+	var value scriptNum
+	if isSmallInt(lockTime.Op) {
+		value = smallIntValue(lockTime.Op)
+	} else if value, err = makeScriptNum(lockTime.Data, maxLockTimeLength); err != nil {
+		return nil, ErrInvalidHTLC
+	}
+
+	htlc := &HTLC{
+		HashLock:           instructions[5].Data,
+		ReceiverPubKeyHash: instructions[9].Data,
+		RefundPubKeyHash:   instructions[16].Data,
+		LockTime:           int64(value),
+	}
+
+	// The terms only describe the script if they rebuild it exactly
+	expected, err := HTLCScript(htlc)
+	if err != nil || !bytes.Equal(expected, script) {
+		return nil, ErrInvalidHTLC
+	}
+
+	return htlc, nil
+}
+
+// Returns <signature> <public key> <preimage> OP_1, which takes the claim branch
+// of an HTLC
+func HTLCClaimUnlock(signature, publicKey, preimage []byte) ([]byte, error) {
+	return NewBuilder().AddData(signature).AddData(publicKey).AddData(preimage).AddOp(OP_1).Script()
+}
+
+// Returns <signature> <public key> OP_0, which takes the refund branch of an HTLC
+func HTLCRefundUnlock(signature, publicKey []byte) ([]byte, error) {
+	return NewBuilder().AddData(signature).AddData(publicKey).AddOp(OP_0).Script()
+}
+
+// Returns the preimage revealed by the unlocking script of a pay to script hash
+// input claiming htlc, or nil if the input does not claim it
+func ExtractHTLCPreimage(unlocking []byte, htlc *HTLC) []byte {
+	items, err := PushedData(unlocking)
+	if err != nil || len(items) != 5 {
+		return nil
+	}
+
+	preimage := items[2]
+	if hash := sha256.Sum256(preimage); len(preimage) != HashLockLength || !bytes.Equal(hash[:], htlc.HashLock) {
+		return nil
+	}
+
+	return preimage
+}
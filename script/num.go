@@ -0,0 +1,98 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Maximum length of a stack item read as a number
+const maxNumLength = 4
+
+var ErrNonMinimalNumber = errors.New("number is not minimally encoded")
+
+// scriptNum is a number on the script stack. Numbers are encoded little endian
+// with the sign in the most significant bit of the last byte, and zero encodes
+// as the empty item, as in Bitcoin.
+type scriptNum int64
+
+func (n scriptNum) Bytes() []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	magnitude := uint64(n)
+	if negative {
+		magnitude = uint64(-n)
+	}
+
+	b := make([]byte, 0, 9)
+	for magnitude > 0 {
+		b = append(b, byte(magnitude&0xFF))
+		magnitude >>= 8
+	}
+
+	// The sign bit needs its own byte when the magnitude uses the top bit
+	if b[len(b)-1]&0x80 != 0 {
+		if negative {
+			b = append(b, 0x80)
+		} else {
+			b = append(b, 0x00)
+		}
+	} else if negative {
+		b[len(b)-1] |= 0x80
+	}
+
+	return b
+}
+
+// Reads a minimally encoded number of at most maxLength bytes
+func makeScriptNum(b []byte, maxLength int) (scriptNum, error) {
+	if len(b) > maxLength {
+		return 0, fmt.Errorf("number of %d bytes exceeds maximum of %d", len(b), maxLength)
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	// The last byte may only be 0x00 or 0x80 if the byte before needs its top bit
+	if b[len(b)-1]&0x7F == 0 && (len(b) == 1 || b[len(b)-2]&0x80 == 0) {
+		return 0, ErrNonMinimalNumber
+	}
+
+	var n int64
+	for i, value := range b {
+		n |= int64(value) << uint(8*i)
+	}
+
+	if b[len(b)-1]&0x80 != 0 {
+		n &= ^(int64(0x80) << uint(8*(len(b)-1)))
+		n = -n
+	}
+
+	return scriptNum(n), nil
+}
+
+// Reads a stack item as a boolean: false is any encoding of zero, including negative zero
+func asBool(b []byte) bool {
+	for i, value := range b {
+		if value != 0 {
+			// Negative zero is 0x80 in the last byte
+			if i == len(b)-1 && value == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{0x01}
+	}
+
+	return []byte{}
+}
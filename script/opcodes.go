@@ -0,0 +1,101 @@
+package script
+
+import "fmt"
+
+// Opcodes of the script language. Their values follow Bitcoin's so scripts read
+// the same in tools that know Bitcoin script.
+const (
+	OP_0            byte = 0x00 // pushes an empty item
+	OP_DATA_1       byte = 0x01 // 0x01 - 0x4B push the next 1 - 75 bytes
+	OP_DATA_75      byte = 0x4B
+	OP_PUSHDATA1    byte = 0x4C // the next byte is the number of bytes to push
+	OP_PUSHDATA2    byte = 0x4D // the next 2 bytes (little endian) are the number of bytes to push
+	OP_1NEGATE      byte = 0x4F
+	OP_1            byte = 0x51 // 0x51 - 0x60 push the numbers 1 - 16
+	OP_16           byte = 0x60
+	OP_NOP          byte = 0x61
+	OP_IF           byte = 0x63
+	OP_NOTIF        byte = 0x64
+	OP_ELSE         byte = 0x67
+	OP_ENDIF        byte = 0x68
+	OP_VERIFY       byte = 0x69
+	OP_RETURN       byte = 0x6A
+	OP_TOALTSTACK   byte = 0x6B
+	OP_FROMALTSTACK byte = 0x6C
+	OP_DROP         byte = 0x75
+	OP_DUP          byte = 0x76
+	OP_NIP          byte = 0x77
+	OP_OVER         byte = 0x78
+	OP_SWAP         byte = 0x7C
+	OP_SIZE         byte = 0x82
+	OP_EQUAL        byte = 0x87
+	OP_EQUALVERIFY  byte = 0x88
+	OP_NOT          byte = 0x91
+	OP_SHA256       byte = 0xA8
+	OP_HASH160      byte = 0xA9
+	OP_HASH256      byte = 0xAA
+
+	OP_CHECKSIG            byte = 0xAC
+	OP_CHECKSIGVERIFY      byte = 0xAD
+	OP_CHECKMULTISIG       byte = 0xAE
+	OP_CHECKMULTISIGVERIFY byte = 0xAF
+	OP_CHECKLOCKTIMEVERIFY byte = 0xB1
+	OP_CHECKSEQUENCEVERIFY byte = 0xB2
+)
+
+var opcodeNames = map[byte]string{
+	OP_0:            "OP_0",
+	OP_PUSHDATA1:    "OP_PUSHDATA1",
+	OP_PUSHDATA2:    "OP_PUSHDATA2",
+	OP_1NEGATE:      "OP_1NEGATE",
+	OP_NOP:          "OP_NOP",
+	OP_IF:           "OP_IF",
+	OP_NOTIF:        "OP_NOTIF",
+	OP_ELSE:         "OP_ELSE",
+	OP_ENDIF:        "OP_ENDIF",
+	OP_VERIFY:       "OP_VERIFY",
+	OP_RETURN:       "OP_RETURN",
+	OP_TOALTSTACK:   "OP_TOALTSTACK",
+	OP_FROMALTSTACK: "OP_FROMALTSTACK",
+	OP_DROP:         "OP_DROP",
+	OP_DUP:          "OP_DUP",
+	OP_NIP:          "OP_NIP",
+	OP_OVER:         "OP_OVER",
+	OP_SWAP:         "OP_SWAP",
+	OP_SIZE:         "OP_SIZE",
+	OP_EQUAL:        "OP_EQUAL",
+	OP_EQUALVERIFY:  "OP_EQUALVERIFY",
+	OP_NOT:          "OP_NOT",
+	OP_SHA256:       "OP_SHA256",
+	OP_HASH160:      "OP_HASH160",
+	OP_HASH256:      "OP_HASH256",
+
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+}
+
+func OpcodeName(op byte) string {
+	if name, exists := opcodeNames[op]; exists {
+		return name
+	}
+
+	if op >= OP_1 && op <= OP_16 {
+		return fmt.Sprintf("OP_%d", op-OP_1+1)
+	}
+
+	return fmt.Sprintf("OP_UNKNOWN_%#02x", op)
+}
+
+// Reports whether op only pushes data onto the stack
+func isPushOp(op byte) bool {
+	return op <= OP_PUSHDATA2 || op == OP_1NEGATE || (op >= OP_1 && op <= OP_16)
+}
+
+// Reports whether op is a conditional, which executes even in a branch that is not taken
+func isConditionalOp(op byte) bool {
+	return op == OP_IF || op == OP_NOTIF || op == OP_ELSE || op == OP_ENDIF
+}
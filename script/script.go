@@ -0,0 +1,135 @@
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxScriptSize      = 10_000
+	MaxStackItemSize   = 520
+	MaxStackSize       = 1_000
+	MaxOpsPerScript    = 201 // non push opcodes
+	MaxPublicKeysMulti = 20
+)
+
+var ErrMalformedScript = errors.New("malformed script")
+
+// Instruction is a parsed opcode together with the data it pushes, if any
+type Instruction struct {
+	Op   byte
+	Data []byte
+}
+
+// Splits a script into its instructions
+func Parse(script []byte) ([]*Instruction, error) {
+	instructions := make([]*Instruction, 0)
+
+	for pc := 0; pc < len(script); {
+		op := script[pc]
+		pc++
+
+		length := 0
+		switch {
+		case op >= OP_DATA_1 && op <= OP_DATA_75:
+			length = int(op)
+		case op == OP_PUSHDATA1:
+			if pc+1 > len(script) {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA1 length", ErrMalformedScript)
+			}
+			length = int(script[pc])
+			pc++
+		case op == OP_PUSHDATA2:
+			if pc+2 > len(script) {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA2 length", ErrMalformedScript)
+			}
+			length = int(binary.LittleEndian.Uint16(script[pc : pc+2]))
+			pc += 2
+		}
+
+		if pc+length > len(script) {
+			return nil, fmt.Errorf("%w: push of %d bytes exceeds script", ErrMalformedScript, length)
+		}
+
+		instruction := &Instruction{Op: op}
+		if op <= OP_PUSHDATA2 {
+			instruction.Data = script[pc : pc+length]
+		}
+		pc += length
+
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, nil
+}
+
+// Reports whether the script only pushes data
+func IsPushOnly(script []byte) bool {
+	instructions, err := Parse(script)
+	if err != nil {
+		return false
+	}
+
+	for _, instruction := range instructions {
+		if !isPushOp(instruction.Op) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the data pushed by a push only script
+func PushedData(script []byte) ([][]byte, error) {
+	instructions, err := Parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([][]byte, 0, len(instructions))
+	for _, instruction := range instructions {
+		switch {
+		case instruction.Op <= OP_PUSHDATA2:
+			items = append(items, instruction.Data)
+		case instruction.Op == OP_1NEGATE || (instruction.Op >= OP_1 && instruction.Op <= OP_16):
+			items = append(items, smallIntValue(instruction.Op).Bytes())
+		default:
+			return nil, fmt.Errorf("script is not push only: %s", OpcodeName(instruction.Op))
+		}
+	}
+
+	return items, nil
+}
+
+// Returns a human readable form of the script, e.g. "OP_DUP OP_HASH160 <hex> OP_EQUALVERIFY OP_CHECKSIG"
+func Disassemble(script []byte) string {
+	instructions, err := Parse(script)
+	if err != nil {
+		return fmt.Sprintf("[error: %s]", err)
+	}
+
+	parts := make([]string, 0, len(instructions))
+	for _, instruction := range instructions {
+		if instruction.Op > OP_0 && instruction.Op <= OP_PUSHDATA2 {
+			parts = append(parts, hex.EncodeToString(instruction.Data))
+			continue
+		}
+		parts = append(parts, OpcodeName(instruction.Op))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func smallIntValue(op byte) scriptNum {
+	if op == OP_1NEGATE {
+		return -1
+	}
+	if op == OP_0 {
+		return 0
+	}
+
+	return scriptNum(op - OP_1 + 1)
+}
@@ -0,0 +1,290 @@
+package script
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/jenlesamuel/magcoin/share"
+)
+
+const (
+	PubKeyHashLength = 20
+	MaxNullDataSize  = 80 // bytes a standard data carrier output may hold
+)
+
+// ScriptClass is the kind of a standard locking script
+type ScriptClass int
+
+const (
+	NonStandard ScriptClass = iota
+	PubKeyHash
+	MultiSig
+	ScriptHash
+	NullData
+)
+
+var (
+	ErrInvalidPubKeyHash = errors.New("public key hash must be 20 bytes")
+	ErrInvalidMultiSig   = errors.New("invalid multisig parameters")
+	ErrNullDataTooLarge  = fmt.Errorf("data carrier output holds more than %d bytes", MaxNullDataSize)
+)
+
+// Returns OP_DUP OP_HASH160 <public key hash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHash(pkHash []byte) ([]byte, error) {
+	if len(pkHash) != PubKeyHashLength {
+		return nil, ErrInvalidPubKeyHash
+	}
+
+	return NewBuilder().
+		AddOps(OP_DUP, OP_HASH160).
+		AddData(pkHash).
+		AddOps(OP_EQUALVERIFY, OP_CHECKSIG).
+		Script()
+}
+
+// Returns <signature> <public key>, the unlocking script of a pay to public key hash output
+func PayToPubKeyHashUnlock(signature, publicKey []byte) ([]byte, error) {
+	return NewBuilder().AddData(signature).AddData(publicKey).Script()
+}
+
+func isPubKeyHash(script []byte) bool {
+	return len(script) == 25 &&
+		script[0] == OP_DUP &&
+		script[1] == OP_HASH160 &&
+		script[2] == PubKeyHashLength &&
+		script[23] == OP_EQUALVERIFY &&
+		script[24] == OP_CHECKSIG
+}
+
+// Returns the public key hash a pay to public key hash script locks to, or nil
+func ExtractPubKeyHash(script []byte) []byte {
+	if !isPubKeyHash(script) {
+		return nil
+	}
+
+	return script[3:23]
+}
+
+// Returns <m> <public key 1> ... <public key n> <n> OP_CHECKMULTISIG, which is
+// unlocked by m signatures from the n public keys. Public keys must be 33 bytes
+// compressed keys; their order is the order signatures must follow.
+func MultiSigScript(m int, publicKeys [][]byte) ([]byte, error) {
+	n := len(publicKeys)
+	if m < 1 || m > n || n > 16 {
+		return nil, fmt.Errorf("%w: %d of %d keys, 1 <= m <= n <= 16", ErrInvalidMultiSig, m, n)
+	}
+
+	builder := NewBuilder().AddInt64(int64(m))
+	for idx, publicKey := range publicKeys {
+		if len(publicKey) != share.CompressedPublicKeyLength {
+			return nil, fmt.Errorf("%w: public key %d is not a compressed key", ErrInvalidMultiSig, idx)
+		}
+		builder.AddData(publicKey)
+	}
+
+	return builder.AddInt64(int64(n)).AddOp(OP_CHECKMULTISIG).Script()
+}
+
+// Returns the number of required signatures and the public keys of a multisig
+// script, or ok false if script is not a multisig script
+func ExtractMultiSig(script []byte) (m int, publicKeys [][]byte, ok bool) {
+	instructions, err := Parse(script)
+	if err != nil || len(instructions) < 4 {
+		return 0, nil, false
+	}
+
+	first, last := instructions[0], instructions[len(instructions)-1]
+	count := instructions[len(instructions)-2]
+	if last.Op != OP_CHECKMULTISIG || !isSmallInt(first.Op) || !isSmallInt(count.Op) {
+		return 0, nil, false
+	}
+
+	m, n := int(smallIntValue(first.Op)), int(smallIntValue(count.Op))
+	keys := instructions[1 : len(instructions)-2]
+	if m < 1 || m > n || n != len(keys) {
+		return 0, nil, false
+	}
+
+	publicKeys = make([][]byte, 0, n)
+	for _, key := range keys {
+		if key.Op != share.CompressedPublicKeyLength {
+			return 0, nil, false
+		}
+		publicKeys = append(publicKeys, key.Data)
+	}
+
+	return m, publicKeys, true
+}
+
+// Returns OP_0 <signature 1> ... <signature m>, the unlocking script of a multisig
+// output. Signatures must be in the order of the public keys they match.
+func MultiSigUnlock(signatures [][]byte) ([]byte, error) {
+	builder := NewBuilder().AddOp(OP_0)
+	for _, signature := range signatures {
+		builder.AddData(signature)
+	}
+
+	return builder.Script()
+}
+
+// Returns OP_HASH160 <script hash> OP_EQUAL, which is unlocked by pushes
+// satisfying a redeem script followed by the redeem script itself
+func PayToScriptHash(scriptHash []byte) ([]byte, error) {
+	if len(scriptHash) != PubKeyHashLength {
+		return nil, errors.New("script hash must be 20 bytes")
+	}
+
+	return NewBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// Reports whether script is a pay to script hash locking script
+func IsScriptHash(script []byte) bool {
+	return len(script) == 23 &&
+		script[0] == OP_HASH160 &&
+		script[1] == PubKeyHashLength &&
+		script[22] == OP_EQUAL
+}
+
+// Returns the redeem script hash a pay to script hash script locks to, or nil
+func ExtractScriptHash(script []byte) []byte {
+	if !IsScriptHash(script) {
+		return nil
+	}
+
+	return script[2:22]
+}
+
+// Returns the unlocking script of a pay to script hash output: the pushes of
+// unlocking, the unlocking script satisfying redeemScript, then redeemScript
+func PayToScriptHashUnlock(unlocking, redeemScript []byte) ([]byte, error) {
+	if !IsPushOnly(unlocking) {
+		return nil, ErrUnlockingNotPushes
+	}
+
+	builder := NewBuilder().AddOps(unlocking...)
+
+	return builder.AddData(redeemScript).Script()
+}
+
+// Returns OP_RETURN <data>, a provably unspendable output carrying data
+func NullDataScript(data []byte) ([]byte, error) {
+	if len(data) > MaxNullDataSize {
+		return nil, ErrNullDataTooLarge
+	}
+
+	return NewBuilder().AddOp(OP_RETURN).AddData(data).Script()
+}
+
+// Returns the data carried by a standard OP_RETURN <data> script, or nil
+func ExtractNullData(script []byte) []byte {
+	if len(script) == 0 || script[0] != OP_RETURN {
+		return nil
+	}
+
+	instructions, err := Parse(script[1:])
+	if err != nil || len(instructions) != 1 || instructions[0].Op > OP_PUSHDATA2 {
+		return nil
+	}
+
+	data := instructions[0].Data
+	if len(data) > MaxNullDataSize {
+		return nil
+	}
+	if data == nil {
+		data = []byte{}
+	}
+
+	return data
+}
+
+// Reports whether no unlocking script can spend an output locked by script, so
+// the output never needs to be tracked as unspent
+func IsUnspendable(script []byte) bool {
+	return (len(script) > 0 && script[0] == OP_RETURN) || len(script) > MaxScriptSize
+}
+
+func isSmallInt(op byte) bool {
+	return op >= OP_1 && op <= OP_16
+}
+
+func Classify(script []byte) ScriptClass {
+	if isPubKeyHash(script) {
+		return PubKeyHash
+	}
+
+	if _, _, ok := ExtractMultiSig(script); ok {
+		return MultiSig
+	}
+
+	if IsScriptHash(script) {
+		return ScriptHash
+	}
+
+	if ExtractNullData(script) != nil {
+		return NullData
+	}
+
+	return NonStandard
+}
+
+// Returns the locking script paying to address
+func PayToAddress(address string) ([]byte, error) {
+	if share.IsMultiSigAddress(address) {
+		lockingScript, err := share.MultiSigScriptFromAddress(address)
+		if err != nil {
+			return nil, err
+		}
+
+		if Classify(lockingScript) != MultiSig {
+			return nil, ErrInvalidMultiSig
+		}
+
+		return lockingScript, nil
+	}
+
+	if share.IsScriptHashAddress(address) {
+		scriptHash, err := share.ScriptHashFromAddress(address)
+		if err != nil {
+			return nil, err
+		}
+
+		return PayToScriptHash(scriptHash)
+	}
+
+	if !share.ValidateAddress(address) {
+		return nil, share.ErrInvalidAddress
+	}
+
+	return PayToPubKeyHash(share.PublicKeyHashFromAddress(address))
+}
+
+// Returns the address an output locked by script pays to, the inverse of
+// PayToAddress, or an empty string for scripts without an address
+func ExtractAddress(script []byte) string {
+	if pkHash := ExtractPubKeyHash(script); pkHash != nil {
+		return share.AddressFromPublicKeyHash(pkHash)
+	}
+
+	if scriptHash := ExtractScriptHash(script); scriptHash != nil {
+		address, _ := share.AddressFromScriptHash(scriptHash)
+		return address
+	}
+
+	if Classify(script) == MultiSig {
+		return share.AddressFromMultiSigScript(script)
+	}
+
+	return ""
+}
+
+// Reports whether the locking script is one of the standard templates
+func IsStandard(script []byte) bool {
+	return Classify(script) != NonStandard
+}
+
+// Reports whether the locking script pays to pkHash
+func PaysToPubKeyHash(script, pkHash []byte) bool {
+	extracted := ExtractPubKeyHash(script)
+	return extracted != nil && bytes.Equal(extracted, pkHash)
+}
@@ -0,0 +1,111 @@
+package script
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiSig(t *testing.T) {
+	keys := [][]byte{
+		bytes.Repeat([]byte{0x02}, 33),
+		bytes.Repeat([]byte{0x03}, 33),
+		append([]byte{0x02}, bytes.Repeat([]byte{0x04}, 32)...),
+	}
+
+	t.Run("should extract the parameters of a multisig script", func(t *testing.T) {
+		lockingScript, err := MultiSigScript(2, keys)
+		assert.NoError(t, err)
+		assert.Equal(t, MultiSig, Classify(lockingScript))
+
+		m, publicKeys, ok := ExtractMultiSig(lockingScript)
+		assert.True(t, ok)
+		assert.Equal(t, 2, m)
+		assert.Equal(t, keys, publicKeys)
+	})
+
+	t.Run("should reject invalid multisig parameters", func(t *testing.T) {
+		_, err := MultiSigScript(4, keys)
+		assert.ErrorIs(t, err, ErrInvalidMultiSig)
+
+		_, err = MultiSigScript(0, keys)
+		assert.ErrorIs(t, err, ErrInvalidMultiSig)
+
+		_, err = MultiSigScript(1, [][]byte{keys[0][:32]})
+		assert.ErrorIs(t, err, ErrInvalidMultiSig)
+	})
+
+	t.Run("should pay to the script of a multisig address", func(t *testing.T) {
+		lockingScript, err := MultiSigScript(2, keys)
+		assert.NoError(t, err)
+
+		address := share.AddressFromMultiSigScript(lockingScript)
+		assert.True(t, share.IsMultiSigAddress(address))
+		assert.False(t, share.ValidateAddress(address))
+
+		paid, err := PayToAddress(address)
+		assert.NoError(t, err)
+		assert.Equal(t, lockingScript, paid)
+
+		// Corrupt the checksum
+		tampered := []byte(address)
+		tampered[len(tampered)-1] ^= 0x01
+		_, err = PayToAddress(string(tampered))
+		assert.Error(t, err)
+	})
+
+	t.Run("should extract the address a locking script pays to", func(t *testing.T) {
+		multiSig, err := MultiSigScript(2, keys)
+		assert.NoError(t, err)
+
+		pkHash := bytes.Repeat([]byte{0x05}, PubKeyHashLength)
+		pubKeyHash, err := PayToPubKeyHash(pkHash)
+		assert.NoError(t, err)
+
+		scriptHash, err := PayToScriptHash(pkHash)
+		assert.NoError(t, err)
+
+		for _, lockingScript := range [][]byte{multiSig, pubKeyHash, scriptHash} {
+			paid, err := PayToAddress(ExtractAddress(lockingScript))
+			assert.NoError(t, err)
+			assert.Equal(t, lockingScript, paid)
+		}
+
+		nullData, err := NullDataScript([]byte{0x42})
+		assert.NoError(t, err)
+		assert.Empty(t, ExtractAddress(nullData))
+	})
+
+	t.Run("should pay an address created before versions to its public key hash", func(t *testing.T) {
+		paid, err := PayToAddress("BgGZ9tcN4rm9KBzDn7KprQz87SZ1k5oUs")
+		assert.NoError(t, err)
+
+		expected, err := PayToAddress("1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, paid)
+	})
+}
+
+func TestNullData(t *testing.T) {
+	t.Run("should carry data up to the size limit in an unspendable output", func(t *testing.T) {
+		data := bytes.Repeat([]byte{0x42}, MaxNullDataSize)
+
+		nullData, err := NullDataScript(data)
+		assert.NoError(t, err)
+		assert.Equal(t, NullData, Classify(nullData))
+		assert.Equal(t, data, ExtractNullData(nullData))
+		assert.True(t, IsUnspendable(nullData))
+
+		_, err = NullDataScript(append(data, 0x42))
+		assert.ErrorIs(t, err, ErrNullDataTooLarge)
+
+		oversized := mustScript(t, NewBuilder().AddOp(OP_RETURN).AddData(append(data, 0x42)))
+		assert.Equal(t, NonStandard, Classify(oversized))
+		assert.True(t, IsUnspendable(oversized))
+
+		twoPushes := mustScript(t, NewBuilder().AddOp(OP_RETURN).AddData(data[:1]).AddData(data[:1]))
+		assert.Equal(t, NonStandard, Classify(twoPushes))
+	})
+}
@@ -0,0 +1,156 @@
+package share
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// Address versions. An address is base58(version || payload || checksum), where
+// the checksum is the first 4 bytes of the double SHA-256 of version || payload.
+// Public key hash addresses created before versions existed lack the version
+// byte; they are still accepted, see DecodeAddress.
+const (
+	PubKeyHashAddressVersion byte = 0x00 // payload is a 20 bytes public key hash
+	ScriptHashAddressVersion byte = 0x05 // payload is a 20 bytes redeem script hash
+	MultiSigAddressVersion   byte = 0x32 // payload is a whole multisig locking script
+)
+
+const addressHashLength = 20
+
+// Length of a decoded unversioned address: public key hash and checksum
+const legacyAddressLength = addressHashLength + 4
+
+var ErrInvalidAddress = errors.New("invalid address")
+
+func encodeAddress(version byte, payload []byte) string {
+	addressBytes := append([]byte{version}, payload...)
+
+	doubleHash := DoubleSha256(addressBytes)
+	addressBytes = append(addressBytes, doubleHash[:4]...)
+
+	return base58.Encode(addressBytes)
+}
+
+// Returns the version and payload of an address after checking its checksum.
+// An unversioned address, base58(public key hash || checksum), decodes as a
+// public key hash address; no versioned address has its length, as their
+// payloads are at least 20 bytes.
+func DecodeAddress(address string) (byte, []byte, error) {
+	addressBytes := base58.Decode(address)
+	if len(addressBytes) < 5 {
+		return 0, nil, ErrInvalidAddress
+	}
+
+	if len(addressBytes) == legacyAddressLength {
+		pkHash := addressBytes[:addressHashLength]
+
+		doubleHash := DoubleSha256(pkHash)
+		if !bytes.Equal(doubleHash[:4], addressBytes[addressHashLength:]) {
+			return 0, nil, ErrInvalidAddress
+		}
+
+		return PubKeyHashAddressVersion, pkHash, nil
+	}
+
+	data := addressBytes[:len(addressBytes)-4]
+	checksum := addressBytes[len(addressBytes)-4:]
+
+	doubleHash := DoubleSha256(data)
+	if !bytes.Equal(doubleHash[:4], checksum) {
+		return 0, nil, ErrInvalidAddress
+	}
+
+	return data[0], data[1:], nil
+}
+
+// Returns the payload of address if it has the given version
+func decodeAddressPayload(address string, version byte) ([]byte, error) {
+	addressVersion, payload, err := DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if addressVersion != version {
+		return nil, ErrInvalidAddress
+	}
+
+	if version != MultiSigAddressVersion && len(payload) != addressHashLength {
+		return nil, ErrInvalidAddress
+	}
+
+	return payload, nil
+}
+
+func AddressFromPublicKey(publicKey *ecdsa.PublicKey) (string, error) {
+	pkHash, err := GetPublicKeyHashFromPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	return AddressFromPublicKeyHash(pkHash[:]), nil
+}
+
+// Returns the address of the outputs locked to a public key hash
+func AddressFromPublicKeyHash(pkHash []byte) string {
+	return encodeAddress(PubKeyHashAddressVersion, pkHash)
+}
+
+// Reports whether address is a valid public key hash address, versioned or not
+func ValidateAddress(address string) bool {
+	_, err := decodeAddressPayload(address, PubKeyHashAddressVersion)
+
+	return err == nil
+}
+
+// Returns the public key hash of a public key hash address, or 20 zero bytes
+// if address is not one
+func PublicKeyHashFromAddress(address string) []byte {
+	pkHash, err := decodeAddressPayload(address, PubKeyHashAddressVersion)
+	if err != nil {
+		return make([]byte, addressHashLength)
+	}
+
+	return pkHash
+}
+
+// Returns the address of the outputs locked to the hash of a redeem script
+func AddressFromScriptHash(scriptHash []byte) (string, error) {
+	if len(scriptHash) != addressHashLength {
+		return "", errors.New("script hash must be 20 bytes")
+	}
+
+	return encodeAddress(ScriptHashAddressVersion, scriptHash), nil
+}
+
+// Reports whether address is a valid script hash address
+func IsScriptHashAddress(address string) bool {
+	_, err := decodeAddressPayload(address, ScriptHashAddressVersion)
+
+	return err == nil
+}
+
+// Returns the redeem script hash of a script hash address
+func ScriptHashFromAddress(address string) ([]byte, error) {
+	return decodeAddressPayload(address, ScriptHashAddressVersion)
+}
+
+// Returns the address of a multisig locking script. Unlike the other addresses
+// it carries the whole script, so that anyone can pay to it.
+func AddressFromMultiSigScript(script []byte) string {
+	return encodeAddress(MultiSigAddressVersion, script)
+}
+
+// Reports whether address is a valid multisig address
+func IsMultiSigAddress(address string) bool {
+	_, err := decodeAddressPayload(address, MultiSigAddressVersion)
+
+	return err == nil
+}
+
+// Returns the locking script carried by a multisig address
+func MultiSigScriptFromAddress(address string) ([]byte, error) {
+	return decodeAddressPayload(address, MultiSigAddressVersion)
+}
@@ -0,0 +1,65 @@
+package share
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddress(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x42}, 20)
+
+	t.Run("should tell public key hash and script hash addresses apart", func(t *testing.T) {
+		privateKey, err := GeneratePrivateKey(RegtestParams.Curve)
+		assert.NoError(t, err)
+
+		pkhAddress, err := AddressFromPublicKey(&privateKey.PublicKey)
+		assert.NoError(t, err)
+		assert.True(t, ValidateAddress(pkhAddress))
+		assert.False(t, IsScriptHashAddress(pkhAddress))
+
+		shAddress, err := AddressFromScriptHash(hash)
+		assert.NoError(t, err)
+		assert.True(t, IsScriptHashAddress(shAddress))
+		assert.False(t, ValidateAddress(shAddress))
+
+		scriptHash, err := ScriptHashFromAddress(shAddress)
+		assert.NoError(t, err)
+		assert.Equal(t, hash, scriptHash)
+	})
+
+	t.Run("should reject a bad checksum", func(t *testing.T) {
+		address := encodeAddress(PubKeyHashAddressVersion, hash)
+		_, _, err := DecodeAddress(address)
+		assert.NoError(t, err)
+
+		addressBytes := append([]byte{PubKeyHashAddressVersion}, hash...)
+		addressBytes = append(addressBytes, 0x00, 0x00, 0x00, 0x00)
+		_, _, err = DecodeAddress(base58.Encode(addressBytes))
+		assert.ErrorIs(t, err, ErrInvalidAddress)
+		assert.False(t, ValidateAddress(""))
+	})
+	t.Run("should decode a public key hash address created before versions", func(t *testing.T) {
+		pkHash, err := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+		assert.NoError(t, err)
+
+		legacy := "BgGZ9tcN4rm9KBzDn7KprQz87SZ1k5oUs"
+		version, payload, err := DecodeAddress(legacy)
+		assert.NoError(t, err)
+		assert.Equal(t, PubKeyHashAddressVersion, version)
+		assert.Equal(t, pkHash, payload)
+
+		assert.True(t, ValidateAddress(legacy))
+		assert.False(t, IsScriptHashAddress(legacy))
+		assert.Equal(t, pkHash, PublicKeyHashFromAddress(legacy))
+		assert.Equal(t, "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH", AddressFromPublicKeyHash(pkHash))
+
+		// The checksum of the unversioned form is checked too
+		addressBytes := append(append([]byte{}, pkHash...), 0x00, 0x00, 0x00, 0x00)
+		_, _, err = DecodeAddress(base58.Encode(addressBytes))
+		assert.ErrorIs(t, err, ErrInvalidAddress)
+	})
+}
@@ -1,46 +1,80 @@
 package share
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
+	"errors"
 
-	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcd/btcec"
 	"golang.org/x/crypto/ripemd160"
 )
 
-func GeneratePrivateKey() (*ecdsa.PrivateKey, error) {
-	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-}
+const CompressedPublicKeyLength = 33
 
-func Sign(hash []byte, privateKey *ecdsa.PrivateKey) (*Signature, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
-	if err != nil {
-		return nil, err
-	}
+// Length of the PKIX form of a P-256 public key of the legacy network, the
+// longest public key GetPublicKeyBytes returns
+const MaxPublicKeyLength = 91
 
-	signature := new(Signature)
-	signature.R = r
-	signature.S = s
+func GeneratePrivateKey(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(curve, rand.Reader)
+}
 
-	return signature, nil
+// Signs hash with a deterministic RFC 6979 nonce, see SignWithEntropy
+func Sign(hash []byte, privateKey *ecdsa.PrivateKey) (*Signature, error) {
+	return SignWithEntropy(hash, privateKey, nil)
 }
 
+// Reports whether signature is a valid low S signature of hash by publicKey
 func VerifySignature(publicKey *ecdsa.PublicKey, hash []byte, signature *Signature) bool {
+	if !signature.IsLowS(publicKey.Curve) {
+		return false
+	}
+
 	return ecdsa.Verify(publicKey, hash, signature.R, signature.S)
 }
 
+// Returns the serialized public key: the 33 bytes compressed SEC1 form for
+// secp256k1 keys, as used by Bitcoin, and the PKIX form for P-256 keys so that
+// the addresses of existing P-256 keys stay the same
 func GetPublicKeyBytes(publicKey *ecdsa.PublicKey) ([]byte, error) {
+	if IsSecp256k1(publicKey.Curve) {
+		return (*btcec.PublicKey)(publicKey).SerializeCompressed(), nil
+	}
+
 	pkBytes, err := x509.MarshalPKIXPublicKey(publicKey)
 	if err != nil {
-		return make([]byte, 0), nil
+		return make([]byte, 0), err
 	}
 	return pkBytes, nil
 }
 
+// Parses a public key serialized by GetPublicKeyBytes
+func ParsePublicKey(publicKeyBytes []byte) (*ecdsa.PublicKey, error) {
+	if len(publicKeyBytes) == CompressedPublicKeyLength && btcec.IsCompressedPubKey(publicKeyBytes) {
+		key, err := btcec.ParsePubKey(publicKeyBytes, btcec.S256())
+		if err != nil {
+			return nil, err
+		}
+
+		return key.ToECDSA(), nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(publicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA public key")
+	}
+
+	return publicKey, nil
+}
+
 func GetPublicKeyHashFromPublicKey(publicKey *ecdsa.PublicKey) ([20]byte, error) {
 	publicKeyBytes, err := GetPublicKeyBytes(publicKey)
 	if err != nil {
@@ -62,42 +96,8 @@ func GetPublicKeyHashFromPublicKey(publicKey *ecdsa.PublicKey) ([20]byte, error)
 	return b20, nil
 }
 
-func AddressFromPublicKey(publicKey *ecdsa.PublicKey) (string, error) {
-	pkHash, err := GetPublicKeyHashFromPublicKey(publicKey)
-	if err != nil {
-		return "", err
-	}
-
-	doubleHash := DoubleSha256(pkHash[:])
-	checksum := doubleHash[0:4]
-
-	addressBytes := pkHash[:]
-	addressBytes = append(addressBytes, checksum...)
-
-	return base58.Encode(addressBytes), nil
-}
-
-func ValidateAddress(address string) bool {
-	addressBytes := base58.Decode(address)
-	publicKeyHash := addressBytes[:20]
-	checksum := addressBytes[20:]
-
-	doubleHash := DoubleSha256(publicKeyHash)
-
-	return bytes.Equal(doubleHash[:4], checksum)
-}
-
 func DoubleSha256(data []byte) [32]byte {
 	singleHash := sha256.Sum256(data)
 
 	return sha256.Sum256(singleHash[:])
 }
-
-func PublicKeyHashFromAddress(address string) []byte {
-	addressBytes := base58.Decode(address)
-
-	pkHash := [20]byte{}
-	copy(pkHash[:], addressBytes[:20])
-
-	return pkHash[:]
-}
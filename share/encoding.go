@@ -0,0 +1,129 @@
+package share
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Upper bound on any length prefixed field, protects decoders from huge allocations
+const MaxVarBytesLength = 1 << 20
+
+var ErrNonCanonicalVarInt = errors.New("non canonical variable length integer")
+
+// Writes n using the shortest of the 1, 3, 5 or 9 bytes variable length encodings:
+// values below 0xFD are written as a single byte, larger values are written as a
+// 0xFD, 0xFE or 0xFF marker followed by the big endian uint16, uint32 or uint64.
+func WriteVarInt(w io.Writer, n uint64) error {
+	var b []byte
+
+	switch {
+	case n < 0xFD:
+		b = []byte{byte(n)}
+	case n <= 0xFFFF:
+		b = make([]byte, 3)
+		b[0] = 0xFD
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+	case n <= 0xFFFFFFFF:
+		b = make([]byte, 5)
+		b[0] = 0xFE
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+	default:
+		b = make([]byte, 9)
+		b[0] = 0xFF
+		binary.BigEndian.PutUint64(b[1:], n)
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// Reads an integer written by WriteVarInt, rejecting encodings that are not the shortest
+func ReadVarInt(r io.Reader) (uint64, error) {
+	marker := make([]byte, 1)
+	if _, err := io.ReadFull(r, marker); err != nil {
+		return 0, err
+	}
+
+	var n, min uint64
+	switch marker[0] {
+	case 0xFD:
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+		n, min = uint64(binary.BigEndian.Uint16(b)), 0xFD
+	case 0xFE:
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+		n, min = uint64(binary.BigEndian.Uint32(b)), 0x10000
+	case 0xFF:
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+		n, min = binary.BigEndian.Uint64(b), 0x100000000
+	default:
+		return uint64(marker[0]), nil
+	}
+
+	if n < min {
+		return 0, ErrNonCanonicalVarInt
+	}
+
+	return n, nil
+}
+
+// Writes b prefixed with its length
+func WriteVarBytes(w io.Writer, b []byte) error {
+	if err := WriteVarInt(w, uint64(len(b))); err != nil {
+		return err
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// Reads a length prefixed byte slice of at most maxLength bytes. field names the
+// value being read in errors.
+func ReadVarBytes(r io.Reader, maxLength int, field string) ([]byte, error) {
+	length, err := ReadVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s length: %w", field, err)
+	}
+
+	if length > uint64(maxLength) {
+		return nil, fmt.Errorf("%s length %d exceeds maximum of %d bytes", field, length, maxLength)
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", field, err)
+	}
+
+	return b, nil
+}
+
+// Writes b after checking that it is exactly length bytes long. field names the
+// value being written in errors.
+func WriteFixedBytes(w io.Writer, b []byte, length int, field string) error {
+	if len(b) != length {
+		return fmt.Errorf("%s should be %d bytes, got %d", field, length, len(b))
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// Reads exactly length bytes. field names the value being read in errors.
+func ReadFixedBytes(r io.Reader, length int, field string) ([]byte, error) {
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", field, err)
+	}
+
+	return b, nil
+}
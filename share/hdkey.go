@@ -0,0 +1,355 @@
+package share
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// Child indexes from HardenedKeyStart on derive hardened keys, which cannot be
+// derived from the parent public key
+const HardenedKeyStart uint32 = 0x80000000
+
+const (
+	MinSeedLength = 16 // bytes
+	MaxSeedLength = 64 // bytes
+
+	extendedKeyLength = 78 // version, depth, parent fingerprint, child index, chain code and key
+)
+
+// Key of the HMAC deriving master keys, as in BIP-32
+var masterKeyHMACKey = []byte("Bitcoin seed")
+
+var (
+	ErrInvalidSeed              = fmt.Errorf("seed must be %d to %d bytes", MinSeedLength, MaxSeedLength)
+	ErrInvalidExtendedKey       = errors.New("invalid extended key")
+	ErrInvalidChild             = errors.New("child key is invalid, use the next index")
+	ErrDeriveHardenedFromPublic = errors.New("cannot derive a hardened child from an extended public key")
+	ErrNotPrivateExtendedKey    = errors.New("extended key is public")
+	ErrHDUnsupported            = errors.New("network does not support hierarchical deterministic keys")
+	ErrInvalidDerivationPath    = errors.New("invalid derivation path")
+)
+
+// ExtendedKey is a BIP-32 hierarchical deterministic key: a secp256k1 private or
+// public key with the chain code deriving its children
+type ExtendedKey struct {
+	params            *NetworkParams
+	key               []byte // 32 bytes private key or 33 bytes compressed public key
+	chainCode         []byte
+	parentFingerprint []byte
+	depth             uint8
+	childIndex        uint32
+	private           bool
+}
+
+// Returns the master key of seed on the network of params
+func NewMasterKey(seed []byte, params *NetworkParams) (*ExtendedKey, error) {
+	if !params.SupportsHD() {
+		return nil, ErrHDUnsupported
+	}
+
+	if len(seed) < MinSeedLength || len(seed) > MaxSeedLength {
+		return nil, ErrInvalidSeed
+	}
+
+	mac := hmac.New(sha512.New, masterKeyHMACKey)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	key := sum[:32]
+	if k := new(big.Int).SetBytes(key); k.Sign() == 0 || k.Cmp(btcec.S256().N) >= 0 {
+		return nil, ErrInvalidSeed
+	}
+
+	return &ExtendedKey{
+		params:            params,
+		key:               key,
+		chainCode:         sum[32:],
+		parentFingerprint: make([]byte, 4),
+		private:           true,
+	}, nil
+}
+
+// Reports whether the key holds a private key
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.private
+}
+
+func (k *ExtendedKey) Depth() uint8 {
+	return k.depth
+}
+
+func (k *ExtendedKey) Params() *NetworkParams {
+	return k.params
+}
+
+// Returns the compressed public key
+func (k *ExtendedKey) PublicKeyBytes() []byte {
+	if !k.private {
+		return k.key
+	}
+
+	x, y := btcec.S256().ScalarBaseMult(k.key)
+	return (&btcec.PublicKey{Curve: btcec.S256(), X: x, Y: y}).SerializeCompressed()
+}
+
+func (k *ExtendedKey) PublicKey() (*ecdsa.PublicKey, error) {
+	key, err := btcec.ParsePubKey(k.PublicKeyBytes(), btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+
+	return key.ToECDSA(), nil
+}
+
+func (k *ExtendedKey) PrivateKey() (*ecdsa.PrivateKey, error) {
+	if !k.private {
+		return nil, ErrNotPrivateExtendedKey
+	}
+
+	privateKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), k.key)
+	return privateKey.ToECDSA(), nil
+}
+
+func (k *ExtendedKey) fingerprint() ([]byte, error) {
+	publicKey, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := GetPublicKeyHashFromPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return hash[:4], nil
+}
+
+// Returns the child key at index. Indexes from HardenedKeyStart on derive
+// hardened children, which need a private key.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.depth == 255 {
+		return nil, ErrInvalidChild
+	}
+
+	hardened := index >= HardenedKeyStart
+	if hardened && !k.private {
+		return nil, ErrDeriveHardenedFromPublic
+	}
+
+	data := make([]byte, 0, 37)
+	if hardened {
+		data = append(append(data, 0x00), k.key...)
+	} else {
+		data = append(data, k.PublicKeyBytes()...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	curve := btcec.S256()
+	tweak := new(big.Int).SetBytes(sum[:32])
+	if tweak.Cmp(curve.N) >= 0 {
+		return nil, ErrInvalidChild
+	}
+
+	var childKey []byte
+	if k.private {
+		child := tweak.Add(tweak, new(big.Int).SetBytes(k.key))
+		child.Mod(child, curve.N)
+		if child.Sign() == 0 {
+			return nil, ErrInvalidChild
+		}
+
+		childKey = make([]byte, 32)
+		child.FillBytes(childKey)
+	} else {
+		parent, err := btcec.ParsePubKey(k.key, curve)
+		if err != nil {
+			return nil, err
+		}
+
+		tx, ty := curve.ScalarBaseMult(sum[:32])
+		x, y := curve.Add(tx, ty, parent.X, parent.Y)
+		if x.Sign() == 0 && y.Sign() == 0 {
+			return nil, ErrInvalidChild
+		}
+
+		childKey = (&btcec.PublicKey{Curve: curve, X: x, Y: y}).SerializeCompressed()
+	}
+
+	fingerprint, err := k.fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExtendedKey{
+		params:            k.params,
+		key:               childKey,
+		chainCode:         sum[32:],
+		parentFingerprint: fingerprint,
+		depth:             k.depth + 1,
+		childIndex:        index,
+		private:           k.private,
+	}, nil
+}
+
+// Returns the key at path below k
+func (k *ExtendedKey) Derive(path DerivationPath) (*ExtendedKey, error) {
+	key := k
+	for _, index := range path {
+		child, err := key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+
+	return key, nil
+}
+
+// Returns the extended public key of k, which derives the public keys of the
+// non-hardened children of k
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.private {
+		return k
+	}
+
+	return &ExtendedKey{
+		params:            k.params,
+		key:               k.PublicKeyBytes(),
+		chainCode:         k.chainCode,
+		parentFingerprint: k.parentFingerprint,
+		depth:             k.depth,
+		childIndex:        k.childIndex,
+	}
+}
+
+// Returns the base58 serialization of the key, e.g. "xprv..." or "xpub..." on mainnet
+func (k *ExtendedKey) String() string {
+	version := k.params.HDPublicKeyID
+	key := k.key
+	if k.private {
+		version = k.params.HDPrivateKeyID
+		key = append([]byte{0x00}, k.key...)
+	}
+
+	payload := make([]byte, 0, extendedKeyLength+4)
+	payload = append(payload, version[:]...)
+	payload = append(payload, k.depth)
+	payload = append(payload, k.parentFingerprint...)
+	payload = binary.BigEndian.AppendUint32(payload, k.childIndex)
+	payload = append(payload, k.chainCode...)
+	payload = append(payload, key...)
+
+	checksum := DoubleSha256(payload)
+
+	return base58.Encode(append(payload, checksum[:4]...))
+}
+
+// Parses a key serialized by String, for any of the networks supporting it
+func ParseExtendedKey(serialized string) (*ExtendedKey, error) {
+	decoded := base58.Decode(serialized)
+	if len(decoded) != extendedKeyLength+4 {
+		return nil, ErrInvalidExtendedKey
+	}
+
+	payload, checksum := decoded[:extendedKeyLength], decoded[extendedKeyLength:]
+	if expected := DoubleSha256(payload); !bytes.Equal(expected[:4], checksum) {
+		return nil, fmt.Errorf("%w: bad checksum", ErrInvalidExtendedKey)
+	}
+
+	var version [4]byte
+	copy(version[:], payload[:4])
+
+	params, private := paramsByHDVersion(version)
+	if params == nil {
+		return nil, fmt.Errorf("%w: unknown version %x", ErrInvalidExtendedKey, version)
+	}
+
+	key := &ExtendedKey{
+		params:            params,
+		depth:             payload[4],
+		parentFingerprint: payload[5:9],
+		childIndex:        binary.BigEndian.Uint32(payload[9:13]),
+		chainCode:         payload[13:45],
+		private:           private,
+	}
+
+	if private {
+		if payload[45] != 0x00 {
+			return nil, ErrInvalidExtendedKey
+		}
+		key.key = payload[46:78]
+
+		if k := new(big.Int).SetBytes(key.key); k.Sign() == 0 || k.Cmp(btcec.S256().N) >= 0 {
+			return nil, ErrInvalidExtendedKey
+		}
+	} else {
+		key.key = payload[45:78]
+
+		if _, err := btcec.ParsePubKey(key.key, btcec.S256()); err != nil {
+			return nil, ErrInvalidExtendedKey
+		}
+	}
+
+	return key, nil
+}
+
+// DerivationPath is a list of child indexes leading from a key to a descendant
+type DerivationPath []uint32
+
+// Parses a path such as "m/44'/0'/0'/0/1", where ' or h marks hardened indexes
+func ParseDerivationPath(path string) (DerivationPath, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q must start with m", ErrInvalidDerivationPath, path)
+	}
+
+	derivationPath := make(DerivationPath, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDerivationPath, path)
+		}
+
+		if hardened {
+			index += uint64(HardenedKeyStart)
+		}
+		derivationPath = append(derivationPath, uint32(index))
+	}
+
+	return derivationPath, nil
+}
+
+func (path DerivationPath) String() string {
+	var builder strings.Builder
+	builder.WriteString("m")
+
+	for _, index := range path {
+		if index >= HardenedKeyStart {
+			fmt.Fprintf(&builder, "/%d'", index-HardenedKeyStart)
+			continue
+		}
+		fmt.Fprintf(&builder, "/%d", index)
+	}
+
+	return builder.String()
+}
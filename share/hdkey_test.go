@@ -0,0 +1,61 @@
+package share
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtendedKey(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	master, err := NewMasterKey(seed, MainNetParams)
+	assert.NoError(t, err)
+
+	t.Run("should derive the BIP-32 test vectors", func(t *testing.T) {
+		assert.Equal(t, "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi", master.String())
+		assert.Equal(t, "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8", master.Neuter().String())
+
+		path, err := ParseDerivationPath("m/0'/1/2h/2")
+		assert.NoError(t, err)
+		assert.Equal(t, "m/0'/1/2'/2", path.String())
+
+		key, err := master.Derive(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "xprvA2JDeKCSNNZky6uBCviVfJSKyQ1mDYahRjijr5idH2WwLsEd4Hsb2Tyh8RfQMuPh7f7RtyzTtdrbdqqsunu5Mm3wDvUAKRHSC34sJ7in334", key.String())
+		assert.Equal(t, "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV", key.Neuter().String())
+	})
+
+	t.Run("should derive the same public children from the extended public key", func(t *testing.T) {
+		account, err := master.Derive(DerivationPath{HardenedKeyStart, 1})
+		assert.NoError(t, err)
+
+		private, err := account.Child(7)
+		assert.NoError(t, err)
+
+		public, err := account.Neuter().Child(7)
+		assert.NoError(t, err)
+		assert.Equal(t, private.Neuter().String(), public.String())
+
+		_, err = account.Neuter().Child(HardenedKeyStart)
+		assert.ErrorIs(t, err, ErrDeriveHardenedFromPublic)
+	})
+
+	t.Run("should parse serialized keys", func(t *testing.T) {
+		for _, key := range []*ExtendedKey{master, master.Neuter()} {
+			parsed, err := ParseExtendedKey(key.String())
+			assert.NoError(t, err)
+			assert.Equal(t, key.String(), parsed.String())
+			assert.Equal(t, key.IsPrivate(), parsed.IsPrivate())
+		}
+
+		serialized := []byte(master.String())
+		serialized[len(serialized)-1]++
+		_, err := ParseExtendedKey(string(serialized))
+		assert.ErrorIs(t, err, ErrInvalidExtendedKey)
+
+		_, err = NewMasterKey(seed, LegacyParams)
+		assert.ErrorIs(t, err, ErrHDUnsupported)
+	})
+}
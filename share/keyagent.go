@@ -0,0 +1,269 @@
+package share
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A key agent keeps a keystore unlocked between commands, which each run in
+// their own process. It holds the key in memory only and hands it to the
+// commands of the same user over a socket in the keystore directory, until the
+// timeout of the unlock elapses or a command locks the keystore.
+const (
+	// Name of the socket, in the keystore directory, of the agent
+	KeyAgentSocketFilename = "mag_agent.sock"
+
+	// Command line argument running the agent, followed by the keystore directory
+	KeyAgentCommand = "key-agent"
+
+	keyAgentIOTimeout = 5 * time.Second
+	keyAgentReady     = "ready"
+	keyAgentKey       = "key"
+	keyAgentLock      = "lock"
+)
+
+var ErrKeyAgentTimeout = errors.New("a key agent needs the keystore unlocked with a timeout")
+
+// Starts executable as the agent of the unlocked keystore, in a new session so
+// that it outlives the command, and hands it the key through its standard input.
+// Returns once the agent listens. An agent already running is stopped first.
+func (ks *Keystore) StartKeyAgent(executable string) error {
+	ks.mutex.RLock()
+	key, expires := ks.key, ks.expires
+	ks.mutex.RUnlock()
+
+	if key == nil {
+		return ErrKeystoreLocked
+	}
+	if expires.IsZero() {
+		return ErrKeyAgentTimeout
+	}
+
+	if _, err := ks.StopKeyAgent(); err != nil {
+		return err
+	}
+
+	command := exec.Command(executable, KeyAgentCommand, ks.dir)
+	command.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = command.Start(); err != nil {
+		return err
+	}
+
+	if err = writeAgentKey(stdin, key, expires); err == nil {
+		err = stdin.Close()
+	}
+
+	var line string
+	if err == nil {
+		line, err = bufio.NewReader(stdout).ReadString('\n')
+	}
+	if err == nil && strings.TrimSpace(line) != keyAgentReady {
+		err = errors.New(strings.TrimSpace(line))
+	}
+	if err != nil {
+		_ = command.Process.Kill()
+		_ = command.Wait()
+		return fmt.Errorf("key agent did not start: %s", err)
+	}
+
+	return command.Process.Release()
+}
+
+// Runs the agent of the keystore in dir: reads the key and the time it locks
+// from r, as StartKeyAgent writes them, writes "ready" to w once it listens,
+// and serves the key until the keystore locks
+func ServeKeyAgent(dir string, r io.Reader, w io.Writer) error {
+	ks, err := OpenKeystore(dir)
+	if err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	key, expires, err := parseAgentKey(line)
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Until(expires)
+	if timeout <= 0 {
+		return ErrKeyAgentTimeout
+	}
+
+	path := ks.path(KeyAgentSocketFilename)
+	if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %s", path, err)
+	}
+	defer listener.Close()
+
+	if err = os.Chmod(path, 0o600); err != nil {
+		return err
+	}
+
+	// Locking, on request or once the timeout elapses, stops the agent
+	ks.SetOnLock(func() { listener.Close() })
+	ks.setKey(key, timeout)
+
+	if _, err = fmt.Fprintln(w, keyAgentReady); err != nil {
+		return err
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ks.IsLocked() {
+				return nil
+			}
+			return err
+		}
+
+		ks.serveAgentRequest(conn)
+	}
+}
+
+func (ks *Keystore) serveAgentRequest(conn net.Conn) {
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(keyAgentIOTimeout)); err != nil {
+		return
+	}
+
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	switch strings.TrimSpace(request) {
+	case keyAgentKey:
+		ks.mutex.RLock()
+		key, expires := ks.key, ks.expires
+		ks.mutex.RUnlock()
+
+		if key != nil {
+			_ = writeAgentKey(conn, key, expires)
+		}
+	case keyAgentLock:
+		ks.Lock()
+		_, _ = fmt.Fprintln(conn, keyAgentLock)
+	}
+}
+
+// Unlocks the keystore with the key of its agent, if one is running, until the
+// agent's timeout elapses. Reports whether an agent unlocked it.
+func (ks *Keystore) UnlockFromAgent() (bool, error) {
+	reply, err := ks.agentRequest(keyAgentKey)
+	if err != nil || reply == "" {
+		return false, err
+	}
+
+	key, expires, err := parseAgentKey(reply)
+	if err != nil {
+		return false, fmt.Errorf("invalid reply of the key agent: %s", err)
+	}
+
+	timeout := time.Until(expires)
+	if timeout <= 0 {
+		return false, nil
+	}
+	ks.setKey(key, timeout)
+
+	return true, nil
+}
+
+// Asks the agent of the keystore, if one is running, to lock it and exit.
+// Reports whether an agent was running.
+func (ks *Keystore) StopKeyAgent() (bool, error) {
+	reply, err := ks.agentRequest(keyAgentLock)
+	return reply != "", err
+}
+
+// Sends request to the agent and returns its reply, or an empty reply if no
+// agent is running. The socket of an agent that exited is removed.
+func (ks *Keystore) agentRequest(request string) (string, error) {
+	path := ks.path(KeyAgentSocketFilename)
+
+	// No agent can listen on a path too long for a socket
+	conn, err := net.DialTimeout("unix", path, keyAgentIOTimeout)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.EINVAL) {
+		return "", nil
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "", os.Remove(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(keyAgentIOTimeout)); err != nil {
+		return "", err
+	}
+
+	if _, err = fmt.Fprintln(conn, request); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if errors.Is(err, io.EOF) && reply == "" {
+		return "", nil
+	}
+
+	return strings.TrimSpace(reply), err
+}
+
+// Writes the key in hex and the time it locks in unix nanoseconds on a line
+func writeAgentKey(w io.Writer, key []byte, expires time.Time) error {
+	_, err := fmt.Fprintf(w, "%x %d\n", key, expires.UnixNano())
+	return err
+}
+
+func parseAgentKey(line string) ([]byte, time.Time, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return nil, time.Time{}, errors.New("expected a key and a time")
+	}
+
+	key, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(key) != keystoreKeyLength {
+		return nil, time.Time{}, fmt.Errorf("invalid key length %d", len(key))
+	}
+
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return key, time.Unix(0, expires), nil
+}
@@ -0,0 +1,116 @@
+package share
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Runs the key agent of the keystore in dest with the key of keystore until
+// expires, and returns once it listens along with the channel of its result
+func startTestKeyAgent(t *testing.T, dest string, keystore *Keystore, expires time.Time) <-chan error {
+	key := new(bytes.Buffer)
+	assert.NoError(t, writeAgentKey(key, keystore.key, expires))
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeKeyAgent(dest, key, writer)
+		writer.Close()
+	}()
+
+	ready := make([]byte, len(keyAgentReady)+1)
+	_, err := io.ReadFull(reader, ready)
+	assert.NoError(t, err)
+	assert.Equal(t, keyAgentReady, strings.TrimSpace(string(ready)))
+	go io.Copy(io.Discard, reader)
+
+	return done
+}
+
+func TestKeyAgent(t *testing.T) {
+	t.Run("should unlock other keystores of the directory until locked", func(t *testing.T) {
+		dest := t.TempDir()
+		keystore := newTestKeystore(t, dest)
+		ciphertext, err := keystore.Encrypt([]byte("seed"))
+		assert.NoError(t, err)
+
+		expires := time.Now().Add(time.Hour)
+		done := startTestKeyAgent(t, dest, keystore, expires)
+
+		path := filepath.Join(dest, KeyAgentSocketFilename)
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		command, err := OpenKeystore(dest)
+		assert.NoError(t, err)
+		assert.True(t, command.IsLocked())
+
+		unlocked, err := command.UnlockFromAgent()
+		assert.NoError(t, err)
+		assert.True(t, unlocked)
+		assert.WithinDuration(t, expires, command.UnlockedUntil(), time.Millisecond)
+
+		plaintext, err := command.Decrypt(ciphertext)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("seed"), plaintext)
+
+		stopped, err := command.StopKeyAgent()
+		assert.NoError(t, err)
+		assert.True(t, stopped)
+		assert.NoError(t, <-done)
+		assert.NoFileExists(t, path)
+
+		other, err := OpenKeystore(dest)
+		assert.NoError(t, err)
+		unlocked, err = other.UnlockFromAgent()
+		assert.NoError(t, err)
+		assert.False(t, unlocked)
+		assert.True(t, other.IsLocked())
+
+		stopped, err = other.StopKeyAgent()
+		assert.NoError(t, err)
+		assert.False(t, stopped)
+	})
+
+	t.Run("should stop once the timeout elapses", func(t *testing.T) {
+		dest := t.TempDir()
+		keystore := newTestKeystore(t, dest)
+
+		done := startTestKeyAgent(t, dest, keystore, time.Now().Add(50*time.Millisecond))
+
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the key agent is still running")
+		}
+		assert.NoFileExists(t, filepath.Join(dest, KeyAgentSocketFilename))
+
+		command, err := OpenKeystore(dest)
+		assert.NoError(t, err)
+		unlocked, err := command.UnlockFromAgent()
+		assert.NoError(t, err)
+		assert.False(t, unlocked)
+	})
+
+	t.Run("should not start without a timeout", func(t *testing.T) {
+		dest := t.TempDir()
+		keystore := newTestKeystore(t, dest)
+
+		assert.ErrorIs(t, keystore.StartKeyAgent(os.Args[0]), ErrKeyAgentTimeout)
+		keystore.Lock()
+		assert.ErrorIs(t, keystore.StartKeyAgent(os.Args[0]), ErrKeystoreLocked)
+
+		key := new(bytes.Buffer)
+		assert.NoError(t, writeAgentKey(key, make([]byte, keystoreKeyLength), time.Now().Add(-time.Second)))
+		assert.ErrorIs(t, ServeKeyAgent(dest, key, io.Discard), ErrKeyAgentTimeout)
+	})
+}
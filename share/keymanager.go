@@ -1,138 +1,261 @@
 package share
 
 import (
-	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"math/big"
 	"os"
-	"strings"
+	"path/filepath"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec"
 )
 
 const PrivateKeyFilename = "mag_ecdsa_private_key.pem"
 const AddressFilename = "mag_wallet_address.txt"
 
-type Signature struct {
-	R *big.Int
-	S *big.Int
-}
-
-func (s *Signature) Bytes() []byte {
-	rBytes := s.R.Bytes()
-	sBytes := s.S.Bytes()
+// ASN.1 object identifier of secp256k1 (SEC 2)
+var oidSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
 
-	return bytes.Join([][]byte{rBytes, sBytes}, []byte{})
-}
-
-// TODO: implement
-func SignatureFromBytes(b []byte) *Signature {
-	return nil
+// ecPrivateKey is the SEC 1 ECPrivateKey structure
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
 }
 
+// KeyManager holds the main key of the wallet. While the keystore is locked
+// only the public key is loaded, and signing fails with ErrKeystoreLocked.
 type KeyManager struct {
-	PrivateKey *ecdsa.PrivateKey
+	PrivateKey *ecdsa.PrivateKey // nil while the keystore is locked, read it with GetPrivateKey
 	PublicKey  *ecdsa.PublicKey
+	Params     *NetworkParams
+	keystore   *Keystore
+	mutex      sync.RWMutex // guards PrivateKey, which the keystore clears when its timeout elapses
 }
 
-func LoadKeyManager(dest string) (*KeyManager, error) {
-	if dest == "" {
-		dest = "/tmp"
-	}
+// Loads the key stored in the directory of keystore, or generates and stores a
+// new key on the curve of params when there is none, which needs the keystore
+// unlocked. A nil params selects the network of the stored key: legacy for a
+// P-256 key, so that nodes created before secp256k1 keep starting, and mainnet
+// otherwise.
+func LoadKeyManager(keystore *Keystore, params *NetworkParams) (*KeyManager, error) {
+	path := filepath.Join(keystore.Dir(), PrivateKeyFilename)
 
-	var privateKey *ecdsa.PrivateKey
+	publicKey, err := LoadPublicKey(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
 
-	privateKey, err := loadPrivateKeyFromFile(fmt.Sprintf("%s/%s", dest, PrivateKeyFilename))
-	if err != nil {
-		privateKey, err = generatePrivateKey()
-		if err != nil {
-			return nil, err
+	if params == nil {
+		params = MainNetParams
+		if publicKey != nil && publicKey.Curve.Params().N.Cmp(LegacyParams.Curve.Params().N) == 0 {
+			params = LegacyParams
 		}
+	}
 
-		if err = writePrivateKeyToFile(privateKey, dest); err != nil {
-			return nil, err
-		}
+	km := &KeyManager{Params: params, keystore: keystore}
 
-		address, err := AddressFromPublicKey(&privateKey.PublicKey)
+	if publicKey == nil {
+		privateKey, err := GeneratePrivateKey(params.Curve)
 		if err != nil {
 			return nil, err
 		}
 
-		path := fmt.Sprintf("%s/%s", dest, AddressFilename)
-		if err = writeAddressToFile(address, path); err != nil {
-			return nil, err
-		}
+		return km, km.SetPrivateKey(privateKey)
+	}
+
+	if publicKey.Curve.Params().N.Cmp(params.Curve.Params().N) != 0 {
+		return nil, fmt.Errorf(
+			"key in %s is not on the curve of the %s network, use a different data directory",
+			keystore.Dir(),
+			params.Name,
+		)
+	}
+	km.PublicKey = publicKey
+
+	if keystore.IsLocked() {
+		return km, nil
+	}
+
+	if km.PrivateKey, err = keystore.LoadPrivateKey(path); err != nil {
+		return nil, err
 	}
 
-	return &KeyManager{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}, nil
+	return km, nil
 }
 
-func (km *KeyManager) Sign(hash []byte) (*Signature, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, km.PrivateKey, hash)
+// Returns the keystore encrypting the key
+func (km *KeyManager) Keystore() *Keystore {
+	return km.keystore
+}
+
+// Loads the private key once the keystore is unlocked
+func (km *KeyManager) Unlock() error {
+	privateKey, err := km.keystore.LoadPrivateKey(filepath.Join(km.keystore.Dir(), PrivateKeyFilename))
 	if err != nil {
-		return nil, err
+		return err
 	}
+	km.setPrivateKey(privateKey)
 
-	signature := new(Signature)
-	signature.R = r
-	signature.S = s
+	return nil
+}
 
-	return signature, nil
+// Forgets the private key, once the keystore is locked
+func (km *KeyManager) Lock() {
+	km.setPrivateKey(nil)
 }
 
-func (km *KeyManager) VerifySignature(hash []byte, signature *Signature) bool {
-	return ecdsa.Verify(km.PublicKey, hash, signature.R, signature.S)
+func (km *KeyManager) setPrivateKey(privateKey *ecdsa.PrivateKey) {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	km.PrivateKey = privateKey
 }
 
-func generatePrivateKey() (*ecdsa.PrivateKey, error) {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, err
+// Returns the private key, or nil while locked
+func (km *KeyManager) GetPrivateKey() *ecdsa.PrivateKey {
+	km.mutex.RLock()
+	defer km.mutex.RUnlock()
+
+	return km.PrivateKey
+}
+
+// Returns the private key if it is loaded and the keystore, which may lock
+// again on its own once its timeout elapses, still unlocked, or nil
+func (km *KeyManager) signingKey() *ecdsa.PrivateKey {
+	if km.keystore.IsLocked() {
+		return nil
 	}
-	return privateKey, nil
+
+	return km.GetPrivateKey()
 }
 
-func writePrivateKeyToFile(privateKey *ecdsa.PrivateKey, dest string) error {
-	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
-	if err != nil {
-		return err
+// Replaces the key with privateKey, which is stored encrypted in place of the
+// current key
+func (km *KeyManager) SetPrivateKey(privateKey *ecdsa.PrivateKey) error {
+	if privateKey.Curve.Params().N.Cmp(km.Params.Curve.Params().N) != 0 {
+		return fmt.Errorf("key is not on the curve of the %s network", km.Params.Name)
 	}
 
-	pemBlock := &pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: privateKeyBytes,
+	if err := km.keystore.SavePrivateKey(privateKey, filepath.Join(km.keystore.Dir(), PrivateKeyFilename)); err != nil {
+		return err
 	}
 
-	dest = strings.TrimSuffix(dest, "/")
-	file, err := os.Create(fmt.Sprintf("%s/%s", dest, PrivateKeyFilename))
+	address, err := AddressFromPublicKey(&privateKey.PublicKey)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	if err := pem.Encode(file, pemBlock); err != nil {
+	if err = writeAddressToFile(address, filepath.Join(km.keystore.Dir(), AddressFilename)); err != nil {
 		return err
 	}
 
+	km.setPrivateKey(privateKey)
+	km.PublicKey = &privateKey.PublicKey
+
 	return nil
 }
 
-func loadPrivateKeyFromFile(file string) (*ecdsa.PrivateKey, error) {
-	privateKeyPEM, err := os.ReadFile(file)
+// Signs hash deterministically: the same hash always gets the same signature
+func (km *KeyManager) Sign(hash []byte) (*Signature, error) {
+	privateKey := km.signingKey()
+	if privateKey == nil {
+		return nil, ErrKeystoreLocked
+	}
+
+	return Sign(hash, privateKey)
+}
+
+// Signs hash with extraEntropy mixed into the deterministic nonce
+func (km *KeyManager) SignWithEntropy(hash []byte, extraEntropy []byte) (*Signature, error) {
+	privateKey := km.signingKey()
+	if privateKey == nil {
+		return nil, ErrKeystoreLocked
+	}
+
+	return SignWithEntropy(hash, privateKey, extraEntropy)
+}
+
+// Signs hash with a deterministic BIP-340 Schnorr signature. Requires a secp256k1 key.
+func (km *KeyManager) SignSchnorr(hash []byte) ([]byte, error) {
+	privateKey := km.signingKey()
+	if privateKey == nil {
+		return nil, ErrKeystoreLocked
+	}
+
+	return SchnorrSign(hash, privateKey)
+}
+
+// Returns the x-only public key Schnorr signatures verify against
+func (km *KeyManager) GetSchnorrPublicKeyBytes() ([]byte, error) {
+	return SchnorrPublicKeyBytes(km.PublicKey)
+}
+
+func (km *KeyManager) VerifySignature(hash []byte, signature *Signature) bool {
+	return VerifySignature(km.PublicKey, hash, signature)
+}
+
+// Returns the SEC 1 DER encoding of the private key. The standard library only
+// knows the NIST curves, so secp256k1 keys are encoded here.
+func marshalECPrivateKey(privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	if !IsSecp256k1(privateKey.Curve) {
+		return x509.MarshalECPrivateKey(privateKey)
+	}
+
+	publicKey := (*btcec.PublicKey)(&privateKey.PublicKey).SerializeUncompressed()
+
+	return asn1.Marshal(ecPrivateKey{
+		Version:       1,
+		PrivateKey:    intToOctets(privateKey.D, privateKey.Curve.Params().N),
+		NamedCurveOID: oidSecp256k1,
+		PublicKey:     asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)},
+	})
+}
+
+func parseECPrivateKey(der []byte) (*ecdsa.PrivateKey, error) {
+	var key ecPrivateKey
+	if _, err := asn1.Unmarshal(der, &key); err != nil {
+		return nil, err
+	}
+
+	if !key.NamedCurveOID.Equal(oidSecp256k1) {
+		return x509.ParseECPrivateKey(der)
+	}
+
+	// PrivKeyFromBytes takes any value, so the range is checked as x509 does
+	d := new(big.Int).SetBytes(key.PrivateKey)
+	if d.Sign() == 0 || d.Cmp(btcec.S256().N) >= 0 {
+		return nil, errors.New("invalid secp256k1 private key value")
+	}
+
+	privateKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), key.PrivateKey)
+
+	return privateKey.ToECDSA(), nil
+}
+
+// Reads an unencrypted PEM encoded SEC 1 key, as written before keys were
+// encrypted. Encrypted keys are read with Keystore.LoadPrivateKey.
+func LoadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	pemBlock, err := readPEMBlock(path)
 	if err != nil {
 		return nil, err
 	}
 
-	pemBlock, _ := pem.Decode(privateKeyPEM)
-	if pemBlock == nil || pemBlock.Type != "EC PRIVATE KEY" {
+	if pemBlock.Type == encryptedPrivateKeyType {
+		return nil, ErrEncryptedPrivateKey
+	}
+
+	if pemBlock.Type != "EC PRIVATE KEY" {
 		return nil, errors.New("could not decode PEM block")
 	}
 
-	privateKey, err := x509.ParseECPrivateKey(pemBlock.Bytes)
+	privateKey, err := parseECPrivateKey(pemBlock.Bytes)
 	if err != nil {
 		return nil, err
 	}
@@ -140,6 +263,20 @@ func loadPrivateKeyFromFile(file string) (*ecdsa.PrivateKey, error) {
 	return privateKey, nil
 }
 
+func readPEMBlock(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pemBlock, _ := pem.Decode(data)
+	if pemBlock == nil {
+		return nil, errors.New("could not decode PEM block")
+	}
+
+	return pemBlock, nil
+}
+
 func (km *KeyManager) GetPublicKeyHash() ([20]byte, error) {
 	return GetPublicKeyHashFromPublicKey(km.PublicKey)
 }
@@ -149,15 +286,5 @@ func (km *KeyManager) GetAddress() (string, error) {
 }
 
 func writeAddressToFile(address, path string) error {
-	file, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	if _, err = file.WriteString(address); err != nil {
-		return err
-	}
-
-	return nil
+	return os.WriteFile(path, []byte(address), 0o600)
 }
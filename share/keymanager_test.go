@@ -0,0 +1,102 @@
+package share
+
+import (
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a new unlocked keystore in dest
+func newTestKeystore(t *testing.T, dest string) *Keystore {
+	keystore, err := OpenKeystore(dest)
+	assert.NoError(t, err)
+	assert.NoError(t, keystore.Create("passphrase"))
+
+	return keystore
+}
+
+func TestLoadKeyManager(t *testing.T) {
+	t.Run("should persist and reload a secp256k1 key", func(t *testing.T) {
+		keystore := newTestKeystore(t, t.TempDir())
+
+		km, err := LoadKeyManager(keystore, MainNetParams)
+		assert.NoError(t, err)
+		assert.True(t, IsSecp256k1(km.PublicKey.Curve))
+
+		reloaded, err := LoadKeyManager(keystore, MainNetParams)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, km.PrivateKey.D.Cmp(reloaded.PrivateKey.D))
+
+		pkBytes, err := GetPublicKeyBytes(reloaded.PublicKey)
+		assert.NoError(t, err)
+		assert.Len(t, pkBytes, CompressedPublicKeyLength)
+
+		parsed, err := ParsePublicKey(pkBytes)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, parsed.X.Cmp(km.PublicKey.X))
+		assert.Equal(t, 0, parsed.Y.Cmp(km.PublicKey.Y))
+
+		hash := DoubleSha256([]byte("magcoin"))
+		signature, err := reloaded.Sign(hash[:])
+		assert.NoError(t, err)
+		assert.True(t, VerifySignature(parsed, hash[:], signature))
+	})
+
+	t.Run("should refuse a key on the curve of another network", func(t *testing.T) {
+		keystore := newTestKeystore(t, t.TempDir())
+
+		_, err := LoadKeyManager(keystore, LegacyParams)
+		assert.NoError(t, err)
+
+		_, err = LoadKeyManager(keystore, MainNetParams)
+		assert.Error(t, err)
+	})
+	t.Run("should select the network of the stored key without params", func(t *testing.T) {
+		keystore := newTestKeystore(t, t.TempDir())
+
+		_, err := LoadKeyManager(keystore, LegacyParams)
+		assert.NoError(t, err)
+
+		km, err := LoadKeyManager(keystore, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, LegacyParams, km.Params)
+		assert.NotNil(t, km.PrivateKey)
+
+		fresh, err := LoadKeyManager(newTestKeystore(t, t.TempDir()), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, MainNetParams, fresh.Params)
+		assert.True(t, IsSecp256k1(fresh.PublicKey.Curve))
+	})
+}
+
+func TestParseECPrivateKey(t *testing.T) {
+	t.Run("should reject secp256k1 keys out of range", func(t *testing.T) {
+		n := btcec.S256().N
+
+		for _, d := range []*big.Int{big.NewInt(0), n, new(big.Int).Add(n, big.NewInt(1))} {
+			der, err := asn1.Marshal(ecPrivateKey{
+				Version:       1,
+				PrivateKey:    intToOctets(d, n),
+				NamedCurveOID: oidSecp256k1,
+			})
+			assert.NoError(t, err)
+
+			_, err = parseECPrivateKey(der)
+			assert.Error(t, err, "d = %s", d)
+		}
+
+		der, err := asn1.Marshal(ecPrivateKey{
+			Version:       1,
+			PrivateKey:    intToOctets(new(big.Int).Sub(n, big.NewInt(1)), n),
+			NamedCurveOID: oidSecp256k1,
+		})
+		assert.NoError(t, err)
+
+		privateKey, err := parseECPrivateKey(der)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, privateKey.D.Cmp(new(big.Int).Sub(n, big.NewInt(1))))
+	})
+}
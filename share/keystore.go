@@ -0,0 +1,474 @@
+package share
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KeystoreFilename = "mag_keystore.json"
+
+	// Name of the file in which older versions kept the unlocked key between
+	// commands. It is deleted when found.
+	LegacySessionFilename = "mag_session.json"
+
+	// Environment variable overriding the data directory
+	DataDirEnv = "MAGCOIN_DATA_DIR"
+
+	// Name of the data directory in the home directory of the user
+	DefaultDataDirname = ".magcoin"
+)
+
+// Cost parameters of the scrypt key derivation from the passphrase
+const (
+	ScryptN = 1 << 15
+	ScryptR = 8
+	ScryptP = 1
+)
+
+const (
+	keystoreKeyLength  = 32 // AES-256
+	keystoreSaltLength = 32
+
+	encryptedPrivateKeyType = "ENCRYPTED EC PRIVATE KEY"
+	publicKeyHeader         = "Public-Key"
+)
+
+var (
+	ErrKeystoreLocked      = errors.New("wallet is locked, unlock it with wallet unlock")
+	ErrKeystoreExists      = errors.New("keystore already has a passphrase")
+	ErrKeystoreNotCreated  = errors.New("keystore has no passphrase yet")
+	ErrWrongPassphrase     = errors.New("wrong passphrase")
+	ErrEmptyPassphrase     = errors.New("passphrase cannot be empty")
+	ErrInvalidCiphertext   = errors.New("ciphertext is too short")
+	ErrEncryptedPrivateKey = errors.New("key file is encrypted, unlock the wallet")
+)
+
+// keystoreFile holds the key encrypting the wallet files, itself encrypted
+// with a key derived from the passphrase. Changing the passphrase only encrypts
+// this key again.
+type keystoreFile struct {
+	KDF          string `json:"kdf"`
+	N            int    `json:"n"`
+	R            int    `json:"r"`
+	P            int    `json:"p"`
+	Salt         string `json:"salt"`          // hex
+	EncryptedKey string `json:"encrypted_key"` // hex, nonce followed by the AES-GCM ciphertext
+}
+
+// Keystore encrypts the private keys and seed of the wallet at rest, with
+// AES-256-GCM under a random key that the passphrase unlocks. The unlocked key
+// only lives in memory: it is never written to disk.
+type Keystore struct {
+	dir     string
+	file    *keystoreFile // nil until a passphrase is set
+	key     []byte        // nil while locked
+	expires time.Time     // zero unless unlocked with a timeout
+	timer   *time.Timer   // locks the keystore when the timeout elapses
+	onLock  func()        // forgets what the key decrypted when the keystore locks
+	mutex   sync.RWMutex
+}
+
+// Returns the data directory, created with owner only permissions: the value
+// of MAGCOIN_DATA_DIR, or .magcoin in the home directory
+func DataDir() (string, error) {
+	dir := os.Getenv(DataDirEnv)
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, DefaultDataDirname)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// Opens the keystore of dir, locked. A session file of an older version, which
+// held the unlocked key, is deleted.
+func OpenKeystore(dir string) (*Keystore, error) {
+	ks := &Keystore{dir: dir}
+
+	if err := os.Remove(ks.path(LegacySessionFilename)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(ks.path(KeystoreFilename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ks, nil
+		}
+		return nil, err
+	}
+
+	var file keystoreFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse keystore %s: %s", ks.path(KeystoreFilename), err)
+	}
+	ks.file = &file
+
+	return ks, nil
+}
+
+func (ks *Keystore) path(filename string) string {
+	return filepath.Join(ks.dir, filename)
+}
+
+// Returns the directory of the keystore, where the key files are stored
+func (ks *Keystore) Dir() string {
+	return ks.dir
+}
+
+// Reports whether a passphrase has been set
+func (ks *Keystore) IsCreated() bool {
+	return ks.file != nil
+}
+
+func (ks *Keystore) IsLocked() bool {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	return ks.key == nil
+}
+
+// Sets the passphrase of a new keystore, which is left unlocked
+func (ks *Keystore) Create(passphrase string) error {
+	if ks.IsCreated() {
+		return ErrKeystoreExists
+	}
+
+	key := make([]byte, keystoreKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	if err := ks.writeKey(key, passphrase); err != nil {
+		return err
+	}
+	ks.setKey(key, 0)
+
+	return nil
+}
+
+// Unlocks the keystore with passphrase. A positive timeout locks it again once
+// the timeout elapses; otherwise it stays unlocked until Lock or the end of the
+// process.
+func (ks *Keystore) Unlock(passphrase string, timeout time.Duration) error {
+	key, err := ks.unwrapKey(passphrase)
+	if err != nil {
+		return err
+	}
+	ks.setKey(key, timeout)
+
+	return nil
+}
+
+func (ks *Keystore) setKey(key []byte, timeout time.Duration) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ks.stopTimer()
+	ks.key = key
+
+	if timeout > 0 {
+		ks.expires = time.Now().Add(timeout)
+		ks.timer = time.AfterFunc(timeout, ks.Lock)
+	}
+}
+
+// Locks the keystore, forgetting the unlocked key
+func (ks *Keystore) Lock() {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ks.stopTimer()
+	ks.key = nil
+
+	if ks.onLock != nil {
+		ks.onLock()
+	}
+}
+
+// Sets the function called whenever the keystore locks, on Lock or once the
+// timeout of Unlock elapses, to forget the keys and seed it decrypted. It runs
+// under the lock of the keystore, so it must not call the keystore.
+func (ks *Keystore) SetOnLock(onLock func()) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ks.onLock = onLock
+}
+
+// Callers must hold the lock
+func (ks *Keystore) stopTimer() {
+	if ks.timer != nil {
+		ks.timer.Stop()
+		ks.timer = nil
+	}
+	ks.expires = time.Time{}
+}
+
+// Replaces the passphrase. The files the keystore encrypted stay the same.
+func (ks *Keystore) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	key, err := ks.unwrapKey(oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	if err = ks.writeKey(key, newPassphrase); err != nil {
+		return err
+	}
+
+	ks.Lock()
+
+	return nil
+}
+
+// Returns the time the keystore locks again, or the zero time if it was not
+// unlocked with a timeout
+func (ks *Keystore) UnlockedUntil() time.Time {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	return ks.expires
+}
+
+// Encrypts key with a key derived from passphrase and a new salt, and writes
+// the keystore file
+func (ks *Keystore) writeKey(key []byte, passphrase string) error {
+	if passphrase == "" {
+		return ErrEmptyPassphrase
+	}
+
+	salt := make([]byte, keystoreSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	file := &keystoreFile{KDF: "scrypt", N: ScryptN, R: ScryptR, P: ScryptP, Salt: hex.EncodeToString(salt)}
+
+	passphraseKey, err := file.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	encryptedKey, err := seal(passphraseKey, key)
+	if err != nil {
+		return err
+	}
+	file.EncryptedKey = hex.EncodeToString(encryptedKey)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(ks.path(KeystoreFilename), data, 0o600); err != nil {
+		return err
+	}
+	ks.file = file
+
+	return nil
+}
+
+// Returns the key encrypting the wallet files, decrypted with passphrase
+func (ks *Keystore) unwrapKey(passphrase string) ([]byte, error) {
+	if !ks.IsCreated() {
+		return nil, ErrKeystoreNotCreated
+	}
+
+	passphraseKey, err := ks.file.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := hex.DecodeString(ks.file.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Authentication of the ciphertext fails for a wrong passphrase
+	key, err := open(passphraseKey, encryptedKey)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return key, nil
+}
+
+func (file *keystoreFile) deriveKey(passphrase string) ([]byte, error) {
+	if file.KDF != "scrypt" {
+		return nil, fmt.Errorf("unknown key derivation function %q", file.KDF)
+	}
+
+	salt, err := hex.DecodeString(file.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return scrypt.Key([]byte(passphrase), salt, file.N, file.R, file.P, keystoreKeyLength)
+}
+
+// Encrypts plaintext with the key of the keystore
+func (ks *Keystore) Encrypt(plaintext []byte) ([]byte, error) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	if ks.key == nil {
+		return nil, ErrKeystoreLocked
+	}
+
+	return seal(ks.key, plaintext)
+}
+
+// Decrypts a ciphertext returned by Encrypt
+func (ks *Keystore) Decrypt(ciphertext []byte) ([]byte, error) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	if ks.key == nil {
+		return nil, ErrKeystoreLocked
+	}
+
+	return open(ks.key, ciphertext)
+}
+
+// Writes privateKey to path as a PEM block holding the encrypted SEC 1 key and,
+// in the clear, the public key. The file is replaced by a rename, so that a key
+// encrypted in place is never lost half written.
+func (ks *Keystore) SavePrivateKey(privateKey *ecdsa.PrivateKey, path string) error {
+	der, err := marshalECPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := ks.Encrypt(der)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := GetPublicKeyBytes(&privateKey.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, pem.EncodeToMemory(&pem.Block{
+		Type:    encryptedPrivateKeyType,
+		Headers: map[string]string{publicKeyHeader: hex.EncodeToString(publicKey)},
+		Bytes:   encrypted,
+	}), 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Reads a private key written by SavePrivateKey, or an unencrypted key written
+// before the keystore existed. An unencrypted key is encrypted in place once the
+// keystore is unlocked.
+func (ks *Keystore) LoadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	privateKey, err := LoadPrivateKey(path)
+	if err == nil {
+		if ks.IsLocked() {
+			return privateKey, nil
+		}
+
+		if err = ks.SavePrivateKey(privateKey, path); err != nil {
+			return nil, fmt.Errorf("could not encrypt key file %s: %w", path, err)
+		}
+		return privateKey, nil
+	}
+	if !errors.Is(err, ErrEncryptedPrivateKey) {
+		return nil, err
+	}
+
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := ks.Decrypt(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseECPrivateKey(der)
+}
+
+// Returns the public key of the key file at path, which needs no passphrase
+func LoadPublicKey(path string) (*ecdsa.PublicKey, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type != encryptedPrivateKeyType {
+		privateKey, err := LoadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return &privateKey.PublicKey, nil
+	}
+
+	publicKey, err := hex.DecodeString(block.Headers[publicKeyHeader])
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePublicKey(publicKey)
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,160 @@
+package share
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeystore(t *testing.T) {
+	t.Run("should encrypt key files with owner only permissions", func(t *testing.T) {
+		dest := t.TempDir()
+		keystore := newTestKeystore(t, dest)
+
+		km, err := LoadKeyManager(keystore, MainNetParams)
+		assert.NoError(t, err)
+
+		path := filepath.Join(dest, PrivateKeyFilename)
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.True(t, strings.Contains(string(data), encryptedPrivateKeyType))
+
+		_, err = LoadPrivateKey(path)
+		assert.ErrorIs(t, err, ErrEncryptedPrivateKey)
+
+		privateKey, err := keystore.LoadPrivateKey(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, privateKey.D.Cmp(km.PrivateKey.D))
+	})
+
+	t.Run("should encrypt an unencrypted key file once unlocked", func(t *testing.T) {
+		dest := t.TempDir()
+		path := filepath.Join(dest, PrivateKeyFilename)
+		writeLegacyKey(t, path)
+		legacy, err := LoadPrivateKey(path)
+		assert.NoError(t, err)
+
+		keystore, err := OpenKeystore(dest)
+		assert.NoError(t, err)
+		assert.NoError(t, keystore.Create("passphrase"))
+		keystore.Lock()
+
+		publicKey, err := LoadPublicKey(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, publicKey.X.Cmp(legacy.X))
+
+		privateKey, err := keystore.LoadPrivateKey(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, privateKey.D.Cmp(legacy.D))
+		_, err = LoadPrivateKey(path)
+		assert.NoError(t, err, "the key stays in the clear while locked")
+
+		assert.NoError(t, keystore.Unlock("passphrase", 0))
+		km, err := LoadKeyManager(keystore, MainNetParams)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, km.PrivateKey.D.Cmp(legacy.D))
+
+		_, err = LoadPrivateKey(path)
+		assert.ErrorIs(t, err, ErrEncryptedPrivateKey)
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+
+	t.Run("should load only the public key while locked", func(t *testing.T) {
+		dest := t.TempDir()
+		km, err := LoadKeyManager(newTestKeystore(t, dest), MainNetParams)
+		assert.NoError(t, err)
+
+		keystore, err := OpenKeystore(dest)
+		assert.NoError(t, err)
+		assert.True(t, keystore.IsCreated())
+		assert.True(t, keystore.IsLocked())
+
+		locked, err := LoadKeyManager(keystore, MainNetParams)
+		assert.NoError(t, err)
+		assert.Nil(t, locked.PrivateKey)
+		assert.Equal(t, 0, locked.PublicKey.X.Cmp(km.PublicKey.X))
+
+		_, err = locked.Sign(make([]byte, 32))
+		assert.ErrorIs(t, err, ErrKeystoreLocked)
+
+		assert.ErrorIs(t, keystore.Unlock("wrong", 0), ErrWrongPassphrase)
+		assert.NoError(t, keystore.Unlock("passphrase", 0))
+
+		unlocked, err := LoadKeyManager(keystore, MainNetParams)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, unlocked.PrivateKey.D.Cmp(km.PrivateKey.D))
+	})
+
+	t.Run("should keep the unlocked key in memory only until the timeout", func(t *testing.T) {
+		dest := t.TempDir()
+		newTestKeystore(t, dest)
+
+		// Session files of older versions held the unlocked key
+		sessionPath := filepath.Join(dest, LegacySessionFilename)
+		assert.NoError(t, os.WriteFile(sessionPath, []byte(`{"key":"00"}`), 0o600))
+
+		keystore, err := OpenKeystore(dest)
+		assert.NoError(t, err)
+		assert.True(t, keystore.IsLocked())
+		assert.NoFileExists(t, sessionPath)
+
+		assert.NoError(t, keystore.Unlock("passphrase", time.Hour))
+		assert.False(t, keystore.IsLocked())
+		assert.WithinDuration(t, time.Now().Add(time.Hour), keystore.UnlockedUntil(), time.Minute)
+
+		files, err := os.ReadDir(dest)
+		assert.NoError(t, err)
+		assert.Len(t, files, 1) // the keystore file only
+
+		reopened, err := OpenKeystore(dest)
+		assert.NoError(t, err)
+		assert.True(t, reopened.IsLocked())
+
+		keystore.Lock()
+		assert.True(t, keystore.IsLocked())
+		assert.True(t, keystore.UnlockedUntil().IsZero())
+
+		locks := 0
+		keystore.SetOnLock(func() { locks++ })
+
+		assert.NoError(t, keystore.Unlock("passphrase", 10*time.Millisecond))
+		assert.Eventually(t, keystore.IsLocked, time.Second, 5*time.Millisecond)
+		keystore.mutex.RLock()
+		assert.Equal(t, 1, locks, "the timeout calls the lock hook")
+		keystore.mutex.RUnlock()
+
+		_, err = keystore.Encrypt([]byte("seed"))
+		assert.ErrorIs(t, err, ErrKeystoreLocked)
+	})
+
+	t.Run("should decrypt existing files after a passphrase change", func(t *testing.T) {
+		dest := t.TempDir()
+		keystore := newTestKeystore(t, dest)
+
+		ciphertext, err := keystore.Encrypt([]byte("seed"))
+		assert.NoError(t, err)
+
+		assert.ErrorIs(t, keystore.ChangePassphrase("wrong", "new passphrase"), ErrWrongPassphrase)
+		assert.NoError(t, keystore.ChangePassphrase("passphrase", "new passphrase"))
+		assert.True(t, keystore.IsLocked())
+
+		reopened, err := OpenKeystore(dest)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, reopened.Unlock("passphrase", 0), ErrWrongPassphrase)
+		assert.NoError(t, reopened.Unlock("new passphrase", 0))
+
+		plaintext, err := reopened.Decrypt(ciphertext)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("seed"), plaintext)
+	})
+}
@@ -0,0 +1,105 @@
+package share
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Directory in which versions before the data directory kept the key files and
+// the policy
+const LegacyKeyDir = "/tmp"
+
+// Moves the key of legacyDir, and the files and directories of names found
+// there, into dataDir when dataDir has no key yet. Files dataDir already holds
+// are kept. Returns the names moved, the key first. Moved keys are in the clear
+// until the unlocked keystore loads and encrypts them.
+func MigrateLegacyKeyDir(legacyDir, dataDir string, names ...string) ([]string, error) {
+	moved := make([]string, 0)
+
+	if _, err := os.Stat(filepath.Join(dataDir, PrivateKeyFilename)); !errors.Is(err, os.ErrNotExist) {
+		return moved, err
+	}
+
+	if _, err := os.Stat(filepath.Join(legacyDir, PrivateKeyFilename)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return moved, nil
+		}
+		return moved, err
+	}
+
+	for _, name := range append([]string{PrivateKeyFilename}, names...) {
+		source, dest := filepath.Join(legacyDir, name), filepath.Join(dataDir, name)
+
+		if _, err := os.Stat(source); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+			if err != nil {
+				return moved, err
+			}
+			continue
+		}
+
+		if err := copyPath(source, dest); err != nil {
+			return moved, err
+		}
+
+		if err := os.RemoveAll(source); err != nil {
+			return moved, err
+		}
+		moved = append(moved, name)
+	}
+
+	return moved, nil
+}
+
+// Copies the file or directory at source to dest with owner only permissions
+func copyPath(source, dest string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return copyFile(source, dest)
+	}
+
+	if err = os.MkdirAll(dest, 0o700); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err = copyPath(filepath.Join(source, entry.Name()), filepath.Join(dest, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(source, dest string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,71 @@
+package share
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a new private key in the clear to path, as versions before the
+// keystore did
+func writeLegacyKey(t *testing.T, path string) {
+	privateKey, err := GeneratePrivateKey(MainNetParams.Curve)
+	assert.NoError(t, err)
+
+	der, err := marshalECPrivateKey(privateKey)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o644))
+}
+
+func TestMigrateLegacyKeyDir(t *testing.T) {
+	t.Run("should move the legacy key and files into the data directory", func(t *testing.T) {
+		legacyDir, dataDir := t.TempDir(), t.TempDir()
+		writeLegacyKey(t, filepath.Join(legacyDir, PrivateKeyFilename))
+		assert.NoError(t, os.WriteFile(filepath.Join(legacyDir, "mag_policy.json"), []byte("{}"), 0o644))
+		assert.NoError(t, os.Mkdir(filepath.Join(legacyDir, "keys"), 0o755))
+		writeLegacyKey(t, filepath.Join(legacyDir, "keys", "imported.pem"))
+
+		moved, err := MigrateLegacyKeyDir(legacyDir, dataDir, "mag_policy.json", "keys", AddressFilename)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{PrivateKeyFilename, "mag_policy.json", "keys"}, moved)
+
+		for _, name := range []string{PrivateKeyFilename, "mag_policy.json", filepath.Join("keys", "imported.pem")} {
+			info, err := os.Stat(filepath.Join(dataDir, name))
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+			_, err = os.Stat(filepath.Join(legacyDir, name))
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		}
+
+		info, err := os.Stat(filepath.Join(dataDir, "keys"))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	})
+
+	t.Run("should leave the legacy directory when the data directory has a key", func(t *testing.T) {
+		legacyDir, dataDir := t.TempDir(), t.TempDir()
+		writeLegacyKey(t, filepath.Join(legacyDir, PrivateKeyFilename))
+		writeLegacyKey(t, filepath.Join(dataDir, PrivateKeyFilename))
+
+		moved, err := MigrateLegacyKeyDir(legacyDir, dataDir)
+		assert.NoError(t, err)
+		assert.Empty(t, moved)
+
+		_, err = os.Stat(filepath.Join(legacyDir, PrivateKeyFilename))
+		assert.NoError(t, err)
+	})
+
+	t.Run("should move nothing without a legacy key", func(t *testing.T) {
+		legacyDir, dataDir := t.TempDir(), t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(legacyDir, "mag_policy.json"), []byte("{}"), 0o644))
+
+		moved, err := MigrateLegacyKeyDir(legacyDir, dataDir, "mag_policy.json")
+		assert.NoError(t, err)
+		assert.Empty(t, moved)
+	})
+}
@@ -0,0 +1,97 @@
+package share
+
+import (
+	"crypto/elliptic"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// NetworkParams holds the settings that differ between magcoin networks
+type NetworkParams struct {
+	Name  string
+	Curve elliptic.Curve // curve of the keys generated on the network
+
+	// Version bytes of serialized extended keys; zero on networks without
+	// hierarchical deterministic keys
+	HDPrivateKeyID [4]byte
+	HDPublicKeyID  [4]byte
+	HDCoinType     uint32 // coin type level of BIP-44 derivation paths
+
+	// Confirmations the wallet waits for before it spends a block reward. This
+	// is wallet policy: neither the mempool nor block validation check it, so
+	// a reward spent earlier elsewhere is still accepted.
+	WalletCoinbaseMaturity int
+}
+
+var (
+	MainNetParams = &NetworkParams{
+		Name:           "mainnet",
+		Curve:          btcec.S256(),
+		HDPrivateKeyID: [4]byte{0x04, 0x88, 0xad, 0xe4}, // xprv
+		HDPublicKeyID:  [4]byte{0x04, 0x88, 0xb2, 0x1e}, // xpub
+		HDCoinType:     0,
+
+		WalletCoinbaseMaturity: 100,
+	}
+
+	RegtestParams = &NetworkParams{
+		Name:           "regtest",
+		Curve:          btcec.S256(),
+		HDPrivateKeyID: [4]byte{0x04, 0x35, 0x83, 0x94}, // tprv
+		HDPublicKeyID:  [4]byte{0x04, 0x35, 0x87, 0xcf}, // tpub
+		HDCoinType:     1,
+
+		// Rewards are spendable once mined, as test chains are rarely mined deep
+		WalletCoinbaseMaturity: 1,
+	}
+
+	// Network of the nodes whose keys were generated before secp256k1 support
+	LegacyParams = &NetworkParams{
+		Name:  "legacy",
+		Curve: elliptic.P256(),
+
+		// Rewards of the legacy network could always be spent once mined
+		WalletCoinbaseMaturity: 1,
+	}
+)
+
+// Returns the parameters of the network called name. An empty name selects mainnet.
+func ParamsByName(name string) (*NetworkParams, error) {
+	switch name {
+	case "", MainNetParams.Name:
+		return MainNetParams, nil
+	case RegtestParams.Name:
+		return RegtestParams, nil
+	case LegacyParams.Name:
+		return LegacyParams, nil
+	default:
+		return nil, fmt.Errorf("unknown network %q", name)
+	}
+}
+
+// Reports whether curve is secp256k1
+func IsSecp256k1(curve elliptic.Curve) bool {
+	_, ok := curve.(*btcec.KoblitzCurve)
+	return ok
+}
+
+// Reports whether keys of the network can be derived from a seed
+func (params *NetworkParams) SupportsHD() bool {
+	return params.HDPrivateKeyID != [4]byte{}
+}
+
+// Returns the network whose extended keys have version, and whether the version
+// is that of private keys
+func paramsByHDVersion(version [4]byte) (*NetworkParams, bool) {
+	for _, params := range []*NetworkParams{MainNetParams, RegtestParams} {
+		switch version {
+		case params.HDPrivateKeyID:
+			return params, true
+		case params.HDPublicKeyID:
+			return params, false
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,138 @@
+package share
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// Signs hash with a nonce derived deterministically from the private key and the
+// hash as described in RFC 6979, so signing the same hash with the same key always
+// yields the same signature. When extraEntropy is not empty it is mixed into the
+// nonce derivation (RFC 6979 section 3.6): signatures stay valid but are only
+// reproducible with the same extra entropy. The returned signature has a low S.
+func SignWithEntropy(hash []byte, privateKey *ecdsa.PrivateKey, extraEntropy []byte) (*Signature, error) {
+	curve := privateKey.Curve
+	n := curve.Params().N
+	e := hashToInt(hash, n)
+
+	nonces := newRFC6979Generator(privateKey.D, n, hash, extraEntropy)
+	for attempt := 0; attempt < 100; attempt++ {
+		k := nonces.next()
+
+		r, _ := curve.ScalarBaseMult(k.Bytes())
+		r.Mod(r, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		// s = k^-1 * (e + r * d) mod n
+		s := new(big.Int).Mul(r, privateKey.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		signature := &Signature{R: r, S: s}
+		signature.NormalizeS(curve)
+
+		return signature, nil
+	}
+
+	return nil, errors.New("could not find a valid nonce")
+}
+
+// Converts a hash to an integer as ECDSA does: the leftmost bits of the hash,
+// as many as the bit length of the curve order (RFC 6979 bits2int)
+func hashToInt(hash []byte, n *big.Int) *big.Int {
+	orderBits := n.BitLen()
+	orderBytes := (orderBits + 7) / 8
+	if len(hash) > orderBytes {
+		hash = hash[:orderBytes]
+	}
+
+	e := new(big.Int).SetBytes(hash)
+	if excess := len(hash)*8 - orderBits; excess > 0 {
+		e.Rsh(e, uint(excess))
+	}
+
+	return e
+}
+
+// Returns x as a big endian byte slice as long as the curve order (RFC 6979 int2octets)
+func intToOctets(x *big.Int, n *big.Int) []byte {
+	length := (n.BitLen() + 7) / 8
+	b := x.Bytes()
+	if len(b) >= length {
+		return b[len(b)-length:]
+	}
+
+	return append(make([]byte, length-len(b)), b...)
+}
+
+// rfc6979Generator yields the candidate nonces of RFC 6979 section 3.2 using HMAC-SHA256
+type rfc6979Generator struct {
+	n    *big.Int
+	k, v []byte
+}
+
+func newRFC6979Generator(d, n *big.Int, hash, extraEntropy []byte) *rfc6979Generator {
+	// bits2octets: reduce the hash modulo the order before encoding it
+	h := hashToInt(hash, n)
+	h.Mod(h, n)
+
+	seed := append(intToOctets(d, n), intToOctets(h, n)...)
+	seed = append(seed, extraEntropy...)
+
+	g := &rfc6979Generator{
+		n: n,
+		k: make([]byte, sha256.Size),
+		v: make([]byte, sha256.Size),
+	}
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+
+	g.k = g.mac(g.v, []byte{0x00}, seed)
+	g.v = g.mac(g.v)
+	g.k = g.mac(g.v, []byte{0x01}, seed)
+	g.v = g.mac(g.v)
+
+	return g
+}
+
+func (g *rfc6979Generator) mac(data ...[]byte) []byte {
+	h := hmac.New(sha256.New, g.k)
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
+
+// Returns the next nonce in [1, n - 1]
+func (g *rfc6979Generator) next() *big.Int {
+	orderBits := g.n.BitLen()
+
+	for {
+		t := make([]byte, 0, (orderBits+7)/8)
+		for len(t)*8 < orderBits {
+			g.v = g.mac(g.v)
+			t = append(t, g.v...)
+		}
+
+		k := hashToInt(t, g.n)
+
+		// Prepare the state for a further candidate whether or not this one is used
+		g.k = g.mac(g.v, []byte{0x00})
+		g.v = g.mac(g.v)
+
+		if k.Sign() > 0 && k.Cmp(g.n) < 0 {
+			return k
+		}
+	}
+}
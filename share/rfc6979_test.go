@@ -0,0 +1,88 @@
+package share
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hexToInt(t *testing.T, s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	assert.True(t, ok, "invalid hex integer %s", s)
+
+	return n
+}
+
+// Test vectors from RFC 6979 appendix A.2.5 (P-256, SHA-256)
+func rfc6979TestKey(t *testing.T) *ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	d := hexToInt(t, "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721")
+
+	privateKey := &ecdsa.PrivateKey{D: d}
+	privateKey.Curve = curve
+	privateKey.X, privateKey.Y = curve.ScalarBaseMult(d.Bytes())
+
+	return privateKey
+}
+
+func TestSignRFC6979(t *testing.T) {
+	t.Run("should produce the RFC 6979 signatures", func(t *testing.T) {
+		type test struct {
+			message string
+			r       string
+			s       string
+		}
+
+		tests := []test{
+			{
+				"sample",
+				"EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+				"F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8",
+			},
+			{
+				"test",
+				"F1ABB023518351CD71D881567B1EA663ED3EFCF6C5132B354F28D3B0B7D38367",
+				"019F4113742A2B14BD25926B49C649155F267E60D3814B4C0CC84250E46F0083",
+			},
+		}
+
+		privateKey := rfc6979TestKey(t)
+		n := privateKey.Curve.Params().N
+
+		for _, test := range tests {
+			hash := sha256.Sum256([]byte(test.message))
+
+			signature, err := Sign(hash[:], privateKey)
+			assert.NoError(t, err)
+
+			// Sign returns the low S form of the RFC signature
+			expected := &Signature{R: hexToInt(t, test.r), S: hexToInt(t, test.s)}
+			expected.NormalizeS(privateKey.Curve)
+
+			assert.Equal(t, 0, expected.R.Cmp(signature.R), "R mismatch for %q", test.message)
+			assert.Equal(t, 0, expected.S.Cmp(signature.S), "S mismatch for %q", test.message)
+			assert.True(t, signature.S.Cmp(n) < 0)
+			assert.True(t, VerifySignature(&privateKey.PublicKey, hash[:], signature))
+		}
+	})
+
+	t.Run("should be reproducible without extra entropy only", func(t *testing.T) {
+		privateKey := rfc6979TestKey(t)
+		hash := DoubleSha256([]byte("magcoin"))
+
+		first, err := Sign(hash[:], privateKey)
+		assert.NoError(t, err)
+		second, err := Sign(hash[:], privateKey)
+		assert.NoError(t, err)
+		assert.Equal(t, first.Bytes(), second.Bytes())
+
+		withEntropy, err := SignWithEntropy(hash[:], privateKey, []byte("extra entropy"))
+		assert.NoError(t, err)
+		assert.NotEqual(t, first.Bytes(), withEntropy.Bytes())
+		assert.True(t, VerifySignature(&privateKey.PublicKey, hash[:], withEntropy))
+	})
+}
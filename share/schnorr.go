@@ -0,0 +1,210 @@
+package share
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+const (
+	SchnorrPublicKeyLength = 32
+	SchnorrSignatureLength = 64
+)
+
+var (
+	ErrSchnorrCurve        = errors.New("schnorr signatures require a secp256k1 key")
+	ErrInvalidSchnorrKey   = errors.New("invalid schnorr public key")
+	ErrInvalidSchnorrSig   = errors.New("invalid schnorr signature")
+	ErrSchnorrBatchInvalid = errors.New("schnorr batch verification failed")
+)
+
+// Returns SHA256(SHA256(tag) || SHA256(tag) || data) as defined by BIP-340
+func taggedHash(tag string, data ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+
+	return sum
+}
+
+// Returns the 32 bytes x-only public key of BIP-340: the x coordinate of the
+// point, whose y coordinate is implicitly even
+func SchnorrPublicKeyBytes(publicKey *ecdsa.PublicKey) ([]byte, error) {
+	if !IsSecp256k1(publicKey.Curve) {
+		return nil, ErrSchnorrCurve
+	}
+
+	return intToOctets(publicKey.X, publicKey.Curve.Params().P), nil
+}
+
+// Returns the point with x coordinate x and an even y coordinate (BIP-340 lift_x)
+func liftX(x *big.Int) (*big.Int, *big.Int, error) {
+	curve := btcec.S256()
+	p := curve.Params().P
+
+	if x.Cmp(p) >= 0 {
+		return nil, nil, ErrInvalidSchnorrKey
+	}
+
+	// y^2 = x^3 + 7
+	c := new(big.Int).Exp(x, big.NewInt(3), p)
+	c.Add(c, curve.Params().B)
+	c.Mod(c, p)
+
+	y := new(big.Int).Exp(c, curve.QPlus1Div4(), p)
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(c) != 0 {
+		return nil, nil, ErrInvalidSchnorrKey
+	}
+
+	if y.Bit(0) == 1 {
+		y.Sub(p, y)
+	}
+
+	return x, y, nil
+}
+
+// Parses a BIP-340 x-only public key
+func ParseSchnorrPublicKey(b []byte) (*ecdsa.PublicKey, error) {
+	if len(b) != SchnorrPublicKeyLength {
+		return nil, ErrInvalidSchnorrKey
+	}
+
+	x, y, err := liftX(new(big.Int).SetBytes(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PublicKey{Curve: btcec.S256(), X: x, Y: y}, nil
+}
+
+// Signs the 32 bytes hash with a BIP-340 Schnorr signature. auxRand is mixed
+// into the nonce; BIP-340 recommends fresh randomness but an all zero auxRand,
+// as used by SchnorrSign, makes signatures deterministic.
+func SchnorrSignWithAux(hash []byte, privateKey *ecdsa.PrivateKey, auxRand [32]byte) ([]byte, error) {
+	if !IsSecp256k1(privateKey.Curve) {
+		return nil, ErrSchnorrCurve
+	}
+
+	curve := btcec.S256()
+	n := curve.Params().N
+
+	d := new(big.Int).Set(privateKey.D)
+	if d.Sign() == 0 || d.Cmp(n) >= 0 {
+		return nil, errors.New("invalid private key")
+	}
+
+	px, py := curve.ScalarBaseMult(intToOctets(d, n))
+	if py.Bit(0) == 1 {
+		d.Sub(n, d)
+	}
+	pBytes := intToOctets(px, n)
+
+	auxHash := taggedHash("BIP0340/aux", auxRand[:])
+	t := intToOctets(d, n)
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+
+	nonce := taggedHash("BIP0340/nonce", t, pBytes, hash)
+	k := new(big.Int).SetBytes(nonce[:])
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return nil, errors.New("could not derive a valid nonce")
+	}
+
+	rx, ry := curve.ScalarBaseMult(intToOctets(k, n))
+	if ry.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+	rBytes := intToOctets(rx, n)
+
+	e := schnorrChallenge(rBytes, pBytes, hash)
+
+	// s = k + e * d mod n
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+
+	signature := append(rBytes, intToOctets(s, n)...)
+
+	publicKey := &ecdsa.PublicKey{Curve: curve, X: px, Y: py}
+	if !SchnorrVerify(publicKey, hash, signature) {
+		return nil, errors.New("produced an invalid schnorr signature")
+	}
+
+	return signature, nil
+}
+
+// Signs hash with a deterministic BIP-340 Schnorr signature
+func SchnorrSign(hash []byte, privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	return SchnorrSignWithAux(hash, privateKey, [32]byte{})
+}
+
+func schnorrChallenge(rBytes, pBytes, hash []byte) *big.Int {
+	challenge := taggedHash("BIP0340/challenge", rBytes, pBytes, hash)
+
+	e := new(big.Int).SetBytes(challenge[:])
+	return e.Mod(e, btcec.S256().Params().N)
+}
+
+// Splits a signature into R and s after checking their ranges
+func parseSchnorrSignature(signature []byte) (*big.Int, *big.Int, error) {
+	params := btcec.S256().Params()
+
+	if len(signature) != SchnorrSignatureLength {
+		return nil, nil, ErrInvalidSchnorrSig
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if r.Cmp(params.P) >= 0 || s.Cmp(params.N) >= 0 {
+		return nil, nil, ErrInvalidSchnorrSig
+	}
+
+	return r, s, nil
+}
+
+// Reports whether signature is a valid BIP-340 signature of hash by publicKey.
+// Only the x coordinate of publicKey is used.
+func SchnorrVerify(publicKey *ecdsa.PublicKey, hash []byte, signature []byte) bool {
+	if !IsSecp256k1(publicKey.Curve) {
+		return false
+	}
+
+	curve := btcec.S256()
+	n := curve.Params().N
+
+	px, py, err := liftX(publicKey.X)
+	if err != nil {
+		return false
+	}
+
+	r, s, err := parseSchnorrSignature(signature)
+	if err != nil {
+		return false
+	}
+
+	e := schnorrChallenge(signature[:32], intToOctets(px, n), hash)
+
+	// R = s * G - e * P
+	sx, sy := curve.ScalarBaseMult(intToOctets(s, n))
+	ex, ey := curve.ScalarMult(px, py, intToOctets(new(big.Int).Sub(n, e), n))
+	rx, ry := curve.Add(sx, sy, ex, ey)
+
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+
+	return ry.Bit(0) == 0 && rx.Cmp(r) == 0
+}
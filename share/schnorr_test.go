@@ -0,0 +1,283 @@
+package share
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/assert"
+)
+
+func hexToBytes(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.NoError(t, err)
+
+	return b
+}
+
+func schnorrTestKey(t *testing.T, secretKey string) *ecdsa.PrivateKey {
+	privateKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), hexToBytes(t, secretKey))
+
+	return privateKey.ToECDSA()
+}
+
+func TestSchnorr(t *testing.T) {
+	t.Run("should produce the BIP-340 test vector signatures", func(t *testing.T) {
+		type test struct {
+			secretKey string
+			publicKey string
+			auxRand   string
+			message   string
+			signature string
+		}
+
+		// Vectors 0 and 1 of the BIP-340 test vectors
+		tests := []test{
+			{
+				"0000000000000000000000000000000000000000000000000000000000000003",
+				"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA8215" +
+					"25F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+			},
+			{
+				"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+				"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+				"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE3341" +
+					"8906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+			},
+		}
+
+		for _, test := range tests {
+			privateKey := schnorrTestKey(t, test.secretKey)
+
+			publicKey, err := SchnorrPublicKeyBytes(&privateKey.PublicKey)
+			assert.NoError(t, err)
+			assert.Equal(t, hexToBytes(t, test.publicKey), publicKey)
+
+			var auxRand [32]byte
+			copy(auxRand[:], hexToBytes(t, test.auxRand))
+
+			signature, err := SchnorrSignWithAux(hexToBytes(t, test.message), privateKey, auxRand)
+			assert.NoError(t, err)
+			assert.Equal(t, hexToBytes(t, test.signature), signature)
+
+			parsed, err := ParseSchnorrPublicKey(publicKey)
+			assert.NoError(t, err)
+			assert.True(t, SchnorrVerify(parsed, hexToBytes(t, test.message), signature))
+		}
+	})
+
+	t.Run("should verify a batch of valid signatures and reject a batch with a forgery", func(t *testing.T) {
+		batch := NewSchnorrBatch()
+		forged := NewSchnorrBatch()
+
+		for i := 0; i < 4; i++ {
+			privateKey, err := GeneratePrivateKey(btcec.S256())
+			assert.NoError(t, err)
+
+			publicKey, err := SchnorrPublicKeyBytes(&privateKey.PublicKey)
+			assert.NoError(t, err)
+
+			hash := DoubleSha256([]byte{byte(i)})
+			signature, err := SchnorrSign(hash[:], privateKey)
+			assert.NoError(t, err)
+
+			batch.Add(publicKey, hash[:], signature)
+
+			forgedHash := append([]byte{}, hash[:]...)
+			if i == 2 {
+				forgedHash[0] ^= 0x01
+			}
+			forged.Add(publicKey, forgedHash, signature)
+		}
+
+		assert.NoError(t, batch.Verify())
+
+		err := forged.Verify()
+		assert.ErrorIs(t, err, ErrSchnorrBatchInvalid)
+		assert.ErrorContains(t, err, "signature 2")
+	})
+
+	t.Run("should verify the BIP-340 test vector signatures in a batch", func(t *testing.T) {
+		batch := NewSchnorrBatch()
+		batch.Add(
+			hexToBytes(t, "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9"),
+			hexToBytes(t, "0000000000000000000000000000000000000000000000000000000000000000"),
+			hexToBytes(t, "E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA8215"+
+				"25F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0"),
+		)
+		batch.Add(
+			hexToBytes(t, "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659"),
+			hexToBytes(t, "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89"),
+			hexToBytes(t, "6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE3341"+
+				"8906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A"),
+		)
+
+		assert.True(t, batch.verifyBatch())
+		assert.NoError(t, batch.Verify())
+	})
+
+	t.Run("should reject a batch with a public key that is not on the curve", func(t *testing.T) {
+		batch := schnorrTestBatch(t, 2)
+		// x = 5 has no point on secp256k1
+		batch.publicKeys[1] = hexToBytes(t, "0000000000000000000000000000000000000000000000000000000000000005")
+
+		assert.False(t, batch.verifyBatch())
+		assert.ErrorIs(t, batch.Verify(), ErrSchnorrBatchInvalid)
+	})
+}
+
+func TestMultiScalarMult(t *testing.T) {
+	curve := btcec.S256()
+	n := curve.Params().N
+
+	t.Run("should agree with the btcec scalar multiplication", func(t *testing.T) {
+		points := make([]jacobianPoint, 0)
+		scalars := make([][]byte, 0)
+		var expectedX, expectedY *big.Int
+
+		for i := 0; i < 5; i++ {
+			k := schnorrTestScalar(t, n)
+			px, py := curve.ScalarBaseMult(intToOctets(k, n))
+
+			scalar := schnorrTestScalar(t, n)
+
+			qx, qy := curve.ScalarMult(px, py, intToOctets(scalar, n))
+			if expectedX == nil {
+				expectedX, expectedY = qx, qy
+			} else {
+				expectedX, expectedY = curve.Add(expectedX, expectedY, qx, qy)
+			}
+
+			points = append(points, jacobianFromAffine(px, py))
+			scalars = append(scalars, intToOctets(scalar, n))
+		}
+
+		// Adding minus the expected sum must cancel the multiplication out
+		points = append(points, jacobianFromAffine(expectedX, expectedY))
+		scalars = append(scalars, intToOctets(new(big.Int).Sub(n, big.NewInt(1)), n))
+
+		sum := multiScalarMult(points, scalars)
+		assert.True(t, sum.isInfinity())
+
+		// Without it, the sum is not the point at infinity
+		sum = multiScalarMult(points[:len(points)-1], scalars[:len(scalars)-1])
+		assert.False(t, sum.isInfinity())
+	})
+
+	t.Run("should lift the x coordinate of a point to its even y coordinate", func(t *testing.T) {
+		k := schnorrTestScalar(t, n)
+		px, py := curve.ScalarBaseMult(intToOctets(k, n))
+		if py.Bit(0) == 1 {
+			py.Sub(curve.Params().P, py)
+		}
+
+		x := fieldFromBig(px)
+		point, ok := liftXJacobian(&x)
+		assert.True(t, ok)
+		assert.Equal(t, fieldFromBig(py), point.y)
+	})
+}
+
+func TestWNAF(t *testing.T) {
+	n := btcec.S256().Params().N
+
+	t.Run("should write a scalar as odd digits spaced by at least four zeros", func(t *testing.T) {
+		scalars := []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(31), new(big.Int).Sub(n, big.NewInt(1))}
+		for i := 0; i < 20; i++ {
+			scalars = append(scalars, schnorrTestScalar(t, n))
+		}
+
+		for _, scalar := range scalars {
+			digits := wnaf(intToOctets(scalar, n))
+
+			sum := new(big.Int)
+			lastNonZero := -wnafWidth
+			for i := len(digits) - 1; i >= 0; i-- {
+				sum.Lsh(sum, 1)
+				sum.Add(sum, big.NewInt(int64(digits[i])))
+
+				if digits[i] != 0 {
+					assert.Equal(t, int8(1), digits[i]&1)
+					assert.Less(t, int(digits[i]), 16)
+					assert.Greater(t, int(digits[i]), -16)
+				}
+			}
+			for i, digit := range digits {
+				if digit != 0 {
+					assert.GreaterOrEqual(t, i-lastNonZero, wnafWidth)
+					lastNonZero = i
+				}
+			}
+
+			assert.Equal(t, 0, scalar.Cmp(sum))
+		}
+	})
+}
+
+// Returns a random integer in [1, n - 1]
+func schnorrTestScalar(t *testing.T, n *big.Int) *big.Int {
+	privateKey, err := GeneratePrivateKey(btcec.S256())
+	assert.NoError(t, err)
+
+	return new(big.Int).Mod(privateKey.D, n)
+}
+
+// Returns a batch of count valid signatures
+func schnorrTestBatch(t testing.TB, count int) *SchnorrBatch {
+	batch := NewSchnorrBatch()
+
+	for i := 0; i < count; i++ {
+		privateKey, err := GeneratePrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		publicKey, err := SchnorrPublicKeyBytes(&privateKey.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash := DoubleSha256([]byte(fmt.Sprintf("message %d", i)))
+		signature, err := SchnorrSign(hash[:], privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		batch.Add(publicKey, hash[:], signature)
+	}
+
+	return batch
+}
+
+func BenchmarkSchnorrBatch(b *testing.B) {
+	for _, size := range []int{1, 8, 64} {
+		batch := schnorrTestBatch(b, size)
+
+		b.Run(fmt.Sprintf("individual/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := range batch.signatures {
+					publicKey, err := ParseSchnorrPublicKey(batch.publicKeys[j])
+					if err != nil || !SchnorrVerify(publicKey, batch.hashes[j], batch.signatures[j]) {
+						b.Fatal("invalid signature")
+					}
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("batch/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := batch.Verify(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
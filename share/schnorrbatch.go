@@ -0,0 +1,140 @@
+package share
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// SchnorrBatch collects Schnorr signatures to verify them together
+type SchnorrBatch struct {
+	publicKeys [][]byte
+	hashes     [][]byte
+	signatures [][]byte
+}
+
+func NewSchnorrBatch() *SchnorrBatch {
+	return &SchnorrBatch{}
+}
+
+// Adds a signature of hash by the x-only publicKey to the batch
+func (batch *SchnorrBatch) Add(publicKey, hash, signature []byte) {
+	batch.publicKeys = append(batch.publicKeys, publicKey)
+	batch.hashes = append(batch.hashes, hash)
+	batch.signatures = append(batch.signatures, signature)
+}
+
+func (batch *SchnorrBatch) Len() int {
+	return len(batch.signatures)
+}
+
+// Verifies every signature of the batch at once with the BIP-340 batch equation
+//
+//	(s1 + a2 * s2 + ... + au * su) * G = R1 + a2 * R2 + ... + au * Ru + e1 * P1 + (a2 * e2) * P2 + ... + (au * eu) * Pu
+//
+// where the ai are random 128 bit weights, so a forged signature only passes by
+// chance of 1 in 2^128. Both sides are computed as a single multi-scalar multiplication
+// that must yield the point at infinity. When the batch fails, the signatures
+// are verified one by one and the error names the first invalid one. A single
+// signature is cheaper to verify on its own.
+func (batch *SchnorrBatch) Verify() error {
+	if batch.Len() == 0 || batch.Len() > 1 && batch.verifyBatch() {
+		return nil
+	}
+
+	for i := range batch.signatures {
+		publicKey, err := ParseSchnorrPublicKey(batch.publicKeys[i])
+		if err != nil {
+			return fmt.Errorf("%w: signature %d: %s", ErrSchnorrBatchInvalid, i, err)
+		}
+
+		if !SchnorrVerify(publicKey, batch.hashes[i], batch.signatures[i]) {
+			return fmt.Errorf("%w: signature %d", ErrSchnorrBatchInvalid, i)
+		}
+	}
+
+	return nil
+}
+
+// Reports whether the batch equation holds, which it does not when any key or
+// signature cannot be parsed
+func (batch *SchnorrBatch) verifyBatch() bool {
+	curve := btcec.S256()
+	n := curve.Params().N
+
+	points := make([]jacobianPoint, 0, 2*batch.Len()+1)
+	scalars := make([][]byte, 0, 2*batch.Len()+1)
+	sSum := new(big.Int)
+
+	for i := range batch.signatures {
+		var px fieldElement
+		if len(batch.publicKeys[i]) != SchnorrPublicKeyLength || !px.setBytes(batch.publicKeys[i]) {
+			return false
+		}
+
+		publicKey, ok := liftXJacobian(&px)
+		if !ok {
+			return false
+		}
+
+		if len(batch.signatures[i]) != SchnorrSignatureLength {
+			return false
+		}
+
+		var rx fieldElement
+		if !rx.setBytes(batch.signatures[i][:32]) {
+			return false
+		}
+
+		r, ok := liftXJacobian(&rx)
+		if !ok {
+			return false
+		}
+
+		s := new(big.Int).SetBytes(batch.signatures[i][32:])
+		if s.Cmp(n) >= 0 {
+			return false
+		}
+
+		a := big.NewInt(1)
+		if i > 0 {
+			var err error
+			if a, err = randomWeight(); err != nil {
+				return false
+			}
+		}
+
+		e := schnorrChallenge(batch.signatures[i][:32], batch.publicKeys[i], batch.hashes[i])
+		ae := new(big.Int).Mul(a, e)
+
+		sSum.Add(sSum, new(big.Int).Mul(a, s))
+		sSum.Mod(sSum, n)
+
+		points = append(points, r, publicKey)
+		scalars = append(scalars, intToOctets(a, n), intToOctets(ae.Mod(ae, n), n))
+	}
+
+	// Moving the s * G side over: -(s1 + a2 * s2 + ... + au * su) * G
+	sSum.Sub(n, sSum)
+	points = append(points, jacobianFromAffine(curve.Params().Gx, curve.Params().Gy))
+	scalars = append(scalars, intToOctets(sSum.Mod(sSum, n), n))
+
+	sum := multiScalarMult(points, scalars)
+	return sum.isInfinity()
+}
+
+// Returns a uniformly random non zero 128 bit integer
+func randomWeight() (*big.Int, error) {
+	b := make([]byte, 16)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		if a := new(big.Int).SetBytes(b); a.Sign() > 0 {
+			return a, nil
+		}
+	}
+}
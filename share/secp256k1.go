@@ -0,0 +1,556 @@
+package share
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// Arithmetic on secp256k1 for the multi-scalar multiplication of Schnorr batch
+// verification. btcec only exposes affine operations, which pay a field inversion
+// on every addition; points here stay in Jacobian coordinates instead. None of
+// it is constant time, so it must only handle public data.
+
+// fieldElement is an integer modulo the field prime p = 2^256 - 2^32 - 977, held
+// fully reduced in four little endian 64 bit limbs
+type fieldElement [4]uint64
+
+// 2^256 mod p
+const fieldReduction = 0x1000003D1
+
+var (
+	fieldPrime         = fieldElement{0xFFFFFFFEFFFFFC2F, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF}
+	fieldPrimeMinusTwo = fieldElement{0xFFFFFFFEFFFFFC2D, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFFFFFFFFFF}
+	fieldSeven         = fieldElement{7}
+)
+
+func fieldFromBig(x *big.Int) fieldElement {
+	var f fieldElement
+	f.setBytes(x.FillBytes(make([]byte, 32)))
+	return f
+}
+
+// Sets f to the 32 bytes big endian b and reports whether b is less than p
+func (f *fieldElement) setBytes(b []byte) bool {
+	for i := 0; i < 4; i++ {
+		f[i] = 0
+		for _, v := range b[24-8*i : 32-8*i] {
+			f[i] = f[i]<<8 | uint64(v)
+		}
+	}
+
+	return !f.geqPrime()
+}
+
+func (f *fieldElement) geqPrime() bool {
+	return f[3] == fieldPrime[3] && f[2] == fieldPrime[2] && f[1] == fieldPrime[1] && f[0] >= fieldPrime[0]
+}
+
+func (f *fieldElement) isZero() bool {
+	return f[0]|f[1]|f[2]|f[3] == 0
+}
+
+func (f *fieldElement) isOdd() bool {
+	return f[0]&1 == 1
+}
+
+func fieldAdd(a, b *fieldElement) fieldElement {
+	var r fieldElement
+	var carry uint64
+	r[0], carry = bits.Add64(a[0], b[0], 0)
+	r[1], carry = bits.Add64(a[1], b[1], carry)
+	r[2], carry = bits.Add64(a[2], b[2], carry)
+	r[3], carry = bits.Add64(a[3], b[3], carry)
+
+	// a + b < 2p, so adding 2^256 mod p back cannot carry again
+	if carry == 1 {
+		r.addSmall(fieldReduction)
+	}
+	if r.geqPrime() {
+		r.subPrime()
+	}
+
+	return r
+}
+
+func fieldSub(a, b *fieldElement) fieldElement {
+	var r fieldElement
+	var borrow uint64
+	r[0], borrow = bits.Sub64(a[0], b[0], 0)
+	r[1], borrow = bits.Sub64(a[1], b[1], borrow)
+	r[2], borrow = bits.Sub64(a[2], b[2], borrow)
+	r[3], borrow = bits.Sub64(a[3], b[3], borrow)
+
+	// Adding p modulo 2^256 is subtracting 2^256 mod p
+	if borrow == 1 {
+		r[0], borrow = bits.Sub64(r[0], fieldReduction, 0)
+		r[1], borrow = bits.Sub64(r[1], 0, borrow)
+		r[2], borrow = bits.Sub64(r[2], 0, borrow)
+		r[3], _ = bits.Sub64(r[3], 0, borrow)
+	}
+
+	return r
+}
+
+func fieldMul(a, b *fieldElement) fieldElement {
+	var t [8]uint64
+	for i := 0; i < 4; i++ {
+		var carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[i], b[j])
+			var c uint64
+			lo, c = bits.Add64(lo, t[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			t[i+j], carry = lo, hi
+		}
+		t[i+4] = carry
+	}
+
+	return fieldReduce(&t)
+}
+
+func fieldSquare(a *fieldElement) fieldElement {
+	return fieldMul(a, a)
+}
+
+// Reduces a 512 bit product modulo p: with 2^256 = fieldReduction mod p, the high
+// half folds into the low half twice
+func fieldReduce(t *[8]uint64) fieldElement {
+	var r fieldElement
+	var carry, c uint64
+
+	var m [5]uint64
+	for i := 0; i < 4; i++ {
+		hi, lo := bits.Mul64(t[4+i], fieldReduction)
+		lo, c = bits.Add64(lo, carry, 0)
+		m[i], carry = lo, hi+c
+	}
+	m[4] = carry
+
+	r[0], carry = bits.Add64(t[0], m[0], 0)
+	r[1], carry = bits.Add64(t[1], m[1], carry)
+	r[2], carry = bits.Add64(t[2], m[2], carry)
+	r[3], carry = bits.Add64(t[3], m[3], carry)
+
+	hi, lo := bits.Mul64(m[4]+carry, fieldReduction)
+	r[0], carry = bits.Add64(r[0], lo, 0)
+	r[1], carry = bits.Add64(r[1], hi, carry)
+	r[2], carry = bits.Add64(r[2], 0, carry)
+	r[3], carry = bits.Add64(r[3], 0, carry)
+
+	if carry == 1 {
+		r.addSmall(fieldReduction)
+	}
+	if r.geqPrime() {
+		r.subPrime()
+	}
+
+	return r
+}
+
+func (f *fieldElement) addSmall(n uint64) {
+	var carry uint64
+	f[0], carry = bits.Add64(f[0], n, 0)
+	f[1], carry = bits.Add64(f[1], 0, carry)
+	f[2], carry = bits.Add64(f[2], 0, carry)
+	f[3], _ = bits.Add64(f[3], 0, carry)
+}
+
+func (f *fieldElement) subPrime() {
+	var borrow uint64
+	f[0], borrow = bits.Sub64(f[0], fieldPrime[0], 0)
+	f[1], borrow = bits.Sub64(f[1], fieldPrime[1], borrow)
+	f[2], borrow = bits.Sub64(f[2], fieldPrime[2], borrow)
+	f[3], _ = bits.Sub64(f[3], fieldPrime[3], borrow)
+}
+
+// Returns a^e by square and multiply from the top bit of e
+func fieldExp(a *fieldElement, e *fieldElement) fieldElement {
+	r := fieldElement{1}
+	for i := 255; i >= 0; i-- {
+		r = fieldSquare(&r)
+		if e[i/64]>>(i%64)&1 == 1 {
+			r = fieldMul(&r, a)
+		}
+	}
+
+	return r
+}
+
+// Returns a^(2^n) * b
+func fieldSquareMul(a *fieldElement, n int, b *fieldElement) fieldElement {
+	r := *a
+	for i := 0; i < n; i++ {
+		r = fieldSquare(&r)
+	}
+
+	return fieldMul(&r, b)
+}
+
+// Returns a^((p + 1) / 4), the square root of a if it has one, with the
+// addition chain of libsecp256k1: xk below is a^(2^k - 1), and the exponent is
+// 223 ones, a zero, 22 ones, four zeros and 11 followed by two zeros in binary
+func fieldSqrt(a *fieldElement) fieldElement {
+	x2 := fieldSquareMul(a, 1, a)
+	x3 := fieldSquareMul(&x2, 1, a)
+	x6 := fieldSquareMul(&x3, 3, &x3)
+	x9 := fieldSquareMul(&x6, 3, &x3)
+	x11 := fieldSquareMul(&x9, 2, &x2)
+	x22 := fieldSquareMul(&x11, 11, &x11)
+	x44 := fieldSquareMul(&x22, 22, &x22)
+	x88 := fieldSquareMul(&x44, 44, &x44)
+	x176 := fieldSquareMul(&x88, 88, &x88)
+	x220 := fieldSquareMul(&x176, 44, &x44)
+	x223 := fieldSquareMul(&x220, 3, &x3)
+
+	r := fieldSquareMul(&x223, 23, &x22)
+	r = fieldSquareMul(&r, 6, &x2)
+	r = fieldSquare(&r)
+
+	return fieldSquare(&r)
+}
+
+// Returns 1 / a for a non zero a as a^(p - 2)
+func fieldInverse(a *fieldElement) fieldElement {
+	return fieldExp(a, &fieldPrimeMinusTwo)
+}
+
+// affinePoint is the point (x, y) of secp256k1, never the point at infinity
+type affinePoint struct {
+	x, y fieldElement
+}
+
+// jacobianPoint is the point (x / z^2, y / z^3) of secp256k1, or the point at
+// infinity when z is zero
+type jacobianPoint struct {
+	x, y, z fieldElement
+}
+
+func (point *jacobianPoint) isInfinity() bool {
+	return point.z.isZero()
+}
+
+// Returns the point with x coordinate x and an even y coordinate (BIP-340
+// lift_x), or ok false if x is not the x coordinate of a point
+func liftXJacobian(x *fieldElement) (point jacobianPoint, ok bool) {
+	// y^2 = x^3 + 7
+	x3 := fieldSquare(x)
+	x3 = fieldMul(&x3, x)
+	c := fieldAdd(&x3, &fieldSeven)
+
+	y := fieldSqrt(&c)
+	if y2 := fieldSquare(&y); y2 != c {
+		return point, false
+	}
+
+	if y.isOdd() {
+		y = fieldSub(&fieldElement{}, &y)
+	}
+
+	return jacobianPoint{x: *x, y: y, z: fieldElement{1}}, true
+}
+
+func jacobianFromAffine(x, y *big.Int) jacobianPoint {
+	return jacobianPoint{x: fieldFromBig(x), y: fieldFromBig(y), z: fieldElement{1}}
+}
+
+// Returns the affine form of points, none of which may be the point at
+// infinity, with a single inversion shared by all of them (Montgomery's trick)
+func toAffine(points []jacobianPoint) []affinePoint {
+	if len(points) == 0 {
+		return nil
+	}
+
+	// products[i] = z0 * ... * zi
+	products := make([]fieldElement, len(points))
+	products[0] = points[0].z
+	for i := 1; i < len(points); i++ {
+		products[i] = fieldMul(&products[i-1], &points[i].z)
+	}
+
+	inverse := fieldInverse(&products[len(points)-1])
+
+	affine := make([]affinePoint, len(points))
+	for i := len(points) - 1; i >= 0; i-- {
+		// inverse is 1 / (z0 * ... * zi) here
+		zInv := inverse
+		if i > 0 {
+			zInv = fieldMul(&inverse, &products[i-1])
+			inverse = fieldMul(&inverse, &points[i].z)
+		}
+
+		zInv2 := fieldSquare(&zInv)
+		zInv3 := fieldMul(&zInv2, &zInv)
+		affine[i] = affinePoint{x: fieldMul(&points[i].x, &zInv2), y: fieldMul(&points[i].y, &zInv3)}
+	}
+
+	return affine
+}
+
+// Returns 2 * point with the a = 0 doubling formulas (dbl-2009-l)
+func jacobianDouble(point *jacobianPoint) jacobianPoint {
+	if point.isInfinity() {
+		return *point
+	}
+
+	a := fieldSquare(&point.x)
+	b := fieldSquare(&point.y)
+	c := fieldSquare(&b)
+
+	// d = 2 * ((x + b)^2 - a - c)
+	d := fieldAdd(&point.x, &b)
+	d = fieldSquare(&d)
+	d = fieldSub(&d, &a)
+	d = fieldSub(&d, &c)
+	d = fieldAdd(&d, &d)
+
+	// e = 3 * a, f = e^2
+	e := fieldAdd(&a, &a)
+	e = fieldAdd(&e, &a)
+	f := fieldSquare(&e)
+
+	var r jacobianPoint
+
+	// x3 = f - 2 * d
+	r.x = fieldSub(&f, &d)
+	r.x = fieldSub(&r.x, &d)
+
+	// y3 = e * (d - x3) - 8 * c
+	r.y = fieldSub(&d, &r.x)
+	r.y = fieldMul(&e, &r.y)
+	c8 := fieldAdd(&c, &c)
+	c8 = fieldAdd(&c8, &c8)
+	c8 = fieldAdd(&c8, &c8)
+	r.y = fieldSub(&r.y, &c8)
+
+	// z3 = 2 * y * z
+	r.z = fieldMul(&point.y, &point.z)
+	r.z = fieldAdd(&r.z, &r.z)
+
+	return r
+}
+
+// Returns p + q with the addition formulas add-2007-bl
+func jacobianAdd(p, q *jacobianPoint) jacobianPoint {
+	if p.isInfinity() {
+		return *q
+	}
+	if q.isInfinity() {
+		return *p
+	}
+
+	z1z1 := fieldSquare(&p.z)
+	z2z2 := fieldSquare(&q.z)
+	u1 := fieldMul(&p.x, &z2z2)
+	u2 := fieldMul(&q.x, &z1z1)
+	s1 := fieldMul(&p.y, &q.z)
+	s1 = fieldMul(&s1, &z2z2)
+	s2 := fieldMul(&q.y, &p.z)
+	s2 = fieldMul(&s2, &z1z1)
+
+	if u1 == u2 {
+		if s1 == s2 {
+			return jacobianDouble(p)
+		}
+		return jacobianPoint{}
+	}
+
+	h := fieldSub(&u2, &u1)
+	i := fieldAdd(&h, &h)
+	i = fieldSquare(&i)
+	j := fieldMul(&h, &i)
+	rr := fieldSub(&s2, &s1)
+	rr = fieldAdd(&rr, &rr)
+	v := fieldMul(&u1, &i)
+
+	var r jacobianPoint
+
+	// x3 = rr^2 - j - 2 * v
+	r.x = fieldSquare(&rr)
+	r.x = fieldSub(&r.x, &j)
+	r.x = fieldSub(&r.x, &v)
+	r.x = fieldSub(&r.x, &v)
+
+	// y3 = rr * (v - x3) - 2 * s1 * j
+	r.y = fieldSub(&v, &r.x)
+	r.y = fieldMul(&rr, &r.y)
+	s1j := fieldMul(&s1, &j)
+	s1j = fieldAdd(&s1j, &s1j)
+	r.y = fieldSub(&r.y, &s1j)
+
+	// z3 = ((z1 + z2)^2 - z1z1 - z2z2) * h
+	r.z = fieldAdd(&p.z, &q.z)
+	r.z = fieldSquare(&r.z)
+	r.z = fieldSub(&r.z, &z1z1)
+	r.z = fieldSub(&r.z, &z2z2)
+	r.z = fieldMul(&r.z, &h)
+
+	return r
+}
+
+// Returns p + q, or p - q when negate is set, with the mixed addition formulas
+// madd-2007-bl, which save a third of the work of add-2007-bl as q has z = 1
+func jacobianAddAffine(p *jacobianPoint, q *affinePoint, negate bool) jacobianPoint {
+	y2 := q.y
+	if negate {
+		y2 = fieldSub(&fieldElement{}, &q.y)
+	}
+
+	if p.isInfinity() {
+		return jacobianPoint{x: q.x, y: y2, z: fieldElement{1}}
+	}
+
+	z1z1 := fieldSquare(&p.z)
+	u2 := fieldMul(&q.x, &z1z1)
+	s2 := fieldMul(&y2, &p.z)
+	s2 = fieldMul(&s2, &z1z1)
+
+	if u2 == p.x {
+		if s2 == p.y {
+			return jacobianDouble(p)
+		}
+		return jacobianPoint{}
+	}
+
+	h := fieldSub(&u2, &p.x)
+	hh := fieldSquare(&h)
+	i := fieldAdd(&hh, &hh)
+	i = fieldAdd(&i, &i)
+	j := fieldMul(&h, &i)
+	rr := fieldSub(&s2, &p.y)
+	rr = fieldAdd(&rr, &rr)
+	v := fieldMul(&p.x, &i)
+
+	var r jacobianPoint
+
+	// x3 = rr^2 - j - 2 * v
+	r.x = fieldSquare(&rr)
+	r.x = fieldSub(&r.x, &j)
+	r.x = fieldSub(&r.x, &v)
+	r.x = fieldSub(&r.x, &v)
+
+	// y3 = rr * (v - x3) - 2 * y1 * j
+	r.y = fieldSub(&v, &r.x)
+	r.y = fieldMul(&rr, &r.y)
+	y1j := fieldMul(&p.y, &j)
+	y1j = fieldAdd(&y1j, &y1j)
+	r.y = fieldSub(&r.y, &y1j)
+
+	// z3 = (z1 + h)^2 - z1z1 - hh
+	r.z = fieldAdd(&p.z, &h)
+	r.z = fieldSquare(&r.z)
+	r.z = fieldSub(&r.z, &z1z1)
+	r.z = fieldSub(&r.z, &hh)
+
+	return r
+}
+
+// Width of the non adjacent form of the scalars: each point has a table of its
+// 2^(wnafWidth - 2) odd multiples 1, 3, ..., 15
+const wnafWidth = 5
+
+// Returns the width wnafWidth non adjacent form of the 32 bytes big endian
+// scalar, least significant digit first: odd digits in [-15, 15] with at least
+// wnafWidth - 1 zeros after each non zero one
+func wnaf(scalar []byte) []int8 {
+	// Little endian limbs with one spare for the carries of negative digits
+	var k [5]uint64
+	for i := 0; i < 4; i++ {
+		for _, v := range scalar[24-8*i : 32-8*i] {
+			k[i] = k[i]<<8 | uint64(v)
+		}
+	}
+
+	digits := make([]int8, 0, 257)
+	for k != [5]uint64{} {
+		var digit int8
+		if k[0]&1 == 1 {
+			digit = int8(k[0] & (1<<wnafWidth - 1))
+			if digit >= 1<<(wnafWidth-1) {
+				digit -= 1 << wnafWidth
+			}
+
+			var borrow uint64
+			if digit > 0 {
+				k[0], borrow = bits.Sub64(k[0], uint64(digit), 0)
+				for i := 1; i < 5; i++ {
+					k[i], borrow = bits.Sub64(k[i], 0, borrow)
+				}
+			} else {
+				k[0], borrow = bits.Add64(k[0], uint64(-digit), 0)
+				for i := 1; i < 5; i++ {
+					k[i], borrow = bits.Add64(k[i], 0, borrow)
+				}
+			}
+		}
+
+		digits = append(digits, digit)
+		for i := 0; i < 4; i++ {
+			k[i] = k[i]>>1 | k[i+1]<<63
+		}
+		k[4] >>= 1
+	}
+
+	return digits
+}
+
+// Returns scalars[0] * points[0] + ... + scalars[k] * points[k] with Strauss'
+// method: the points share a single chain of doublings, and each adds an odd
+// multiple from its table at every non zero digit of its scalar in width 5
+// non adjacent form. The tables are made affine together so that the additions
+// are mixed. Scalars are 32 bytes big endian.
+func multiScalarMult(points []jacobianPoint, scalars [][]byte) jacobianPoint {
+	const tableSize = 1 << (wnafWidth - 2)
+
+	// The odd multiples of each point one after the other
+	multiples := make([]jacobianPoint, 0, tableSize*len(points))
+	digits := make([][]int8, 0, len(points))
+	length := 0
+
+	for i := range points {
+		digits = append(digits, wnaf(scalars[i]))
+		if len(digits[i]) > length {
+			length = len(digits[i])
+		}
+
+		// A multiple of a point that is the point at infinity, or a point that
+		// is never used, cannot be made affine, and adds nothing anyway
+		if points[i].isInfinity() || len(digits[i]) == 0 {
+			digits[i] = nil
+			continue
+		}
+
+		double := jacobianDouble(&points[i])
+		multiples = append(multiples, points[i])
+		for k := 1; k < tableSize; k++ {
+			multiples = append(multiples, jacobianAdd(&multiples[len(multiples)-1], &double))
+		}
+	}
+
+	affine := toAffine(multiples)
+
+	var acc jacobianPoint
+	for bit := length - 1; bit >= 0; bit-- {
+		acc = jacobianDouble(&acc)
+
+		table := affine
+		for i := range points {
+			if digits[i] == nil {
+				continue
+			}
+
+			if bit < len(digits[i]) {
+				if digit := digits[i][bit]; digit > 0 {
+					acc = jacobianAddAffine(&acc, &table[(digit-1)/2], false)
+				} else if digit < 0 {
+					acc = jacobianAddAffine(&acc, &table[(-digit-1)/2], true)
+				}
+			}
+
+			table = table[tableSize:]
+		}
+	}
+
+	return acc
+}
@@ -0,0 +1,142 @@
+package share
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	derSequenceTag = 0x30
+	derIntegerTag  = 0x02
+
+	// 0x30 len 0x02 len R 0x02 len S with R and S at least one byte long
+	minDERSignatureLength = 8
+	// R and S are at most 33 bytes long once padded to stay positive
+	maxDERSignatureLength = 72
+)
+
+var (
+	ErrNonCanonicalSignature = errors.New("non canonical DER signature")
+	ErrHighS                 = errors.New("signature S value is not in the lower half of the curve order")
+)
+
+type Signature struct {
+	R *big.Int
+	S *big.Int
+}
+
+// Returns the strict DER encoding of the signature:
+//
+//	0x30 <total length> 0x02 <length of R> <R> 0x02 <length of S> <S>
+//
+// where R and S are big endian, minimally encoded and positive.
+func (s *Signature) Bytes() []byte {
+	rBytes := derInteger(s.R)
+	sBytes := derInteger(s.S)
+
+	b := make([]byte, 0, 6+len(rBytes)+len(sBytes))
+	b = append(b, derSequenceTag, byte(4+len(rBytes)+len(sBytes)))
+	b = append(b, derIntegerTag, byte(len(rBytes)))
+	b = append(b, rBytes...)
+	b = append(b, derIntegerTag, byte(len(sBytes)))
+	b = append(b, sBytes...)
+
+	return b
+}
+
+// Returns the minimal big endian encoding of a positive integer, with a leading
+// zero byte added when the high bit is set so that it is not read as negative
+func derInteger(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) == 0 {
+		return []byte{0x00}
+	}
+
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0x00}, b...)
+	}
+
+	return b
+}
+
+// Parses a strict DER encoded signature. Any encoding Bytes would not produce is
+// rejected, so every signature has exactly one valid encoding.
+func SignatureFromBytes(b []byte) (*Signature, error) {
+	if len(b) < minDERSignatureLength || len(b) > maxDERSignatureLength {
+		return nil, fmt.Errorf("%w: invalid length %d", ErrNonCanonicalSignature, len(b))
+	}
+
+	if b[0] != derSequenceTag {
+		return nil, fmt.Errorf("%w: missing sequence tag", ErrNonCanonicalSignature)
+	}
+
+	if int(b[1]) != len(b)-2 {
+		return nil, fmt.Errorf("%w: sequence length does not match signature length", ErrNonCanonicalSignature)
+	}
+
+	r, rest, err := parseDERInteger(b[2:], "R")
+	if err != nil {
+		return nil, err
+	}
+
+	s, rest, err := parseDERInteger(rest, "S")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes after S", ErrNonCanonicalSignature)
+	}
+
+	return &Signature{R: r, S: s}, nil
+}
+
+func parseDERInteger(b []byte, name string) (*big.Int, []byte, error) {
+	if len(b) < 2 || b[0] != derIntegerTag {
+		return nil, nil, fmt.Errorf("%w: missing %s integer tag", ErrNonCanonicalSignature, name)
+	}
+
+	length := int(b[1])
+	if length == 0 {
+		return nil, nil, fmt.Errorf("%w: %s is empty", ErrNonCanonicalSignature, name)
+	}
+
+	if length > len(b)-2 {
+		return nil, nil, fmt.Errorf("%w: %s length exceeds signature", ErrNonCanonicalSignature, name)
+	}
+
+	value := b[2 : 2+length]
+
+	if value[0]&0x80 != 0 {
+		return nil, nil, fmt.Errorf("%w: %s is negative", ErrNonCanonicalSignature, name)
+	}
+
+	if length > 1 && value[0] == 0x00 && value[1]&0x80 == 0 {
+		return nil, nil, fmt.Errorf("%w: %s has excessive padding", ErrNonCanonicalSignature, name)
+	}
+
+	n := new(big.Int).SetBytes(value)
+	if n.Sign() == 0 {
+		return nil, nil, fmt.Errorf("%w: %s is zero", ErrNonCanonicalSignature, name)
+	}
+
+	return n, b[2+length:], nil
+}
+
+// Reports whether S is at most half the curve order. For every valid signature
+// (R, S), (R, N - S) is valid too; accepting only the low S form means a third
+// party cannot alter a signature, and so a transaction hash, without the key.
+func (s *Signature) IsLowS(curve elliptic.Curve) bool {
+	halfOrder := new(big.Int).Rsh(curve.Params().N, 1)
+
+	return s.S.Cmp(halfOrder) <= 0
+}
+
+// Replaces S with N - S when S is in the upper half of the curve order
+func (s *Signature) NormalizeS(curve elliptic.Curve) {
+	if !s.IsLowS(curve) {
+		s.S = new(big.Int).Sub(curve.Params().N, s.S)
+	}
+}
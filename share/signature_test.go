@@ -0,0 +1,68 @@
+package share
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureDER(t *testing.T) {
+	t.Run("should encode R and S as minimal positive DER integers", func(t *testing.T) {
+		signature := &Signature{R: big.NewInt(0x80), S: big.NewInt(0x01)}
+
+		expected := []byte{0x30, 0x07, 0x02, 0x02, 0x00, 0x80, 0x02, 0x01, 0x01}
+		assert.True(t, bytes.Equal(expected, signature.Bytes()))
+	})
+
+	t.Run("should decode an encoded signature to the same signature", func(t *testing.T) {
+		privateKey, err := GeneratePrivateKey(elliptic.P256())
+		assert.NoError(t, err)
+
+		hash := DoubleSha256([]byte("magcoin"))
+		signature, err := Sign(hash[:], privateKey)
+		assert.NoError(t, err)
+
+		decoded, err := SignatureFromBytes(signature.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, signature.R.Cmp(decoded.R))
+		assert.Equal(t, 0, signature.S.Cmp(decoded.S))
+		assert.True(t, VerifySignature(&privateKey.PublicKey, hash[:], decoded))
+	})
+
+	t.Run("should reject non canonical encodings", func(t *testing.T) {
+		tests := map[string][]byte{
+			"padded R":         {0x30, 0x07, 0x02, 0x02, 0x00, 0x01, 0x02, 0x01, 0x01},
+			"negative S":       {0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x81},
+			"zero R":           {0x30, 0x06, 0x02, 0x01, 0x00, 0x02, 0x01, 0x01},
+			"wrong length":     {0x30, 0x07, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01},
+			"trailing bytes":   {0x30, 0x07, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01, 0x00},
+			"wrong tag":        {0x31, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01},
+			"empty S":          {0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x00, 0x01},
+			"concatenated R S": {0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+		}
+
+		for name, encoding := range tests {
+			_, err := SignatureFromBytes(encoding)
+			assert.ErrorIs(t, err, ErrNonCanonicalSignature, name)
+		}
+	})
+
+	t.Run("should only verify low S signatures", func(t *testing.T) {
+		privateKey, err := GeneratePrivateKey(elliptic.P256())
+		assert.NoError(t, err)
+
+		hash := DoubleSha256([]byte("magcoin"))
+		signature, err := Sign(hash[:], privateKey)
+		assert.NoError(t, err)
+		assert.True(t, signature.IsLowS(elliptic.P256()))
+
+		highS := &Signature{R: signature.R, S: new(big.Int).Sub(elliptic.P256().Params().N, signature.S)}
+		assert.False(t, VerifySignature(&privateKey.PublicKey, hash[:], highS))
+
+		highS.NormalizeS(elliptic.P256())
+		assert.True(t, VerifySignature(&privateKey.PublicKey, hash[:], highS))
+	})
+}
@@ -0,0 +1,270 @@
+package transaction
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/jenlesamuel/magcoin/share"
+)
+
+// Version of the binary encoding written by Encode. Decoders reject other versions.
+const EncodingVersion byte = 2
+
+const (
+	maxInputsPerTransaction  = 100_000
+	maxOutputsPerTransaction = 100_000
+)
+
+// Returns the canonical binary encoding of the transaction:
+//
+//	encoding version (1 byte)
+//	version (4 bytes)
+//	input count (var int) followed by each input
+//	output count (var int) followed by each output
+//	lock time (4 bytes)
+//
+// The transaction ID is not encoded, decoders recompute it from the contents.
+func (trx *Transaction) Encode() ([]byte, error) {
+	buff := new(bytes.Buffer)
+	if err := trx.EncodeTo(buff); err != nil {
+		return make([]byte, 0), err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (trx *Transaction) EncodeTo(w io.Writer) error {
+	if _, err := w.Write([]byte{EncodingVersion}); err != nil {
+		return err
+	}
+
+	if err := share.WriteFixedBytes(w, trx.Version, 4, "version"); err != nil {
+		return err
+	}
+
+	if err := share.WriteVarInt(w, uint64(len(trx.Input))); err != nil {
+		return err
+	}
+
+	for idx, input := range trx.Input {
+		if err := input.EncodeTo(w); err != nil {
+			return fmt.Errorf("could not encode input %d: %w", idx, err)
+		}
+	}
+
+	if err := share.WriteVarInt(w, uint64(len(trx.Output))); err != nil {
+		return err
+	}
+
+	for idx, output := range trx.Output {
+		if err := output.EncodeTo(w); err != nil {
+			return fmt.Errorf("could not encode output %d: %w", idx, err)
+		}
+	}
+
+	return share.WriteFixedBytes(w, trx.LockTime, 4, "lock time")
+}
+
+// Decodes a transaction encoded with Encode. Trailing bytes are rejected.
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	r := bytes.NewReader(data)
+
+	trx, err := DecodeTransactionFrom(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after transaction", r.Len())
+	}
+
+	return trx, nil
+}
+
+func DecodeTransactionFrom(r io.Reader) (*Transaction, error) {
+	version, err := share.ReadFixedBytes(r, 1, "encoding version")
+	if err != nil {
+		return nil, err
+	}
+
+	if version[0] != EncodingVersion {
+		return nil, fmt.Errorf("unsupported transaction encoding version %d", version[0])
+	}
+
+	trxVersion, err := share.ReadFixedBytes(r, 4, "version")
+	if err != nil {
+		return nil, err
+	}
+
+	inputCount, err := share.ReadVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read input count: %w", err)
+	}
+
+	if inputCount > maxInputsPerTransaction {
+		return nil, fmt.Errorf("input count %d exceeds maximum of %d", inputCount, maxInputsPerTransaction)
+	}
+
+	inputs := make([]*TrxInput, 0, inputCount)
+	for i := uint64(0); i < inputCount; i++ {
+		input, err := DecodeTrxInputFrom(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode input %d: %w", i, err)
+		}
+		inputs = append(inputs, input)
+	}
+
+	outputCount, err := share.ReadVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read output count: %w", err)
+	}
+
+	if outputCount > maxOutputsPerTransaction {
+		return nil, fmt.Errorf("output count %d exceeds maximum of %d", outputCount, maxOutputsPerTransaction)
+	}
+
+	outputs := make([]*TrxOutput, 0, outputCount)
+	for i := uint64(0); i < outputCount; i++ {
+		output, err := DecodeTrxOutputFrom(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode output %d: %w", i, err)
+		}
+		outputs = append(outputs, output)
+	}
+
+	lockTime, err := share.ReadFixedBytes(r, 4, "lock time")
+	if err != nil {
+		return nil, err
+	}
+
+	trx := &Transaction{Version: trxVersion, Input: inputs, Output: outputs, LockTime: lockTime}
+	// Coinbase IDs commit to the coinbase data, see NewCoinbaseTransaction
+	hash, err := trx.Hash(trx.IsCoinbase())
+	if err != nil {
+		return nil, err
+	}
+	trx.ID = hash[:]
+
+	return trx, nil
+}
+
+// Returns the canonical binary encoding of the input:
+//
+//	outpoint hash (32 bytes)
+//	outpoint index (4 bytes)
+//	unlocking script or coinbase data (var bytes)
+//	sequence (4 bytes)
+func (input *TrxInput) Encode() ([]byte, error) {
+	buff := new(bytes.Buffer)
+	if err := input.EncodeTo(buff); err != nil {
+		return make([]byte, 0), err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (input *TrxInput) EncodeTo(w io.Writer) error {
+	if err := share.WriteFixedBytes(w, input.OutpointHash, 32, "outpoint hash"); err != nil {
+		return err
+	}
+
+	if err := share.WriteFixedBytes(w, input.OutpointIndex, 4, "outpoint index"); err != nil {
+		return err
+	}
+
+	if err := share.WriteVarBytes(w, input.UnlockingScript); err != nil {
+		return err
+	}
+
+	return share.WriteFixedBytes(w, input.Sequence, 4, "sequence")
+}
+
+func DecodeTrxInput(data []byte) (*TrxInput, error) {
+	r := bytes.NewReader(data)
+
+	input, err := DecodeTrxInputFrom(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after input", r.Len())
+	}
+
+	return input, nil
+}
+
+func DecodeTrxInputFrom(r io.Reader) (*TrxInput, error) {
+	var err error
+	input := new(TrxInput)
+
+	if input.OutpointHash, err = share.ReadFixedBytes(r, 32, "outpoint hash"); err != nil {
+		return nil, err
+	}
+
+	if input.OutpointIndex, err = share.ReadFixedBytes(r, 4, "outpoint index"); err != nil {
+		return nil, err
+	}
+
+	if input.UnlockingScript, err = share.ReadVarBytes(r, share.MaxVarBytesLength, "unlocking script"); err != nil {
+		return nil, err
+	}
+
+	if input.Sequence, err = share.ReadFixedBytes(r, 4, "sequence"); err != nil {
+		return nil, err
+	}
+
+	return input, nil
+}
+
+// Returns the canonical binary encoding of the output:
+//
+//	amount (8 bytes)
+//	locking script (var bytes)
+func (output *TrxOutput) Encode() ([]byte, error) {
+	buff := new(bytes.Buffer)
+	if err := output.EncodeTo(buff); err != nil {
+		return make([]byte, 0), err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (output *TrxOutput) EncodeTo(w io.Writer) error {
+	if err := share.WriteFixedBytes(w, output.Amount, 8, "amount"); err != nil {
+		return err
+	}
+
+	return share.WriteVarBytes(w, output.LockingScript)
+}
+
+func DecodeTrxOutput(data []byte) (*TrxOutput, error) {
+	r := bytes.NewReader(data)
+
+	output, err := DecodeTrxOutputFrom(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after output", r.Len())
+	}
+
+	return output, nil
+}
+
+func DecodeTrxOutputFrom(r io.Reader) (*TrxOutput, error) {
+	var err error
+	output := new(TrxOutput)
+
+	if output.Amount, err = share.ReadFixedBytes(r, 8, "amount"); err != nil {
+		return nil, err
+	}
+
+	if output.LockingScript, err = share.ReadVarBytes(r, share.MaxVarBytesLength, "locking script"); err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
@@ -0,0 +1,105 @@
+package transaction
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTransaction(t *testing.T) *Transaction {
+	inputs := []*TrxInput{
+		{
+			OutpointHash:    bytes.Repeat([]byte{0xAB}, 32),
+			OutpointIndex:   []byte{0x00, 0x00, 0x00, 0x01},
+			UnlockingScript: []byte{0x04, 0x30, 0x44, 0x02, 0x20, 0x03, 0x02, 0x11, 0x22},
+			Sequence:        share.IntToBytes(int(DefaultSequence)),
+		},
+		{
+			OutpointHash:    bytes.Repeat([]byte{0xCD}, 32),
+			OutpointIndex:   []byte{0x00, 0x00, 0x00, 0x00},
+			UnlockingScript: make([]byte, 300),
+			Sequence:        []byte{0x00, 0x00, 0x00, 0x05},
+		},
+	}
+	outputs := []*TrxOutput{
+		{Amount: share.Int64ToBytes(1_000), LockingScript: bytes.Repeat([]byte{0x01}, 25)},
+		{Amount: share.Int64ToBytes(2_500), LockingScript: bytes.Repeat([]byte{0x02}, 25)},
+	}
+
+	trx, err := NewTransaction(inputs, outputs)
+	assert.NoError(t, err)
+
+	return trx
+}
+
+func TestTransactionEncoding(t *testing.T) {
+	t.Run("should decode an encoded transaction to the same transaction", func(t *testing.T) {
+		trx := testTransaction(t)
+
+		encoded, err := trx.Encode()
+		assert.NoError(t, err)
+
+		decoded, err := DecodeTransaction(encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, trx, decoded)
+
+		reencoded, err := decoded.Encode()
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(encoded, reencoded), "encoding is not canonical")
+	})
+
+	t.Run("should reject an unknown encoding version", func(t *testing.T) {
+		encoded, err := testTransaction(t).Encode()
+		assert.NoError(t, err)
+
+		encoded[0] = EncodingVersion + 1
+		_, err = DecodeTransaction(encoded)
+		assert.Error(t, err)
+	})
+
+	t.Run("should reject truncated and trailing data", func(t *testing.T) {
+		encoded, err := testTransaction(t).Encode()
+		assert.NoError(t, err)
+
+		_, err = DecodeTransaction(encoded[:len(encoded)-1])
+		assert.Error(t, err)
+
+		_, err = DecodeTransaction(append(encoded, 0x00))
+		assert.Error(t, err)
+	})
+
+	t.Run("should reject fields of the wrong fixed length", func(t *testing.T) {
+		trx := testTransaction(t)
+		trx.Input[0].OutpointHash = trx.Input[0].OutpointHash[:31]
+
+		_, err := trx.Encode()
+		assert.Error(t, err)
+	})
+
+	t.Run("should hash transactions differing only in field boundaries differently", func(t *testing.T) {
+		a := testTransaction(t)
+		b := testTransaction(t)
+
+		// Fold the second output into the locking script of the first one.
+		// Without length prefixes both would serialize to the same bytes.
+		merged := append([]byte{}, a.Output[0].LockingScript...)
+		merged = append(merged, a.Output[1].Amount...)
+		merged = append(merged, a.Output[1].LockingScript...)
+		b.Output = []*TrxOutput{{Amount: a.Output[0].Amount, LockingScript: merged}}
+
+		hashA, err := a.Hash(false)
+		assert.NoError(t, err)
+		hashB, err := b.Hash(false)
+		assert.NoError(t, err)
+		assert.NotEqual(t, hashA, hashB)
+	})
+
+	t.Run("should reject non canonical length prefixes", func(t *testing.T) {
+		output := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xFD, 0x00, 0x01, 0xFF}
+
+		_, err := DecodeTrxOutput(output)
+		assert.ErrorIs(t, err, share.ErrNonCanonicalVarInt)
+	})
+}
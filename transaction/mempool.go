@@ -1,37 +1,440 @@
 package transaction
 
 import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
+// Default fee rate bucket boundaries (maglia per byte) used by FeeHistogram
+var DefaultFeeRateBuckets = []uint64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+// MemPoolEntry is a transaction waiting in the mempool together with the
+// data needed to rank it: the fee it pays and its serialized size
+type MemPoolEntry struct {
+	ID          string
+	Transaction *Transaction
+	Fee         uint64 // maglia
+	Size        int    // bytes
+	Time        time.Time
+}
+
+// Returns the fee paid per byte of the serialized transaction
+func (entry *MemPoolEntry) FeeRate() uint64 {
+	if entry.Size == 0 {
+		return 0
+	}
+
+	return entry.Fee / uint64(entry.Size)
+}
+
+// FeeBucket holds the number and total size of mempool transactions whose fee
+// rate is at least MinFeeRate and less than the MinFeeRate of the next bucket
+type FeeBucket struct {
+	MinFeeRate uint64
+	Count      int
+	Size       int
+}
+
+type MemPoolEventType int
+
+const (
+	// A transaction from a disconnected block was put back into the mempool
+	EventTransactionReadded MemPoolEventType = iota
+	// A transaction from a disconnected block was dropped because it conflicts
+	// with the new chain or with the mempool
+	EventTransactionConflicted
+)
+
+type MemPoolEvent struct {
+	Type        MemPoolEventType
+	ID          string
+	Transaction *Transaction
+	Reason      string
+}
+
+// UTXOView gives access to the unspent outputs of a chain
+type UTXOView interface {
+	GetOutput(hash []byte, index int) *TrxOutput
+	// Returns the height and timestamp of the block that confirmed the output,
+	// or ok false if the output is not in the view
+	GetOutputConfirmation(hash []byte, index int) (height int, blockTime int64, ok bool)
+	// Returns the height of the last block of the chain
+	Height() int
+}
+
 type MemPool struct {
-	transactions map[string]*Transaction
-	mu           sync.RWMutex
+	entries     map[string]*MemPoolEntry
+	spends      map[string]string              // outpoint -> id of the spending transaction
+	byScript    map[string]map[string]struct{} // hex locking script -> ids of paying transactions
+	size        int
+	subscribers []func(*MemPoolEvent)
+	policy      *Policy
+	mu          sync.RWMutex
 }
 
-func NewMemPool() *MemPool {
+// Returns a new mempool accepting the transactions that are standard under policy.
+// A nil policy means the default policy.
+func NewMemPool(policy *Policy) *MemPool {
+	if policy == nil {
+		policy = DefaultPolicy()
+	}
+
 	return &MemPool{
-		transactions: make(map[string]*Transaction),
+		entries:  make(map[string]*MemPoolEntry),
+		spends:   make(map[string]string),
+		byScript: make(map[string]map[string]struct{}),
+		policy:   policy,
 	}
 }
 
-func (mp *MemPool) AddTransaction(idx string, trx *Transaction) {
+func (mp *MemPool) Policy() *Policy {
+	return mp.policy
+}
+
+// Returns the key used to index the output at index of the transaction with hash
+func OutpointKey(hash []byte, index int) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(hash), index)
+}
+
+// Adds trx to the mempool if each of its inputs spends an output of view or of a
+// mempool transaction that no other mempool transaction spends, its lock times
+// are reached for the next block, its scripts verify under the policy flags and
+// it is standard. The fee is what the spent
+// outputs hold beyond the outputs of trx.
+func (mp *MemPool) AddTransaction(idx string, trx *Transaction, view UTXOView) error {
+	if trx.IsCoinbase() {
+		return errors.New("coinbase transactions cannot enter the mempool")
+	}
+
+	trxBytes, err := trx.Encode()
+	if err != nil {
+		return err
+	}
+
+	// The checks and the insert share the write lock so that two transactions
+	// spending the same output cannot both pass the conflict check
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 
-	mp.transactions[idx] = trx
+	fee, prevOutputs, err := mp.checkInputs(idx, trx, view)
+	if err != nil {
+		return err
+	}
+
+	if err := trx.CheckLocks(view, view.Height()+1, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	if err := trx.VerifyScripts(prevOutputs, mp.policy.ScriptFlags(), nil); err != nil {
+		return err
+	}
+
+	if err := mp.policy.CheckTransaction(trx); err != nil {
+		return err
+	}
+
+	if _, exists := mp.entries[idx]; exists {
+		mp.deleteTransaction(idx)
+	}
+
+	entry := &MemPoolEntry{
+		ID:          idx,
+		Transaction: trx,
+		Fee:         fee,
+		Size:        len(trxBytes),
+		Time:        time.Now(),
+	}
+	mp.entries[idx] = entry
+	mp.size += entry.Size
+
+	for _, input := range trx.Input {
+		outIdx, err := input.OutputIndex()
+		if err != nil {
+			continue
+		}
+		mp.spends[OutpointKey(input.OutpointHash, outIdx)] = idx
+	}
+
+	for _, output := range trx.Output {
+		scriptHex := hex.EncodeToString(output.LockingScript)
+		if _, exists := mp.byScript[scriptHex]; !exists {
+			mp.byScript[scriptHex] = make(map[string]struct{})
+		}
+		mp.byScript[scriptHex][idx] = struct{}{}
+	}
+
+	return nil
 }
 
 func (mp *MemPool) DeleteTransaction(idx string) {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 
-	delete(mp.transactions, idx)
+	mp.deleteTransaction(idx)
+}
+
+// Removes a transaction and its index entries. Callers must hold the write lock.
+func (mp *MemPool) deleteTransaction(idx string) {
+	entry, exists := mp.entries[idx]
+	if !exists {
+		return
+	}
+
+	for _, input := range entry.Transaction.Input {
+		outIdx, err := input.OutputIndex()
+		if err != nil {
+			continue
+		}
+
+		key := OutpointKey(input.OutpointHash, outIdx)
+		if mp.spends[key] == idx {
+			delete(mp.spends, key)
+		}
+	}
+
+	for _, output := range entry.Transaction.Output {
+		scriptHex := hex.EncodeToString(output.LockingScript)
+		delete(mp.byScript[scriptHex], idx)
+		if len(mp.byScript[scriptHex]) == 0 {
+			delete(mp.byScript, scriptHex)
+		}
+	}
+
+	mp.size -= entry.Size
+	delete(mp.entries, idx)
+}
+
+// Registers fn to be called with every event emitted by the mempool
+func (mp *MemPool) Subscribe(fn func(*MemPoolEvent)) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.subscribers = append(mp.subscribers, fn)
+}
+
+func (mp *MemPool) publish(events []*MemPoolEvent) {
+	mp.mu.RLock()
+	subscribers := append([]func(*MemPoolEvent){}, mp.subscribers...)
+	mp.mu.RUnlock()
+
+	for _, event := range events {
+		for _, fn := range subscribers {
+			fn(event)
+		}
+	}
+}
+
+// Puts transactions from disconnected blocks back into the mempool. trxs must be
+// ordered so that a transaction comes after any transaction it spends from.
+// A transaction is re-admitted when AddTransaction accepts it on top of view;
+// otherwise it is dropped. Returns the dropped transactions.
+func (mp *MemPool) ReaddTransactions(trxs []*Transaction, view UTXOView) []*Transaction {
+	dropped := make([]*Transaction, 0)
+	events := make([]*MemPoolEvent, 0)
+
+	for _, trx := range trxs {
+		idx := hex.EncodeToString(trx.ID)
+
+		if err := mp.AddTransaction(idx, trx, view); err != nil {
+			dropped = append(dropped, trx)
+			events = append(events, &MemPoolEvent{
+				Type:        EventTransactionConflicted,
+				ID:          idx,
+				Transaction: trx,
+				Reason:      err.Error(),
+			})
+			continue
+		}
+
+		events = append(events, &MemPoolEvent{
+			Type:        EventTransactionReadded,
+			ID:          idx,
+			Transaction: trx,
+		})
+	}
+
+	mp.publish(events)
+
+	return dropped
+}
+
+// Returns the fee paid by trx and the outputs its inputs spend if all of them
+// can be spent on top of view and the mempool, or an error why they cannot.
+// Callers must hold the write lock.
+func (mp *MemPool) checkInputs(idx string, trx *Transaction, view UTXOView) (uint64, []*TrxOutput, error) {
+	prevOutputs := make([]*TrxOutput, 0, len(trx.Input))
+
+	for _, input := range trx.Input {
+		outIdx, err := input.OutputIndex()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		key := OutpointKey(input.OutpointHash, outIdx)
+		if spender, isSpent := mp.spends[key]; isSpent && spender != idx {
+			return 0, nil, fmt.Errorf("output %s is already spent by mempool transaction %s", key, spender)
+		}
+
+		output := view.GetOutput(input.OutpointHash, outIdx)
+		if output == nil {
+			if parent, exists := mp.entries[hex.EncodeToString(input.OutpointHash)]; exists &&
+				outIdx < len(parent.Transaction.Output) {
+				output = parent.Transaction.Output[outIdx]
+			}
+		}
+
+		if output == nil {
+			return 0, nil, fmt.Errorf("output %s is missing or already spent", key)
+		}
+
+		prevOutputs = append(prevOutputs, output)
+	}
+
+	fee, err := trx.Fee(prevOutputs)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return fee, prevOutputs, nil
 }
 
 func (mp *MemPool) GetTransaction(idx string) *Transaction {
 	mp.mu.RLock()
 	defer mp.mu.RUnlock()
 
-	return mp.transactions[idx]
+	entry, exists := mp.entries[idx]
+	if !exists {
+		return nil
+	}
+
+	return entry.Transaction
+}
+
+func (mp *MemPool) GetEntry(idx string) *MemPoolEntry {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.entries[idx]
+}
+
+// Returns the number of transactions in the mempool
+func (mp *MemPool) Count() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.entries)
+}
+
+// Returns the total serialized size in bytes of the transactions in the mempool
+func (mp *MemPool) Size() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.size
+}
+
+// Returns at most limit entries starting at offset, ordered by arrival time,
+// together with the total number of entries in the mempool.
+// A limit of zero or less returns every entry after offset.
+func (mp *MemPool) List(offset, limit int) ([]*MemPoolEntry, int) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	entries := mp.sortedEntries()
+	total := len(entries)
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total {
+		return make([]*MemPoolEntry, 0), total
+	}
+
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+
+	return entries[offset:end], total
+}
+
+// Returns the entries ordered by arrival time, breaking ties by id so that
+// pages stay stable between calls. Callers must hold the read lock.
+func (mp *MemPool) sortedEntries() []*MemPoolEntry {
+	entries := make([]*MemPoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries
+}
+
+// Groups the mempool transactions by fee rate. bounds must be in ascending order;
+// transactions paying less than the first bound are counted in a bucket starting at 0.
+func (mp *MemPool) FeeHistogram(bounds []uint64) []*FeeBucket {
+	buckets := []*FeeBucket{{MinFeeRate: 0}}
+	for _, bound := range bounds {
+		if bound == 0 {
+			continue
+		}
+		buckets = append(buckets, &FeeBucket{MinFeeRate: bound})
+	}
+
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	for _, entry := range mp.entries {
+		feeRate := entry.FeeRate()
+
+		bucketIdx := sort.Search(len(buckets), func(i int) bool {
+			return buckets[i].MinFeeRate > feeRate
+		}) - 1
+
+		buckets[bucketIdx].Count++
+		buckets[bucketIdx].Size += entry.Size
+	}
+
+	return buckets
+}
+
+// Returns the mempool transaction spending the output at index of the transaction
+// with hash, or nil if no mempool transaction spends it
+func (mp *MemPool) GetTransactionSpending(hash []byte, index int) *MemPoolEntry {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	idx, exists := mp.spends[OutpointKey(hash, index)]
+	if !exists {
+		return nil
+	}
+
+	return mp.entries[idx]
+}
+
+// Returns the mempool transactions with at least one output locked by lockingScript
+func (mp *MemPool) GetTransactionsPaying(lockingScript []byte) []*MemPoolEntry {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	entries := make([]*MemPoolEntry, 0)
+	for idx := range mp.byScript[hex.EncodeToString(lockingScript)] {
+		entries = append(entries, mp.entries[idx])
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries
 }
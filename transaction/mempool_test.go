@@ -0,0 +1,310 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/hex"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+// Amount of every output of a utxoView, in maglia
+const testOutputAmount = 1_000_000
+
+// utxoView holds outputs anyone can spend, confirmed in the genesis block
+type utxoView map[string]*TrxOutput
+
+func (view utxoView) GetOutput(hash []byte, index int) *TrxOutput {
+	return view[OutpointKey(hash, index)]
+}
+
+func (view utxoView) GetOutputConfirmation(hash []byte, index int) (int, int64, bool) {
+	_, ok := view[OutpointKey(hash, index)]
+	return 0, 0, ok
+}
+
+func (view utxoView) Height() int {
+	return 0
+}
+
+// yieldingView lets other goroutines run on every lookup of an output
+type yieldingView struct {
+	utxoView
+}
+
+func (view yieldingView) GetOutput(hash []byte, index int) *TrxOutput {
+	runtime.Gosched()
+	return view.utxoView.GetOutput(hash, index)
+}
+
+// Adds to view an output anyone can spend and returns its transaction hash
+func (view utxoView) fund(n byte) []byte {
+	hash := bytes.Repeat([]byte{n}, 32)
+	view[OutpointKey(hash, 0)] = &TrxOutput{Amount: share.Int64ToBytes(testOutputAmount), LockingScript: []byte{script.OP_1}}
+
+	return hash
+}
+
+func payToTestHash(t *testing.T, n byte) []byte {
+	lockingScript, err := script.PayToPubKeyHash(bytes.Repeat([]byte{n}, 20))
+	assert.NoError(t, err)
+
+	return lockingScript
+}
+
+// Returns a transaction spending the output of view funded by hash to
+// lockingScript with a fee of feeRate maglia per byte
+func feePaying(t *testing.T, hash []byte, lockingScript []byte, feeRate uint64) *Transaction {
+	build := func(amount int64) *Transaction {
+		input := &TrxInput{
+			OutpointHash:  hash,
+			OutpointIndex: share.IntToBytes(0),
+			Sequence:      share.IntToBytes(int(DefaultSequence)),
+		}
+		trx, err := NewTransaction([]*TrxInput{input}, []*TrxOutput{{Amount: share.Int64ToBytes(amount), LockingScript: lockingScript}})
+		assert.NoError(t, err)
+
+		return trx
+	}
+
+	// Amounts have a fixed width, so the size does not depend on the fee
+	trxBytes, err := build(testOutputAmount).Encode()
+	assert.NoError(t, err)
+
+	return build(testOutputAmount - int64(feeRate)*int64(len(trxBytes)))
+}
+
+// Returns a transaction spending the output of view funded by hash to one
+// output per amount
+func paying(t *testing.T, hash []byte, amounts ...int64) *Transaction {
+	input := &TrxInput{
+		OutpointHash:  hash,
+		OutpointIndex: share.IntToBytes(0),
+		Sequence:      share.IntToBytes(int(DefaultSequence)),
+	}
+
+	outputs := make([]*TrxOutput, 0, len(amounts))
+	for _, amount := range amounts {
+		outputs = append(outputs, &TrxOutput{Amount: share.Int64ToBytes(amount), LockingScript: payToTestHash(t, 0x01)})
+	}
+
+	trx, err := NewTransaction([]*TrxInput{input}, outputs)
+	assert.NoError(t, err)
+
+	return trx
+}
+
+// Adds trx to mempool and returns its id
+func addToMemPool(t *testing.T, mempool *MemPool, trx *Transaction, view UTXOView) string {
+	idx := hex.EncodeToString(trx.ID)
+	assert.NoError(t, mempool.AddTransaction(idx, trx, view))
+
+	return idx
+}
+
+func TestMemPoolList(t *testing.T) {
+	view := make(utxoView)
+	mempool := NewMemPool(nil)
+	for n := byte(1); n <= 5; n++ {
+		addToMemPool(t, mempool, feePaying(t, view.fund(n), payToTestHash(t, n), 1), view)
+	}
+
+	all, total := mempool.List(0, 0)
+	assert.Len(t, all, 5)
+	assert.Equal(t, 5, total)
+
+	t.Run("should return consecutive pages of the entries", func(t *testing.T) {
+		first, total := mempool.List(0, 2)
+		assert.Equal(t, 5, total)
+		second, _ := mempool.List(2, 2)
+		last, _ := mempool.List(4, 2)
+
+		assert.Equal(t, all, append(append(first, second...), last...))
+	})
+
+	t.Run("should bound the offset and the limit", func(t *testing.T) {
+		entries, total := mempool.List(-3, 2)
+		assert.Equal(t, all[:2], entries)
+		assert.Equal(t, 5, total)
+
+		entries, _ = mempool.List(3, 10)
+		assert.Equal(t, all[3:], entries)
+
+		entries, _ = mempool.List(1, -1)
+		assert.Equal(t, all[1:], entries)
+
+		for _, offset := range []int{5, 6} {
+			entries, total = mempool.List(offset, 2)
+			assert.Empty(t, entries)
+			assert.NotNil(t, entries)
+			assert.Equal(t, 5, total)
+		}
+	})
+}
+
+func TestMemPoolFeeHistogram(t *testing.T) {
+	t.Run("should count each transaction in the bucket its fee rate starts", func(t *testing.T) {
+		view := make(utxoView)
+		mempool := NewMemPool(nil)
+
+		sizes := make(map[uint64]int)
+		for n, feeRate := range []uint64{0, 1, 2, 4, 5, 9} {
+			idx := addToMemPool(t, mempool, feePaying(t, view.fund(byte(n+1)), payToTestHash(t, byte(n+1)), feeRate), view)
+			assert.Equal(t, feeRate, mempool.GetEntry(idx).FeeRate())
+			sizes[feeRate] = mempool.GetEntry(idx).Size
+		}
+
+		// A zero bound is the bucket the histogram always starts with
+		assert.Equal(t, []*FeeBucket{
+			{MinFeeRate: 0, Count: 2, Size: sizes[0] + sizes[1]},
+			{MinFeeRate: 2, Count: 2, Size: sizes[2] + sizes[4]},
+			{MinFeeRate: 5, Count: 2, Size: sizes[5] + sizes[9]},
+			{MinFeeRate: 10},
+		}, mempool.FeeHistogram([]uint64{0, 2, 5, 10}))
+	})
+
+	t.Run("should return empty buckets for an empty mempool", func(t *testing.T) {
+		assert.Equal(t, []*FeeBucket{{MinFeeRate: 0}, {MinFeeRate: 1}}, NewMemPool(nil).FeeHistogram([]uint64{1}))
+	})
+}
+
+func TestMemPoolIndexes(t *testing.T) {
+	t.Run("should forget the outputs spent and the scripts paid by a removed transaction", func(t *testing.T) {
+		view := make(utxoView)
+		mempool := NewMemPool(nil)
+		lockingScript := payToTestHash(t, 0x42)
+
+		firstHash, secondHash := view.fund(1), view.fund(2)
+		first := addToMemPool(t, mempool, feePaying(t, firstHash, lockingScript, 1), view)
+		second := addToMemPool(t, mempool, feePaying(t, secondHash, lockingScript, 1), view)
+		secondSize := mempool.GetEntry(second).Size
+
+		assert.Equal(t, first, mempool.GetTransactionSpending(firstHash, 0).ID)
+		assert.Len(t, mempool.GetTransactionsPaying(lockingScript), 2)
+
+		mempool.DeleteTransaction(first)
+
+		assert.Nil(t, mempool.GetTransactionSpending(firstHash, 0))
+		assert.Equal(t, second, mempool.GetTransactionSpending(secondHash, 0).ID)
+		paying := mempool.GetTransactionsPaying(lockingScript)
+		assert.Len(t, paying, 1)
+		assert.Equal(t, second, paying[0].ID)
+		assert.Equal(t, 1, mempool.Count())
+		assert.Equal(t, secondSize, mempool.Size())
+
+		mempool.DeleteTransaction(second)
+
+		assert.Nil(t, mempool.GetTransactionSpending(secondHash, 0))
+		assert.Empty(t, mempool.GetTransactionsPaying(lockingScript))
+		assert.Empty(t, mempool.byScript)
+		assert.Empty(t, mempool.spends)
+		assert.Zero(t, mempool.Size())
+
+		// The output spent by the removed transaction can be spent again
+		addToMemPool(t, mempool, feePaying(t, firstHash, payToTestHash(t, 0x43), 2), view)
+	})
+
+	t.Run("should reject a transaction spending an output spent in the mempool", func(t *testing.T) {
+		view := make(utxoView)
+		mempool := NewMemPool(nil)
+		hash := view.fund(1)
+
+		spender := addToMemPool(t, mempool, feePaying(t, hash, payToTestHash(t, 1), 1), view)
+
+		conflicting := feePaying(t, hash, payToTestHash(t, 2), 1)
+		assert.Error(t, mempool.AddTransaction(hex.EncodeToString(conflicting.ID), conflicting, view))
+		assert.Equal(t, spender, mempool.GetTransactionSpending(hash, 0).ID)
+		assert.Empty(t, mempool.GetTransactionsPaying(payToTestHash(t, 2)))
+	})
+
+	t.Run("should admit a single one of concurrent spends of an output", func(t *testing.T) {
+		view := make(utxoView)
+		mempool := NewMemPool(nil)
+		hash := view.fund(1)
+
+		spends := make([]*Transaction, 0, 8)
+		for n := byte(1); n <= 8; n++ {
+			spends = append(spends, feePaying(t, hash, payToTestHash(t, n), 1))
+		}
+
+		// The spends are released together and yield on every lookup to race
+		// through the checks
+		start := make(chan struct{})
+		errs := make([]error, len(spends))
+		var wg sync.WaitGroup
+		for idx, trx := range spends {
+			wg.Add(1)
+			go func(idx int, trx *Transaction) {
+				defer wg.Done()
+				<-start
+				errs[idx] = mempool.AddTransaction(hex.EncodeToString(trx.ID), trx, yieldingView{view})
+			}(idx, trx)
+		}
+		close(start)
+		wg.Wait()
+
+		admitted := 0
+		for _, err := range errs {
+			if err == nil {
+				admitted++
+			}
+		}
+		assert.Equal(t, 1, admitted)
+		assert.Equal(t, 1, mempool.Count())
+	})
+}
+
+func TestMemPoolAddTransaction(t *testing.T) {
+	maxAmount := int64(share.MAX_MAGLIA)
+
+	tests := []struct {
+		name    string
+		amounts []int64
+		err     error
+	}{
+		{
+			name:    "should accept outputs spending the whole input",
+			amounts: []int64{testOutputAmount - 1_000, 1_000},
+		},
+		{
+			name:    "should reject outputs paying more than the input",
+			amounts: []int64{testOutputAmount, 1_000},
+			err:     ErrOutputsExceedInputs,
+		},
+		{
+			name:    "should reject a negative output even when the total is in range",
+			amounts: []int64{1_000_000_000_000, -1_000_000_000_000 + testOutputAmount - 1},
+			err:     ErrAmountOutOfRange,
+		},
+		{
+			name:    "should reject an output paying more than the money supply",
+			amounts: []int64{maxAmount + 1},
+			err:     ErrAmountOutOfRange,
+		},
+		{
+			name:    "should reject outputs whose total exceeds the money supply",
+			amounts: []int64{maxAmount, maxAmount, maxAmount, maxAmount, maxAmount},
+			err:     ErrAmountOutOfRange,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			view := make(utxoView)
+			mempool := NewMemPool(nil)
+			trx := paying(t, view.fund(1), test.amounts...)
+
+			err := mempool.AddTransaction(hex.EncodeToString(trx.ID), trx, view)
+			if test.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, test.err)
+			assert.Zero(t, mempool.Count())
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+)
+
+const PolicyFilename = "mag_policy.json"
+
+var (
+	ErrNonStandardSize         = errors.New("transaction size exceeds policy limit")
+	ErrNonStandardInputs       = errors.New("transaction input count exceeds policy limit")
+	ErrNonStandardOutputs      = errors.New("transaction output count exceeds policy limit")
+	ErrDustOutput              = errors.New("transaction output is dust")
+	ErrNonStandardCoinbaseData = errors.New("coinbase data size exceeds policy limit")
+	ErrNonStandardLocking      = errors.New("locking script is not a standard template")
+	ErrNonStandardUnlocking    = errors.New("unlocking script is not push only")
+	ErrNonStandardDataCarrier  = errors.New("data carrier output exceeds policy limit")
+	ErrMultipleDataCarriers    = errors.New("transaction has more than one data carrier output")
+)
+
+// Policy holds the standardness rules a node applies on top of consensus rules
+// to decide which transactions it relays and mines. Two nodes with different
+// policies still agree on which blocks are valid.
+type Policy struct {
+	DustThreshold       uint64 `json:"dust_threshold"`         // outputs paying less maglia are not relayed
+	MaxTransactionSize  int    `json:"max_transaction_size"`   // bytes
+	MaxInputs           int    `json:"max_inputs"`             // inputs per transaction
+	MaxOutputs          int    `json:"max_outputs"`            // outputs per transaction
+	MaxCoinbaseDataSize int    `json:"max_coinbase_data_size"` // bytes of coinbase input data
+	StrictEncoding      bool   `json:"strict_encoding"`        // require valid key and signature encodings in scripts
+	MaxDataCarrierSize  int    `json:"max_data_carrier_size"`  // bytes of data in an OP_RETURN output
+}
+
+func DefaultPolicy() *Policy {
+	return &Policy{
+		DustThreshold:       546,
+		MaxTransactionSize:  100_000,
+		MaxInputs:           1_000,
+		MaxOutputs:          1_000,
+		MaxCoinbaseDataSize: 108, // 100 bytes of data followed by an 8 bytes timestamp
+		StrictEncoding:      true,
+		MaxDataCarrierSize:  script.MaxNullDataSize,
+	}
+}
+
+// Loads a policy from a JSON file. Rules missing from the file keep their default
+// value and a missing file yields the default policy.
+func LoadPolicy(path string) (*Policy, error) {
+	policy := DefaultPolicy()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return policy, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("could not parse policy file %s: %s", path, err)
+	}
+
+	return policy, nil
+}
+
+// Returns nil if trx is standard under the policy, or an error describing the
+// first rule it breaks
+func (policy *Policy) CheckTransaction(trx *Transaction) error {
+	trxBytes, err := trx.Encode()
+	if err != nil {
+		return err
+	}
+
+	if len(trxBytes) > policy.MaxTransactionSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrNonStandardSize, len(trxBytes), policy.MaxTransactionSize)
+	}
+
+	if len(trx.Input) > policy.MaxInputs {
+		return fmt.Errorf("%w: %d > %d", ErrNonStandardInputs, len(trx.Input), policy.MaxInputs)
+	}
+
+	if len(trx.Output) > policy.MaxOutputs {
+		return fmt.Errorf("%w: %d > %d", ErrNonStandardOutputs, len(trx.Output), policy.MaxOutputs)
+	}
+
+	dataCarriers := 0
+	for idx, output := range trx.Output {
+		amount, err := share.BytesToInt64(output.Amount)
+		if err != nil {
+			return err
+		}
+
+		if amount < 0 {
+			return fmt.Errorf("%w: output %d pays %d maglia", ErrAmountOutOfRange, idx, amount)
+		}
+
+		// Data carrier outputs are never spent, so they are not dust whatever they pay
+		if data := script.ExtractNullData(output.LockingScript); data != nil {
+			if len(data) > policy.MaxDataCarrierSize {
+				return fmt.Errorf("%w: output %d holds %d > %d bytes", ErrNonStandardDataCarrier, idx, len(data), policy.MaxDataCarrierSize)
+			}
+
+			if dataCarriers++; dataCarriers > 1 {
+				return fmt.Errorf("%w: output %d", ErrMultipleDataCarriers, idx)
+			}
+
+			continue
+		}
+
+		if uint64(amount) < policy.DustThreshold {
+			return fmt.Errorf("%w: output %d pays %d < %d maglia", ErrDustOutput, idx, amount, policy.DustThreshold)
+		}
+
+		if !script.IsStandard(output.LockingScript) {
+			return fmt.Errorf("%w: output %d", ErrNonStandardLocking, idx)
+		}
+	}
+
+	if trx.IsCoinbase() {
+		if size := len(trx.Input[0].UnlockingScript); size > policy.MaxCoinbaseDataSize {
+			return fmt.Errorf("%w: %d > %d bytes", ErrNonStandardCoinbaseData, size, policy.MaxCoinbaseDataSize)
+		}
+
+		return nil
+	}
+
+	for idx, input := range trx.Input {
+		if !script.IsPushOnly(input.UnlockingScript) {
+			return fmt.Errorf("%w: input %d", ErrNonStandardUnlocking, idx)
+		}
+	}
+
+	return nil
+}
+
+// Returns the script verification flags the policy requires on top of consensus
+func (policy *Policy) ScriptFlags() script.VerifyFlags {
+	var flags script.VerifyFlags
+
+	if policy.StrictEncoding {
+		flags |= script.VerifyStrictEncoding
+	}
+
+	return flags
+}
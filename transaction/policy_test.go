@@ -0,0 +1,230 @@
+package transaction
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a transaction with the given number of push only inputs and one pay
+// to public key hash output per amount
+func policyTransaction(t *testing.T, inputs int, amounts ...int64) *Transaction {
+	trxInputs := make([]*TrxInput, 0, inputs)
+	for i := 0; i < inputs; i++ {
+		trxInputs = append(trxInputs, &TrxInput{
+			OutpointHash:    bytes.Repeat([]byte{0xAB}, 32),
+			OutpointIndex:   share.IntToBytes(i),
+			UnlockingScript: []byte{0x02, 0x30, 0x44},
+			Sequence:        share.IntToBytes(int(DefaultSequence)),
+		})
+	}
+
+	trxOutputs := make([]*TrxOutput, 0, len(amounts))
+	for _, amount := range amounts {
+		trxOutputs = append(trxOutputs, &TrxOutput{Amount: share.Int64ToBytes(amount), LockingScript: payToTestHash(t, 0x01)})
+	}
+
+	trx, err := NewTransaction(trxInputs, trxOutputs)
+	assert.NoError(t, err)
+
+	return trx
+}
+
+// Returns a coinbase transaction whose input holds size bytes of data
+func policyCoinbase(t *testing.T, size int) *Transaction {
+	input := &TrxInput{
+		OutpointHash:    make([]byte, 32),
+		OutpointIndex:   []byte{0xFF, 0xFF, 0xFF, 0xFF},
+		UnlockingScript: make([]byte, size),
+		Sequence:        share.IntToBytes(int(DefaultSequence)),
+	}
+	output := &TrxOutput{Amount: share.Int64ToBytes(5_000_000_000), LockingScript: payToTestHash(t, 0x01)}
+
+	trx, err := NewCoinbaseTransaction([]*TrxInput{input}, []*TrxOutput{output})
+	assert.NoError(t, err)
+
+	return trx
+}
+
+func nullDataOutput(t *testing.T, size int) *TrxOutput {
+	lockingScript, err := script.NullDataScript(make([]byte, size))
+	assert.NoError(t, err)
+
+	return &TrxOutput{Amount: share.Int64ToBytes(0), LockingScript: lockingScript}
+}
+
+func TestPolicyCheckTransaction(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy func(policy *Policy)
+		trx    func(t *testing.T) *Transaction
+		err    error
+	}{
+		{
+			name: "should accept a standard transaction",
+			trx:  func(t *testing.T) *Transaction { return policyTransaction(t, 2, 1_000, 2_000) },
+		},
+		{
+			name: "should accept an output paying the dust threshold",
+			trx:  func(t *testing.T) *Transaction { return policyTransaction(t, 1, 546) },
+		},
+		{
+			name: "should reject an output paying less than the dust threshold",
+			trx:  func(t *testing.T) *Transaction { return policyTransaction(t, 1, 1_000, 545) },
+			err:  ErrDustOutput,
+		},
+		{
+			name: "should reject an output paying a negative amount",
+			trx:  func(t *testing.T) *Transaction { return policyTransaction(t, 1, 1_000, -1) },
+			err:  ErrAmountOutOfRange,
+		},
+		{
+			name: "should reject a data carrier output paying a negative amount",
+			trx: func(t *testing.T) *Transaction {
+				trx := policyTransaction(t, 1, 1_000)
+				trx.Output = append(trx.Output, nullDataOutput(t, 4))
+				trx.Output[1].Amount = share.Int64ToBytes(-1)
+				return trx
+			},
+			err: ErrAmountOutOfRange,
+		},
+		{
+			name: "should reject a transaction larger than the maximum size",
+			policy: func(policy *Policy) {
+				policy.MaxTransactionSize = 100
+			},
+			trx: func(t *testing.T) *Transaction { return policyTransaction(t, 2, 1_000, 1_000) },
+			err: ErrNonStandardSize,
+		},
+		{
+			name: "should accept as many inputs and outputs as the limits",
+			policy: func(policy *Policy) {
+				policy.MaxInputs, policy.MaxOutputs = 2, 2
+			},
+			trx: func(t *testing.T) *Transaction { return policyTransaction(t, 2, 1_000, 1_000) },
+		},
+		{
+			name: "should reject more inputs than the limit",
+			policy: func(policy *Policy) {
+				policy.MaxInputs = 2
+			},
+			trx: func(t *testing.T) *Transaction { return policyTransaction(t, 3, 1_000) },
+			err: ErrNonStandardInputs,
+		},
+		{
+			name: "should reject more outputs than the limit",
+			policy: func(policy *Policy) {
+				policy.MaxOutputs = 2
+			},
+			trx: func(t *testing.T) *Transaction { return policyTransaction(t, 1, 1_000, 1_000, 1_000) },
+			err: ErrNonStandardOutputs,
+		},
+		{
+			name: "should reject an output locked by a non standard script",
+			trx: func(t *testing.T) *Transaction {
+				trx := policyTransaction(t, 1, 1_000)
+				trx.Output[0].LockingScript = []byte{script.OP_1}
+				return trx
+			},
+			err: ErrNonStandardLocking,
+		},
+		{
+			name: "should reject an unlocking script that is not push only",
+			trx: func(t *testing.T) *Transaction {
+				trx := policyTransaction(t, 1, 1_000)
+				trx.Input[0].UnlockingScript = []byte{script.OP_CHECKSIG}
+				return trx
+			},
+			err: ErrNonStandardUnlocking,
+		},
+		{
+			name: "should accept a data carrier output paying nothing",
+			trx: func(t *testing.T) *Transaction {
+				trx := policyTransaction(t, 1, 1_000)
+				trx.Output = append(trx.Output, nullDataOutput(t, script.MaxNullDataSize))
+				return trx
+			},
+		},
+		{
+			name: "should reject a data carrier output larger than the limit",
+			policy: func(policy *Policy) {
+				policy.MaxDataCarrierSize = 10
+			},
+			trx: func(t *testing.T) *Transaction {
+				trx := policyTransaction(t, 1, 1_000)
+				trx.Output = append(trx.Output, nullDataOutput(t, 11))
+				return trx
+			},
+			err: ErrNonStandardDataCarrier,
+		},
+		{
+			name: "should reject a second data carrier output",
+			trx: func(t *testing.T) *Transaction {
+				trx := policyTransaction(t, 1, 1_000)
+				trx.Output = append(trx.Output, nullDataOutput(t, 4), nullDataOutput(t, 4))
+				return trx
+			},
+			err: ErrMultipleDataCarriers,
+		},
+		{
+			name: "should accept coinbase data of the maximum size",
+			trx:  func(t *testing.T) *Transaction { return policyCoinbase(t, 108) },
+		},
+		{
+			name: "should reject coinbase data larger than the maximum size",
+			trx:  func(t *testing.T) *Transaction { return policyCoinbase(t, 109) },
+			err:  ErrNonStandardCoinbaseData,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := DefaultPolicy()
+			if test.policy != nil {
+				test.policy(policy)
+			}
+
+			err := policy.CheckTransaction(test.trx(t))
+			if test.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	t.Run("should return the default policy when the file is missing", func(t *testing.T) {
+		policy, err := LoadPolicy(filepath.Join(t.TempDir(), PolicyFilename))
+		assert.NoError(t, err)
+		assert.Equal(t, DefaultPolicy(), policy)
+	})
+
+	t.Run("should keep the default of the rules missing from the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), PolicyFilename)
+		assert.NoError(t, os.WriteFile(path, []byte(`{"dust_threshold": 1000, "strict_encoding": false}`), 0o600))
+
+		policy, err := LoadPolicy(path)
+		assert.NoError(t, err)
+
+		expected := DefaultPolicy()
+		expected.DustThreshold = 1_000
+		expected.StrictEncoding = false
+		assert.Equal(t, expected, policy)
+	})
+
+	t.Run("should fail on a file that is not a policy", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), PolicyFilename)
+		assert.NoError(t, os.WriteFile(path, []byte(`{"dust_threshold": "high"`), 0o600))
+
+		policy, err := LoadPolicy(path)
+		assert.ErrorContains(t, err, "could not parse policy file")
+		assert.Nil(t, policy)
+	})
+}
@@ -0,0 +1,486 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+)
+
+// Magic bytes starting the encoding of a partially signed transaction
+var partiallySignedMagic = []byte{'m', 'p', 's', 't', 0xFF}
+
+// Maximum number of signatures of an input, the most public keys of a multisig script
+const maxPartialSignatures = 16
+
+var (
+	ErrNotPartiallySigned     = errors.New("not a partially signed transaction")
+	ErrSignedInput            = errors.New("partially signed transaction inputs must not have unlocking scripts")
+	ErrPreviousTransactions   = errors.New("one previous transaction is needed per input")
+	ErrOutpointMismatch       = errors.New("previous transaction does not hold the spent output")
+	ErrPartialSignature       = errors.New("signature does not sign the transaction with the public key")
+	ErrUnknownSigner          = errors.New("public key cannot sign for the output")
+	ErrMissingSignatures      = errors.New("transaction is missing signatures")
+	ErrMissingRedeemScript    = errors.New("redeem script of a pay to script hash output is missing")
+	ErrUnsupportedSpentOutput = errors.New("spent output is neither a key hash nor a multisig output")
+	ErrRedeemScriptMismatch   = errors.New("redeem script does not match the script hash")
+)
+
+// PartialSignature is the signature of one public key for an input
+type PartialSignature struct {
+	PublicKey []byte
+	Signature []byte
+}
+
+// PartialInput holds what signers of an input need: the transaction holding
+// the output it spends, the redeem script of a pay to script hash output, and
+// the signatures so far
+type PartialInput struct {
+	PreviousTransaction *Transaction
+	SpentOutput         *TrxOutput // the output of PreviousTransaction the input spends
+	RedeemScript        []byte
+	Signatures          []*PartialSignature
+}
+
+// PartiallySignedTransaction is an unsigned transaction passed between signers,
+// such as an offline machine holding the key or the cosigners of a multisig
+// address, until it has enough signatures to be finalized. Signatures sign the
+// signature hash, which does not change as signatures are added.
+//
+// The signature hash commits to the outpoints only, so each input carries the
+// whole transaction it spends from: its ID is checked against the outpoint hash,
+// which authenticates the amount and locking script of the spent output, and so
+// the fee a signer is shown.
+type PartiallySignedTransaction struct {
+	Transaction *Transaction // without unlocking scripts
+	Inputs      []*PartialInput
+}
+
+// Returns a partially signed transaction without signatures spending outputs of
+// previousTransactions, in input order. redeemScripts, if not nil, holds the
+// redeem script of each pay to script hash output.
+func NewPartiallySignedTransaction(
+	trx *Transaction,
+	previousTransactions []*Transaction,
+	redeemScripts [][]byte,
+) (*PartiallySignedTransaction, error) {
+	if len(previousTransactions) != len(trx.Input) || (redeemScripts != nil && len(redeemScripts) != len(trx.Input)) {
+		return nil, ErrPreviousTransactions
+	}
+
+	for _, input := range trx.Input {
+		if len(input.UnlockingScript) != 0 {
+			return nil, ErrSignedInput
+		}
+	}
+
+	psbt := &PartiallySignedTransaction{Transaction: trx, Inputs: make([]*PartialInput, 0, len(trx.Input))}
+	for idx, previous := range previousTransactions {
+		output, err := spentOutput(trx.Input[idx], previous)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", idx, err)
+		}
+
+		input := &PartialInput{PreviousTransaction: previous, SpentOutput: output}
+		if redeemScripts != nil {
+			input.RedeemScript = redeemScripts[idx]
+		}
+
+		if err := input.checkRedeemScript(); err != nil {
+			return nil, fmt.Errorf("input %d: %w", idx, err)
+		}
+		psbt.Inputs = append(psbt.Inputs, input)
+	}
+
+	return psbt, nil
+}
+
+// Returns the output of previous spent by input, after checking that previous
+// hashes to the outpoint hash of input
+func spentOutput(input *TrxInput, previous *Transaction) (*TrxOutput, error) {
+	// The ID is computed again, rather than trusting the one previous carries
+	hash, err := previous.Hash(previous.IsCoinbase())
+	if err != nil {
+		return nil, err
+	}
+
+	outIdx, err := input.OutputIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(hash[:], input.OutpointHash) || outIdx >= len(previous.Output) {
+		return nil, ErrOutpointMismatch
+	}
+
+	return previous.Output[outIdx], nil
+}
+
+// Returns the script whose conditions the signatures satisfy: the redeem script
+// of a pay to script hash output, the locking script otherwise
+func (input *PartialInput) SigningScript() []byte {
+	if script.IsScriptHash(input.SpentOutput.LockingScript) {
+		return input.RedeemScript
+	}
+
+	return input.SpentOutput.LockingScript
+}
+
+func (input *PartialInput) checkRedeemScript() error {
+	scriptHash := script.ExtractScriptHash(input.SpentOutput.LockingScript)
+	if scriptHash == nil {
+		return nil
+	}
+
+	if len(input.RedeemScript) == 0 {
+		return ErrMissingRedeemScript
+	}
+
+	if !bytes.Equal(script.Hash160(input.RedeemScript), scriptHash) {
+		return ErrRedeemScriptMismatch
+	}
+
+	return nil
+}
+
+// Returns the public keys that can sign for the input, in the order their
+// signatures go in the unlocking script, and the number of signatures needed
+func (input *PartialInput) Signers() ([][]byte, int) {
+	signingScript := input.SigningScript()
+
+	if m, publicKeys, ok := script.ExtractMultiSig(signingScript); ok {
+		return publicKeys, m
+	}
+
+	return nil, 1
+}
+
+// Reports whether publicKey can sign for the input
+func (input *PartialInput) CanSign(publicKey []byte) bool {
+	signingScript := input.SigningScript()
+
+	if pkHash := script.ExtractPubKeyHash(signingScript); pkHash != nil {
+		return bytes.Equal(script.Hash160(publicKey), pkHash)
+	}
+
+	signers, _ := input.Signers()
+	for _, signer := range signers {
+		if bytes.Equal(signer, publicKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the signature of publicKey, or nil
+func (input *PartialInput) SignatureOf(publicKey []byte) []byte {
+	for _, signature := range input.Signatures {
+		if bytes.Equal(signature.PublicKey, publicKey) {
+			return signature.Signature
+		}
+	}
+
+	return nil
+}
+
+// Reports whether the input holds the signatures it needs
+func (input *PartialInput) IsComplete() bool {
+	_, required := input.Signers()
+	return len(input.Signatures) >= required
+}
+
+// Adds the signature of publicKey to input idx, after checking it signs the
+// signature hash. publicKey is serialized as by share.GetPublicKeyBytes, or an
+// x-only key signing with Schnorr. A signature already present for publicKey is
+// kept.
+func (psbt *PartiallySignedTransaction) AddSignature(idx int, publicKey, signature []byte) error {
+	if idx < 0 || idx >= len(psbt.Inputs) {
+		return fmt.Errorf("no input %d", idx)
+	}
+	input := psbt.Inputs[idx]
+
+	if !input.CanSign(publicKey) {
+		return fmt.Errorf("input %d: %w", idx, ErrUnknownSigner)
+	}
+
+	if input.SignatureOf(publicKey) != nil {
+		return nil
+	}
+
+	hash, err := psbt.Transaction.SignatureHash()
+	if err != nil {
+		return err
+	}
+
+	// Checked as the scripts check it: a BIP-340 Schnorr signature for a 32 bytes
+	// x-only key, and an ECDSA signature for any other key
+	checker := &inputChecker{trx: psbt.Transaction, idx: idx, hash: hash[:]}
+	if !checker.CheckSig(signature, publicKey, false) {
+		return fmt.Errorf("input %d: %w", idx, ErrPartialSignature)
+	}
+
+	input.Signatures = append(input.Signatures, &PartialSignature{PublicKey: publicKey, Signature: signature})
+	return nil
+}
+
+// Reports whether every input holds the signatures it needs
+func (psbt *PartiallySignedTransaction) IsComplete() bool {
+	for _, input := range psbt.Inputs {
+		if !input.IsComplete() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the amount of the spent outputs less the amount of the outputs
+func (psbt *PartiallySignedTransaction) Fee() (int64, error) {
+	var fee int64
+	for _, input := range psbt.Inputs {
+		amount, err := share.BytesToInt64(input.SpentOutput.Amount)
+		if err != nil {
+			return 0, err
+		}
+		fee += amount
+	}
+
+	for _, output := range psbt.Transaction.Output {
+		amount, err := share.BytesToInt64(output.Amount)
+		if err != nil {
+			return 0, err
+		}
+		fee -= amount
+	}
+
+	return fee, nil
+}
+
+// Returns the transaction with the unlocking scripts the signatures make, checked
+// against the spent outputs. The partially signed transaction is not changed.
+func (psbt *PartiallySignedTransaction) Finalize(flags script.VerifyFlags) (*Transaction, error) {
+	inputs := make([]*TrxInput, 0, len(psbt.Inputs))
+	spentOutputs := make([]*TrxOutput, 0, len(psbt.Inputs))
+
+	for idx, input := range psbt.Inputs {
+		unlockingScript, err := input.unlockingScript()
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", idx, err)
+		}
+
+		trxInput := *psbt.Transaction.Input[idx]
+		trxInput.UnlockingScript = unlockingScript
+		inputs = append(inputs, &trxInput)
+		spentOutputs = append(spentOutputs, input.SpentOutput)
+	}
+
+	trx := *psbt.Transaction
+	trx.Input = inputs
+
+	if err := trx.VerifyScripts(spentOutputs, flags, nil); err != nil {
+		return nil, err
+	}
+
+	return &trx, nil
+}
+
+func (input *PartialInput) unlockingScript() ([]byte, error) {
+	if !input.IsComplete() {
+		return nil, ErrMissingSignatures
+	}
+
+	signingScript := input.SigningScript()
+
+	var unlocking []byte
+	var err error
+	switch {
+	case script.ExtractPubKeyHash(signingScript) != nil:
+		signature := input.Signatures[0]
+		unlocking, err = script.PayToPubKeyHashUnlock(signature.Signature, signature.PublicKey)
+	case script.Classify(signingScript) == script.MultiSig:
+		signers, required := input.Signers()
+
+		// The first signatures in key order
+		signatures := make([][]byte, 0, required)
+		for _, signer := range signers {
+			if signature := input.SignatureOf(signer); signature != nil && len(signatures) < required {
+				signatures = append(signatures, signature)
+			}
+		}
+		unlocking, err = script.MultiSigUnlock(signatures)
+	default:
+		return nil, ErrUnsupportedSpentOutput
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !script.IsScriptHash(input.SpentOutput.LockingScript) {
+		return unlocking, nil
+	}
+
+	return script.PayToScriptHashUnlock(unlocking, input.RedeemScript)
+}
+
+// Returns the binary encoding of the partially signed transaction:
+//
+//	magic "mpst" 0xFF (5 bytes)
+//	transaction, as encoded by Encode
+//	for each input:
+//		previous transaction, as encoded by Encode
+//		redeem script (var bytes, empty if none)
+//		signature count (var int), then for each signature:
+//			public key (var bytes), signature (var bytes)
+func (psbt *PartiallySignedTransaction) Encode() ([]byte, error) {
+	buff := new(bytes.Buffer)
+	buff.Write(partiallySignedMagic)
+
+	if err := psbt.Transaction.EncodeTo(buff); err != nil {
+		return make([]byte, 0), err
+	}
+
+	for idx, input := range psbt.Inputs {
+		if err := input.encodeTo(buff); err != nil {
+			return make([]byte, 0), fmt.Errorf("could not encode input %d: %w", idx, err)
+		}
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (input *PartialInput) encodeTo(w io.Writer) error {
+	if err := input.PreviousTransaction.EncodeTo(w); err != nil {
+		return err
+	}
+
+	if err := share.WriteVarBytes(w, input.RedeemScript); err != nil {
+		return err
+	}
+
+	if err := share.WriteVarInt(w, uint64(len(input.Signatures))); err != nil {
+		return err
+	}
+
+	for _, signature := range input.Signatures {
+		if err := share.WriteVarBytes(w, signature.PublicKey); err != nil {
+			return err
+		}
+
+		if err := share.WriteVarBytes(w, signature.Signature); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decodes a partially signed transaction encoded with Encode. The previous
+// transactions and signatures are checked again. Trailing bytes are rejected.
+func DecodePartiallySignedTransaction(data []byte) (*PartiallySignedTransaction, error) {
+	if !bytes.HasPrefix(data, partiallySignedMagic) {
+		return nil, ErrNotPartiallySigned
+	}
+	r := bytes.NewReader(data[len(partiallySignedMagic):])
+
+	trx, err := DecodeTransactionFrom(r)
+	if err != nil {
+		return nil, err
+	}
+
+	previousTransactions := make([]*Transaction, 0, len(trx.Input))
+	redeemScripts := make([][]byte, 0, len(trx.Input))
+	signatures := make([][]*PartialSignature, 0, len(trx.Input))
+
+	for idx := range trx.Input {
+		previous, err := DecodeTransactionFrom(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode input %d: %w", idx, err)
+		}
+
+		redeemScript, err := share.ReadVarBytes(r, script.MaxStackItemSize, "redeem script")
+		if err != nil {
+			return nil, fmt.Errorf("could not decode input %d: %w", idx, err)
+		}
+
+		inputSignatures, err := decodePartialSignatures(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode input %d: %w", idx, err)
+		}
+
+		previousTransactions = append(previousTransactions, previous)
+		redeemScripts = append(redeemScripts, redeemScript)
+		signatures = append(signatures, inputSignatures)
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after partially signed transaction", r.Len())
+	}
+
+	psbt, err := NewPartiallySignedTransaction(trx, previousTransactions, redeemScripts)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, inputSignatures := range signatures {
+		for _, signature := range inputSignatures {
+			if err = psbt.AddSignature(idx, signature.PublicKey, signature.Signature); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return psbt, nil
+}
+
+func decodePartialSignatures(r io.Reader) ([]*PartialSignature, error) {
+	count, err := share.ReadVarInt(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signature count: %w", err)
+	}
+
+	if count > maxPartialSignatures {
+		return nil, fmt.Errorf("signature count %d exceeds maximum of %d", count, maxPartialSignatures)
+	}
+
+	signatures := make([]*PartialSignature, 0, count)
+	for i := uint64(0); i < count; i++ {
+		publicKey, err := share.ReadVarBytes(r, share.MaxPublicKeyLength, "public key")
+		if err != nil {
+			return nil, err
+		}
+
+		signature, err := share.ReadVarBytes(r, script.MaxStackItemSize, "signature")
+		if err != nil {
+			return nil, err
+		}
+
+		signatures = append(signatures, &PartialSignature{PublicKey: publicKey, Signature: signature})
+	}
+
+	return signatures, nil
+}
+
+// Returns the base64 encoding of Encode, to copy between machines as text
+func (psbt *PartiallySignedTransaction) Base64() (string, error) {
+	data, err := psbt.Encode()
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decodes a partially signed transaction from the text returned by Base64
+func ParsePartiallySignedTransaction(text string) (*PartiallySignedTransaction, error) {
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, ErrNotPartiallySigned
+	}
+
+	return DecodePartiallySignedTransaction(data)
+}
@@ -0,0 +1,247 @@
+package transaction
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a transaction whose second output pays 1,500 maglia to lockingScript
+func previousTransaction(t *testing.T, lockingScript []byte) *Transaction {
+	input := &TrxInput{
+		OutpointHash:  bytes.Repeat([]byte{0xAB}, 32),
+		OutpointIndex: share.IntToBytes(0),
+		Sequence:      share.IntToBytes(int(DefaultSequence)),
+	}
+	outputs := []*TrxOutput{
+		{Amount: share.Int64ToBytes(700), LockingScript: lockingScript},
+		{Amount: share.Int64ToBytes(1_500), LockingScript: lockingScript},
+	}
+
+	trx, err := NewTransaction([]*TrxInput{input}, outputs)
+	assert.NoError(t, err)
+
+	return trx
+}
+
+// Returns an unsigned transaction spending the output of previousTransaction
+// locked by lockingScript, with the signature hash
+func partiallySigned(t *testing.T, lockingScript, redeemScript []byte) (*PartiallySignedTransaction, []byte) {
+	previous := previousTransaction(t, lockingScript)
+	input := &TrxInput{
+		OutpointHash:  previous.ID,
+		OutpointIndex: share.IntToBytes(1),
+		Sequence:      share.IntToBytes(int(DefaultSequence)),
+	}
+	output := &TrxOutput{Amount: share.Int64ToBytes(1_000), LockingScript: lockingScript}
+
+	trx, err := NewTransaction([]*TrxInput{input}, []*TrxOutput{output})
+	assert.NoError(t, err)
+
+	psbt, err := NewPartiallySignedTransaction(trx, []*Transaction{previous}, [][]byte{redeemScript})
+	assert.NoError(t, err)
+
+	hash, err := trx.SignatureHash()
+	assert.NoError(t, err)
+
+	return psbt, hash[:]
+}
+
+// Returns the public key of privateKey and its signature of hash
+func signPartial(t *testing.T, privateKey *ecdsa.PrivateKey, hash []byte) ([]byte, []byte) {
+	publicKey, err := share.GetPublicKeyBytes(&privateKey.PublicKey)
+	assert.NoError(t, err)
+
+	signature, err := share.Sign(hash, privateKey)
+	assert.NoError(t, err)
+
+	return publicKey, signature.Bytes()
+}
+
+func TestPartiallySignedTransaction(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for idx := range keys {
+		privateKey, err := share.GeneratePrivateKey(share.RegtestParams.Curve)
+		assert.NoError(t, err)
+		keys[idx] = privateKey
+	}
+
+	t.Run("should finalize a pay to public key hash spend once signed", func(t *testing.T) {
+		pkHash, err := share.GetPublicKeyHashFromPublicKey(&keys[0].PublicKey)
+		assert.NoError(t, err)
+		lockingScript, err := script.PayToPubKeyHash(pkHash[:])
+		assert.NoError(t, err)
+
+		psbt, hash := partiallySigned(t, lockingScript, nil)
+		assert.False(t, psbt.IsComplete())
+
+		_, err = psbt.Finalize(script.VerifyStrictEncoding)
+		assert.ErrorIs(t, err, ErrMissingSignatures)
+
+		fee, err := psbt.Fee()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(500), fee)
+
+		otherKey, otherSignature := signPartial(t, keys[1], hash)
+		assert.ErrorIs(t, psbt.AddSignature(0, otherKey, otherSignature), ErrUnknownSigner)
+
+		publicKey, signature := signPartial(t, keys[0], hash)
+		assert.ErrorIs(t, psbt.AddSignature(0, publicKey, otherSignature), ErrPartialSignature)
+		assert.NoError(t, psbt.AddSignature(0, publicKey, signature))
+		assert.True(t, psbt.IsComplete())
+
+		trx, err := psbt.Finalize(script.VerifyStrictEncoding)
+		assert.NoError(t, err)
+		assert.Equal(t, psbt.Transaction.ID, trx.ID)
+		assert.Empty(t, psbt.Transaction.Input[0].UnlockingScript)
+	})
+
+	t.Run("should collect multisig signatures across encodings", func(t *testing.T) {
+		publicKeys := make([][]byte, 0, len(keys))
+		for _, privateKey := range keys {
+			publicKey, err := share.GetPublicKeyBytes(&privateKey.PublicKey)
+			assert.NoError(t, err)
+			publicKeys = append(publicKeys, publicKey)
+		}
+
+		redeemScript, err := script.MultiSigScript(2, publicKeys)
+		assert.NoError(t, err)
+		lockingScript, err := script.PayToScriptHash(script.Hash160(redeemScript))
+		assert.NoError(t, err)
+
+		psbt, hash := partiallySigned(t, lockingScript, redeemScript)
+
+		_, err = NewPartiallySignedTransaction(psbt.Transaction, []*Transaction{psbt.Inputs[0].PreviousTransaction}, nil)
+		assert.ErrorIs(t, err, ErrMissingRedeemScript)
+
+		// Signatures in reverse key order, each signer decoding the previous one's text
+		for _, idx := range []int{2, 0} {
+			text, err := psbt.Base64()
+			assert.NoError(t, err)
+
+			psbt, err = ParsePartiallySignedTransaction(text)
+			assert.NoError(t, err)
+			assert.False(t, psbt.IsComplete())
+
+			publicKey, signature := signPartial(t, keys[idx], hash)
+			assert.NoError(t, psbt.AddSignature(0, publicKey, signature))
+		}
+
+		data, err := psbt.Encode()
+		assert.NoError(t, err)
+
+		decoded, err := DecodePartiallySignedTransaction(data)
+		assert.NoError(t, err)
+		assert.True(t, decoded.IsComplete())
+		assert.Len(t, decoded.Inputs[0].Signatures, 2)
+
+		trx, err := decoded.Finalize(script.VerifyStrictEncoding)
+		assert.NoError(t, err)
+		assert.NoError(t, trx.VerifyScripts([]*TrxOutput{decoded.Inputs[0].SpentOutput}, script.VerifyStrictEncoding, nil))
+
+		_, err = DecodePartiallySignedTransaction(append(data, 0x00))
+		assert.Error(t, err)
+
+		_, err = DecodePartiallySignedTransaction(data[1:])
+		assert.ErrorIs(t, err, ErrNotPartiallySigned)
+	})
+	t.Run("should reject previous transactions not matching the outpoints", func(t *testing.T) {
+		pkHash, err := share.GetPublicKeyHashFromPublicKey(&keys[0].PublicKey)
+		assert.NoError(t, err)
+		lockingScript, err := script.PayToPubKeyHash(pkHash[:])
+		assert.NoError(t, err)
+
+		psbt, _ := partiallySigned(t, lockingScript, nil)
+		trx, previous := psbt.Transaction, psbt.Inputs[0].PreviousTransaction
+
+		_, err = NewPartiallySignedTransaction(trx, nil, nil)
+		assert.ErrorIs(t, err, ErrPreviousTransactions)
+
+		// A previous transaction claiming a larger amount hashes to another ID
+		inflated := *previous
+		inflated.Output = []*TrxOutput{previous.Output[0], {Amount: share.Int64ToBytes(1_000_000), LockingScript: lockingScript}}
+		_, err = NewPartiallySignedTransaction(trx, []*Transaction{&inflated}, nil)
+		assert.ErrorIs(t, err, ErrOutpointMismatch)
+
+		// Even when it carries the ID of the real one
+		inflated.ID = previous.ID
+		_, err = NewPartiallySignedTransaction(trx, []*Transaction{&inflated}, nil)
+		assert.ErrorIs(t, err, ErrOutpointMismatch)
+
+		outOfRange := *trx.Input[0]
+		outOfRange.OutpointIndex = share.IntToBytes(2)
+		spendsMissing, err := NewTransaction([]*TrxInput{&outOfRange}, trx.Output)
+		assert.NoError(t, err)
+		_, err = NewPartiallySignedTransaction(spendsMissing, []*Transaction{previous}, nil)
+		assert.ErrorIs(t, err, ErrOutpointMismatch)
+
+		// The same checks apply to a decoded transaction
+		data, err := psbt.Encode()
+		assert.NoError(t, err)
+
+		amount := share.Int64ToBytes(1_500)
+		idx := bytes.LastIndex(data, amount)
+		assert.NotEqual(t, -1, idx)
+		tampered := append([]byte(nil), data...)
+		copy(tampered[idx:], share.Int64ToBytes(1_000_000))
+
+		_, err = DecodePartiallySignedTransaction(tampered)
+		assert.ErrorIs(t, err, ErrOutpointMismatch)
+	})
+	t.Run("should round trip the signature of a legacy P-256 key", func(t *testing.T) {
+		privateKey, err := share.GeneratePrivateKey(share.LegacyParams.Curve)
+		assert.NoError(t, err)
+
+		pkHash, err := share.GetPublicKeyHashFromPublicKey(&privateKey.PublicKey)
+		assert.NoError(t, err)
+		lockingScript, err := script.PayToPubKeyHash(pkHash[:])
+		assert.NoError(t, err)
+
+		psbt, hash := partiallySigned(t, lockingScript, nil)
+		publicKey, signature := signPartial(t, privateKey, hash)
+		assert.Len(t, publicKey, share.MaxPublicKeyLength)
+		assert.NoError(t, psbt.AddSignature(0, publicKey, signature))
+
+		data, err := psbt.Encode()
+		assert.NoError(t, err)
+
+		decoded, err := DecodePartiallySignedTransaction(data)
+		assert.NoError(t, err)
+		assert.True(t, decoded.IsComplete())
+		assert.Equal(t, publicKey, decoded.Inputs[0].Signatures[0].PublicKey)
+
+		trx, err := decoded.Finalize(script.VerifyStrictEncoding)
+		assert.NoError(t, err)
+		assert.NoError(t, trx.VerifyScripts([]*TrxOutput{decoded.Inputs[0].SpentOutput}, script.VerifyStrictEncoding, nil))
+	})
+
+	t.Run("should collect the Schnorr signature of an x-only key", func(t *testing.T) {
+		publicKey, err := share.SchnorrPublicKeyBytes(&keys[0].PublicKey)
+		assert.NoError(t, err)
+		lockingScript, err := script.PayToPubKeyHash(script.Hash160(publicKey))
+		assert.NoError(t, err)
+
+		psbt, hash := partiallySigned(t, lockingScript, nil)
+
+		_, ecdsaSignature := signPartial(t, keys[0], hash)
+		assert.ErrorIs(t, psbt.AddSignature(0, publicKey, ecdsaSignature), ErrPartialSignature)
+
+		signature, err := share.SchnorrSign(hash, keys[0])
+		assert.NoError(t, err)
+		assert.NoError(t, psbt.AddSignature(0, publicKey, signature))
+
+		text, err := psbt.Base64()
+		assert.NoError(t, err)
+		decoded, err := ParsePartiallySignedTransaction(text)
+		assert.NoError(t, err)
+		assert.True(t, decoded.IsComplete())
+
+		trx, err := decoded.Finalize(script.VerifyStrictEncoding)
+		assert.NoError(t, err)
+		assert.NoError(t, trx.VerifyScripts([]*TrxOutput{decoded.Inputs[0].SpentOutput}, script.VerifyStrictEncoding, nil))
+	})
+}
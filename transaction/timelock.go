@@ -0,0 +1,174 @@
+package transaction
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Lock times below the threshold are block heights, lock times at or above it
+// are unix timestamps in seconds
+const LockTimeThreshold = 500_000_000
+
+// Relative lock time encoding of an input sequence, as in BIP-68. A sequence
+// without the disable flag keeps the input from being mined until the output it
+// spends is LockValue blocks deep, or LockValue * 512 seconds old if the type
+// flag is set.
+const (
+	SequenceLockDisableFlag uint32 = 1 << 31
+	SequenceLockTypeFlag    uint32 = 1 << 22
+	SequenceLockMask        uint32 = 0x0000FFFF
+	SequenceLockGranularity        = 9 // time locks count units of 2^9 = 512 seconds
+	sequenceLockMinVersion         = 2
+)
+
+var (
+	ErrNonFinal     = errors.New("transaction lock time is not reached")
+	ErrSequenceLock = errors.New("input relative lock time is not reached")
+)
+
+func (trx *Transaction) lockTime() uint32 {
+	if len(trx.LockTime) != 4 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(trx.LockTime)
+}
+
+func (trx *Transaction) version() uint32 {
+	if len(trx.Version) != 4 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(trx.Version)
+}
+
+func (input *TrxInput) sequence() uint32 {
+	if len(input.Sequence) != 4 {
+		return DefaultSequence
+	}
+
+	return binary.BigEndian.Uint32(input.Sequence)
+}
+
+// Returns nil if trx may be mined in a block at height with timestamp blockTime.
+// A transaction is final when its lock time is zero, lies before the block, or
+// every input has the default sequence.
+func (trx *Transaction) CheckFinal(height int, blockTime int64) error {
+	lockTime := trx.lockTime()
+	if lockTime == 0 {
+		return nil
+	}
+
+	limit := int64(height)
+	if lockTime >= LockTimeThreshold {
+		limit = blockTime
+	}
+
+	if int64(lockTime) < limit {
+		return nil
+	}
+
+	for _, input := range trx.Input {
+		if input.sequence() != DefaultSequence {
+			return fmt.Errorf("%w: %d", ErrNonFinal, lockTime)
+		}
+	}
+
+	return nil
+}
+
+// Returns nil if the relative lock time of every input of trx is reached in a
+// block at height with timestamp blockTime. Outputs missing from view are
+// treated as confirmed by that block, as are outputs of unconfirmed parents.
+func (trx *Transaction) CheckSequenceLocks(view UTXOView, height int, blockTime int64) error {
+	if trx.IsCoinbase() || trx.version() < sequenceLockMinVersion {
+		return nil
+	}
+
+	for idx, input := range trx.Input {
+		sequence := input.sequence()
+		if sequence&SequenceLockDisableFlag != 0 {
+			continue
+		}
+
+		outIdx, err := input.OutputIndex()
+		if err != nil {
+			return err
+		}
+
+		confirmedHeight, confirmedTime, ok := view.GetOutputConfirmation(input.OutpointHash, outIdx)
+		if !ok {
+			confirmedHeight, confirmedTime = height, blockTime
+		}
+
+		value := int64(sequence & SequenceLockMask)
+		if sequence&SequenceLockTypeFlag != 0 {
+			if unlockTime := confirmedTime + value<<SequenceLockGranularity; blockTime < unlockTime {
+				return fmt.Errorf("%w: input %d spendable from time %d", ErrSequenceLock, idx, unlockTime)
+			}
+			continue
+		}
+
+		if unlockHeight := int64(confirmedHeight) + value; int64(height) < unlockHeight {
+			return fmt.Errorf("%w: input %d spendable from height %d", ErrSequenceLock, idx, unlockHeight)
+		}
+	}
+
+	return nil
+}
+
+// Returns nil if both the absolute and the relative lock times of trx are
+// reached in a block at height with timestamp blockTime
+func (trx *Transaction) CheckLocks(view UTXOView, height int, blockTime int64) error {
+	if err := trx.CheckFinal(height, blockTime); err != nil {
+		return err
+	}
+
+	return trx.CheckSequenceLocks(view, height, blockTime)
+}
+
+// Reports whether an OP_CHECKLOCKTIMEVERIFY argument is satisfied by trx spent
+// through input idx: the lock time of trx must be of the same kind and at least
+// lockTime, and the input must not opt out of the lock time
+func (trx *Transaction) checkLockTime(idx int, lockTime int64) bool {
+	trxLockTime := int64(trx.lockTime())
+
+	if (lockTime < LockTimeThreshold) != (trxLockTime < LockTimeThreshold) {
+		return false
+	}
+
+	if lockTime > trxLockTime {
+		return false
+	}
+
+	return trx.Input[idx].sequence() != DefaultSequence
+}
+
+// Reports whether an OP_CHECKSEQUENCEVERIFY argument is satisfied by input idx
+// of trx: the input must carry a relative lock of the same kind and at least as
+// long as sequence. An argument with the disable flag set always passes.
+func (trx *Transaction) checkSequence(idx int, sequence int64) bool {
+	required := uint32(sequence)
+	if required&SequenceLockDisableFlag != 0 {
+		return true
+	}
+
+	if trx.version() < sequenceLockMinVersion {
+		return false
+	}
+
+	inputSequence := trx.Input[idx].sequence()
+	if inputSequence&SequenceLockDisableFlag != 0 {
+		return false
+	}
+
+	mask := SequenceLockTypeFlag | SequenceLockMask
+	required, inputSequence = required&mask, inputSequence&mask
+
+	if (required&SequenceLockTypeFlag != 0) != (inputSequence&SequenceLockTypeFlag != 0) {
+		return false
+	}
+
+	return required <= inputSequence
+}
@@ -0,0 +1,88 @@
+package transaction
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+// confirmationView confirms every output at the same height and time
+type confirmationView struct {
+	height    int
+	blockTime int64
+}
+
+func (view *confirmationView) GetOutput(hash []byte, index int) *TrxOutput {
+	return nil
+}
+
+func (view *confirmationView) GetOutputConfirmation(hash []byte, index int) (int, int64, bool) {
+	return view.height, view.blockTime, true
+}
+
+func (view *confirmationView) Height() int {
+	return view.height
+}
+
+func lockedTransaction(t *testing.T, lockTime uint32, sequence uint32) *Transaction {
+	input := &TrxInput{
+		OutpointHash:  bytes.Repeat([]byte{0xAB}, 32),
+		OutpointIndex: []byte{0x00, 0x00, 0x00, 0x00},
+		Sequence:      share.IntToBytes(int(sequence)),
+	}
+	output := &TrxOutput{Amount: share.Int64ToBytes(1_000), LockingScript: []byte{0x51}}
+
+	trx, err := NewTransactionWithLockTime([]*TrxInput{input}, []*TrxOutput{output}, lockTime)
+	assert.NoError(t, err)
+
+	return trx
+}
+
+func TestTimeLocks(t *testing.T) {
+	t.Run("should only mine a transaction after its lock height", func(t *testing.T) {
+		trx := lockedTransaction(t, 100, DefaultSequence-1)
+
+		assert.ErrorIs(t, trx.CheckFinal(100, 0), ErrNonFinal)
+		assert.NoError(t, trx.CheckFinal(101, 0))
+
+		// Inputs with the default sequence opt out of the lock time
+		assert.NoError(t, lockedTransaction(t, 100, DefaultSequence).CheckFinal(100, 0))
+	})
+
+	t.Run("should compare timestamp lock times with the block time", func(t *testing.T) {
+		trx := lockedTransaction(t, 1_700_000_000, 0)
+
+		assert.ErrorIs(t, trx.CheckFinal(1_000_000, 1_700_000_000), ErrNonFinal)
+		assert.NoError(t, trx.CheckFinal(1, 1_700_000_001))
+	})
+
+	t.Run("should enforce relative locks from the confirmation of the spent output", func(t *testing.T) {
+		view := &confirmationView{height: 10, blockTime: 1_000}
+
+		byHeight := lockedTransaction(t, 0, 5)
+		assert.ErrorIs(t, byHeight.CheckSequenceLocks(view, 14, 0), ErrSequenceLock)
+		assert.NoError(t, byHeight.CheckSequenceLocks(view, 15, 0))
+
+		byTime := lockedTransaction(t, 0, SequenceLockTypeFlag|2)
+		assert.ErrorIs(t, byTime.CheckSequenceLocks(view, 100, 1_000+1_023), ErrSequenceLock)
+		assert.NoError(t, byTime.CheckSequenceLocks(view, 100, 1_000+1_024))
+
+		disabled := lockedTransaction(t, 0, SequenceLockDisableFlag|5)
+		assert.NoError(t, disabled.CheckSequenceLocks(view, 10, 0))
+	})
+
+	t.Run("should check lock time script arguments against the transaction", func(t *testing.T) {
+		trx := lockedTransaction(t, 100, 5)
+
+		assert.True(t, trx.checkLockTime(0, 100))
+		assert.False(t, trx.checkLockTime(0, 101))
+		assert.False(t, trx.checkLockTime(0, 1_700_000_000))
+
+		assert.True(t, trx.checkSequence(0, 5))
+		assert.False(t, trx.checkSequence(0, 6))
+		assert.False(t, trx.checkSequence(0, int64(SequenceLockTypeFlag|1)))
+		assert.True(t, trx.checkSequence(0, int64(SequenceLockDisableFlag)))
+	})
+}
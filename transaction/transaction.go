@@ -2,39 +2,84 @@ package transaction
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jenlesamuel/magcoin/script"
 	"github.com/jenlesamuel/magcoin/share"
 )
 
+var (
+	ErrInvalidSignature    = errors.New("invalid signature")
+	ErrAmountOutOfRange    = errors.New("amount is out of range")
+	ErrOutputsExceedInputs = errors.New("outputs total exceeds inputs total")
+)
+
+// Amount paid by the coinbase of a block on top of the fees of its transactions
+const BlockSubsidy int64 = 5_000_000_000 // 5,000,000,000 maglia, equivalent of 50 magcoin
+
+// Version of the transaction format, committed to by the transaction ID.
+// Sequence locks only apply from version 2 on.
+const TransactionVersion = 2
+
+// Sequence of an input that opts out of any sequence based rule
+const DefaultSequence uint32 = 0xFFFFFFFF
+
 type TrxInput struct {
 	OutpointHash  []byte // the hash of the referenced transaction (32 bytes
 	OutpointIndex []byte //the index of the referenced transaction output (4 bytes)
-	SigOrData     []byte
-	PublicKey     []byte
+	// Satisfies the locking script of the referenced output, e.g. <signature> <public key>.
+	// Holds arbitrary data in a coinbase input.
+	UnlockingScript []byte
+	Sequence        []byte // 4 bytes
+}
+
+// Returns the index of the referenced transaction output
+func (input *TrxInput) OutputIndex() (int, error) {
+	if len(input.OutpointIndex) != 4 {
+		return 0, fmt.Errorf("invalid outpoint index length %d", len(input.OutpointIndex))
+	}
+
+	return int(binary.BigEndian.Uint32(input.OutpointIndex)), nil
 }
 
 type TrxOutput struct {
 	Amount        []byte //amount of maglia (8 bytes)
-	PublicKeyHash []byte // 20 bytes
+	LockingScript []byte // conditions to spend the output, e.g. pay to public key hash
 }
 
 type Transaction struct {
-	ID     []byte // aka Transaction Hash, 32 bytes
-	Input  []*TrxInput
-	Output []*TrxOutput
+	ID       []byte // aka Transaction Hash, 32 bytes
+	Version  []byte // 4 bytes
+	Input    []*TrxInput
+	Output   []*TrxOutput
+	LockTime []byte // 4 bytes, block height or unix timestamp before which the transaction cannot be mined
 }
 
 type UTXO struct {
 	TransactionHash []byte // 32 bytes
 	OutpointIndex   []byte // 4 bytes
 	Amount          []byte // 8 bytes
+	LockingScript   []byte
 }
 
 // Returns a new non-coinbase transaction
 func NewTransaction(inputs []*TrxInput, outputs []*TrxOutput) (*Transaction, error) {
-	trx := &Transaction{Input: inputs, Output: outputs}
+	return NewTransactionWithLockTime(inputs, outputs, 0)
+}
+
+// Returns a new non-coinbase transaction that cannot be mined before lockTime,
+// see CheckFinal. The lock time only applies if an input has a sequence other
+// than DefaultSequence.
+func NewTransactionWithLockTime(inputs []*TrxInput, outputs []*TrxOutput, lockTime uint32) (*Transaction, error) {
+	trx := &Transaction{
+		Version:  share.IntToBytes(TransactionVersion),
+		Input:    inputs,
+		Output:   outputs,
+		LockTime: share.IntToBytes(int(lockTime)),
+	}
 	hash, err := trx.Hash(false)
 	if err != nil {
 		return nil, err
@@ -49,7 +94,12 @@ func NewTransaction(inputs []*TrxInput, outputs []*TrxOutput) (*Transaction, err
 // A coinbase transaction is the first transaction added to a block.
 // It references no previous transaction and its output is paid to the miner of the block.
 func NewCoinbaseTransaction(inputs []*TrxInput, outputs []*TrxOutput) (*Transaction, error) {
-	trx := &Transaction{Input: inputs, Output: outputs}
+	trx := &Transaction{
+		Version:  share.IntToBytes(TransactionVersion),
+		Input:    inputs,
+		Output:   outputs,
+		LockTime: make([]byte, 4),
+	}
 	hash, err := trx.Hash(true)
 	if err != nil {
 		return nil, err
@@ -76,8 +126,166 @@ func (trx *Transaction) Validate() bool {
 	return false
 }
 
-func (trx *Transaction) Hash(withSigOrData bool) ([32]byte, error) {
-	trxBytes, err := trx.Serialize(withSigOrData)
+// Returns the hash every input signs: the transaction hash without unlocking
+// scripts. It commits to all outpoints, and so to the locking scripts they
+// reference, and to all outputs.
+func (trx *Transaction) SignatureHash() ([32]byte, error) {
+	return trx.Hash(false)
+}
+
+// Returns the outputs spent by the inputs of a non-coinbase transaction, in
+// input order, or an error if one of them is not in view
+func (trx *Transaction) ResolveInputs(view UTXOView) ([]*TrxOutput, error) {
+	prevOutputs := make([]*TrxOutput, 0, len(trx.Input))
+
+	for idx, input := range trx.Input {
+		outIdx, err := input.OutputIndex()
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", idx, err)
+		}
+
+		output := view.GetOutput(input.OutpointHash, outIdx)
+		if output == nil {
+			return nil, fmt.Errorf("input %d: output %s is missing or already spent", idx, OutpointKey(input.OutpointHash, outIdx))
+		}
+
+		prevOutputs = append(prevOutputs, output)
+	}
+
+	return prevOutputs, nil
+}
+
+// Returns the total amount of the outputs of trx. Every amount and the total
+// must be between 0 and share.MAX_MAGLIA.
+func (trx *Transaction) OutputTotal() (uint64, error) {
+	return sumAmounts(trx.Output, "output")
+}
+
+// Returns the fee trx pays when its inputs spend prevOutputs, that is what
+// prevOutputs hold beyond the outputs of trx
+func (trx *Transaction) Fee(prevOutputs []*TrxOutput) (uint64, error) {
+	inputTotal, err := sumAmounts(prevOutputs, "input")
+	if err != nil {
+		return 0, err
+	}
+
+	outputTotal, err := trx.OutputTotal()
+	if err != nil {
+		return 0, err
+	}
+
+	if outputTotal > inputTotal {
+		return 0, fmt.Errorf("%w: %d > %d maglia", ErrOutputsExceedInputs, outputTotal, inputTotal)
+	}
+
+	return inputTotal - outputTotal, nil
+}
+
+// Sums the amounts of outputs, rejecting any amount or running total outside
+// the money range so that the sum cannot wrap
+func sumAmounts(outputs []*TrxOutput, name string) (uint64, error) {
+	total := uint64(0)
+
+	for idx, output := range outputs {
+		amount, err := share.BytesToInt64(output.Amount)
+		if err != nil {
+			return 0, fmt.Errorf("%s %d: %w", name, idx, err)
+		}
+
+		if amount < 0 || uint64(amount) > share.MAX_MAGLIA {
+			return 0, fmt.Errorf("%w: %s %d holds %d maglia", ErrAmountOutOfRange, name, idx, amount)
+		}
+
+		total += uint64(amount)
+		if total > share.MAX_MAGLIA {
+			return 0, fmt.Errorf("%w: %s total exceeds %d maglia", ErrAmountOutOfRange, name, share.MAX_MAGLIA)
+		}
+	}
+
+	return total, nil
+}
+
+// Runs the unlocking script of every input of a non-coinbase transaction against
+// the locking script of prevOutputs[i], the output the input spends. Signatures
+// sign the signature hash. When batch is not nil, Schnorr signatures checked by
+// OP_CHECKSIG are added to it and left to the caller to verify.
+func (trx *Transaction) VerifyScripts(prevOutputs []*TrxOutput, flags script.VerifyFlags, batch *share.SchnorrBatch) error {
+	if trx.IsCoinbase() {
+		return nil
+	}
+
+	if len(prevOutputs) != len(trx.Input) {
+		return fmt.Errorf("%d spent outputs given for %d inputs", len(prevOutputs), len(trx.Input))
+	}
+
+	hash, err := trx.SignatureHash()
+	if err != nil {
+		return err
+	}
+
+	for idx, input := range trx.Input {
+		checker := &inputChecker{trx: trx, idx: idx, hash: hash[:], batch: batch}
+
+		if err := script.Verify(input.UnlockingScript, prevOutputs[idx].LockingScript, checker, flags); err != nil {
+			return fmt.Errorf("input %d: %w", idx, err)
+		}
+	}
+
+	return nil
+}
+
+// inputChecker checks the script conditions on input idx of trx. Signatures sign
+// the signature hash: a public key of 32 bytes is an x-only key signing with
+// BIP-340 Schnorr, any other public key signs with strict DER, low S ECDSA.
+type inputChecker struct {
+	trx   *Transaction
+	idx   int
+	hash  []byte
+	batch *share.SchnorrBatch
+}
+
+func (checker *inputChecker) CheckLockTime(lockTime int64) bool {
+	return checker.trx.checkLockTime(checker.idx, lockTime)
+}
+
+func (checker *inputChecker) CheckSequence(sequence int64) bool {
+	return checker.trx.checkSequence(checker.idx, sequence)
+}
+
+func (checker *inputChecker) CheckSig(signature, publicKey []byte, deferrable bool) bool {
+	if len(publicKey) == share.SchnorrPublicKeyLength {
+		if len(signature) != share.SchnorrSignatureLength {
+			return false
+		}
+
+		if deferrable && checker.batch != nil {
+			checker.batch.Add(publicKey, checker.hash, signature)
+			return true
+		}
+
+		key, err := share.ParseSchnorrPublicKey(publicKey)
+		if err != nil {
+			return false
+		}
+
+		return share.SchnorrVerify(key, checker.hash, signature)
+	}
+
+	key, err := share.ParsePublicKey(publicKey)
+	if err != nil {
+		return false
+	}
+
+	parsed, err := share.SignatureFromBytes(signature)
+	if err != nil {
+		return false
+	}
+
+	return share.VerifySignature(key, checker.hash, parsed)
+}
+
+func (trx *Transaction) Hash(withUnlockingScript bool) ([32]byte, error) {
+	trxBytes, err := trx.Serialize(withUnlockingScript)
 	if err != nil {
 		return [32]byte{}, err
 	}
@@ -85,40 +293,67 @@ func (trx *Transaction) Hash(withSigOrData bool) ([32]byte, error) {
 	return share.DoubleSha256(trxBytes), nil
 }
 
-func (trx *Transaction) Serialize(withSigOrData bool) ([]byte, error) {
+// Returns the bytes the transaction ID is computed over. Every variable length
+// field is prefixed with its length and every fixed length field is checked, so
+// two different transactions never serialize to the same bytes:
+//
+//	version (4 bytes)
+//	input count (var int), then for each input:
+//		outpoint hash (32 bytes), outpoint index (4 bytes),
+//		unlocking script (var bytes, only when withUnlockingScript is set), sequence (4 bytes)
+//	output count (var int), then for each output:
+//		amount (8 bytes), locking script (var bytes)
+//	lock time (4 bytes)
+func (trx *Transaction) Serialize(withUnlockingScript bool) ([]byte, error) {
 	buff := new(bytes.Buffer)
 	empty := make([]byte, 0)
 
-	for _, input := range trx.Input {
-		if _, err := buff.Write(input.OutpointHash[:]); err != nil {
-			return empty, err
-		}
+	if err := share.WriteFixedBytes(buff, trx.Version, 4, "version"); err != nil {
+		return empty, err
+	}
+
+	if err := share.WriteVarInt(buff, uint64(len(trx.Input))); err != nil {
+		return empty, err
+	}
 
-		if _, err := buff.Write(input.OutpointIndex[:]); err != nil {
+	for _, input := range trx.Input {
+		if err := share.WriteFixedBytes(buff, input.OutpointHash, 32, "outpoint hash"); err != nil {
 			return empty, err
 		}
 
-		if _, err := buff.Write(input.PublicKey); err != nil {
+		if err := share.WriteFixedBytes(buff, input.OutpointIndex, 4, "outpoint index"); err != nil {
 			return empty, err
 		}
 
-		if withSigOrData {
-			if _, err := buff.Write(input.SigOrData); err != nil {
+		if withUnlockingScript {
+			if err := share.WriteVarBytes(buff, input.UnlockingScript); err != nil {
 				return empty, err
 			}
 		}
+
+		if err := share.WriteFixedBytes(buff, input.Sequence, 4, "sequence"); err != nil {
+			return empty, err
+		}
+	}
+
+	if err := share.WriteVarInt(buff, uint64(len(trx.Output))); err != nil {
+		return empty, err
 	}
 
 	for _, output := range trx.Output {
-		if _, err := buff.Write(output.Amount[:]); err != nil {
+		if err := share.WriteFixedBytes(buff, output.Amount, 8, "amount"); err != nil {
 			return empty, err
 		}
 
-		if _, err := buff.Write(output.PublicKeyHash[:]); err != nil {
+		if err := share.WriteVarBytes(buff, output.LockingScript); err != nil {
 			return empty, err
 		}
 	}
 
+	if err := share.WriteFixedBytes(buff, trx.LockTime, 4, "lock time"); err != nil {
+		return empty, err
+	}
+
 	return buff.Bytes(), nil
 }
 
@@ -139,10 +374,10 @@ func (tm *TransactionManager) CreateCoinbaseTransaction(data string) (*Transacti
 	dataBytes = append(dataBytes, timestampBytes...)
 
 	input := &TrxInput{
-		OutpointHash:  make([]byte, 32),
-		OutpointIndex: []byte{0xFF, 0xFF, 0xFF, 0xFF},
-		SigOrData:     dataBytes,
-		PublicKey:     make([]byte, 0),
+		OutpointHash:    make([]byte, 32),
+		OutpointIndex:   []byte{0xFF, 0xFF, 0xFF, 0xFF},
+		UnlockingScript: dataBytes,
+		Sequence:        share.IntToBytes(int(DefaultSequence)),
 	}
 
 	pkHash, err := share.GetPublicKeyHashFromPublicKey(tm.keyManager.PublicKey)
@@ -150,9 +385,14 @@ func (tm *TransactionManager) CreateCoinbaseTransaction(data string) (*Transacti
 		return nil, err
 	}
 
+	lockingScript, err := script.PayToPubKeyHash(pkHash[:])
+	if err != nil {
+		return nil, err
+	}
+
 	output := &TrxOutput{
-		Amount:        share.Int64ToBytes(5_000_000_000), // 5,000,000,000 maglia, equivalent of 50 magcoin
-		PublicKeyHash: pkHash[:],
+		Amount:        share.Int64ToBytes(BlockSubsidy),
+		LockingScript: lockingScript,
 	}
 
 	return NewCoinbaseTransaction(
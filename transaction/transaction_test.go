@@ -0,0 +1,85 @@
+package transaction
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a transaction spending prevOutput with the unlocking script unlock
+// builds from the signature hash
+func signedTransaction(
+	t *testing.T,
+	prevOutput *TrxOutput,
+	unlock func(hash []byte) []byte,
+) *Transaction {
+	input := &TrxInput{
+		OutpointHash:  bytes.Repeat([]byte{0xAB}, 32),
+		OutpointIndex: []byte{0x00, 0x00, 0x00, 0x00},
+		Sequence:      share.IntToBytes(int(DefaultSequence)),
+	}
+	output := &TrxOutput{Amount: share.Int64ToBytes(1_000), LockingScript: prevOutput.LockingScript}
+
+	trx, err := NewTransaction([]*TrxInput{input}, []*TrxOutput{output})
+	assert.NoError(t, err)
+
+	hash, err := trx.SignatureHash()
+	assert.NoError(t, err)
+	input.UnlockingScript = unlock(hash[:])
+
+	return trx
+}
+
+func TestVerifyScripts(t *testing.T) {
+	privateKey, err := share.GeneratePrivateKey(share.RegtestParams.Curve)
+	assert.NoError(t, err)
+
+	t.Run("should verify an ECDSA signed pay to public key hash spend", func(t *testing.T) {
+		pkHash, err := share.GetPublicKeyHashFromPublicKey(&privateKey.PublicKey)
+		assert.NoError(t, err)
+		locking, err := script.PayToPubKeyHash(pkHash[:])
+		assert.NoError(t, err)
+		prevOutput := &TrxOutput{Amount: share.Int64ToBytes(2_000), LockingScript: locking}
+
+		trx := signedTransaction(t, prevOutput, func(hash []byte) []byte {
+			signature, err := share.Sign(hash, privateKey)
+			assert.NoError(t, err)
+			publicKey, err := share.GetPublicKeyBytes(&privateKey.PublicKey)
+			assert.NoError(t, err)
+			unlocking, err := script.PayToPubKeyHashUnlock(signature.Bytes(), publicKey)
+			assert.NoError(t, err)
+			return unlocking
+		})
+
+		prevOutputs := []*TrxOutput{prevOutput}
+		assert.NoError(t, trx.VerifyScripts(prevOutputs, script.VerifyStrictEncoding, nil))
+
+		// The signature does not cover another output
+		trx.Output[0].Amount = share.Int64ToBytes(1_500)
+		assert.ErrorIs(t, trx.VerifyScripts(prevOutputs, script.VerifyStrictEncoding, nil), script.ErrNullFail)
+	})
+
+	t.Run("should defer Schnorr signatures to the batch", func(t *testing.T) {
+		publicKey, err := share.SchnorrPublicKeyBytes(&privateKey.PublicKey)
+		assert.NoError(t, err)
+		locking, err := script.NewBuilder().AddData(publicKey).AddOp(script.OP_CHECKSIG).Script()
+		assert.NoError(t, err)
+		prevOutput := &TrxOutput{Amount: share.Int64ToBytes(2_000), LockingScript: locking}
+
+		trx := signedTransaction(t, prevOutput, func(hash []byte) []byte {
+			signature, err := share.SchnorrSign(hash, privateKey)
+			assert.NoError(t, err)
+			unlocking, err := script.NewBuilder().AddData(signature).Script()
+			assert.NoError(t, err)
+			return unlocking
+		})
+
+		batch := share.NewSchnorrBatch()
+		assert.NoError(t, trx.VerifyScripts([]*TrxOutput{prevOutput}, 0, batch))
+		assert.Equal(t, 1, batch.Len())
+		assert.NoError(t, batch.Verify())
+	})
+}
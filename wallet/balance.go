@@ -0,0 +1,142 @@
+package wallet
+
+import (
+	"errors"
+
+	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+// Confirmations an output needs to count in the confirmed balance by default
+const DefaultMinConfirmations = 1
+
+const ErrMinConfirmations = "minimum confirmations cannot be negative"
+
+// Balance splits the amount of the wallet's outputs by how settled they are.
+// Each unspent output of the chain counts in exactly one of Confirmed,
+// Confirming, Immature and UnconfirmedOutgoing.
+type Balance struct {
+	MinConfirmations int
+
+	Confirmed  uint64 // outputs on the chain with at least MinConfirmations
+	Confirming uint64 // outputs on the chain with fewer confirmations
+	Immature   uint64 // block rewards the wallet does not spend yet
+
+	// Outputs of mempool transactions paying to the wallet, change included, and
+	// not spent by another mempool transaction
+	UnconfirmedIncoming uint64
+	// Outputs of the chain spent by mempool transactions
+	UnconfirmedOutgoing uint64
+}
+
+// Returns the amount the wallet will hold once its mempool transactions are
+// mined
+func (balance *Balance) Total() uint64 {
+	return balance.Confirmed + balance.Confirming + balance.Immature + balance.UnconfirmedIncoming
+}
+
+// Returns the balance of every key of the wallet, counting outputs with at
+// least minConfirmations as confirmed
+func (wm *WalletManager) GetBalances(minConfirmations int) (*Balance, error) {
+	lockingScripts, err := wm.keyring.LockingScripts()
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.balanceOf(minConfirmations, lockingScripts...)
+}
+
+// Returns the balance of address, counting outputs with at least
+// minConfirmations as confirmed
+func (wm *WalletManager) GetAddressBalance(address string, minConfirmations int) (*Balance, error) {
+	lockingScript, err := script.PayToAddress(address)
+	if err != nil {
+		return nil, errors.New(ErrInvalidAddress)
+	}
+
+	return wm.balanceOf(minConfirmations, lockingScript)
+}
+
+func (wm *WalletManager) balanceOf(minConfirmations int, lockingScripts ...[]byte) (*Balance, error) {
+	if minConfirmations < 0 {
+		return nil, errors.New(ErrMinConfirmations)
+	}
+
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+	if err != nil {
+		return nil, err
+	}
+
+	balance := &Balance{MinConfirmations: minConfirmations}
+	for _, entry := range utxoSet.Entries() {
+		if !containsScript(lockingScripts, entry.Output.LockingScript) {
+			continue
+		}
+
+		amount, err := share.BytesToInt64(entry.Output.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case wm.mempool.GetTransactionSpending(entry.TransactionHash, entry.Index) != nil:
+			balance.UnconfirmedOutgoing += uint64(amount)
+		case wm.isImmature(utxoSet, entry):
+			balance.Immature += uint64(amount)
+		case confirmations(utxoSet, entry) < minConfirmations:
+			balance.Confirming += uint64(amount)
+		default:
+			balance.Confirmed += uint64(amount)
+		}
+	}
+
+	entries, _ := wm.mempool.List(0, 0)
+	for _, entry := range entries {
+		for idx, output := range entry.Transaction.Output {
+			if !containsScript(lockingScripts, output.LockingScript) ||
+				wm.mempool.GetTransactionSpending(entry.Transaction.ID, idx) != nil {
+				continue
+			}
+
+			amount, err := share.BytesToInt64(output.Amount)
+			if err != nil {
+				return nil, err
+			}
+			balance.UnconfirmedIncoming += uint64(amount)
+		}
+	}
+
+	return balance, nil
+}
+
+// Retrieves the UTXOs of utxoSet locked by any of lockingScripts that the wallet
+// may spend: immature block rewards and outputs a mempool transaction already
+// spends are left out, so that the wallet never double-spends its pending
+// transactions
+func (wm *WalletManager) getSpendableUTXOFromSet(utxoSet *blockchain.UTXOSet, lockingScripts ...[]byte) []*transaction.UTXO {
+	utxos := make([]*transaction.UTXO, 0)
+	for _, entry := range utxoSet.Entries() {
+		if !containsScript(lockingScripts, entry.Output.LockingScript) ||
+			wm.isImmature(utxoSet, entry) ||
+			wm.mempool.GetTransactionSpending(entry.TransactionHash, entry.Index) != nil {
+			continue
+		}
+
+		utxos = append(utxos, newUTXO(entry))
+	}
+
+	return utxos
+}
+
+// Reports whether entry is a block reward without the confirmations the wallet
+// waits for before spending it, see NetworkParams.WalletCoinbaseMaturity
+func (wm *WalletManager) isImmature(utxoSet *blockchain.UTXOSet, entry *blockchain.UTXOEntry) bool {
+	return entry.IsCoinbase && confirmations(utxoSet, entry) < wm.keyring.params.WalletCoinbaseMaturity
+}
+
+// Returns the number of blocks of utxoSet confirming entry, its own included
+func confirmations(utxoSet *blockchain.UTXOSet, entry *blockchain.UTXOEntry) int {
+	return utxoSet.Height() - entry.Height + 1
+}
@@ -0,0 +1,223 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+	"github.com/stretchr/testify/assert"
+)
+
+// Reward of every block of a test chain, in maglia
+const testBlockReward = 5_000_000_000
+
+// testChain is a chain in a temporary database whose block rewards pay the main
+// key of its wallet
+type testChain struct {
+	blockchain   *blockchain.Blockchain
+	blockManager *blockchain.BlockManager
+	mempool      *transaction.MemPool
+	mined        int
+}
+
+// Returns the wallet manager of a new wallet on params, on a new chain holding
+// only the genesis block
+func newTestChain(t *testing.T, params *share.NetworkParams) (*WalletManager, *testChain) {
+	dataDir := t.TempDir()
+
+	keymanager, err := share.LoadKeyManager(unlockedKeystore(t, dataDir), params)
+	assert.NoError(t, err)
+
+	keyring, err := LoadKeyRing(keymanager)
+	assert.NoError(t, err)
+
+	db, err := badger.Open(badger.DefaultOptions(filepath.Join(dataDir, "blocks")).WithLogger(nil))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	blockManager := blockchain.NewBlockManager(transaction.NewTransactionManager(keymanager), nil)
+	genesis, err := blockManager.GenesisBlock()
+	assert.NoError(t, err)
+
+	bc, err := blockchain.LoadBlockchain(db, genesis)
+	assert.NoError(t, err)
+
+	chain := &testChain{blockchain: bc, blockManager: blockManager, mempool: transaction.NewMemPool(nil)}
+
+	return NewWalletManager(bc, keyring, chain.mempool), chain
+}
+
+// Mines a block holding the mempool transactions on top of the chain
+func (chain *testChain) mine(t *testing.T) *blockchain.Block {
+	// Coinbases of the same millisecond only differ by their data
+	chain.mined++
+	block, err := chain.blockManager.CreateBlock(chain.blockchain.LastBlockHeaderHash, fmt.Sprintf("test block %d", chain.mined))
+	assert.NoError(t, err)
+	assert.NoError(t, chain.blockManager.AddMemPoolTransactions(block, chain.mempool))
+	assert.True(t, block.Mine())
+	assert.NoError(t, chain.blockchain.AddBlock(block))
+
+	for _, trx := range block.Transactions {
+		chain.mempool.DeleteTransaction(hex.EncodeToString(trx.ID))
+	}
+
+	return block
+}
+
+// Returns an address none of the test wallets owns
+func foreignAddress() string {
+	return share.AddressFromPublicKeyHash(bytes.Repeat([]byte{0x42}, 20))
+}
+
+// Returns the outpoint keys of utxos
+func outpoints(t *testing.T, utxos []*transaction.UTXO) []string {
+	keys := make([]string, 0, len(utxos))
+	for _, utxo := range utxos {
+		index, err := (&transaction.TrxInput{OutpointIndex: utxo.OutpointIndex}).OutputIndex()
+		assert.NoError(t, err)
+		keys = append(keys, transaction.OutpointKey(utxo.TransactionHash, index))
+	}
+
+	return keys
+}
+
+func TestBalance(t *testing.T) {
+	// Rewards are spent by the wallet from their third confirmation
+	params := *share.RegtestParams
+	params.WalletCoinbaseMaturity = 3
+
+	t.Run("should count each output in the bucket of its state", func(t *testing.T) {
+		wm, chain := newTestChain(t, &params)
+		for i := 0; i < 3; i++ {
+			chain.mine(t)
+		}
+
+		// Rewards of heights 0 and 1 are mature, those of heights 2 and 3 are not
+		balance, err := wm.GetBalances(1)
+		assert.NoError(t, err)
+		assert.Equal(t, &Balance{MinConfirmations: 1, Confirmed: 2 * testBlockReward, Immature: 2 * testBlockReward}, balance)
+
+		trx, err := wm.CreateTransaction(1_000_000_000, foreignAddress(), &LargestFirst{}, 1)
+		assert.NoError(t, err)
+		assert.Len(t, trx.Input, 1)
+		assert.Len(t, trx.Output, 2)
+
+		change, err := share.BytesToInt64(trx.Output[1].Amount)
+		assert.NoError(t, err)
+
+		// The spent reward is outgoing until mined, and the change incoming
+		balance, err = wm.GetBalances(1)
+		assert.NoError(t, err)
+		assert.Equal(t, &Balance{
+			MinConfirmations:    1,
+			Confirmed:           testBlockReward,
+			Immature:            2 * testBlockReward,
+			UnconfirmedIncoming: uint64(change),
+			UnconfirmedOutgoing: testBlockReward,
+		}, balance)
+
+		// Once mined at height 4 the change has one confirmation, and the reward
+		// of height 2 matures
+		chain.mine(t)
+
+		balance, err = wm.GetBalances(2)
+		assert.NoError(t, err)
+		assert.Equal(t, &Balance{
+			MinConfirmations: 2,
+			Confirmed:        2 * testBlockReward,
+			Confirming:       uint64(change),
+			Immature:         2 * testBlockReward,
+		}, balance)
+
+		balance, err = wm.GetBalances(1)
+		assert.NoError(t, err)
+		assert.Equal(t, 2*testBlockReward+uint64(change), balance.Confirmed)
+		assert.Zero(t, balance.Confirming)
+
+		// The balance of an address only counts the outputs paying to it. The
+		// wallet has no seed, so the change went back to its main key.
+		mainAddress, err := wm.keymanager.GetAddress()
+		assert.NoError(t, err)
+
+		balance, err = wm.GetAddressBalance(mainAddress, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 2*testBlockReward+uint64(change), balance.Confirmed)
+
+		balance, err = wm.GetAddressBalance(foreignAddress(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, &Balance{MinConfirmations: 1, Confirmed: 1_000_000_000}, balance)
+
+		_, err = wm.GetBalances(-1)
+		assert.EqualError(t, err, ErrMinConfirmations)
+	})
+
+	t.Run("should not spend immature rewards or outputs spent in the mempool", func(t *testing.T) {
+		wm, chain := newTestChain(t, &params)
+		genesis, err := wm.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+
+		first := chain.mine(t)
+		chain.mine(t)
+		chain.mine(t)
+
+		mainScript, err := wm.keyring.MainScript()
+		assert.NoError(t, err)
+
+		utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+		assert.NoError(t, err)
+		assert.Len(t, wm.getUTXOFromSet(utxoSet, mainScript), 4)
+		assert.ElementsMatch(t, []string{
+			transaction.OutpointKey(genesis.Transactions[0].ID, 0),
+			transaction.OutpointKey(first.Transactions[0].ID, 0),
+		}, outpoints(t, wm.getSpendableUTXOFromSet(utxoSet, mainScript)))
+
+		trx, err := wm.CreateTransaction(1_000_000_000, foreignAddress(), &LargestFirst{}, 1)
+		assert.NoError(t, err)
+		spent, err := trx.Input[0].OutputIndex()
+		assert.NoError(t, err)
+
+		spendable := outpoints(t, wm.getSpendableUTXOFromSet(utxoSet, mainScript))
+		assert.Len(t, spendable, 1)
+		assert.NotContains(t, spendable, transaction.OutpointKey(trx.Input[0].OutpointHash, spent))
+
+		// A second payment only has the other mature reward left
+		_, err = wm.CreateTransaction(testBlockReward, foreignAddress(), &LargestFirst{}, 1)
+		assert.EqualError(t, err, ErrInsufficientBalance)
+
+		second, err := wm.CreateTransaction(1_000_000_000, foreignAddress(), &LargestFirst{}, 1)
+		assert.NoError(t, err)
+		assert.NotEqual(t, trx.Input[0].OutpointHash, second.Input[0].OutpointHash)
+
+		// The change of both payments is in the mempool, not in the set
+		lockingScripts, err := wm.keyring.LockingScripts()
+		assert.NoError(t, err)
+		assert.Empty(t, wm.getSpendableUTXOFromSet(utxoSet, lockingScripts...))
+	})
+	t.Run("should not add a change output without change under a zero dust threshold", func(t *testing.T) {
+		wm, chain := newTestChain(t, &params)
+		chain.mempool.Policy().DustThreshold = 0
+		for i := 0; i < 3; i++ {
+			chain.mine(t)
+		}
+
+		// Without fee a whole reward pays the amount exactly
+		trx, err := wm.CreateTransaction(testBlockReward, foreignAddress(), &LargestFirst{}, 0)
+		assert.NoError(t, err)
+		assert.Len(t, trx.Input, 1)
+		assert.Len(t, trx.Output, 1)
+
+		trx, err = wm.CreateTransaction(testBlockReward-1, foreignAddress(), &LargestFirst{}, 0)
+		assert.NoError(t, err)
+		assert.Len(t, trx.Output, 2)
+
+		change, err := share.BytesToInt64(trx.Output[1].Amount)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), change)
+	})
+}
@@ -0,0 +1,329 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+// Estimated sizes in bytes of the encoded parts of a pay to public key hash
+// transaction, used to turn a fee rate into a fee before the transaction exists
+const (
+	EstimatedInputSize      = 148 // outpoint, <signature> <public key> unlocking script and sequence
+	EstimatedOutputSize     = 34  // amount and pay to public key hash locking script
+	TransactionOverheadSize = 11  // encoding version, version, counts and lock time
+)
+
+// DefaultFeeRate is the fee rate, in maglia per byte, of wallet transactions
+// that do not ask for another one
+const DefaultFeeRate = 1
+
+// Names of the coin selection strategies
+const (
+	BranchAndBoundSelection = "branch-and-bound"
+	LargestFirstSelection   = "largest-first"
+	SmallestFirstSelection  = "smallest-first"
+	RandomImproveSelection  = "random-improve"
+)
+
+// Number of subsets branch and bound tries before giving up on an exact match
+const branchAndBoundTries = 100_000
+
+var ErrNoExactMatch = errors.New("no set of coins pays the target without change")
+
+// SelectionTarget is what the selected coins must pay for: the outputs, and the
+// fee of the transaction at FeeRate, inputs and change output included
+type SelectionTarget struct {
+	Amount        uint64 // paid to the outputs other than change
+	FeeRate       uint64 // maglia per byte
+	BaseSize      int    // bytes of the transaction without its inputs and change output
+	DustThreshold uint64 // change below the threshold is left to the miner
+}
+
+// Selection is the outcome of coin selection. The selected coins hold Total,
+// which pays the target amount, Fee and Change.
+type Selection struct {
+	UTXOs  []*transaction.UTXO
+	Total  uint64
+	Fee    uint64
+	Change uint64
+}
+
+// CoinSelector picks the coins funding a transaction among the spendable coins
+// of a wallet
+type CoinSelector interface {
+	Select(utxos []*transaction.UTXO, target *SelectionTarget) (*Selection, error)
+}
+
+// Returns the selector of the strategy called name. An empty name is the default
+// strategy: an exact match if there is one, largest coins first otherwise.
+func CoinSelectorByName(name string) (CoinSelector, error) {
+	switch name {
+	case "":
+		return DefaultCoinSelector(), nil
+	case BranchAndBoundSelection:
+		return &BranchAndBound{}, nil
+	case LargestFirstSelection:
+		return &LargestFirst{}, nil
+	case SmallestFirstSelection:
+		return &SmallestFirst{}, nil
+	case RandomImproveSelection:
+		return &RandomImprove{}, nil
+	}
+
+	return nil, fmt.Errorf(
+		"unknown coin selection strategy %q, use one of %s, %s, %s or %s",
+		name,
+		BranchAndBoundSelection,
+		LargestFirstSelection,
+		SmallestFirstSelection,
+		RandomImproveSelection,
+	)
+}
+
+func DefaultCoinSelector() CoinSelector {
+	return &BranchAndBound{Fallback: &LargestFirst{}}
+}
+
+// coin is a UTXO with its amount and its effective value: the amount less the
+// fee of spending it
+type coin struct {
+	utxo      *transaction.UTXO
+	amount    uint64
+	effective int64
+}
+
+func (target *SelectionTarget) fee(size int) uint64 {
+	return target.FeeRate * uint64(size)
+}
+
+// Returns the effective value the selected coins must reach without change
+func (target *SelectionTarget) needed() int64 {
+	return int64(target.Amount + target.fee(target.BaseSize))
+}
+
+// Returns the coins worth spending at the target fee rate
+func (target *SelectionTarget) coins(utxos []*transaction.UTXO) ([]*coin, error) {
+	coins := make([]*coin, 0, len(utxos))
+	for _, utxo := range utxos {
+		amount, err := share.BytesToInt64(utxo.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		effective := amount - int64(target.fee(EstimatedInputSize))
+		if effective <= 0 {
+			continue
+		}
+
+		coins = append(coins, &coin{utxo: utxo, amount: uint64(amount), effective: effective})
+	}
+
+	return coins, nil
+}
+
+// Returns the selection spending coins. The excess over the target becomes change
+// if it pays for the change output and is not dust, and fee otherwise.
+func (target *SelectionTarget) selection(coins []*coin) (*Selection, error) {
+	var total uint64
+	var effective int64
+	utxos := make([]*transaction.UTXO, 0, len(coins))
+	for _, coin := range coins {
+		utxos = append(utxos, coin.utxo)
+		total += coin.amount
+		effective += coin.effective
+	}
+
+	if len(coins) == 0 || effective < target.needed() {
+		return nil, errors.New(ErrInsufficientBalance)
+	}
+
+	fee := total - target.Amount
+	change := uint64(0)
+
+	excess := uint64(effective - target.needed())
+	if changeFee := target.fee(EstimatedOutputSize); excess > changeFee && excess-changeFee >= target.DustThreshold {
+		change = excess - changeFee
+		fee -= change
+	}
+
+	return &Selection{UTXOs: utxos, Total: total, Fee: fee, Change: change}, nil
+}
+
+// Returns the first coins whose effective value reaches the target
+func (target *SelectionTarget) accumulate(coins []*coin) (*Selection, error) {
+	var effective int64
+	for idx, coin := range coins {
+		effective += coin.effective
+		if effective >= target.needed() {
+			return target.selection(coins[:idx+1])
+		}
+	}
+
+	return nil, errors.New(ErrInsufficientBalance)
+}
+
+// LargestFirst spends the largest coins first, which keeps transactions small
+type LargestFirst struct{}
+
+func (selector *LargestFirst) Select(utxos []*transaction.UTXO, target *SelectionTarget) (*Selection, error) {
+	coins, err := target.coins(utxos)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(coins, func(i, j int) bool { return coins[i].amount > coins[j].amount })
+
+	return target.accumulate(coins)
+}
+
+// SmallestFirst spends the smallest coins first, which consolidates small change
+// outputs at the cost of larger transactions
+type SmallestFirst struct{}
+
+func (selector *SmallestFirst) Select(utxos []*transaction.UTXO, target *SelectionTarget) (*Selection, error) {
+	coins, err := target.coins(utxos)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(coins, func(i, j int) bool { return coins[i].amount < coins[j].amount })
+
+	return target.accumulate(coins)
+}
+
+// BranchAndBound searches a set of coins matching the target closely enough that
+// adding a change output would cost more than the excess, so the transaction has
+// no change. Without such a set it uses Fallback, or fails if Fallback is nil.
+// A transaction spends at least one coin, so the empty set never matches, not
+// even a zero target.
+type BranchAndBound struct {
+	Fallback CoinSelector
+}
+
+func (selector *BranchAndBound) Select(utxos []*transaction.UTXO, target *SelectionTarget) (*Selection, error) {
+	coins, err := target.coins(utxos)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(coins, func(i, j int) bool { return coins[i].effective > coins[j].effective })
+
+	// remaining[i] is the effective value of coins[i:]
+	remaining := make([]int64, len(coins)+1)
+	for i := len(coins) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + coins[i].effective
+	}
+
+	low := target.needed()
+	high := low + int64(target.fee(EstimatedOutputSize)) + int64(target.DustThreshold)
+
+	var best []*coin
+	bestExcess := int64(-1)
+	tries := 0
+
+	// Depth first search including, then excluding, each coin
+	selected := make([]*coin, 0, len(coins))
+	var search func(idx int, value int64)
+	search = func(idx int, value int64) {
+		if tries++; tries > branchAndBoundTries || value > high || value+remaining[idx] < low {
+			return
+		}
+
+		if value >= low && len(selected) > 0 {
+			if excess := value - low; bestExcess < 0 || excess < bestExcess {
+				best = append(make([]*coin, 0, len(selected)), selected...)
+				bestExcess = excess
+			}
+			return
+		}
+
+		if idx == len(coins) {
+			return
+		}
+
+		selected = append(selected, coins[idx])
+		search(idx+1, value+coins[idx].effective)
+		selected = selected[:len(selected)-1]
+
+		if bestExcess != 0 {
+			search(idx+1, value)
+		}
+	}
+	search(0, 0)
+
+	if best == nil {
+		if selector.Fallback != nil {
+			return selector.Fallback.Select(utxos, target)
+		}
+		return nil, ErrNoExactMatch
+	}
+
+	selection, err := target.selection(best)
+	if err != nil {
+		return nil, err
+	}
+
+	// The excess is too small to be worth a change output
+	selection.Fee += selection.Change
+	selection.Change = 0
+
+	return selection, nil
+}
+
+// RandomImprove picks random coins until the target is reached, then keeps adding
+// random coins while they bring the selection closer to twice the target, up to
+// three times the target. The change is then about the size of the payment,
+// which keeps the coins of the wallet useful for payments alike.
+type RandomImprove struct {
+	Rand *rand.Rand // nil uses a generator seeded with the current time
+}
+
+func (selector *RandomImprove) Select(utxos []*transaction.UTXO, target *SelectionTarget) (*Selection, error) {
+	coins, err := target.coins(utxos)
+	if err != nil {
+		return nil, err
+	}
+
+	random := selector.Rand
+	if random == nil {
+		random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	random.Shuffle(len(coins), func(i, j int) { coins[i], coins[j] = coins[j], coins[i] })
+
+	needed := target.needed()
+
+	var value int64
+	count := 0
+	for ; count < len(coins) && value < needed; count++ {
+		value += coins[count].effective
+	}
+
+	if value < needed {
+		return nil, errors.New(ErrInsufficientBalance)
+	}
+
+	ideal, limit := 2*needed, 3*needed
+	for ; count < len(coins); count++ {
+		next := value + coins[count].effective
+		if next > limit || abs(ideal-next) >= abs(ideal-value) {
+			break
+		}
+		value = next
+	}
+
+	return target.selection(coins[:count])
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+	"github.com/stretchr/testify/assert"
+)
+
+func testUTXOs(amounts ...int64) []*transaction.UTXO {
+	utxos := make([]*transaction.UTXO, 0, len(amounts))
+	for idx, amount := range amounts {
+		utxos = append(utxos, &transaction.UTXO{
+			TransactionHash: make([]byte, 32),
+			OutpointIndex:   share.IntToBytes(idx),
+			Amount:          share.Int64ToBytes(amount),
+		})
+	}
+
+	return utxos
+}
+
+func selectedAmounts(t *testing.T, selection *Selection) []int64 {
+	amounts := make([]int64, 0, len(selection.UTXOs))
+	for _, utxo := range selection.UTXOs {
+		amount, err := share.BytesToInt64(utxo.Amount)
+		assert.NoError(t, err)
+		amounts = append(amounts, amount)
+	}
+
+	return amounts
+}
+
+func TestCoinSelection(t *testing.T) {
+	utxos := testUTXOs(10_000, 60_000, 30_000, 50_000)
+
+	t.Run("should spend the largest or the smallest coins first", func(t *testing.T) {
+		target := &SelectionTarget{Amount: 55_000, DustThreshold: 546}
+
+		selection, err := (&LargestFirst{}).Select(utxos, target)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{60_000}, selectedAmounts(t, selection))
+		assert.Equal(t, uint64(5_000), selection.Change)
+
+		selection, err = (&SmallestFirst{}).Select(utxos, target)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{10_000, 30_000, 50_000}, selectedAmounts(t, selection))
+		assert.Equal(t, uint64(35_000), selection.Change)
+	})
+
+	t.Run("should pay the fee of every input and of the change output", func(t *testing.T) {
+		target := &SelectionTarget{Amount: 55_000, FeeRate: 2, BaseSize: 45, DustThreshold: 546}
+
+		selection, err := (&LargestFirst{}).Select(utxos, target)
+		assert.NoError(t, err)
+
+		fee := uint64(2 * (45 + EstimatedInputSize + EstimatedOutputSize))
+		assert.Equal(t, fee, selection.Fee)
+		assert.Equal(t, selection.Total, target.Amount+selection.Fee+selection.Change)
+	})
+
+	t.Run("should find an exact match without change", func(t *testing.T) {
+		target := &SelectionTarget{Amount: 80_000, DustThreshold: 546}
+
+		selection, err := (&BranchAndBound{}).Select(utxos, target)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []int64{50_000, 30_000}, selectedAmounts(t, selection))
+		assert.Zero(t, selection.Change)
+		assert.Zero(t, selection.Fee)
+
+		target.Amount = 75_000
+		_, err = (&BranchAndBound{}).Select(utxos, target)
+		assert.ErrorIs(t, err, ErrNoExactMatch)
+
+		selection, err = DefaultCoinSelector().Select(utxos, target)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(35_000), selection.Change)
+	})
+
+	t.Run("should fall back for a zero target no single coin matches", func(t *testing.T) {
+		target := &SelectionTarget{DustThreshold: 546}
+
+		_, err := (&BranchAndBound{}).Select(utxos, target)
+		assert.ErrorIs(t, err, ErrNoExactMatch)
+
+		selection, err := DefaultCoinSelector().Select(utxos, target)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{60_000}, selectedAmounts(t, selection))
+		assert.Equal(t, uint64(60_000), selection.Change)
+
+		// A coin below the dust threshold is spent whole as fee
+		selection, err = (&BranchAndBound{}).Select(testUTXOs(500, 10_000), target)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{500}, selectedAmounts(t, selection))
+		assert.Equal(t, uint64(500), selection.Fee)
+	})
+
+	t.Run("should aim random improve at twice the target", func(t *testing.T) {
+		target := &SelectionTarget{Amount: 20_000, DustThreshold: 546}
+
+		selection, err := (&RandomImprove{Rand: rand.New(rand.NewSource(1))}).Select(utxos, target)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, selection.Total, target.Amount)
+		assert.Equal(t, selection.Total, target.Amount+selection.Fee+selection.Change)
+	})
+
+	t.Run("should fail when the coins do not cover the target", func(t *testing.T) {
+		_, err := (&LargestFirst{}).Select(utxos, &SelectionTarget{Amount: 150_001})
+		assert.EqualError(t, err, ErrInsufficientBalance)
+	})
+}
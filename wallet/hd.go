@@ -0,0 +1,106 @@
+package wallet
+
+// Creates the seed of the wallet from a new mnemonic of words words and
+// passphrase, and returns the mnemonic. Writing the mnemonic down, and keeping
+// the passphrase, backs up every key of the wallet.
+func (wm *WalletManager) CreateWallet(words int, passphrase string) (string, error) {
+	mnemonic, err := NewMnemonic(words)
+	if err != nil {
+		return "", err
+	}
+
+	seed, err := MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	if err = wm.keyring.Initialize(seed); err != nil {
+		return "", err
+	}
+
+	return mnemonic, nil
+}
+
+// Rebuilds the keys of the wallet from mnemonic and passphrase, then scans the
+// chain for their funds. Returns the number of used addresses found and the
+// balance of the wallet.
+func (wm *WalletManager) RestoreWallet(mnemonic, passphrase string) (int, int64, error) {
+	seed, err := MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err = wm.keyring.Initialize(seed); err != nil {
+		return 0, 0, err
+	}
+
+	return wm.Rescan()
+}
+
+// Returns an address of the wallet that has not been handed out before, so each
+// payment to the wallet goes to its own address
+func (wm *WalletManager) NewReceiveAddress() (string, error) {
+	return wm.keyring.NewReceiveAddress()
+}
+
+// Returns the extended public key of the wallet account
+func (wm *WalletManager) GetAccountPublicKey() (string, error) {
+	return wm.keyring.AccountPublicKey()
+}
+
+// Scans the chain and the mempool for outputs paying to keys derived from the
+// wallet seed, adds the keys found to the wallet and returns their number with
+// the balance of the wallet
+func (wm *WalletManager) Rescan() (int, int64, error) {
+	used := make(map[string]bool)
+
+	iterator := wm.blockchain.Iterator()
+	for {
+		block, err := iterator.Next()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		for _, trx := range block.Transactions {
+			for _, output := range trx.Output {
+				used[string(output.LockingScript)] = true
+			}
+		}
+
+		if block.IsGenesis() {
+			break
+		}
+	}
+
+	entries, _ := wm.mempool.List(0, 0)
+	for _, entry := range entries {
+		for _, output := range entry.Transaction.Output {
+			used[string(output.LockingScript)] = true
+		}
+	}
+
+	found, err := wm.keyring.Scan(func(lockingScript []byte) bool {
+		return used[string(lockingScript)]
+	})
+	if err != nil {
+		return found, 0, err
+	}
+
+	balance, err := wm.GetBalance()
+	if err != nil {
+		return found, 0, err
+	}
+
+	return found, balance, nil
+}
+
+// Returns the amount held by every key of the wallet once its mempool
+// transactions are mined
+func (wm *WalletManager) GetBalance() (int64, error) {
+	balance, err := wm.GetBalances(DefaultMinConfirmations)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(balance.Total()), nil
+}
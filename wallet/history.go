@@ -0,0 +1,655 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+// Name of the file, in the directory of a wallet, holding its transaction history
+const HistoryFilename = "mag_history.json"
+
+const (
+	ErrUnknownTransaction = "transaction is not in the wallet history"
+	ErrInvalidDirection   = "direction must be received, sent or self"
+)
+
+// Directions of the transactions of the history
+const (
+	DirectionReceived = "received" // pays the wallet without spending its outputs
+	DirectionSent     = "sent"     // spends wallet outputs and pays others
+	DirectionSelf     = "self"     // spends wallet outputs and only pays the wallet
+)
+
+// HistoryEntry is a transaction paying to or spending from the wallet
+type HistoryEntry struct {
+	TransactionID   string   `json:"transaction_id"` // hex
+	Direction       string   `json:"direction"`
+	Amount          int64    `json:"amount"`                     // change of the wallet balance, negative when sending, fee included
+	Fee             uint64   `json:"fee"`                        // paid by the wallet, 0 for received transactions
+	Addresses       []string `json:"addresses"`                  // senders of received transactions, receivers of sent ones
+	WalletAddresses []string `json:"wallet_addresses,omitempty"` // wallet addresses the transaction pays, change included
+	Height          int      `json:"height"`                     // -1 while unconfirmed
+	Confirmations   int      `json:"confirmations"`
+	Time            int64    `json:"time"` // block time, or when the wallet first saw the transaction
+	IsCoinbase      bool     `json:"is_coinbase,omitempty"`
+	Label           string   `json:"label,omitempty"`
+	Spends          []string `json:"spends,omitempty"`     // outpoints the transaction spends, see transaction.OutpointKey
+	Conflicted      bool     `json:"conflicted,omitempty"` // an output it spends was spent on the chain by another transaction
+	InMemPool       bool     `json:"-"`
+}
+
+// Reports whether the transaction is on the chain
+func (entry *HistoryEntry) IsConfirmed() bool {
+	return entry.Height >= 0
+}
+
+// HistoryFilter selects history entries. Zero fields select every entry.
+type HistoryFilter struct {
+	Direction   string
+	Address     string // a counterparty or wallet address of the transaction
+	Unconfirmed bool   // only transactions not on the chain
+}
+
+func (filter *HistoryFilter) validate() error {
+	switch filter.Direction {
+	case "", DirectionReceived, DirectionSent, DirectionSelf:
+		return nil
+	default:
+		return errors.New(ErrInvalidDirection)
+	}
+}
+
+func (filter *HistoryFilter) matches(entry *HistoryEntry) bool {
+	if filter.Direction != "" && entry.Direction != filter.Direction {
+		return false
+	}
+
+	if filter.Unconfirmed && entry.IsConfirmed() {
+		return false
+	}
+
+	if filter.Address != "" {
+		return containsAddress(entry.Addresses, filter.Address) || containsAddress(entry.WalletAddresses, filter.Address)
+	}
+
+	return true
+}
+
+// historyFile is the content of the history file
+type historyFile struct {
+	Tip          string          `json:"tip,omitempty"` // hex header hash of the block the history was synced to
+	Transactions []*HistoryEntry `json:"transactions"`
+}
+
+// chainHistory holds the wallet transactions of the chain ending at the block
+// whose hex header hash is tip, and what tells the transactions off the chain
+// apart
+type chainHistory struct {
+	tip     string
+	entries []*HistoryEntry                   // newest first
+	outputs map[string]*transaction.TrxOutput // every output of the chain, spent or not, by outpoint
+	spends  map[string]string                 // outpoint -> hex hash of the transaction spending it
+}
+
+// Reports whether an output entry spends is spent on the chain by another
+// transaction
+func (chain *chainHistory) conflicts(entry *HistoryEntry) bool {
+	for _, outpoint := range entry.Spends {
+		if spender, isSpent := chain.spends[outpoint]; isSpent && spender != entry.TransactionID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the transactions of the history selected by filter, unconfirmed ones
+// first, then newest first, skipping offset of them and returning at most limit
+// if positive. The total number of selected transactions is returned with them.
+func (wm *WalletManager) ListHistory(filter *HistoryFilter, offset, limit int) ([]*HistoryEntry, int, error) {
+	if err := filter.validate(); err != nil {
+		return nil, 0, err
+	}
+
+	entries, err := wm.SyncHistory()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	selected := make([]*HistoryEntry, 0)
+	for _, entry := range entries {
+		if filter.matches(entry) {
+			selected = append(selected, entry)
+		}
+	}
+	total := len(selected)
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total {
+		return make([]*HistoryEntry, 0), total, nil
+	}
+
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+
+	return selected[offset:end], total, nil
+}
+
+// Returns the transaction of the history whose hex hash is id
+func (wm *WalletManager) GetHistoryEntry(id string) (*HistoryEntry, error) {
+	entries, err := wm.SyncHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.TransactionID == id {
+			return entry, nil
+		}
+	}
+
+	return nil, errors.New(ErrUnknownTransaction)
+}
+
+// Labels the transaction of the history whose hex hash is id. An empty label
+// removes it.
+func (wm *WalletManager) SetTransactionLabel(id, label string) error {
+	wm.historyMutex.Lock()
+	defer wm.historyMutex.Unlock()
+
+	file, err := wm.syncHistory()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range file.Transactions {
+		if entry.TransactionID == id {
+			entry.Label = label
+			return wm.saveHistory(file)
+		}
+	}
+
+	return errors.New(ErrUnknownTransaction)
+}
+
+// Updates the history file with the wallet transactions of the chain and the
+// mempool, and returns its transactions, unconfirmed ones first, then newest
+// first. The chain is only scanned again once its tip moved or the mempool holds
+// a transaction the history does not know, and the file only written when the
+// history changed.
+//
+// Transactions the wallet sent are kept when they leave the mempool without
+// being mined, as unconfirmed transactions, conflicted once an output they spend
+// is spent on the chain by another transaction. Received transactions are
+// dropped then, as only their sender can send them again.
+func (wm *WalletManager) SyncHistory() ([]*HistoryEntry, error) {
+	wm.historyMutex.Lock()
+	defer wm.historyMutex.Unlock()
+
+	file, err := wm.syncHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	return file.Transactions, nil
+}
+
+// Syncs the history file and returns its content. Callers must hold the history
+// mutex.
+func (wm *WalletManager) syncHistory() (*historyFile, error) {
+	stored, err := wm.loadHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	storedData, err := encodeHistory(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	tip := hex.EncodeToString(wm.blockchain.LastBlockHeaderHash)
+	moved := stored.Tip != tip
+
+	previous := make(map[string]*HistoryEntry, len(stored.Transactions))
+	for _, entry := range stored.Transactions {
+		previous[entry.TransactionID] = entry
+	}
+
+	confirmed := make([]*HistoryEntry, 0)
+	if moved {
+		chain, err := wm.scanHistory(tip)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, scanned := range chain.entries {
+			// The scan is kept for later syncs, so its entries are not handed out
+			entry := *scanned
+			if labeled, ok := previous[entry.TransactionID]; ok {
+				entry.Label = labeled.Label
+			}
+			confirmed = append(confirmed, &entry)
+		}
+	} else {
+		for _, entry := range stored.Transactions {
+			if entry.IsConfirmed() {
+				confirmed = append(confirmed, entry)
+			}
+		}
+	}
+
+	found := make(map[string]bool, len(confirmed))
+	for _, entry := range confirmed {
+		found[entry.TransactionID] = true
+	}
+
+	unconfirmed := make([]*HistoryEntry, 0)
+	mempoolEntries, _ := wm.mempool.List(0, 0)
+	for _, mempoolEntry := range mempoolEntries {
+		if found[mempoolEntry.ID] {
+			continue
+		}
+
+		entry, err := wm.mempoolHistoryEntry(mempoolEntry, previous[mempoolEntry.ID], tip)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry == nil {
+			continue
+		}
+		found[entry.TransactionID] = true
+		unconfirmed = append(unconfirmed, entry)
+	}
+
+	for _, entry := range stored.Transactions {
+		// Rewards of disconnected blocks can never be mined again, and received
+		// transactions are their sender's to send again
+		if found[entry.TransactionID] || entry.IsCoinbase || entry.Direction == DirectionReceived {
+			continue
+		}
+
+		// Conflicts only change with the chain
+		if moved {
+			chain, err := wm.scanHistory(tip)
+			if err != nil {
+				return nil, err
+			}
+			entry.Conflicted = chain.conflicts(entry)
+		}
+
+		entry.Height = -1
+		entry.Confirmations = 0
+		unconfirmed = append(unconfirmed, entry)
+	}
+
+	sort.SliceStable(unconfirmed, func(i, j int) bool {
+		return unconfirmed[i].Time > unconfirmed[j].Time
+	})
+
+	synced := &historyFile{Tip: tip, Transactions: append(unconfirmed, confirmed...)}
+
+	syncedData, err := encodeHistory(synced)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(storedData, syncedData) {
+		return synced, nil
+	}
+
+	return synced, wm.saveHistory(synced)
+}
+
+// Returns the history entry of the mempool transaction of mempoolEntry, or nil
+// if it neither pays to nor spends from the wallet. previous is its entry in the
+// history file, if any.
+func (wm *WalletManager) mempoolHistoryEntry(
+	mempoolEntry *transaction.MemPoolEntry,
+	previous *HistoryEntry,
+	tip string,
+) (*HistoryEntry, error) {
+	// What the transaction spends does not change while it waits
+	if previous != nil && !previous.IsConfirmed() {
+		entry := *previous
+		entry.Conflicted = false
+		entry.InMemPool = true
+		return &entry, nil
+	}
+
+	chain, err := wm.scanHistory(tip)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := func(hash []byte, index int) *transaction.TrxOutput {
+		if output := chain.outputs[transaction.OutpointKey(hash, index)]; output != nil {
+			return output
+		}
+
+		parent := wm.mempool.GetTransaction(hex.EncodeToString(hash))
+		if parent == nil || index >= len(parent.Output) {
+			return nil
+		}
+		return parent.Output[index]
+	}
+
+	entry, err := wm.historyEntry(mempoolEntry.Transaction, resolve)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	entry.Time = mempoolEntry.Time.Unix()
+	entry.InMemPool = true
+	if previous != nil {
+		entry.Label = previous.Label
+		if previous.Time < entry.Time {
+			entry.Time = previous.Time
+		}
+	}
+
+	return entry, nil
+}
+
+// Returns the wallet transactions of the chain ending at the block whose hex
+// header hash is tip. The scan is kept until the tip moves. Callers must hold
+// the history mutex.
+func (wm *WalletManager) scanHistory(tip string) (*chainHistory, error) {
+	if wm.chainHistory != nil && wm.chainHistory.tip == tip {
+		return wm.chainHistory, nil
+	}
+
+	blocks, err := wm.chainBlocks()
+	if err != nil {
+		return nil, err
+	}
+	height := len(blocks) - 1
+
+	chain := &chainHistory{
+		tip:     tip,
+		entries: make([]*HistoryEntry, 0),
+		outputs: make(map[string]*transaction.TrxOutput),
+		spends:  make(map[string]string),
+	}
+	resolve := func(hash []byte, index int) *transaction.TrxOutput {
+		return chain.outputs[transaction.OutpointKey(hash, index)]
+	}
+
+	for i := height; i >= 0; i-- {
+		blockTime, err := share.BytesToInt64(blocks[i].Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, trx := range blocks[i].Transactions {
+			entry, err := wm.historyEntry(trx, resolve)
+			if err != nil {
+				return nil, err
+			}
+
+			id := hex.EncodeToString(trx.ID)
+			for idx, output := range trx.Output {
+				chain.outputs[transaction.OutpointKey(trx.ID, idx)] = output
+			}
+			if !trx.IsCoinbase() {
+				for _, input := range trx.Input {
+					outIdx, err := input.OutputIndex()
+					if err != nil {
+						return nil, err
+					}
+					chain.spends[transaction.OutpointKey(input.OutpointHash, outIdx)] = id
+				}
+			}
+
+			if entry == nil {
+				continue
+			}
+			entry.Height = height - i
+			entry.Confirmations = i + 1
+			entry.Time = blockTime
+			chain.entries = append(chain.entries, entry)
+		}
+	}
+
+	// Newest first, keeping the order of transactions within a block reversed
+	for i, j := 0, len(chain.entries)-1; i < j; i, j = i+1, j-1 {
+		chain.entries[i], chain.entries[j] = chain.entries[j], chain.entries[i]
+	}
+
+	wm.chainHistory = chain
+
+	return chain, nil
+}
+
+// Adds trx, spending outputs of utxoSet or of the mempool, to the mempool and
+// records it in the history, so that it is kept even if the mempool drops it
+func (wm *WalletManager) submit(trx *transaction.Transaction, utxoSet *blockchain.UTXOSet) error {
+	id := hex.EncodeToString(trx.ID)
+	if err := wm.mempool.AddTransaction(id, trx, utxoSet); err != nil {
+		return err
+	}
+
+	resolve := func(hash []byte, index int) *transaction.TrxOutput {
+		if output := utxoSet.GetOutput(hash, index); output != nil {
+			return output
+		}
+
+		parent := wm.mempool.GetTransaction(hex.EncodeToString(hash))
+		if parent == nil || index >= len(parent.Output) {
+			return nil
+		}
+		return parent.Output[index]
+	}
+
+	entry, err := wm.historyEntry(trx, resolve)
+	if err != nil || entry == nil {
+		return err
+	}
+	entry.Time = time.Now().Unix()
+	entry.InMemPool = true
+
+	wm.historyMutex.Lock()
+	defer wm.historyMutex.Unlock()
+
+	stored, err := wm.loadHistory()
+	if err != nil {
+		return fmt.Errorf("transaction %s was sent but not recorded in the history: %s", id, err)
+	}
+
+	entries := []*HistoryEntry{entry}
+	for _, previous := range stored.Transactions {
+		if previous.TransactionID != id {
+			entries = append(entries, previous)
+		}
+	}
+	stored.Transactions = entries
+
+	if err = wm.saveHistory(stored); err != nil {
+		return fmt.Errorf("transaction %s was sent but not recorded in the history: %s", id, err)
+	}
+
+	return nil
+}
+
+// Returns the history entry of trx, whose inputs spend the outputs resolve
+// returns, or nil if trx neither pays to nor spends from the wallet. Height,
+// confirmations and time are left to the caller.
+func (wm *WalletManager) historyEntry(
+	trx *transaction.Transaction,
+	resolve func(hash []byte, index int) *transaction.TrxOutput,
+) (*HistoryEntry, error) {
+	var received, spent, inputTotal, outputTotal uint64
+	resolved := !trx.IsCoinbase()
+	senders := make([]string, 0)
+	spends := make([]string, 0)
+
+	if !trx.IsCoinbase() {
+		for _, input := range trx.Input {
+			outIdx, err := input.OutputIndex()
+			if err != nil {
+				return nil, err
+			}
+			spends = append(spends, transaction.OutpointKey(input.OutpointHash, outIdx))
+
+			output := resolve(input.OutpointHash, outIdx)
+			if output == nil {
+				resolved = false
+				continue
+			}
+
+			amount, err := share.BytesToInt64(output.Amount)
+			if err != nil {
+				return nil, err
+			}
+			inputTotal += uint64(amount)
+
+			if wm.keyring.Owns(output.LockingScript) {
+				spent += uint64(amount)
+			} else {
+				senders = appendAddress(senders, script.ExtractAddress(output.LockingScript))
+			}
+		}
+	}
+
+	receivers := make([]string, 0)
+	walletAddresses := make([]string, 0)
+	for _, output := range trx.Output {
+		amount, err := share.BytesToInt64(output.Amount)
+		if err != nil {
+			return nil, err
+		}
+		outputTotal += uint64(amount)
+
+		if wm.keyring.Owns(output.LockingScript) {
+			received += uint64(amount)
+			walletAddresses = appendAddress(walletAddresses, script.ExtractAddress(output.LockingScript))
+		} else {
+			receivers = appendAddress(receivers, script.ExtractAddress(output.LockingScript))
+		}
+	}
+
+	if spent == 0 && received == 0 {
+		return nil, nil
+	}
+
+	entry := &HistoryEntry{
+		TransactionID:   hex.EncodeToString(trx.ID),
+		Amount:          int64(received) - int64(spent),
+		WalletAddresses: walletAddresses,
+		Height:          -1,
+		IsCoinbase:      trx.IsCoinbase(),
+		Spends:          spends,
+	}
+
+	switch {
+	case spent == 0:
+		entry.Direction = DirectionReceived
+		entry.Addresses = senders
+	case len(receivers) == 0:
+		entry.Direction = DirectionSelf
+		entry.Addresses = receivers
+	default:
+		entry.Direction = DirectionSent
+		entry.Addresses = receivers
+	}
+
+	// The fee is only known once every spent output is, and it is the wallet's
+	// to pay when it spends
+	if spent > 0 && resolved && inputTotal >= outputTotal {
+		entry.Fee = inputTotal - outputTotal
+	}
+
+	return entry, nil
+}
+
+// Returns the blocks of the chain, from the tip down to the genesis block
+func (wm *WalletManager) chainBlocks() ([]*blockchain.Block, error) {
+	blocks := make([]*blockchain.Block, 0)
+
+	iterator := wm.blockchain.Iterator()
+	for {
+		block, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block)
+
+		if block.IsGenesis() {
+			return blocks, nil
+		}
+	}
+}
+
+func (wm *WalletManager) historyPath() string {
+	return filepath.Join(filepath.Dir(wm.keyring.path), HistoryFilename)
+}
+
+// Returns the content of the history file, no transactions if there is no file
+// yet
+func (wm *WalletManager) loadHistory() (*historyFile, error) {
+	file := &historyFile{Transactions: make([]*HistoryEntry, 0)}
+
+	data, err := os.ReadFile(wm.historyPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return file, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("could not parse history file %s: %s", wm.historyPath(), err)
+	}
+
+	return file, nil
+}
+
+func (wm *WalletManager) saveHistory(file *historyFile) error {
+	data, err := encodeHistory(file)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(wm.historyPath(), data, 0o600)
+}
+
+func encodeHistory(file *historyFile) ([]byte, error) {
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// Appends address to addresses unless it is empty, as for outputs without an
+// address, or already there
+func appendAddress(addresses []string, address string) []string {
+	if address == "" || containsAddress(addresses, address) {
+		return addresses
+	}
+
+	return append(addresses, address)
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, candidate := range addresses {
+		if candidate == address {
+			return true
+		}
+	}
+
+	return false
+}
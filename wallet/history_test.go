@@ -0,0 +1,313 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	registry := newTestRegistry(t, t.TempDir())
+	wm := registry.Default()
+
+	walletScript, err := wm.keyring.MainScript()
+	assert.NoError(t, err)
+	walletAddress := script.ExtractAddress(walletScript)
+
+	otherScript, err := script.PayToPubKeyHash(bytes.Repeat([]byte{0x05}, 20))
+	assert.NoError(t, err)
+	otherAddress := script.ExtractAddress(otherScript)
+
+	funding := &transaction.Transaction{
+		ID: bytes.Repeat([]byte{0x01}, 32),
+		Output: []*transaction.TrxOutput{
+			{Amount: share.Int64ToBytes(10_000), LockingScript: walletScript},
+			{Amount: share.Int64ToBytes(7_000), LockingScript: otherScript},
+		},
+	}
+	resolve := func(hash []byte, index int) *transaction.TrxOutput {
+		if bytes.Equal(hash, funding.ID) && index < len(funding.Output) {
+			return funding.Output[index]
+		}
+		return nil
+	}
+
+	spend := func(index int, outputs ...*transaction.TrxOutput) *transaction.Transaction {
+		trx, err := transaction.NewTransaction(
+			[]*transaction.TrxInput{{
+				OutpointHash:  funding.ID,
+				OutpointIndex: share.IntToBytes(index),
+				Sequence:      share.IntToBytes(int(transaction.DefaultSequence)),
+			}},
+			outputs,
+		)
+		assert.NoError(t, err)
+		return trx
+	}
+
+	t.Run("should tell the direction, amount, fee and counterparties of a transaction", func(t *testing.T) {
+		received, err := wm.historyEntry(spend(1, &transaction.TrxOutput{Amount: share.Int64ToBytes(6_800), LockingScript: walletScript}), resolve)
+		assert.NoError(t, err)
+		assert.Equal(t, DirectionReceived, received.Direction)
+		assert.Equal(t, int64(6_800), received.Amount)
+		assert.Equal(t, uint64(0), received.Fee)
+		assert.Equal(t, []string{otherAddress}, received.Addresses)
+		assert.Equal(t, []string{walletAddress}, received.WalletAddresses)
+
+		sent, err := wm.historyEntry(spend(0,
+			&transaction.TrxOutput{Amount: share.Int64ToBytes(4_000), LockingScript: otherScript},
+			&transaction.TrxOutput{Amount: share.Int64ToBytes(5_700), LockingScript: walletScript},
+		), resolve)
+		assert.NoError(t, err)
+		assert.Equal(t, DirectionSent, sent.Direction)
+		assert.Equal(t, int64(-4_300), sent.Amount)
+		assert.Equal(t, uint64(300), sent.Fee)
+		assert.Equal(t, []string{otherAddress}, sent.Addresses)
+
+		self, err := wm.historyEntry(spend(0, &transaction.TrxOutput{Amount: share.Int64ToBytes(9_800), LockingScript: walletScript}), resolve)
+		assert.NoError(t, err)
+		assert.Equal(t, DirectionSelf, self.Direction)
+		assert.Equal(t, int64(-200), self.Amount)
+		assert.Equal(t, uint64(200), self.Fee)
+
+		unrelated, err := wm.historyEntry(spend(1, &transaction.TrxOutput{Amount: share.Int64ToBytes(6_800), LockingScript: otherScript}), resolve)
+		assert.NoError(t, err)
+		assert.Nil(t, unrelated)
+	})
+
+	t.Run("should keep labels in the history file and filter entries", func(t *testing.T) {
+		trx := spend(0, &transaction.TrxOutput{Amount: share.Int64ToBytes(4_000), LockingScript: otherScript})
+		entry, err := wm.historyEntry(trx, resolve)
+		assert.NoError(t, err)
+		entry.Label = "rent"
+
+		assert.NoError(t, wm.saveHistory(&historyFile{Transactions: []*HistoryEntry{entry}}))
+
+		stored, err := wm.loadHistory()
+		assert.NoError(t, err)
+		assert.Len(t, stored.Transactions, 1)
+		assert.Equal(t, hex.EncodeToString(trx.ID), stored.Transactions[0].TransactionID)
+		assert.Equal(t, "rent", stored.Transactions[0].Label)
+
+		assert.True(t, (&HistoryFilter{Direction: DirectionSent, Address: otherAddress, Unconfirmed: true}).matches(entry))
+		assert.False(t, (&HistoryFilter{Direction: DirectionReceived}).matches(entry))
+		assert.False(t, (&HistoryFilter{Address: walletAddress}).matches(entry))
+
+		_, _, err = wm.ListHistory(&HistoryFilter{Direction: "incoming"}, 0, 0)
+		assert.EqualError(t, err, ErrInvalidDirection)
+	})
+}
+
+// Returns the ids of entries
+func historyIDs(entries []*HistoryEntry) []string {
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.TransactionID)
+	}
+
+	return ids
+}
+
+func TestSyncHistory(t *testing.T) {
+	t.Run("should count confirmations from the tip and list pending transactions first", func(t *testing.T) {
+		wm, chain := newTestChain(t, share.RegtestParams)
+		genesis, err := wm.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+		first := chain.mine(t)
+
+		entries, err := wm.SyncHistory()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			hex.EncodeToString(first.Transactions[0].ID),
+			hex.EncodeToString(genesis.Transactions[0].ID),
+		}, historyIDs(entries))
+		assert.Equal(t, []int{1, 0}, []int{entries[0].Height, entries[1].Height})
+		assert.Equal(t, []int{1, 2}, []int{entries[0].Confirmations, entries[1].Confirmations})
+
+		trx, err := wm.CreateTransaction(1_000_000_000, foreignAddress(), &LargestFirst{}, 1)
+		assert.NoError(t, err)
+		id := hex.EncodeToString(trx.ID)
+
+		entries, err = wm.SyncHistory()
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+		assert.Equal(t, id, entries[0].TransactionID)
+		assert.True(t, entries[0].InMemPool)
+		assert.False(t, entries[0].IsConfirmed())
+		assert.Equal(t, DirectionSent, entries[0].Direction)
+		assert.Equal(t, []string{foreignAddress()}, entries[0].Addresses)
+
+		second := chain.mine(t)
+
+		// Transactions of a block come newest first, the reward last
+		entries, err = wm.SyncHistory()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			id,
+			hex.EncodeToString(second.Transactions[0].ID),
+			hex.EncodeToString(first.Transactions[0].ID),
+			hex.EncodeToString(genesis.Transactions[0].ID),
+		}, historyIDs(entries))
+		assert.False(t, entries[0].InMemPool)
+		assert.Equal(t, 2, entries[0].Height)
+		assert.Equal(t, []int{1, 1, 2, 3}, []int{
+			entries[0].Confirmations,
+			entries[1].Confirmations,
+			entries[2].Confirmations,
+			entries[3].Confirmations,
+		})
+	})
+
+	t.Run("should page through the history", func(t *testing.T) {
+		wm, chain := newTestChain(t, share.RegtestParams)
+		chain.mine(t)
+		chain.mine(t)
+		_, err := wm.CreateTransaction(1_000_000_000, foreignAddress(), &LargestFirst{}, 1)
+		assert.NoError(t, err)
+
+		all, total, err := wm.ListHistory(&HistoryFilter{}, 0, 0)
+		assert.NoError(t, err)
+		assert.Len(t, all, 4)
+		assert.Equal(t, 4, total)
+
+		page, total, err := wm.ListHistory(&HistoryFilter{}, 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, historyIDs(all[1:3]), historyIDs(page))
+		assert.Equal(t, 4, total)
+
+		page, _, err = wm.ListHistory(&HistoryFilter{}, 3, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, historyIDs(all[3:]), historyIDs(page))
+
+		page, total, err = wm.ListHistory(&HistoryFilter{}, 4, 2)
+		assert.NoError(t, err)
+		assert.Empty(t, page)
+		assert.Equal(t, 4, total)
+
+		page, total, err = wm.ListHistory(&HistoryFilter{Unconfirmed: true}, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, historyIDs(all[:1]), historyIDs(page))
+		assert.Equal(t, 1, total)
+
+		page, total, err = wm.ListHistory(&HistoryFilter{Direction: DirectionReceived}, -1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, historyIDs(all[1:3]), historyIDs(page))
+		assert.Equal(t, 3, total)
+	})
+
+	t.Run("should follow reorgs and mark sends conflicted by a mined double spend", func(t *testing.T) {
+		// Only the genesis reward can be spent until the second block
+		params := *share.RegtestParams
+		params.WalletCoinbaseMaturity = 2
+
+		wm, chain := newTestChain(t, &params)
+		chain.mine(t)
+
+		sent, err := wm.CreateTransaction(1_000_000_000, foreignAddress(), &LargestFirst{}, 1)
+		assert.NoError(t, err)
+		sentID := hex.EncodeToString(sent.ID)
+		reward := hex.EncodeToString(chain.mine(t).Transactions[0].ID)
+
+		entry, err := wm.GetHistoryEntry(sentID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, entry.Height)
+
+		// The disconnected block puts the payment back into the mempool, and its
+		// reward is gone for good
+		_, dropped, err := wm.blockchain.DisconnectBlocks(1, chain.mempool)
+		assert.NoError(t, err)
+		assert.Empty(t, dropped)
+
+		entries, err := wm.SyncHistory()
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+		assert.NotContains(t, historyIDs(entries), reward)
+		assert.Equal(t, sentID, entries[0].TransactionID)
+		assert.True(t, entries[0].InMemPool)
+		assert.Equal(t, 0, entries[0].Confirmations)
+
+		// Evicted from the mempool, the payment can still be mined
+		chain.mempool.DeleteTransaction(sentID)
+
+		entry, err = wm.GetHistoryEntry(sentID)
+		assert.NoError(t, err)
+		assert.False(t, entry.IsConfirmed())
+		assert.False(t, entry.InMemPool)
+		assert.False(t, entry.Conflicted)
+
+		// Until another payment spending the genesis reward is mined
+		double, err := wm.CreateTransaction(2_000_000_000, foreignAddress(), &LargestFirst{}, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, sent.Input[0].OutpointHash, double.Input[0].OutpointHash)
+		chain.mine(t)
+
+		entry, err = wm.GetHistoryEntry(sentID)
+		assert.NoError(t, err)
+		assert.False(t, entry.IsConfirmed())
+		assert.True(t, entry.Conflicted)
+
+		entry, err = wm.GetHistoryEntry(hex.EncodeToString(double.ID))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, entry.Height)
+		assert.False(t, entry.Conflicted)
+	})
+
+	t.Run("should drop received transactions that left the chain and the mempool", func(t *testing.T) {
+		wm, _ := newTestChain(t, share.RegtestParams)
+
+		received := &HistoryEntry{
+			TransactionID: hex.EncodeToString(bytes.Repeat([]byte{0x07}, 32)),
+			Direction:     DirectionReceived,
+			Amount:        5_000,
+			Height:        3,
+			Confirmations: 1,
+		}
+		assert.NoError(t, wm.saveHistory(&historyFile{Transactions: []*HistoryEntry{received}}))
+
+		entries, err := wm.SyncHistory()
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.True(t, entries[0].IsCoinbase)
+
+		_, err = wm.GetHistoryEntry(received.TransactionID)
+		assert.EqualError(t, err, ErrUnknownTransaction)
+	})
+
+	t.Run("should only write the history file when the history changed", func(t *testing.T) {
+		wm, chain := newTestChain(t, share.RegtestParams)
+		genesis, err := wm.blockchain.Iterator().Next()
+		assert.NoError(t, err)
+		genesisID := hex.EncodeToString(genesis.Transactions[0].ID)
+
+		assert.NoError(t, wm.SetTransactionLabel(genesisID, "first reward"))
+
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		assert.NoError(t, os.Chtimes(wm.historyPath(), past, past))
+
+		_, err = wm.SyncHistory()
+		assert.NoError(t, err)
+		info, err := os.Stat(wm.historyPath())
+		assert.NoError(t, err)
+		assert.Equal(t, past, info.ModTime())
+
+		// A new block moves the tip and the confirmations, and keeps the label
+		chain.mine(t)
+
+		entry, err := wm.GetHistoryEntry(genesisID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, entry.Confirmations)
+		assert.Equal(t, "first reward", entry.Label)
+
+		stored, err := wm.loadHistory()
+		assert.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(wm.blockchain.LastBlockHeaderHash), stored.Tip)
+		assert.Len(t, stored.Transactions, 2)
+	})
+}
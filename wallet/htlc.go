@@ -0,0 +1,275 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+const (
+	ErrNotHTLCReceiver      = "wallet key is not the receiver of the contract"
+	ErrNotHTLCRefunder      = "wallet key is not the refund key of the contract"
+	ErrHTLCPreimageMismatch = "preimage does not match the hash lock of the contract"
+	ErrHTLCNotFunded        = "no unspent output pays to the contract"
+	ErrHTLCPreimageNotFound = "no transaction claiming the contract was found"
+	ErrFeeExceedsAmount     = "fee leaves nothing to spend"
+)
+
+// Returns a random secret preimage and its SHA-256 hash lock. The party starting
+// an atomic swap keeps the preimage and shares the hash lock.
+func NewHTLCSecret() ([]byte, []byte, error) {
+	preimage := make([]byte, script.HashLockLength)
+	if _, err := rand.Read(preimage); err != nil {
+		return nil, nil, err
+	}
+
+	hashLock := sha256.Sum256(preimage)
+
+	return preimage, hashLock[:], nil
+}
+
+// Returns a transaction paying amount from the wallet to a hash time locked
+// contract, which receiverAddress can claim with the preimage of hashLock and the
+// wallet's main key can take back from lockTime on, together with the contract's
+// redeem script. The transaction is added to the mempool; the contract's address
+// is the pay to script hash address of the redeem script.
+func (wm *WalletManager) CreateHTLC(
+	amount uint64,
+	receiverAddress string,
+	hashLock []byte,
+	lockTime int64,
+) (*transaction.Transaction, []byte, error) {
+	if !share.ValidateAddress(receiverAddress) {
+		return nil, nil, errors.New(ErrInvalidAddress)
+	}
+
+	if wm.IsWatchOnly() {
+		return nil, nil, errors.New(ErrWatchOnly)
+	}
+
+	refundPubKeyHash, err := wm.keymanager.GetPublicKeyHash()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	redeemScript, err := script.HTLCScript(&script.HTLC{
+		HashLock:           hashLock,
+		ReceiverPubKeyHash: share.PublicKeyHashFromAddress(receiverAddress),
+		RefundPubKeyHash:   refundPubKeyHash[:],
+		LockTime:           lockTime,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contractScript, err := script.PayToScriptHash(script.Hash160(redeemScript))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trx, err := wm.createPayment(utxoSet, amount, contractScript, DefaultCoinSelector(), DefaultFeeRate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = wm.submit(trx, utxoSet); err != nil {
+		return nil, nil, err
+	}
+
+	return trx, redeemScript, nil
+}
+
+// Returns a transaction claiming every output of the contract of redeemScript to
+// the wallet by revealing preimage, less fee. The transaction is added to the
+// mempool, where the other party of a swap learns the preimage.
+func (wm *WalletManager) ClaimHTLC(redeemScript, preimage []byte, fee uint64) (*transaction.Transaction, error) {
+	htlc, err := script.ExtractHTLC(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash := sha256.Sum256(preimage); !bytes.Equal(hash[:], htlc.HashLock) {
+		return nil, errors.New(ErrHTLCPreimageMismatch)
+	}
+
+	unlock := func(signature, publicKey []byte) ([]byte, error) {
+		unlocking, err := script.HTLCClaimUnlock(signature, publicKey, preimage)
+		if err != nil {
+			return nil, err
+		}
+
+		return script.PayToScriptHashUnlock(unlocking, redeemScript)
+	}
+
+	return wm.spendHTLC(redeemScript, htlc.ReceiverPubKeyHash, ErrNotHTLCReceiver, 0, fee, unlock)
+}
+
+// Returns a transaction taking every output of the contract of redeemScript back
+// to the wallet, less fee. Its lock time is the contract's, so it is only
+// accepted once the lock time is reached.
+func (wm *WalletManager) RefundHTLC(redeemScript []byte, fee uint64) (*transaction.Transaction, error) {
+	htlc, err := script.ExtractHTLC(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := func(signature, publicKey []byte) ([]byte, error) {
+		unlocking, err := script.HTLCRefundUnlock(signature, publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return script.PayToScriptHashUnlock(unlocking, redeemScript)
+	}
+
+	return wm.spendHTLC(redeemScript, htlc.RefundPubKeyHash, ErrNotHTLCRefunder, uint32(htlc.LockTime), fee, unlock)
+}
+
+// Spends every output of the contract of redeemScript to the wallet, which must
+// own pubKeyHash, and adds the transaction to the mempool
+func (wm *WalletManager) spendHTLC(
+	redeemScript []byte,
+	pubKeyHash []byte,
+	errNotOwner string,
+	lockTime uint32,
+	fee uint64,
+	unlock func(signature, publicKey []byte) ([]byte, error),
+) (*transaction.Transaction, error) {
+	if wm.IsWatchOnly() {
+		return nil, errors.New(ErrWatchOnly)
+	}
+
+	walletPubKeyHash, err := wm.keymanager.GetPublicKeyHash()
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(walletPubKeyHash[:], pubKeyHash) {
+		return nil, errors.New(errNotOwner)
+	}
+
+	contractScript, err := script.PayToScriptHash(script.Hash160(redeemScript))
+	if err != nil {
+		return nil, err
+	}
+
+	walletScript, err := script.PayToPubKeyHash(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := wm.getUTXOFromSet(utxoSet, contractScript)
+	if len(utxos) == 0 {
+		return nil, errors.New(ErrHTLCNotFunded)
+	}
+
+	total, err := wm.getUTXOAmount(utxos)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(total) <= fee {
+		return nil, errors.New(ErrFeeExceedsAmount)
+	}
+
+	// A sequence below the default keeps the lock time, which the refund branch
+	// checks, in force
+	sequence := transaction.DefaultSequence
+	if lockTime != 0 {
+		sequence--
+	}
+
+	inputs := make([]*transaction.TrxInput, 0, len(utxos))
+	for _, utxo := range utxos {
+		inputs = append(inputs, &transaction.TrxInput{
+			OutpointHash:  utxo.TransactionHash,
+			OutpointIndex: utxo.OutpointIndex,
+			Sequence:      share.IntToBytes(int(sequence)),
+		})
+	}
+
+	output := &transaction.TrxOutput{
+		Amount:        share.Int64ToBytes(total - int64(fee)),
+		LockingScript: walletScript,
+	}
+
+	trx, err := transaction.NewTransactionWithLockTime(inputs, []*transaction.TrxOutput{output}, lockTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wm.signInputs(trx, wm.mainKeys(len(trx.Input)), unlock); err != nil {
+		return nil, err
+	}
+
+	if err = wm.submit(trx, utxoSet); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+// Returns the preimage revealed by a mempool or chain transaction claiming the
+// contract of redeemScript. The party that funded a swap's other contract claims
+// it with this preimage.
+func (wm *WalletManager) FindHTLCPreimage(redeemScript []byte) ([]byte, error) {
+	htlc, err := script.ExtractHTLC(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _ := wm.mempool.List(0, 0)
+	for _, entry := range entries {
+		if preimage := htlcPreimage(entry.Transaction, htlc); preimage != nil {
+			return preimage, nil
+		}
+	}
+
+	iterator := wm.blockchain.Iterator()
+	for {
+		block, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, trx := range block.Transactions {
+			if preimage := htlcPreimage(trx, htlc); preimage != nil {
+				return preimage, nil
+			}
+		}
+
+		if block.IsGenesis() {
+			return nil, errors.New(ErrHTLCPreimageNotFound)
+		}
+	}
+}
+
+func htlcPreimage(trx *transaction.Transaction, htlc *script.HTLC) []byte {
+	if trx.IsCoinbase() {
+		return nil
+	}
+
+	for _, input := range trx.Input {
+		if preimage := script.ExtractHTLCPreimage(input.UnlockingScript, htlc); preimage != nil {
+			return preimage
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,683 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+)
+
+const (
+	// Name of the file, in the data directory, holding the encrypted seed of the
+	// wallet, its account public key and the number of keys handed out on each
+	// chain
+	HDWalletFilename = "mag_hd_wallet.json"
+
+	// Name of the file, in the directory of a watch-only wallet, holding the
+	// account public key and the addresses the wallet watches
+	WatchOnlyFilename = "mag_watch_only.json"
+
+	// Name of the directory, in the data directory, holding the main keys replaced
+	// by a seed
+	ImportedKeysDirname = "mag_imported_keys"
+
+	// Name of the directory, in the data directory, holding the random change keys
+	// of wallets created before keys were derived from a seed
+	ChangeKeysDirname = "mag_change_keys"
+
+	// Number of consecutive unused addresses after which a scan stops looking
+	GapLimit = 20
+)
+
+const (
+	ErrWalletExists = "wallet already has a seed"
+	ErrNoSeed       = "wallet has no seed, create or restore one"
+	ErrNoAccountKey = "watch-only wallet has no extended public key to derive addresses"
+	ErrNothingWatch = "a watch-only wallet needs an extended public key or addresses"
+	ErrPrivateXPub  = "expected an extended public key, not a private one"
+	ErrXPubNetwork  = "extended public key is for another network"
+)
+
+// Chains of an account, the fourth level of BIP-44 paths
+const (
+	ExternalChain uint32 = 0 // receive addresses
+	InternalChain uint32 = 1 // change addresses
+)
+
+// hdWalletFile is the content of the HD wallet file
+type hdWalletFile struct {
+	EncryptedSeed    string   `json:"encrypted_seed,omitempty"` // hex, empty for watch-only wallets
+	LegacySeed       string   `json:"seed,omitempty"`           // hex, in the clear, as written before the keystore
+	AccountPublicKey string   `json:"account_public_key,omitempty"`
+	Addresses        []string `json:"addresses,omitempty"` // watched by watch-only wallets
+	NextReceive      uint32   `json:"next_receive"`
+	NextInternal     uint32   `json:"next_change"`
+}
+
+// KeyRing is the set of keys the wallet owns. Receive and change keys are
+// derived from the wallet seed along m/44'/<coin type>'/0'/<chain>/<index>, so
+// the seed alone recovers them. The key of the key manager, which receives
+// block rewards, and change keys of older wallets are kept as imported keys.
+//
+// Without a seed, as on networks without HD keys, the key manager's key is the
+// only key, and it also receives payments and change.
+//
+// While the keystore is locked only public keys are loaded: the account public
+// key still derives new addresses, but nothing can be signed.
+//
+// A watch-only key ring has no key manager nor keystore: it holds an account
+// public key, addresses imported without their keys, or both, and never signs.
+type KeyRing struct {
+	keymanager    *share.KeyManager // nil for watch-only wallets
+	keystore      *share.Keystore   // nil for watch-only wallets
+	params        *share.NetworkParams
+	path          string
+	seed          []byte             // nil while locked
+	encryptedSeed []byte             // nil without a seed
+	account       *share.ExtendedKey // nil without a seed, public while locked
+	next          [2]uint32          // index of the next key handed out, per chain
+	keys          map[string]*ecdsa.PrivateKey
+	order         []string // hex public key hashes, in the order the keys were added
+	addresses     []string // addresses watched by a watch-only wallet
+	watched       [][]byte // locking scripts of watched addresses that are not key hashes
+
+	// Guards seed, account and keys, which the keystore clears from another
+	// goroutine once its unlock timeout elapses
+	mutex sync.RWMutex
+}
+
+// Returns the path of the first account below the master key
+func AccountPath(params *share.NetworkParams) share.DerivationPath {
+	return share.DerivationPath{
+		share.HardenedKeyStart + 44,
+		share.HardenedKeyStart + params.HDCoinType,
+		share.HardenedKeyStart,
+	}
+}
+
+// Loads the wallet stored next to the key of keymanager. Until a seed is set
+// with Initialize, the key manager's key is the only key of the wallet.
+func LoadKeyRing(keymanager *share.KeyManager) (*KeyRing, error) {
+	keystore := keymanager.Keystore()
+	keyring := &KeyRing{
+		keymanager: keymanager,
+		keystore:   keystore,
+		params:     keymanager.Params,
+		path:       filepath.Join(keystore.Dir(), HDWalletFilename),
+		keys:       make(map[string]*ecdsa.PrivateKey),
+		order:      make([]string, 0),
+	}
+
+	if err := keyring.add(keymanager.PublicKey, keymanager.GetPrivateKey()); err != nil {
+		return nil, err
+	}
+
+	for _, dirname := range []string{ImportedKeysDirname, ChangeKeysDirname} {
+		if err := keyring.loadImportedKeys(filepath.Join(keystore.Dir(), dirname)); err != nil {
+			return nil, err
+		}
+	}
+
+	if !keymanager.Params.SupportsHD() {
+		return keyring, nil
+	}
+
+	data, err := os.ReadFile(keyring.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return keyring, nil
+		}
+		return nil, err
+	}
+
+	var file hdWalletFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse wallet file %s: %s", keyring.path, err)
+	}
+
+	if file.EncryptedSeed == "" && file.LegacySeed != "" {
+		return keyring, keyring.loadLegacySeed(&file)
+	}
+
+	if keyring.encryptedSeed, err = hex.DecodeString(file.EncryptedSeed); err != nil {
+		return nil, fmt.Errorf("could not parse wallet seed: %s", err)
+	}
+
+	if keystore.IsLocked() {
+		if keyring.account, err = share.ParseExtendedKey(file.AccountPublicKey); err != nil {
+			return nil, err
+		}
+	} else {
+		seed, err := keystore.Decrypt(keyring.encryptedSeed)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = keyring.setSeed(seed); err != nil {
+			return nil, err
+		}
+	}
+
+	return keyring, keyring.deriveFileKeys(&file)
+}
+
+// Loads the seed a wallet file kept in the clear before the keystore existed,
+// and replaces it with the encrypted seed once the keystore is unlocked
+func (keyring *KeyRing) loadLegacySeed(file *hdWalletFile) error {
+	seed, err := hex.DecodeString(file.LegacySeed)
+	if err != nil {
+		return fmt.Errorf("could not parse wallet seed: %s", err)
+	}
+
+	if err = keyring.setSeed(seed); err != nil {
+		return err
+	}
+
+	if keyring.keystore.IsLocked() {
+		keyring.seed = nil
+		keyring.account = keyring.account.Neuter()
+
+		return keyring.deriveFileKeys(file)
+	}
+
+	if keyring.encryptedSeed, err = keyring.keystore.Encrypt(seed); err != nil {
+		return err
+	}
+
+	if err = keyring.deriveFileKeys(file); err != nil {
+		return err
+	}
+
+	return keyring.save()
+}
+
+// Derives the keys handed out on each chain, as counted by file
+func (keyring *KeyRing) deriveFileKeys(file *hdWalletFile) error {
+	for chain, next := range map[uint32]uint32{ExternalChain: file.NextReceive, InternalChain: file.NextInternal} {
+		if err := keyring.deriveUpTo(chain, next); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Loads the private keys once the keystore is unlocked
+func (keyring *KeyRing) Unlock() error {
+	if keyring.IsWatchOnly() {
+		return errors.New(ErrWatchOnly)
+	}
+
+	if err := keyring.keymanager.Unlock(); err != nil {
+		return err
+	}
+
+	unlocked, err := LoadKeyRing(keyring.keymanager)
+	if err != nil {
+		return err
+	}
+
+	keyring.mutex.Lock()
+	keyring.seed, keyring.encryptedSeed, keyring.account = unlocked.seed, unlocked.encryptedSeed, unlocked.account
+	keyring.next, keyring.keys, keyring.order = unlocked.next, unlocked.keys, unlocked.order
+	keyring.mutex.Unlock()
+
+	// The keystore may have locked, and forgotten the keys it knew of, while
+	// they were loaded
+	if keyring.keystore.IsLocked() {
+		keyring.Lock()
+		return share.ErrKeystoreLocked
+	}
+
+	return nil
+}
+
+// Forgets the private keys and seed, once the keystore is locked
+func (keyring *KeyRing) Lock() {
+	if keyring.IsWatchOnly() {
+		return
+	}
+
+	keyring.keymanager.Lock()
+
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	keyring.seed = nil
+	if keyring.account != nil {
+		keyring.account = keyring.account.Neuter()
+	}
+	for pkHash := range keyring.keys {
+		keyring.keys[pkHash] = nil
+	}
+}
+
+// Reports whether the private keys are loaded
+func (keyring *KeyRing) IsLocked() bool {
+	return keyring.keystore != nil && keyring.keystore.IsLocked()
+}
+
+// Reports whether the wallet only watches public keys and addresses
+func (keyring *KeyRing) IsWatchOnly() bool {
+	return keyring.keymanager == nil
+}
+
+// Reports whether the keys of the wallet derive from a seed
+func (keyring *KeyRing) IsHD() bool {
+	return keyring.getAccount() != nil
+}
+
+// Returns the account key, public while locked, or nil without a seed
+func (keyring *KeyRing) getAccount() *share.ExtendedKey {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	return keyring.account
+}
+
+// Derives the keys of the wallet from seed. The first receive key becomes the
+// main key, which receives block rewards, so the seed alone backs up every key
+// the wallet hands out; the previous main key is kept as an imported key.
+func (keyring *KeyRing) Initialize(seed []byte) error {
+	if keyring.IsWatchOnly() {
+		return errors.New(ErrWatchOnly)
+	}
+
+	if !keyring.params.SupportsHD() {
+		return share.ErrHDUnsupported
+	}
+
+	if keyring.IsHD() {
+		return errors.New(ErrWalletExists)
+	}
+
+	if keyring.IsLocked() {
+		return share.ErrKeystoreLocked
+	}
+
+	encryptedSeed, err := keyring.keystore.Encrypt(seed)
+	if err != nil {
+		return err
+	}
+
+	if err = keyring.setSeed(seed); err != nil {
+		return err
+	}
+	keyring.encryptedSeed = encryptedSeed
+
+	_, mainKey, err := keyring.nextKey(ExternalChain)
+	if err != nil {
+		return err
+	}
+
+	previous := keyring.keymanager.GetPrivateKey()
+	if previous.D.Cmp(mainKey.D) != 0 {
+		address, err := share.AddressFromPublicKey(&previous.PublicKey)
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Join(keyring.keystore.Dir(), ImportedKeysDirname)
+		if err = os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+
+		if err = keyring.keystore.SavePrivateKey(previous, filepath.Join(dir, address+".pem")); err != nil {
+			return err
+		}
+	}
+
+	return keyring.keymanager.SetPrivateKey(mainKey)
+}
+
+func (keyring *KeyRing) setSeed(seed []byte) error {
+	master, err := share.NewMasterKey(seed, keyring.params)
+	if err != nil {
+		return err
+	}
+
+	account, err := master.Derive(AccountPath(keyring.params))
+	if err != nil {
+		return err
+	}
+
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	keyring.seed = seed
+	keyring.account = account
+
+	return nil
+}
+
+func (keyring *KeyRing) save() error {
+	file := &hdWalletFile{
+		EncryptedSeed: hex.EncodeToString(keyring.encryptedSeed),
+		Addresses:     keyring.addresses,
+		NextReceive:   keyring.next[ExternalChain],
+		NextInternal:  keyring.next[InternalChain],
+	}
+	if account := keyring.getAccount(); account != nil {
+		file.AccountPublicKey = account.Neuter().String()
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(keyring.path, data, 0o600)
+}
+
+func (keyring *KeyRing) loadImportedKeys(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".pem") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+
+		publicKey, err := share.LoadPublicKey(path)
+		if err != nil {
+			return fmt.Errorf("could not load key %s: %w", name, err)
+		}
+
+		if publicKey.Curve.Params().N.Cmp(keyring.params.Curve.Params().N) != 0 {
+			return fmt.Errorf("key %s is not on the curve of the %s network", name, keyring.params.Name)
+		}
+
+		var privateKey *ecdsa.PrivateKey
+		if !keyring.IsLocked() {
+			if privateKey, err = keyring.keystore.LoadPrivateKey(path); err != nil {
+				return fmt.Errorf("could not load key %s: %w", name, err)
+			}
+		}
+
+		if err = keyring.add(publicKey, privateKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Adds the key of publicKey, whose privateKey is nil while locked
+func (keyring *KeyRing) add(publicKey *ecdsa.PublicKey, privateKey *ecdsa.PrivateKey) error {
+	pkHash, err := share.GetPublicKeyHashFromPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	keyring.addHash(pkHash[:], privateKey)
+	return nil
+}
+
+func (keyring *KeyRing) addHash(pkHash []byte, privateKey *ecdsa.PrivateKey) {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	key := hex.EncodeToString(pkHash)
+	if _, exists := keyring.keys[key]; !exists {
+		keyring.order = append(keyring.order, key)
+	}
+	keyring.keys[key] = privateKey
+}
+
+// Returns the key at index of chain, or share.ErrInvalidChild for the rare
+// indexes that derive no key. The private key is nil while locked.
+func (keyring *KeyRing) derive(chain, index uint32) (*ecdsa.PublicKey, *ecdsa.PrivateKey, error) {
+	key, err := keyring.getAccount().Derive(share.DerivationPath{chain, index})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !key.IsPrivate() {
+		return publicKey, nil, nil
+	}
+
+	privateKey, err := key.PrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return publicKey, privateKey, nil
+}
+
+// Adds the keys of chain below index to the key ring
+func (keyring *KeyRing) deriveUpTo(chain, index uint32) error {
+	for ; keyring.next[chain] < index; keyring.next[chain]++ {
+		publicKey, privateKey, err := keyring.derive(chain, keyring.next[chain])
+		if errors.Is(err, share.ErrInvalidChild) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = keyring.add(publicKey, privateKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Hands out the next key of chain
+func (keyring *KeyRing) nextKey(chain uint32) (*ecdsa.PublicKey, *ecdsa.PrivateKey, error) {
+	for {
+		index := keyring.next[chain]
+		publicKey, privateKey, err := keyring.derive(chain, index)
+		keyring.next[chain] = index + 1
+
+		if errors.Is(err, share.ErrInvalidChild) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = keyring.add(publicKey, privateKey); err != nil {
+			return nil, nil, err
+		}
+
+		return publicKey, privateKey, keyring.save()
+	}
+}
+
+// Returns an address of the wallet that has not been handed out before
+func (keyring *KeyRing) NewReceiveAddress() (string, error) {
+	if !keyring.IsHD() {
+		if keyring.IsWatchOnly() {
+			return "", errors.New(ErrNoAccountKey)
+		}
+		return keyring.keymanager.GetAddress()
+	}
+
+	publicKey, _, err := keyring.nextKey(ExternalChain)
+	if err != nil {
+		return "", err
+	}
+
+	return share.AddressFromPublicKey(publicKey)
+}
+
+// Returns the locking script paying to a change key that has not been used before
+func (keyring *KeyRing) NewChangeScript() ([]byte, error) {
+	if !keyring.IsHD() {
+		return keyring.MainScript()
+	}
+
+	publicKey, _, err := keyring.nextKey(InternalChain)
+	if err != nil {
+		return nil, err
+	}
+
+	pkHash, err := share.GetPublicKeyHashFromPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return script.PayToPubKeyHash(pkHash[:])
+}
+
+// Looks for used keys beyond the keys handed out, as after restoring a wallet
+// from its seed. Each chain is scanned until GapLimit consecutive keys are
+// unused; the keys up to the last used one are added to the key ring. Returns
+// the number of used keys found.
+func (keyring *KeyRing) Scan(used func(lockingScript []byte) bool) (int, error) {
+	if !keyring.IsHD() {
+		return 0, nil
+	}
+
+	found := 0
+	for _, chain := range []uint32{ExternalChain, InternalChain} {
+		lastUsed := uint32(0)
+		for index, gap := uint32(0), 0; gap < GapLimit; index++ {
+			publicKey, _, err := keyring.derive(chain, index)
+			if errors.Is(err, share.ErrInvalidChild) {
+				continue
+			}
+			if err != nil {
+				return found, err
+			}
+
+			pkHash, err := share.GetPublicKeyHashFromPublicKey(publicKey)
+			if err != nil {
+				return found, err
+			}
+
+			lockingScript, err := script.PayToPubKeyHash(pkHash[:])
+			if err != nil {
+				return found, err
+			}
+
+			if !used(lockingScript) {
+				gap++
+				continue
+			}
+
+			found++
+			gap = 0
+			lastUsed = index + 1
+		}
+
+		if err := keyring.deriveUpTo(chain, lastUsed); err != nil {
+			return found, err
+		}
+	}
+
+	return found, keyring.save()
+}
+
+// Returns the extended public key of the wallet account, which derives every
+// receive and change address of the wallet but none of its private keys
+func (keyring *KeyRing) AccountPublicKey() (string, error) {
+	account := keyring.getAccount()
+	if account == nil {
+		return "", errors.New(ErrNoSeed)
+	}
+
+	return account.Neuter().String(), nil
+}
+
+// Returns the key that signs for an output locked by lockingScript, or nil if
+// the wallet does not own the output or is locked
+func (keyring *KeyRing) KeyFor(lockingScript []byte) *ecdsa.PrivateKey {
+	pkHash := script.ExtractPubKeyHash(lockingScript)
+	if pkHash == nil || keyring.IsLocked() {
+		return nil
+	}
+
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	return keyring.keys[hex.EncodeToString(pkHash)]
+}
+
+// Reports whether the wallet can spend an output locked by lockingScript, once
+// unlocked, or watches it
+func (keyring *KeyRing) Owns(lockingScript []byte) bool {
+	pkHash := script.ExtractPubKeyHash(lockingScript)
+	if pkHash == nil {
+		return containsScript(keyring.watched, lockingScript)
+	}
+
+	keyring.mutex.RLock()
+	_, owned := keyring.keys[hex.EncodeToString(pkHash)]
+	keyring.mutex.RUnlock()
+
+	return owned || containsScript(keyring.watched, lockingScript)
+}
+
+// Returns the locking scripts paying to the wallet's keys, in the order the keys
+// were added, followed by the other watched scripts
+func (keyring *KeyRing) LockingScripts() ([][]byte, error) {
+	scripts, err := keyring.keyScripts()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(scripts, keyring.watched...), nil
+}
+
+// Returns the locking scripts paying to the wallet's keys, which the wallet
+// spends from
+func (keyring *KeyRing) keyScripts() ([][]byte, error) {
+	scripts := make([][]byte, 0, len(keyring.order))
+	for _, key := range keyring.order {
+		pkHash, _ := hex.DecodeString(key)
+
+		lockingScript, err := script.PayToPubKeyHash(pkHash)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, lockingScript)
+	}
+
+	return scripts, nil
+}
+
+// Returns the locking script paying to the main key. A watch-only wallet has no
+// main key and returns the script of its first watched address.
+func (keyring *KeyRing) MainScript() ([]byte, error) {
+	if keyring.IsWatchOnly() {
+		scripts, err := keyring.LockingScripts()
+		if err != nil || len(scripts) == 0 {
+			return nil, err
+		}
+		return scripts[0], nil
+	}
+
+	pkHash, err := keyring.keymanager.GetPublicKeyHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return script.PayToPubKeyHash(pkHash[:])
+}
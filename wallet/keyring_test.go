@@ -0,0 +1,297 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the keystore of dest, created or unlocked with a test passphrase
+func unlockedKeystore(t *testing.T, dest string) *share.Keystore {
+	keystore, err := share.OpenKeystore(dest)
+	assert.NoError(t, err)
+
+	if keystore.IsCreated() {
+		assert.NoError(t, keystore.Unlock("passphrase", 0))
+	} else {
+		assert.NoError(t, keystore.Create("passphrase"))
+	}
+
+	return keystore
+}
+
+// Returns the key ring of a new wallet, with its seed, in dest
+func newSeededKeyRing(t *testing.T, dest string) *KeyRing {
+	keymanager, err := share.LoadKeyManager(unlockedKeystore(t, dest), share.MainNetParams)
+	assert.NoError(t, err)
+
+	keyring, err := LoadKeyRing(keymanager)
+	assert.NoError(t, err)
+
+	seed := make([]byte, 32)
+	_, err = rand.Read(seed)
+	assert.NoError(t, err)
+	assert.NoError(t, keyring.Initialize(seed))
+
+	return keyring
+}
+
+func TestKeyRing(t *testing.T) {
+	t.Run("should persist derived keys next to the main key", func(t *testing.T) {
+		dest := t.TempDir()
+		keyring := newSeededKeyRing(t, dest)
+		keymanager := keyring.keymanager
+
+		mainScript, err := keyring.MainScript()
+		assert.NoError(t, err)
+		assert.True(t, keyring.Owns(mainScript))
+
+		changeScript, err := keyring.NewChangeScript()
+		assert.NoError(t, err)
+		assert.NotEqual(t, mainScript, changeScript)
+
+		first, err := keyring.NewReceiveAddress()
+		assert.NoError(t, err)
+		second, err := keyring.NewReceiveAddress()
+		assert.NoError(t, err)
+		assert.NotEqual(t, first, second)
+
+		receiveScript, err := script.PayToAddress(second)
+		assert.NoError(t, err)
+
+		reloaded, err := LoadKeyRing(keymanager)
+		assert.NoError(t, err)
+		assert.True(t, reloaded.Owns(changeScript))
+		assert.True(t, reloaded.Owns(receiveScript))
+
+		scripts, err := reloaded.LockingScripts()
+		assert.NoError(t, err)
+		assert.Len(t, scripts, 5) // main key, replaced main key, change key and two receive keys
+		assert.Contains(t, scripts, mainScript)
+
+		other, err := share.LoadKeyManager(unlockedKeystore(t, t.TempDir()), share.MainNetParams)
+		assert.NoError(t, err)
+		otherKeyring, err := LoadKeyRing(other)
+		assert.NoError(t, err)
+		assert.False(t, otherKeyring.Owns(changeScript))
+	})
+
+	t.Run("should derive the receive addresses from the account public key", func(t *testing.T) {
+		keyring := newSeededKeyRing(t, t.TempDir())
+
+		address, err := keyring.NewReceiveAddress()
+		assert.NoError(t, err)
+
+		xpub, err := keyring.AccountPublicKey()
+		assert.NoError(t, err)
+
+		account, err := share.ParseExtendedKey(xpub)
+		assert.NoError(t, err)
+		assert.False(t, account.IsPrivate())
+
+		child, err := account.Derive(share.DerivationPath{ExternalChain, 1})
+		assert.NoError(t, err)
+
+		publicKey, err := child.PublicKey()
+		assert.NoError(t, err)
+
+		derived, err := share.AddressFromPublicKey(publicKey)
+		assert.NoError(t, err)
+		assert.Equal(t, address, derived)
+	})
+
+	t.Run("should recover used keys from the seed within the gap limit", func(t *testing.T) {
+		keyring := newSeededKeyRing(t, t.TempDir())
+
+		var near, far []byte
+		for idx := 0; idx <= GapLimit+6; idx++ {
+			address, err := keyring.NewReceiveAddress()
+			assert.NoError(t, err)
+
+			lockingScript, err := script.PayToAddress(address)
+			assert.NoError(t, err)
+
+			switch idx {
+			case 5:
+				near = lockingScript
+			case GapLimit + 6:
+				far = lockingScript
+			}
+		}
+
+		// Restore the seed alone in another directory
+		restored := t.TempDir()
+		restoredKeymanager, err := share.LoadKeyManager(unlockedKeystore(t, restored), share.MainNetParams)
+		assert.NoError(t, err)
+
+		restoredKeyring, err := LoadKeyRing(restoredKeymanager)
+		assert.NoError(t, err)
+		assert.NoError(t, restoredKeyring.Initialize(keyring.seed))
+		assert.Equal(t, keyring.keymanager.PrivateKey.D, restoredKeymanager.PrivateKey.D)
+		assert.False(t, restoredKeyring.Owns(near))
+
+		found, err := restoredKeyring.Scan(func(lockingScript []byte) bool {
+			return string(lockingScript) == string(near) || string(lockingScript) == string(far)
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, found)
+		assert.True(t, restoredKeyring.Owns(near))
+		assert.False(t, restoredKeyring.Owns(far))
+
+		// Handing out addresses continues after the last used one
+		next, err := restoredKeyring.NewReceiveAddress()
+		assert.NoError(t, err)
+		nextScript, err := script.PayToAddress(next)
+		assert.NoError(t, err)
+		assert.NotEqual(t, near, nextScript)
+		assert.True(t, keyring.Owns(nextScript))
+	})
+
+	t.Run("should replace the main key by a key derived from the seed", func(t *testing.T) {
+		dest := t.TempDir()
+
+		keymanager, err := share.LoadKeyManager(unlockedKeystore(t, dest), share.MainNetParams)
+		assert.NoError(t, err)
+
+		keyring, err := LoadKeyRing(keymanager)
+		assert.NoError(t, err)
+
+		previousScript, err := keyring.MainScript()
+		assert.NoError(t, err)
+
+		_, err = keyring.AccountPublicKey()
+		assert.EqualError(t, err, ErrNoSeed)
+
+		seed := make([]byte, 32)
+		_, err = rand.Read(seed)
+		assert.NoError(t, err)
+		assert.NoError(t, keyring.Initialize(seed))
+		assert.EqualError(t, keyring.Initialize(seed), ErrWalletExists)
+
+		mainScript, err := keyring.MainScript()
+		assert.NoError(t, err)
+		assert.NotEqual(t, previousScript, mainScript)
+
+		reloadedKeymanager, err := share.LoadKeyManager(unlockedKeystore(t, dest), share.MainNetParams)
+		assert.NoError(t, err)
+		assert.Equal(t, keymanager.PrivateKey.D, reloadedKeymanager.PrivateKey.D)
+
+		reloaded, err := LoadKeyRing(reloadedKeymanager)
+		assert.NoError(t, err)
+		assert.True(t, reloaded.IsHD())
+		assert.True(t, reloaded.Owns(mainScript))
+		assert.True(t, reloaded.Owns(previousScript))
+	})
+
+	t.Run("should hand out addresses but sign nothing while locked", func(t *testing.T) {
+		dest := t.TempDir()
+		keyring := newSeededKeyRing(t, dest)
+
+		keystore, err := share.OpenKeystore(dest)
+		assert.NoError(t, err)
+		assert.True(t, keystore.IsLocked())
+
+		keymanager, err := share.LoadKeyManager(keystore, share.MainNetParams)
+		assert.NoError(t, err)
+
+		locked, err := LoadKeyRing(keymanager)
+		assert.NoError(t, err)
+		assert.True(t, locked.IsLocked())
+		assert.True(t, locked.IsHD())
+
+		mainScript, err := locked.MainScript()
+		assert.NoError(t, err)
+		assert.True(t, locked.Owns(mainScript))
+		assert.Nil(t, locked.KeyFor(mainScript))
+
+		address, err := locked.NewReceiveAddress()
+		assert.NoError(t, err)
+		receiveScript, err := script.PayToAddress(address)
+		assert.NoError(t, err)
+		assert.True(t, locked.Owns(receiveScript))
+		assert.Nil(t, locked.KeyFor(receiveScript))
+
+		// The locked key ring derived the address from the same seed
+		assert.Equal(t, keyring.next[ExternalChain]+1, locked.next[ExternalChain])
+
+		assert.ErrorIs(t, locked.Unlock(), share.ErrKeystoreLocked)
+		assert.NoError(t, keystore.Unlock("passphrase", 0))
+		assert.NoError(t, locked.Unlock())
+		assert.NotNil(t, locked.KeyFor(mainScript))
+		assert.NotNil(t, locked.KeyFor(receiveScript))
+
+		// Keys are unusable once the keystore locks, and forgotten by Lock
+		keystore.Lock()
+		assert.Nil(t, locked.KeyFor(mainScript))
+		locked.Lock()
+		assert.Nil(t, locked.keys[hex.EncodeToString(script.ExtractPubKeyHash(mainScript))])
+		assert.Nil(t, locked.keymanager.PrivateKey)
+	})
+
+	t.Run("should encrypt the seed of a wallet file written before the keystore", func(t *testing.T) {
+		dest := t.TempDir()
+		keyring := newSeededKeyRing(t, dest)
+		mainScript, err := keyring.MainScript()
+		assert.NoError(t, err)
+
+		data, err := json.Marshal(&hdWalletFile{
+			LegacySeed:   hex.EncodeToString(keyring.seed),
+			NextReceive:  keyring.next[ExternalChain],
+			NextInternal: keyring.next[InternalChain],
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(keyring.path, data, 0o600))
+
+		keystore, err := share.OpenKeystore(dest)
+		assert.NoError(t, err)
+		keymanager, err := share.LoadKeyManager(keystore, share.MainNetParams)
+		assert.NoError(t, err)
+
+		locked, err := LoadKeyRing(keymanager)
+		assert.NoError(t, err)
+		assert.True(t, locked.IsHD())
+		assert.True(t, locked.Owns(mainScript))
+		assert.Nil(t, locked.KeyFor(mainScript))
+		assert.Nil(t, locked.seed)
+
+		assert.NoError(t, keystore.Unlock("passphrase", 0))
+		assert.NoError(t, locked.Unlock())
+		assert.NotNil(t, locked.KeyFor(mainScript))
+
+		data, err = os.ReadFile(keyring.path)
+		assert.NoError(t, err)
+		var file hdWalletFile
+		assert.NoError(t, json.Unmarshal(data, &file))
+		assert.Empty(t, file.LegacySeed)
+		assert.NotEmpty(t, file.EncryptedSeed)
+
+		seed, err := keystore.Decrypt(locked.encryptedSeed)
+		assert.NoError(t, err)
+		assert.Equal(t, keyring.seed, seed)
+	})
+
+	t.Run("should use the main key on networks without HD keys", func(t *testing.T) {
+		dest := t.TempDir()
+
+		keymanager, err := share.LoadKeyManager(unlockedKeystore(t, dest), share.LegacyParams)
+		assert.NoError(t, err)
+
+		keyring, err := LoadKeyRing(keymanager)
+		assert.NoError(t, err)
+
+		mainScript, err := keyring.MainScript()
+		assert.NoError(t, err)
+
+		changeScript, err := keyring.NewChangeScript()
+		assert.NoError(t, err)
+		assert.Equal(t, mainScript, changeScript)
+
+		assert.ErrorIs(t, keyring.Initialize(make([]byte, 32)), share.ErrHDUnsupported)
+	})
+}
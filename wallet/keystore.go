@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"errors"
+	"time"
+)
+
+// Reports whether the wallet keys are encrypted and not loaded, so that nothing
+// can be signed. Watch-only wallets have no keys to unlock and are never locked.
+func (wm *WalletManager) IsLocked() bool {
+	return wm.keyring.IsLocked()
+}
+
+// Unlocks the wallet keys with passphrase. A positive timeout locks them again
+// once it elapses; otherwise they stay unlocked until Lock or the end of the
+// process. The unlocked keys are only kept in memory.
+func (wm *WalletManager) Unlock(passphrase string, timeout time.Duration) error {
+	if wm.IsWatchOnly() {
+		return errors.New(ErrWatchOnly)
+	}
+
+	wm.lockKeysWithKeystore()
+	if err := wm.keymanager.Keystore().Unlock(passphrase, timeout); err != nil {
+		return err
+	}
+
+	return wm.keyring.Unlock()
+}
+
+// Unlocks the wallet keys with the key agent started by StartKeyAgent, if one
+// is running, until its timeout elapses. Reports whether an agent unlocked them.
+func (wm *WalletManager) UnlockFromAgent() (bool, error) {
+	if wm.IsWatchOnly() {
+		return false, nil
+	}
+
+	wm.lockKeysWithKeystore()
+	unlocked, err := wm.keymanager.Keystore().UnlockFromAgent()
+	if err != nil || !unlocked {
+		return false, err
+	}
+
+	return true, wm.keyring.Unlock()
+}
+
+// Starts executable as the key agent keeping the unlocked wallet keys in
+// memory until the timeout of Unlock elapses, for the commands to come
+func (wm *WalletManager) StartKeyAgent(executable string) error {
+	if wm.IsWatchOnly() {
+		return errors.New(ErrWatchOnly)
+	}
+
+	return wm.keymanager.Keystore().StartKeyAgent(executable)
+}
+
+// Makes the keystore forget the private keys and seed of the wallet whenever it
+// locks, including once the timeout of Unlock elapses
+func (wm *WalletManager) lockKeysWithKeystore() {
+	wm.keymanager.Keystore().SetOnLock(wm.keyring.Lock)
+}
+
+// Locks the wallet keys and forgets them, stopping the key agent if one is
+// running
+func (wm *WalletManager) Lock() error {
+	if wm.IsWatchOnly() {
+		return errors.New(ErrWatchOnly)
+	}
+
+	if _, err := wm.keymanager.Keystore().StopKeyAgent(); err != nil {
+		return err
+	}
+
+	wm.keymanager.Keystore().Lock()
+	wm.keyring.Lock()
+
+	return nil
+}
+
+// Replaces the passphrase encrypting the wallet keys, and locks the wallet
+func (wm *WalletManager) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if wm.IsWatchOnly() {
+		return errors.New(ErrWatchOnly)
+	}
+
+	if err := wm.keymanager.Keystore().ChangePassphrase(oldPassphrase, newPassphrase); err != nil {
+		return err
+	}
+	wm.keyring.Lock()
+
+	_, err := wm.keymanager.Keystore().StopKeyAgent()
+	return err
+}
+
+// Returns the time the wallet locks again, or the zero time if it was not
+// unlocked with a timeout
+func (wm *WalletManager) UnlockedUntil() time.Time {
+	if wm.IsWatchOnly() {
+		return time.Time{}
+	}
+
+	return wm.keymanager.Keystore().UnlockedUntil()
+}
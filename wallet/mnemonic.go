@@ -0,0 +1,55 @@
+package wallet
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// Lengths of the mnemonics the wallet creates
+const (
+	ShortMnemonicWords = 12 // 128 bits of entropy
+	LongMnemonicWords  = 24 // 256 bits of entropy
+)
+
+const (
+	ErrMnemonicLength  = "mnemonic must have 12 or 24 words"
+	ErrInvalidMnemonic = "invalid mnemonic, check the words and their order"
+)
+
+// Returns a new random BIP-39 mnemonic of words English words
+func NewMnemonic(words int) (string, error) {
+	if words != ShortMnemonicWords && words != LongMnemonicWords {
+		return "", errors.New(ErrMnemonicLength)
+	}
+
+	// Each word encodes 11 bits, one of every 33 bits is checksum
+	entropy, err := bip39.NewEntropy(words * 32 / 3)
+	if err != nil {
+		return "", err
+	}
+
+	return bip39.NewMnemonic(entropy)
+}
+
+// Returns the seed of mnemonic and passphrase. Different passphrases give
+// different seeds, and so different wallets, for the same mnemonic.
+func MnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	mnemonic = NormalizeMnemonic(mnemonic)
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, errors.New(ErrInvalidMnemonic)
+	}
+
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, errors.New(ErrInvalidMnemonic)
+	}
+
+	return seed, nil
+}
+
+// Returns mnemonic in lower case with its words separated by single spaces
+func NormalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
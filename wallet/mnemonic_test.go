@@ -0,0 +1,53 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonic(t *testing.T) {
+	t.Run("should create mnemonics of 12 and 24 words", func(t *testing.T) {
+		for _, words := range []int{ShortMnemonicWords, LongMnemonicWords} {
+			mnemonic, err := NewMnemonic(words)
+			assert.NoError(t, err)
+			assert.Len(t, strings.Fields(mnemonic), words)
+
+			_, err = MnemonicSeed(mnemonic, "")
+			assert.NoError(t, err)
+		}
+
+		_, err := NewMnemonic(15)
+		assert.EqualError(t, err, ErrMnemonicLength)
+	})
+
+	t.Run("should derive the BIP-39 seed of a mnemonic and passphrase", func(t *testing.T) {
+		mnemonic := strings.Repeat("abandon ", 11) + "about"
+
+		seed, err := MnemonicSeed(mnemonic, "TREZOR")
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+			hex.EncodeToString(seed),
+		)
+
+		spaced, err := MnemonicSeed("  ABANDON "+strings.Repeat("abandon  ", 10)+"about\n", "TREZOR")
+		assert.NoError(t, err)
+		assert.Equal(t, seed, spaced)
+
+		other, err := MnemonicSeed(mnemonic, "")
+		assert.NoError(t, err)
+		assert.NotEqual(t, seed, other)
+	})
+
+	t.Run("should reject a mnemonic with a bad checksum or unknown word", func(t *testing.T) {
+		_, err := MnemonicSeed(strings.Repeat("abandon ", 12), "")
+		assert.EqualError(t, err, ErrInvalidMnemonic)
+
+		_, err = MnemonicSeed(strings.Repeat("abandon ", 11)+"magcoin", "")
+		assert.EqualError(t, err, ErrInvalidMnemonic)
+	})
+}
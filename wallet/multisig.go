@@ -0,0 +1,293 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+const (
+	ErrNotMultiSigSigner    = "wallet key is not a signer of the multisig transaction"
+	ErrNotMultiSigAddress   = "not a multisig address"
+	ErrNotPartialMultiSig   = "unlocking script is not a partially signed multisig script"
+	ErrSpentOutputNotFound  = "spent output not found"
+	ErrRedeemScriptMismatch = "redeem script does not match the script hash"
+)
+
+// Returns the public key of the wallet, which other signers need to build a
+// multisig address including this wallet
+func (wm *WalletManager) GetPublicKey() ([]byte, error) {
+	if wm.IsWatchOnly() {
+		return nil, errors.New(ErrWatchOnly)
+	}
+
+	return share.GetPublicKeyBytes(wm.keymanager.PublicKey)
+}
+
+// Returns the address of the outputs that m signatures from publicKeys unlock.
+// With scriptHash set the address is the short pay to script hash address of
+// the multisig script, which then serves as redeem script.
+func (wm *WalletManager) CreateMultiSigAddress(m int, publicKeys [][]byte, scriptHash bool) (string, error) {
+	multiSigScript, err := script.MultiSigScript(m, publicKeys)
+	if err != nil {
+		return "", err
+	}
+
+	if scriptHash {
+		return wm.CreateScriptHashAddress(multiSigScript)
+	}
+
+	return share.AddressFromMultiSigScript(multiSigScript), nil
+}
+
+// Returns the pay to script hash address of redeemScript
+func (wm *WalletManager) CreateScriptHashAddress(redeemScript []byte) (string, error) {
+	if len(redeemScript) > script.MaxStackItemSize {
+		return "", fmt.Errorf("redeem script size %d exceeds maximum of %d", len(redeemScript), script.MaxStackItemSize)
+	}
+
+	return share.AddressFromScriptHash(script.Hash160(redeemScript))
+}
+
+// Returns the multisig script spending an output locked by lockingScript needs to
+// satisfy. For a pay to script hash output it is redeemScript, which must match
+// the hash; redeemScript is ignored otherwise.
+func multiSigScriptFor(lockingScript, redeemScript []byte) ([]byte, error) {
+	if scriptHash := script.ExtractScriptHash(lockingScript); scriptHash != nil {
+		if !bytes.Equal(script.Hash160(redeemScript), scriptHash) {
+			return nil, errors.New(ErrRedeemScriptMismatch)
+		}
+		lockingScript = redeemScript
+	}
+
+	if script.Classify(lockingScript) != script.MultiSig {
+		return nil, errors.New(ErrNotMultiSigAddress)
+	}
+
+	return lockingScript, nil
+}
+
+// Returns the unlocking script holding signatures for a multisig output, followed
+// by redeemScript when the output is a pay to script hash output
+func multiSigUnlock(signatures [][]byte, lockingScript, redeemScript []byte) ([]byte, error) {
+	unlocking, err := script.MultiSigUnlock(signatures)
+	if err != nil {
+		return nil, err
+	}
+
+	if !script.IsScriptHash(lockingScript) {
+		return unlocking, nil
+	}
+
+	return script.PayToScriptHashUnlock(unlocking, redeemScript)
+}
+
+// Returns a transaction paying amount from the outputs of a multisig address to
+// receiverAddress, with the change going back to the multisig address. A pay to
+// script hash multisig address needs its redeem script, the multisig script.
+// The transaction is not signed: each signer passes it to SignMultiSigTransaction.
+//
+// Until enough signatures are collected, the unlocking script of every input
+// holds OP_0 followed by one push per public key of the multisig script, in key
+// order, which is empty for the keys that have not signed yet, and then the
+// redeem script if any.
+func (wm *WalletManager) CreateMultiSigTransaction(
+	amount uint64,
+	multiSigAddress string,
+	receiverAddress string,
+	redeemScript []byte,
+) (*transaction.Transaction, error) {
+	lockingScript, err := script.PayToAddress(multiSigAddress)
+	if err != nil {
+		return nil, errors.New(ErrInvalidAddress)
+	}
+
+	multiSigScript, err := multiSigScriptFor(lockingScript, redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentScript, err := script.PayToAddress(receiverAddress)
+	if err != nil {
+		return nil, errors.New(ErrInvalidAddress)
+	}
+
+	_, publicKeys, _ := script.ExtractMultiSig(multiSigScript)
+
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+	if err != nil {
+		return nil, err
+	}
+
+	utxos, total, err := wm.getUTXOForAmount(utxoSet, amount, lockingScript)
+	if err != nil {
+		return nil, err
+	}
+
+	trx, err := wm.newUnsignedTransaction(utxos, total, amount, paymentScript, lockingScript)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, input := range trx.Input {
+		input.UnlockingScript, err = multiSigUnlock(make([][]byte, len(publicKeys)), lockingScript, redeemScript)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return trx, nil
+}
+
+// Adds the wallet's signature to every input of a multisig transaction created by
+// CreateMultiSigTransaction. Once every input holds the required number of
+// signatures the transaction is finalized, added to the mempool and true is
+// returned. Signing does not change the transaction ID.
+func (wm *WalletManager) SignMultiSigTransaction(trx *transaction.Transaction) (bool, error) {
+	publicKey, err := wm.GetPublicKey()
+	if err != nil {
+		return false, err
+	}
+
+	hash, err := trx.SignatureHash()
+	if err != nil {
+		return false, err
+	}
+
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+	if err != nil {
+		return false, err
+	}
+
+	isSigner := false
+	complete := true
+	signatures := make([][][]byte, len(trx.Input))
+	required := make([]int, len(trx.Input))
+	lockingScripts := make([][]byte, len(trx.Input))
+	redeemScripts := make([][]byte, len(trx.Input))
+
+	for idx, input := range trx.Input {
+		outIdx, err := input.OutputIndex()
+		if err != nil {
+			return false, err
+		}
+
+		output := utxoSet.GetOutput(input.OutpointHash, outIdx)
+		if output == nil {
+			return false, fmt.Errorf("input %d: %s", idx, ErrSpentOutputNotFound)
+		}
+
+		items, err := script.PushedData(input.UnlockingScript)
+		if err != nil {
+			return false, fmt.Errorf("input %d: %w", idx, err)
+		}
+
+		var redeemScript []byte
+		if script.IsScriptHash(output.LockingScript) && len(items) != 0 {
+			redeemScript = items[len(items)-1]
+			items = items[:len(items)-1]
+		}
+
+		multiSigScript, err := multiSigScriptFor(output.LockingScript, redeemScript)
+		if err != nil {
+			return false, fmt.Errorf("input %d: %w", idx, err)
+		}
+
+		m, publicKeys, _ := script.ExtractMultiSig(multiSigScript)
+
+		slots, err := partialSignatures(items, len(publicKeys))
+		if err != nil {
+			return false, fmt.Errorf("input %d: %w", idx, err)
+		}
+
+		for keyIdx, key := range publicKeys {
+			if !bytes.Equal(key, publicKey) {
+				continue
+			}
+
+			isSigner = true
+			if len(slots[keyIdx]) != 0 {
+				continue
+			}
+
+			signature, err := wm.keymanager.Sign(hash[:])
+			if err != nil {
+				return false, err
+			}
+			slots[keyIdx] = signature.Bytes()
+		}
+
+		if countSignatures(slots) < m {
+			complete = false
+		}
+
+		signatures[idx] = slots
+		required[idx] = m
+		lockingScripts[idx] = output.LockingScript
+		redeemScripts[idx] = redeemScript
+	}
+
+	if !isSigner {
+		return false, errors.New(ErrNotMultiSigSigner)
+	}
+
+	for idx, input := range trx.Input {
+		slots := signatures[idx]
+		if complete {
+			slots = firstSignatures(slots, required[idx])
+		}
+
+		input.UnlockingScript, err = multiSigUnlock(slots, lockingScripts[idx], redeemScripts[idx])
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if !complete {
+		return false, nil
+	}
+
+	if err = wm.submit(trx, utxoSet); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Returns the signature slots among the items pushed by a partially signed
+// multisig unlocking script for a script with keyCount public keys
+func partialSignatures(items [][]byte, keyCount int) ([][]byte, error) {
+	if len(items) != keyCount+1 || len(items[0]) != 0 {
+		return nil, errors.New(ErrNotPartialMultiSig)
+	}
+
+	return items[1:], nil
+}
+
+func countSignatures(slots [][]byte) int {
+	count := 0
+	for _, slot := range slots {
+		if len(slot) != 0 {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Returns the first m signatures of slots, in key order
+func firstSignatures(slots [][]byte, m int) [][]byte {
+	signatures := make([][]byte, 0, m)
+	for _, slot := range slots {
+		if len(slot) != 0 && len(signatures) < m {
+			signatures = append(signatures, slot)
+		}
+	}
+
+	return signatures
+}
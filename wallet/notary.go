@@ -0,0 +1,93 @@
+package wallet
+
+import (
+	"bytes"
+
+	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+// Notarization tells which block recorded a document hash, with the merkle proof
+// that the recording transaction is part of the block
+type Notarization struct {
+	BlockHash     []byte
+	BlockTime     int64
+	Confirmations int
+	MerkleRoot    []byte
+	Transaction   *transaction.Transaction
+	Proof         *blockchain.MerkleProof
+}
+
+// Returns a transaction recording data in a data carrier output, paying a fee at
+// feeRate from the wallet's coins. The transaction is added to the mempool.
+func (wm *WalletManager) CreateDataTransaction(data []byte, feeRate uint64) (*transaction.Transaction, error) {
+	dataScript, err := script.NullDataScript(data)
+	if err != nil {
+		return nil, err
+	}
+
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+	if err != nil {
+		return nil, err
+	}
+
+	// The data output pays nothing, all the wallet spends beyond the fee is change
+	trx, err := wm.createPayment(utxoSet, 0, dataScript, DefaultCoinSelector(), feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wm.submit(trx, utxoSet); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+// Returns where data was first recorded on the chain. The proof is checked
+// against the block's merkle root before it is returned.
+func (wm *WalletManager) FindNotarization(data []byte) (*Notarization, error) {
+	block, trx, confirmations, err := wm.blockchain.FindDataTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := block.MerkleProof(trx.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !proof.Verify(block.MerkleRoot) {
+		return nil, blockchain.ErrMerkleRootMismatch
+	}
+
+	blockTime, err := share.BytesToInt64(block.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notarization{
+		BlockHash:     block.HeaderHash(),
+		BlockTime:     blockTime,
+		Confirmations: confirmations,
+		MerkleRoot:    block.MerkleRoot,
+		Transaction:   trx,
+		Proof:         proof,
+	}, nil
+}
+
+// Returns the mempool transaction recording data, or nil
+func (wm *WalletManager) FindPendingDataTransaction(data []byte) *transaction.Transaction {
+	entries, _ := wm.mempool.List(0, 0)
+	for _, entry := range entries {
+		for _, output := range entry.Transaction.Output {
+			if carried := script.ExtractNullData(output.LockingScript); carried != nil && bytes.Equal(carried, data) {
+				return entry.Transaction
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+// IncomingPayment is an output paying to the wallet, on the chain or in the mempool
+type IncomingPayment struct {
+	TransactionHash []byte
+	Index           int
+	Address         string
+	Amount          uint64
+	Height          int // -1 while in the mempool
+	Confirmations   int
+	IsCoinbase      bool
+}
+
+// Returns the outputs paying to the wallet, from the mempool then from the tip
+// of the chain down. Change the wallet pays itself is left out: outputs of
+// transactions spending a wallet output are not incoming payments.
+func (wm *WalletManager) ListIncomingPayments() ([]*IncomingPayment, error) {
+	blocks, err := wm.chainBlocks()
+	if err != nil {
+		return nil, err
+	}
+	tip := len(blocks) - 1
+
+	payments := make([]*IncomingPayment, 0)
+	owned := make(map[string]bool) // outpoints of wallet outputs
+
+	// Blocks are visited from genesis up, so every wallet output is known before
+	// it is spent
+	for i := tip; i >= 0; i-- {
+		for _, trx := range blocks[i].Transactions {
+			received, err := wm.receivedOutputs(trx, owned)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, payment := range received {
+				payment.Height = tip - i
+				payment.Confirmations = i + 1
+			}
+			payments = append(payments, received...)
+		}
+	}
+
+	// Newest first
+	for i, j := 0, len(payments)-1; i < j; i, j = i+1, j-1 {
+		payments[i], payments[j] = payments[j], payments[i]
+	}
+
+	entries, _ := wm.mempool.List(0, 0)
+	for _, entry := range entries {
+		for idx, output := range entry.Transaction.Output {
+			if wm.keyring.Owns(output.LockingScript) {
+				owned[transaction.OutpointKey(entry.Transaction.ID, idx)] = true
+			}
+		}
+	}
+
+	pending := make([]*IncomingPayment, 0)
+	for _, entry := range entries {
+		received, err := wm.receivedOutputs(entry.Transaction, owned)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, payment := range received {
+			payment.Height = -1
+		}
+		pending = append(pending, received...)
+	}
+
+	return append(pending, payments...), nil
+}
+
+// Returns the outputs of trx paying to the wallet, adding them to owned, unless
+// trx spends an owned output
+func (wm *WalletManager) receivedOutputs(trx *transaction.Transaction, owned map[string]bool) ([]*IncomingPayment, error) {
+	outgoing := false
+	if !trx.IsCoinbase() {
+		for _, input := range trx.Input {
+			outIdx, err := input.OutputIndex()
+			if err != nil {
+				return nil, err
+			}
+
+			if owned[transaction.OutpointKey(input.OutpointHash, outIdx)] {
+				outgoing = true
+			}
+		}
+	}
+
+	received := make([]*IncomingPayment, 0)
+	for idx, output := range trx.Output {
+		if !wm.keyring.Owns(output.LockingScript) {
+			continue
+		}
+		owned[transaction.OutpointKey(trx.ID, idx)] = true
+
+		if outgoing {
+			continue
+		}
+
+		amount, err := share.BytesToInt64(output.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		received = append(received, &IncomingPayment{
+			TransactionHash: trx.ID,
+			Index:           idx,
+			Address:         script.ExtractAddress(output.LockingScript),
+			Amount:          uint64(amount),
+			IsCoinbase:      trx.IsCoinbase(),
+		})
+	}
+
+	return received, nil
+}
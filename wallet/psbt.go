@@ -0,0 +1,212 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+)
+
+const ErrNothingToSign = "wallet holds none of the keys the transaction needs"
+
+// Returns an unsigned payment paying amount to receiverAddress, built like
+// CreateTransaction builds a payment, with the outputs it spends. The
+// transaction is not added to the mempool: it is signed elsewhere, which is how
+// watch-only wallets spend.
+func (wm *WalletManager) CreateUnsignedTransaction(
+	amount uint64,
+	receiverAddress string,
+	selector CoinSelector,
+	feeRate uint64,
+) (*transaction.PartiallySignedTransaction, error) {
+	paymentScript, err := script.PayToAddress(receiverAddress)
+	if err != nil {
+		return nil, errors.New(ErrInvalidAddress)
+	}
+
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+	if err != nil {
+		return nil, err
+	}
+
+	trx, _, err := wm.buildPayment(utxoSet, amount, paymentScript, selector, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	previousTransactions, err := wm.previousTransactions(trx)
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction.NewPartiallySignedTransaction(trx, previousTransactions, nil)
+}
+
+// Returns the transaction CreateMultiSigTransaction builds, unsigned, for the
+// signers of the multisig address to pass around
+func (wm *WalletManager) CreateUnsignedMultiSigTransaction(
+	amount uint64,
+	multiSigAddress string,
+	receiverAddress string,
+	redeemScript []byte,
+) (*transaction.PartiallySignedTransaction, error) {
+	trx, err := wm.CreateMultiSigTransaction(amount, multiSigAddress, receiverAddress, redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, input := range trx.Input {
+		input.UnlockingScript = nil
+	}
+
+	previousTransactions, err := wm.previousTransactions(trx)
+	if err != nil {
+		return nil, err
+	}
+
+	redeemScripts := make([][]byte, len(trx.Input))
+	for idx := range redeemScripts {
+		redeemScripts[idx] = redeemScript
+	}
+
+	return transaction.NewPartiallySignedTransaction(trx, previousTransactions, redeemScripts)
+}
+
+// Returns the transactions of the chain or the mempool that the inputs of trx
+// spend from, in input order
+func (wm *WalletManager) previousTransactions(trx *transaction.Transaction) ([]*transaction.Transaction, error) {
+	wanted := make(map[string]*transaction.Transaction)
+	for _, input := range trx.Input {
+		id := hex.EncodeToString(input.OutpointHash)
+		wanted[id] = wm.mempool.GetTransaction(id)
+	}
+
+	blocks, err := wm.chainBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, block := range blocks {
+		for _, blockTrx := range block.Transactions {
+			id := hex.EncodeToString(blockTrx.ID)
+			if previous, ok := wanted[id]; ok && previous == nil {
+				wanted[id] = blockTrx
+			}
+		}
+	}
+
+	previousTransactions := make([]*transaction.Transaction, 0, len(trx.Input))
+	for idx, input := range trx.Input {
+		previous := wanted[hex.EncodeToString(input.OutpointHash)]
+		if previous == nil {
+			return nil, fmt.Errorf("input %d: %s", idx, ErrSpentOutputNotFound)
+		}
+		previousTransactions = append(previousTransactions, previous)
+	}
+
+	return previousTransactions, nil
+}
+
+// Adds the signatures of the wallet's keys to psbt, and returns their number.
+// Signing only uses the data psbt carries, so it works on a machine without
+// the chain.
+func (wm *WalletManager) SignPartiallySignedTransaction(psbt *transaction.PartiallySignedTransaction) (int, error) {
+	if wm.IsWatchOnly() {
+		return 0, errors.New(ErrWatchOnly)
+	}
+
+	hash, err := psbt.Transaction.SignatureHash()
+	if err != nil {
+		return 0, err
+	}
+
+	signed, owned := 0, false
+	for idx, input := range psbt.Inputs {
+		keyScripts, err := signingKeyScripts(input)
+		if err != nil {
+			return signed, err
+		}
+
+		for _, keyScript := range keyScripts {
+			if !wm.keyring.Owns(keyScript) {
+				continue
+			}
+			owned = true
+
+			privateKey := wm.keyring.KeyFor(keyScript)
+			if privateKey == nil {
+				return signed, share.ErrKeystoreLocked
+			}
+
+			publicKey, err := share.GetPublicKeyBytes(&privateKey.PublicKey)
+			if err != nil {
+				return signed, err
+			}
+
+			if input.SignatureOf(publicKey) != nil {
+				continue
+			}
+
+			signature, err := share.Sign(hash[:], privateKey)
+			if err != nil {
+				return signed, err
+			}
+
+			if err = psbt.AddSignature(idx, publicKey, signature.Bytes()); err != nil {
+				return signed, err
+			}
+			signed++
+		}
+	}
+
+	if !owned {
+		return 0, errors.New(ErrNothingToSign)
+	}
+
+	return signed, nil
+}
+
+// Returns the pay to public key hash scripts of the keys that can sign for
+// input, to look the keys up in the key ring
+func signingKeyScripts(input *transaction.PartialInput) ([][]byte, error) {
+	signers, _ := input.Signers()
+	if signers == nil {
+		return [][]byte{input.SigningScript()}, nil
+	}
+
+	keyScripts := make([][]byte, 0, len(signers))
+	for _, publicKey := range signers {
+		keyScript, err := script.PayToPubKeyHash(script.Hash160(publicKey))
+		if err != nil {
+			return nil, err
+		}
+		keyScripts = append(keyScripts, keyScript)
+	}
+
+	return keyScripts, nil
+}
+
+// Builds the signed transaction of a complete psbt and adds it to the mempool
+func (wm *WalletManager) FinalizePartiallySignedTransaction(
+	psbt *transaction.PartiallySignedTransaction,
+) (*transaction.Transaction, error) {
+	trx, err := psbt.Finalize(wm.mempool.Policy().ScriptFlags())
+	if err != nil {
+		return nil, err
+	}
+
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wm.submit(trx, utxoSet); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
@@ -0,0 +1,96 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns an unsigned transaction spending an output locked by lockingScript
+func unsignedSpend(t *testing.T, lockingScript []byte) *transaction.PartiallySignedTransaction {
+	previous, err := transaction.NewTransaction(
+		[]*transaction.TrxInput{{
+			OutpointHash:  make([]byte, 32),
+			OutpointIndex: share.IntToBytes(0),
+			Sequence:      share.IntToBytes(int(transaction.DefaultSequence)),
+		}},
+		[]*transaction.TrxOutput{{Amount: share.Int64ToBytes(1000), LockingScript: lockingScript}},
+	)
+	assert.NoError(t, err)
+
+	trx, err := transaction.NewTransaction(
+		[]*transaction.TrxInput{{
+			OutpointHash:  previous.ID,
+			OutpointIndex: share.IntToBytes(0),
+			Sequence:      share.IntToBytes(int(transaction.DefaultSequence)),
+		}},
+		[]*transaction.TrxOutput{{Amount: share.Int64ToBytes(900), LockingScript: lockingScript}},
+	)
+	assert.NoError(t, err)
+
+	psbt, err := transaction.NewPartiallySignedTransaction(trx, []*transaction.Transaction{previous}, nil)
+	assert.NoError(t, err)
+
+	return psbt
+}
+
+func TestSignPartiallySignedTransaction(t *testing.T) {
+	t.Run("should sign the inputs of its own keys only", func(t *testing.T) {
+		dataDir := t.TempDir()
+		registry := newTestRegistry(t, dataDir)
+
+		hot, err := registry.Create("hot", "hot passphrase")
+		assert.NoError(t, err)
+
+		hotScript, err := hot.keyring.MainScript()
+		assert.NoError(t, err)
+
+		psbt := unsignedSpend(t, hotScript)
+
+		_, err = registry.Default().SignPartiallySignedTransaction(psbt)
+		assert.EqualError(t, err, ErrNothingToSign)
+
+		signed, err := hot.SignPartiallySignedTransaction(psbt)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, signed)
+		assert.True(t, psbt.IsComplete())
+
+		// Signing twice adds nothing
+		signed, err = hot.SignPartiallySignedTransaction(psbt)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, signed)
+
+		_, err = psbt.Finalize(script.VerifyStrictEncoding)
+		assert.NoError(t, err)
+
+		// The wallet loads locked when the node restarts
+		restarted := newTestRegistry(t, dataDir)
+		assert.NoError(t, restarted.LoadOnStartup())
+
+		locked, err := restarted.Get("hot")
+		assert.NoError(t, err)
+		assert.True(t, locked.IsLocked())
+
+		_, err = locked.SignPartiallySignedTransaction(unsignedSpend(t, hotScript))
+		assert.ErrorIs(t, err, share.ErrKeystoreLocked)
+	})
+
+	t.Run("should not sign with a watch-only wallet", func(t *testing.T) {
+		registry := newTestRegistry(t, t.TempDir())
+
+		address, err := registry.Default().keymanager.GetAddress()
+		assert.NoError(t, err)
+
+		watcher, err := registry.CreateWatchOnly("accounting", "", []string{address})
+		assert.NoError(t, err)
+
+		mainScript, err := registry.Default().keyring.MainScript()
+		assert.NoError(t, err)
+
+		_, err = watcher.SignPartiallySignedTransaction(unsignedSpend(t, mainScript))
+		assert.EqualError(t, err, ErrWatchOnly)
+	})
+}
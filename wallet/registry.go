@@ -0,0 +1,327 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/jenlesamuel/magcoin/share"
+)
+
+const (
+	// Name of the wallet kept in the data directory itself, which receives block
+	// rewards and is always loaded
+	DefaultWalletName = "default"
+
+	// Name of the directory, in the data directory, holding a directory per named wallet
+	WalletsDirname = "wallets"
+
+	// Name of the file, in the data directory, listing the wallets loaded on startup
+	WalletSettingsFilename = "mag_wallets.json"
+)
+
+const (
+	ErrWalletNotFound      = "wallet not found, create or restore it first"
+	ErrWalletNotLoaded     = "wallet is not loaded, load it with wallet load"
+	ErrWalletNameTaken     = "a wallet with this name already exists"
+	ErrInvalidWalletName   = "wallet names are 1 to 64 letters, digits, - or _"
+	ErrUnloadDefaultWallet = "the default wallet cannot be unloaded"
+)
+
+var walletNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// walletSettings is the content of the wallet settings file
+type walletSettings struct {
+	LoadOnStartup []string `json:"load_on_startup"`
+}
+
+// Registry holds the wallets of the node. Each wallet has its own directory,
+// keystore and keys; the wallets share the chain and the mempool.
+type Registry struct {
+	dataDir string
+	mutex   sync.RWMutex
+	wallets map[string]*WalletManager
+}
+
+// Returns a registry in which defaultWallet, stored in dataDir, is loaded
+func NewRegistry(dataDir string, defaultWallet *WalletManager) *Registry {
+	return &Registry{
+		dataDir: dataDir,
+		wallets: map[string]*WalletManager{DefaultWalletName: defaultWallet},
+	}
+}
+
+func validateWalletName(name string) error {
+	if !walletNamePattern.MatchString(name) {
+		return errors.New(ErrInvalidWalletName)
+	}
+
+	return nil
+}
+
+// Returns the directory of the wallet called name
+func (registry *Registry) Dir(name string) string {
+	if name == DefaultWalletName {
+		return registry.dataDir
+	}
+
+	return filepath.Join(registry.dataDir, WalletsDirname, name)
+}
+
+// Reports whether a wallet called name exists on disk
+func (registry *Registry) Exists(name string) bool {
+	if validateWalletName(name) != nil {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(registry.Dir(name), share.KeystoreFilename))
+	return err == nil || registry.isWatchOnly(name)
+}
+
+func (registry *Registry) isWatchOnly(name string) bool {
+	_, err := os.Stat(filepath.Join(registry.Dir(name), WatchOnlyFilename))
+	return err == nil
+}
+
+// Returns the loaded wallet called name
+func (registry *Registry) Get(name string) (*WalletManager, error) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	wm, loaded := registry.wallets[name]
+	if !loaded {
+		if !registry.Exists(name) {
+			return nil, errors.New(ErrWalletNotFound)
+		}
+		return nil, errors.New(ErrWalletNotLoaded)
+	}
+
+	return wm, nil
+}
+
+// Returns the default wallet
+func (registry *Registry) Default() *WalletManager {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	return registry.wallets[DefaultWalletName]
+}
+
+// Creates a wallet called name whose keys passphrase encrypts, and loads it. The
+// wallet is left unlocked for the running command.
+func (registry *Registry) Create(name, passphrase string) (*WalletManager, error) {
+	if err := validateWalletName(name); err != nil {
+		return nil, err
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if registry.Exists(name) {
+		return nil, errors.New(ErrWalletNameTaken)
+	}
+
+	dir := registry.Dir(name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	keystore, err := share.OpenKeystore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = keystore.Create(passphrase); err != nil {
+		return nil, err
+	}
+
+	return registry.load(name, keystore)
+}
+
+// Creates a watch-only wallet called name from an account public key, addresses
+// or both, and loads it. The wallet tracks their payments without any private
+// key.
+func (registry *Registry) CreateWatchOnly(name, accountPublicKey string, addresses []string) (*WalletManager, error) {
+	if err := validateWalletName(name); err != nil {
+		return nil, err
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if name == DefaultWalletName || registry.Exists(name) {
+		return nil, errors.New(ErrWalletNameTaken)
+	}
+
+	dir := registry.Dir(name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	keyring, err := NewWatchOnlyKeyRing(dir, registry.params(), accountPublicKey, addresses)
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+
+	return registry.add(name, keyring)
+}
+
+// Loads the wallet called name, and keeps loading it on startup
+func (registry *Registry) Load(name string) (*WalletManager, error) {
+	if err := validateWalletName(name); err != nil {
+		return nil, err
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if wm, loaded := registry.wallets[name]; loaded {
+		return wm, nil
+	}
+
+	if !registry.Exists(name) {
+		return nil, errors.New(ErrWalletNotFound)
+	}
+
+	if registry.isWatchOnly(name) {
+		keyring, err := LoadWatchOnlyKeyRing(registry.Dir(name), registry.params())
+		if err != nil {
+			return nil, err
+		}
+
+		return registry.add(name, keyring)
+	}
+
+	keystore, err := share.OpenKeystore(registry.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.load(name, keystore)
+}
+
+func (registry *Registry) load(name string, keystore *share.Keystore) (*WalletManager, error) {
+	keymanager, err := share.LoadKeyManager(keystore, registry.params())
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := LoadKeyRing(keymanager)
+	if err != nil {
+		return nil, err
+	}
+
+	return registry.add(name, keyring)
+}
+
+// Adds the wallet of keyring, which shares the chain and the mempool of the
+// default wallet
+func (registry *Registry) add(name string, keyring *KeyRing) (*WalletManager, error) {
+	defaultWallet := registry.wallets[DefaultWalletName]
+
+	wm := NewWalletManager(defaultWallet.blockchain, keyring, defaultWallet.mempool)
+	registry.wallets[name] = wm
+
+	return wm, registry.saveSettings()
+}
+
+// Returns the parameters of the network the wallets are on
+func (registry *Registry) params() *share.NetworkParams {
+	return registry.wallets[DefaultWalletName].keyring.params
+}
+
+// Unloads the wallet called name, which is no longer loaded on startup. Its
+// files are kept.
+func (registry *Registry) Unload(name string) error {
+	if name == DefaultWalletName {
+		return errors.New(ErrUnloadDefaultWallet)
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if _, loaded := registry.wallets[name]; !loaded {
+		return errors.New(ErrWalletNotLoaded)
+	}
+	delete(registry.wallets, name)
+
+	return registry.saveSettings()
+}
+
+// Loads the wallets that were loaded when the node last ran
+func (registry *Registry) LoadOnStartup() error {
+	data, err := os.ReadFile(filepath.Join(registry.dataDir, WalletSettingsFilename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var settings walletSettings
+	if err = json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+
+	for _, name := range settings.LoadOnStartup {
+		if _, err = registry.Load(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (registry *Registry) saveSettings() error {
+	data, err := json.MarshalIndent(&walletSettings{LoadOnStartup: registry.loaded()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(registry.dataDir, WalletSettingsFilename), data, 0o600)
+}
+
+func (registry *Registry) loaded() []string {
+	names := make([]string, 0, len(registry.wallets))
+	for name := range registry.wallets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Returns the names of the loaded wallets
+func (registry *Registry) Loaded() []string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	return registry.loaded()
+}
+
+// Returns the names of the wallets on disk, loaded or not
+func (registry *Registry) List() ([]string, error) {
+	names := []string{DefaultWalletName}
+
+	entries, err := os.ReadDir(filepath.Join(registry.dataDir, WalletsDirname))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return names, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() && registry.Exists(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names[1:])
+
+	return names, nil
+}
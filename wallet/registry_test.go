@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a registry of dataDir whose default wallet has no chain
+func newTestRegistry(t *testing.T, dataDir string) *Registry {
+	keymanager, err := share.LoadKeyManager(unlockedKeystore(t, dataDir), share.RegtestParams)
+	assert.NoError(t, err)
+
+	keyring, err := LoadKeyRing(keymanager)
+	assert.NoError(t, err)
+
+	return NewRegistry(dataDir, NewWalletManager(nil, keyring, nil))
+}
+
+func TestRegistry(t *testing.T) {
+	t.Run("should keep the keys of each wallet apart", func(t *testing.T) {
+		registry := newTestRegistry(t, t.TempDir())
+
+		hot, err := registry.Create("hot", "hot passphrase")
+		assert.NoError(t, err)
+
+		cold, err := registry.Create("cold", "cold passphrase")
+		assert.NoError(t, err)
+
+		_, err = registry.Create("cold", "cold passphrase")
+		assert.EqualError(t, err, ErrWalletNameTaken)
+
+		hotScript, err := hot.keyring.MainScript()
+		assert.NoError(t, err)
+		coldScript, err := cold.keyring.MainScript()
+		assert.NoError(t, err)
+
+		assert.True(t, hot.keyring.Owns(hotScript))
+		assert.False(t, hot.keyring.Owns(coldScript))
+		assert.False(t, registry.Default().keyring.Owns(hotScript))
+
+		names, err := registry.List()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{DefaultWalletName, "cold", "hot"}, names)
+	})
+
+	t.Run("should load on startup the wallets loaded when the node last ran", func(t *testing.T) {
+		dataDir := t.TempDir()
+		registry := newTestRegistry(t, dataDir)
+
+		_, err := registry.Create("hot", "hot passphrase")
+		assert.NoError(t, err)
+		_, err = registry.Create("fee", "fee passphrase")
+		assert.NoError(t, err)
+		assert.NoError(t, registry.Unload("fee"))
+		assert.EqualError(t, registry.Unload(DefaultWalletName), ErrUnloadDefaultWallet)
+
+		_, err = registry.Get("fee")
+		assert.EqualError(t, err, ErrWalletNotLoaded)
+		_, err = registry.Get("cold")
+		assert.EqualError(t, err, ErrWalletNotFound)
+
+		restarted := newTestRegistry(t, dataDir)
+		assert.NoError(t, restarted.LoadOnStartup())
+		assert.Equal(t, []string{DefaultWalletName, "hot"}, restarted.Loaded())
+
+		hot, err := restarted.Get("hot")
+		assert.NoError(t, err)
+		assert.True(t, hot.IsLocked())
+
+		fee, err := restarted.Load("fee")
+		assert.NoError(t, err)
+		assert.EqualError(t, fee.Unlock("hot passphrase", 0), share.ErrWrongPassphrase.Error())
+		assert.NoError(t, fee.Unlock("fee passphrase", 0))
+		assert.False(t, fee.IsLocked())
+	})
+
+	t.Run("should refuse wallet names that are not plain file names", func(t *testing.T) {
+		registry := newTestRegistry(t, t.TempDir())
+
+		for _, name := range []string{"", "../hot", "hot wallet", "hot/cold"} {
+			_, err := registry.Create(name, "passphrase")
+			assert.EqualError(t, err, ErrInvalidWalletName)
+		}
+	})
+
+	t.Run("should forget the private keys and seed of a wallet once its unlock timeout elapses", func(t *testing.T) {
+		registry := newTestRegistry(t, t.TempDir())
+
+		hot, err := registry.Create("hot", "hot passphrase")
+		assert.NoError(t, err)
+		assert.NoError(t, hot.keyring.Initialize(make([]byte, 32)))
+		assert.NoError(t, hot.Lock())
+		assert.True(t, hot.IsLocked())
+
+		assert.NoError(t, hot.Unlock("hot passphrase", time.Second))
+		mainScript, err := hot.keyring.MainScript()
+		assert.NoError(t, err)
+		assert.NotNil(t, hot.keyring.KeyFor(mainScript))
+		hot.keyring.mutex.RLock()
+		assert.NotNil(t, hot.keyring.seed)
+		hot.keyring.mutex.RUnlock()
+
+		assert.Eventually(t, hot.IsLocked, 5*time.Second, 10*time.Millisecond)
+		assert.Nil(t, hot.keyring.seed)
+		assert.Nil(t, hot.keymanager.PrivateKey)
+		assert.NotEmpty(t, hot.keyring.keys)
+		for _, key := range hot.keyring.keys {
+			assert.Nil(t, key)
+		}
+	})
+}
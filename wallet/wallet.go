@@ -2,10 +2,13 @@ package wallet
 
 import (
 	"bytes"
-	"encoding/hex"
+	"crypto/ecdsa"
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/jenlesamuel/magcoin/blockchain"
+	"github.com/jenlesamuel/magcoin/script"
 	"github.com/jenlesamuel/magcoin/share"
 	"github.com/jenlesamuel/magcoin/transaction"
 )
@@ -13,6 +16,9 @@ import (
 const (
 	ErrInsufficientBalance = "insufficient balance"
 	ErrInvalidAddress      = "invalid address"
+	ErrForeignInput        = "transaction spends an output the wallet does not own"
+	ErrForeignChange       = "change output does not pay to a wallet key"
+	ErrPaymentMismatch     = "payment output does not pay the amount to the receiver"
 )
 
 type Wallet struct {
@@ -21,95 +27,60 @@ type Wallet struct {
 }
 
 type WalletManager struct {
-	iterator   *blockchain.BlockIterator
+	blockchain *blockchain.Blockchain
 	keymanager *share.KeyManager
+	keyring    *KeyRing
 	mempool    *transaction.MemPool
+
+	// Guards the history file and the scan of the chain kept for it
+	historyMutex sync.Mutex
+	chainHistory *chainHistory
 }
 
 func NewWalletManager(
-	iterator *blockchain.BlockIterator,
-	keymanager *share.KeyManager,
+	bc *blockchain.Blockchain,
+	keyring *KeyRing,
 	mempool *transaction.MemPool,
 ) *WalletManager {
 	return &WalletManager{
-		iterator:   iterator,
-		keymanager: keymanager,
+		blockchain: bc,
+		keymanager: keyring.keymanager,
+		keyring:    keyring,
 		mempool:    mempool,
 	}
 }
 
-// Retrieves all the UTXOs for an address
-func (wm *WalletManager) getUTXO(address string) ([]*transaction.UTXO, error) {
-	pkHash := share.PublicKeyHashFromAddress(address)
-	spendableTransactionOutputs := make(map[string]map[int]int64)
-	empty := make([]*transaction.UTXO, 0)
-
-	for {
-		block, err := wm.iterator.Next()
-		if err != nil {
-			return empty, err
-		}
-
-		for _, trx := range block.Transactions {
-			trxIDHex := hex.EncodeToString(trx.ID)
-			for idx, output := range trx.Output {
-				if !bytes.Equal(output.PublicKeyHash, pkHash) { // output not meant for address
-					continue
-				}
-				amount, err := share.BytesToInt64(output.Amount)
-				if err != nil {
-					return empty, err
-				}
-
-				if _, exists := spendableTransactionOutputs[trxIDHex]; !exists {
-					spendableTransactionOutputs[trxIDHex] = make(map[int]int64)
-				}
-				spendableTransactionOutputs[trxIDHex][idx] = amount
-			}
-
-			if trx.IsCoinbase() {
-				// Coinbase input does not reference any transaction output.
-				// Ignore it.
-				continue
-			}
-
-			for _, input := range trx.Input {
-				outHashHex := hex.EncodeToString(input.OutpointHash)
-				outIdx, err := share.BytesToInt(input.OutpointIndex)
-
-				if err != nil {
-					return empty, err
-				}
-
-				delete(spendableTransactionOutputs[outHashHex], outIdx)
-			}
-		}
-
-		if block.IsGenesis() {
-			break
+// Retrieves the UTXOs of utxoSet locked by any of lockingScripts
+func (wm *WalletManager) getUTXOFromSet(utxoSet *blockchain.UTXOSet, lockingScripts ...[]byte) []*transaction.UTXO {
+	utxos := make([]*transaction.UTXO, 0)
+	for _, entry := range utxoSet.Entries() {
+		if !containsScript(lockingScripts, entry.Output.LockingScript) { // output not meant for address
+			continue
 		}
 
+		utxos = append(utxos, newUTXO(entry))
 	}
 
-	utxos := make([]*transaction.UTXO, 0)
-	for trxIDHex, out := range spendableTransactionOutputs {
-		trxID, err := hex.DecodeString(trxIDHex)
-		if err != nil {
-			return empty, err
-		}
+	return utxos
+}
 
-		for outIdx, amount := range out {
-			utxo := &transaction.UTXO{
-				TransactionHash: trxID,
-				OutpointIndex:   share.IntToBytes(outIdx),
-				Amount:          share.Int64ToBytes(amount),
-			}
+func newUTXO(entry *blockchain.UTXOEntry) *transaction.UTXO {
+	return &transaction.UTXO{
+		TransactionHash: entry.TransactionHash,
+		OutpointIndex:   share.IntToBytes(entry.Index),
+		Amount:          entry.Output.Amount,
+		LockingScript:   entry.Output.LockingScript,
+	}
+}
 
-			utxos = append(utxos, utxo)
+func containsScript(lockingScripts [][]byte, lockingScript []byte) bool {
+	for _, candidate := range lockingScripts {
+		if bytes.Equal(candidate, lockingScript) {
+			return true
 		}
 	}
 
-	return utxos, nil
+	return false
 }
 
 // Get the balance from UTXOs
@@ -128,129 +99,286 @@ func (wm *WalletManager) getUTXOAmount(utxos []*transaction.UTXO) (int64, error)
 	return balance, nil
 }
 
-func (wm *WalletManager) GetAddressBalance(senderAddress string) (int64, error) {
-	utxos, err := wm.getUTXO(senderAddress)
+// Returns UTXOs locked by any of lockingScripts holding at least amount, largest
+// first, and their total amount
+func (wm *WalletManager) getUTXOForAmount(
+	utxoSet *blockchain.UTXOSet,
+	amount uint64,
+	lockingScripts ...[]byte,
+) ([]*transaction.UTXO, uint64, error) {
+	utxos := wm.getSpendableUTXOFromSet(utxoSet, lockingScripts...)
+
+	selection, err := (&LargestFirst{}).Select(utxos, &SelectionTarget{Amount: amount})
 	if err != nil {
-		return 0, err
+		return make([]*transaction.UTXO, 0), 0, err
 	}
 
-	return wm.getUTXOAmount(utxos)
+	return selection.UTXOs, selection.Total, nil
 }
 
-// Returns UTXOs, their total amount sum and nil if sender has enough balance to pay amount
-// and no error occured. Returns zero values and error instead.
-// TODO: optimize method to prevent a scenario where only little denomination (change) utxos exist
-func (wm *WalletManager) getUTXOForAmount(amount uint64, senderAddress string) ([]*transaction.UTXO, uint64, error) {
-	empty := make([]*transaction.UTXO, 0)
+// Returns a transaction paying amount to receiverAddress from the wallet's coins
+// picked by selector, paying a fee at feeRate maglia per byte, with the change
+// going to a new wallet key. The transaction is added to the mempool.
+func (wm *WalletManager) CreateTransaction(
+	amount uint64,
+	receiverAddress string,
+	selector CoinSelector,
+	feeRate uint64,
+) (*transaction.Transaction, error) {
+	paymentScript, err := script.PayToAddress(receiverAddress)
+	if err != nil {
+		return nil, errors.New(ErrInvalidAddress)
+	}
 
-	utxos, err := wm.getUTXO(senderAddress)
+	utxoSet, err := blockchain.BuildUTXOSet(wm.blockchain.Iterator())
 	if err != nil {
-		return empty, uint64(0), err
+		return nil, err
 	}
 
-	sum := uint64(0)
-	res := make([]*transaction.UTXO, 0)
+	trx, err := wm.createPayment(utxoSet, amount, paymentScript, selector, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wm.submit(trx, utxoSet); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+// Returns a signed transaction paying amount to paymentScript from the wallet's
+// coins in utxoSet picked by selector, with a fee at feeRate
+func (wm *WalletManager) createPayment(
+	utxoSet *blockchain.UTXOSet,
+	amount uint64,
+	paymentScript []byte,
+	selector CoinSelector,
+	feeRate uint64,
+) (*transaction.Transaction, error) {
+	trx, utxos, err := wm.buildPayment(utxoSet, amount, paymentScript, selector, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*ecdsa.PrivateKey, 0, len(utxos))
 	for _, utxo := range utxos {
-		if sum >= amount {
-			break
+		keys = append(keys, wm.keyring.KeyFor(utxo.LockingScript))
+	}
+
+	if err = wm.signInputs(trx, keys, script.PayToPubKeyHashUnlock); err != nil {
+		return nil, err
+	}
+
+	return trx, nil
+}
+
+// Returns the unsigned transaction createPayment signs and the outputs it
+// spends. Change goes to a new wallet key, so that payments cannot be linked
+// through a reused address.
+func (wm *WalletManager) buildPayment(
+	utxoSet *blockchain.UTXOSet,
+	amount uint64,
+	paymentScript []byte,
+	selector CoinSelector,
+	feeRate uint64,
+) (*transaction.Transaction, []*transaction.UTXO, error) {
+	walletScripts, err := wm.keyring.keyScripts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	selection, err := selector.Select(wm.getSpendableUTXOFromSet(utxoSet, walletScripts...), &SelectionTarget{
+		Amount:        amount,
+		FeeRate:       feeRate,
+		BaseSize:      TransactionOverheadSize + 9 + len(paymentScript), // amount and script length
+		DustThreshold: wm.mempool.Policy().DustThreshold,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	utxos := selection.UTXOs
+
+	// A change key is only generated when the selection returns change
+	var changeScript []byte
+	if selection.Change != 0 {
+		if changeScript, err = wm.keyring.NewChangeScript(); err != nil {
+			return nil, nil, err
 		}
+	}
 
-		amount, err := share.BytesToInt64(utxo.Amount)
+	// The fee is kept out of the total, so that it is not returned as change
+	trx, err := wm.newUnsignedTransaction(utxos, amount+selection.Change, amount, paymentScript, changeScript)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = wm.checkPayment(trx, utxos, amount, paymentScript); err != nil {
+		return nil, nil, err
+	}
+
+	return trx, utxos, nil
+}
+
+// Checks a payment built by the wallet against its key set: every input must
+// spend a wallet output, the first output must pay amount to paymentScript and
+// every other output must return change to a wallet key
+func (wm *WalletManager) checkPayment(
+	trx *transaction.Transaction,
+	utxos []*transaction.UTXO,
+	amount uint64,
+	paymentScript []byte,
+) error {
+	if len(trx.Input) != len(utxos) {
+		return errors.New(ErrForeignInput)
+	}
+
+	var spent int64
+	for idx, utxo := range utxos {
+		input := trx.Input[idx]
+		if !bytes.Equal(input.OutpointHash, utxo.TransactionHash) ||
+			!bytes.Equal(input.OutpointIndex, utxo.OutpointIndex) ||
+			!wm.keyring.Owns(utxo.LockingScript) {
+			return errors.New(ErrForeignInput)
+		}
+
+		value, err := share.BytesToInt64(utxo.Amount)
 		if err != nil {
-			return empty, uint64(0), err
+			return err
+		}
+		spent += value
+	}
+
+	var paid int64
+	for idx, output := range trx.Output {
+		value, err := share.BytesToInt64(output.Amount)
+		if err != nil {
+			return err
+		}
+		paid += value
+
+		if idx == 0 {
+			if uint64(value) != amount || !bytes.Equal(output.LockingScript, paymentScript) {
+				return errors.New(ErrPaymentMismatch)
+			}
+			continue
 		}
 
-		res = append(res, utxo)
-		sum += uint64(amount)
+		if !wm.keyring.Owns(output.LockingScript) {
+			return errors.New(ErrForeignChange)
+		}
 	}
 
-	if sum < amount {
-		return empty, uint64(0), errors.New(ErrInsufficientBalance)
+	if paid > spent {
+		return errors.New(ErrInsufficientBalance)
 	}
 
-	return res, sum, nil
+	return nil
 }
 
-func (wm *WalletManager) CreateTransaction(amount uint64, receiverAddress string) (*transaction.Transaction, error) {
-	if !share.ValidateAddress(receiverAddress) {
-		return nil, errors.New(ErrInvalidAddress)
+// Sets the unlocking script of every input of trx to the script unlock builds
+// from the signature of the key at the same index of keys and its public key
+func (wm *WalletManager) signInputs(
+	trx *transaction.Transaction,
+	keys []*ecdsa.PrivateKey,
+	unlock func(signature, publicKey []byte) ([]byte, error),
+) error {
+	if len(keys) != len(trx.Input) {
+		return fmt.Errorf("%d keys for %d inputs", len(keys), len(trx.Input))
 	}
 
-	utxos, total, err := wm.getUTXOForAmount(amount, receiverAddress)
+	// Every input signs the transaction hash without unlocking scripts, so
+	// signing does not change the transaction ID
+	hash, err := trx.SignatureHash()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	for idx, input := range trx.Input {
+		if keys[idx] == nil {
+			if wm.keyring.IsWatchOnly() {
+				return errors.New(ErrWatchOnly)
+			}
+			if wm.keyring.IsLocked() {
+				return share.ErrKeystoreLocked
+			}
+			return errors.New(ErrForeignInput)
+		}
+
+		pubKey, err := share.GetPublicKeyBytes(&keys[idx].PublicKey)
+		if err != nil {
+			return err
+		}
+
+		signature, err := share.Sign(hash[:], keys[idx])
+		if err != nil {
+			return err
+		}
+
+		if input.UnlockingScript, err = unlock(signature.Bytes(), pubKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns n copies of the wallet's main key, to sign n inputs spending outputs
+// locked to it
+func (wm *WalletManager) mainKeys(n int) []*ecdsa.PrivateKey {
+	keys := make([]*ecdsa.PrivateKey, n)
+	if wm.IsLocked() {
+		return keys
+	}
+
+	for idx := range keys {
+		keys[idx] = wm.keymanager.GetPrivateKey()
+	}
+
+	return keys
+}
+
+// Returns a transaction spending utxos, which hold total, that pays amount to
+// paymentScript and the rest back to changeScript. Its inputs are not signed.
+func (wm *WalletManager) newUnsignedTransaction(
+	utxos []*transaction.UTXO,
+	total uint64,
+	amount uint64,
+	paymentScript []byte,
+	changeScript []byte,
+) (*transaction.Transaction, error) {
 	inputs := make([]*transaction.TrxInput, 0)
 	outputs := make([]*transaction.TrxOutput, 0)
 
-	pAmountBytes := share.Int64ToBytes(int64(amount))
-	pkHash := share.PublicKeyHashFromAddress(receiverAddress)
-
 	// Payment output is the output that represents the amount to be sent to the receiver
 	paymentOutput := &transaction.TrxOutput{
-		Amount:        pAmountBytes,
-		PublicKeyHash: pkHash,
+		Amount:        share.Int64ToBytes(int64(amount)),
+		LockingScript: paymentScript,
 	}
 	outputs = append(outputs, paymentOutput)
 
-	outBuf := new(bytes.Buffer)
-	outBuf.Write(pAmountBytes)
-	outBuf.Write(pkHash)
-
-	if total > amount {
+	// Change too small to be relayed is left to the miner as fee. Without change
+	// there is no change script, and no change output even with no dust threshold.
+	if change := total - amount; change > 0 && changeScript != nil && change >= wm.mempool.Policy().DustThreshold {
 		// Change Output is the output that represents the change paid back to the sender.
 		// Imagine you need to pay a fee of $25 but have a $100 bill, you'll pay the $100
 		// but get a change of $75
-		cAmountBytes := share.Int64ToBytes(int64(total - amount))
-
 		changeOutput := &transaction.TrxOutput{
-			Amount:        cAmountBytes,
-			PublicKeyHash: pkHash,
+			Amount:        share.Int64ToBytes(int64(change)),
+			LockingScript: changeScript,
 		}
 		outputs = append(outputs, changeOutput)
-
-		outBuf.Write(cAmountBytes)
-		outBuf.Write(pkHash)
-	}
-
-	pubKey, err := share.GetPublicKeyBytes(wm.keymanager.PublicKey)
-	if err != nil {
-		return nil, err
 	}
 
 	for _, utxo := range utxos {
 		input := &transaction.TrxInput{
 			OutpointHash:  utxo.TransactionHash,
 			OutpointIndex: utxo.OutpointIndex,
-			PublicKey:     pubKey,
-		}
-
-		inBuf := new(bytes.Buffer)
-		inBuf.Write(utxo.TransactionHash)
-		inBuf.Write(utxo.OutpointIndex)
-		inBuf.Write(pubKey)
-
-		// Create signature
-		combo := bytes.Join([][]byte{inBuf.Bytes(), outBuf.Bytes()}, []byte{})
-		hash := share.DoubleSha256(combo)
-		signature, err := wm.keymanager.Sign(hash[:])
-		if err != nil {
-			return nil, err
+			Sequence:      share.IntToBytes(int(transaction.DefaultSequence)),
 		}
-		input.SigOrData = signature.Bytes()
 
 		inputs = append(inputs, input)
 	}
 
-	trx, err := transaction.NewTransaction(inputs, outputs)
-	if err != nil {
-		return nil, err
-	}
-
-	trxHashHex := hex.EncodeToString(trx.ID[:])
-
-	wm.mempool.AddTransaction(trxHashHex, trx)
-
-	return trx, nil
+	return transaction.NewTransaction(inputs, outputs)
 }
@@ -0,0 +1,120 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+)
+
+const ErrWatchOnly = "watch-only wallet holds no private keys, create an unsigned transaction and sign it elsewhere"
+
+func newWatchOnlyKeyRing(dir string, params *share.NetworkParams) *KeyRing {
+	return &KeyRing{
+		params: params,
+		path:   filepath.Join(dir, WatchOnlyFilename),
+		keys:   make(map[string]*ecdsa.PrivateKey),
+		order:  make([]string, 0),
+	}
+}
+
+// Creates the watch-only wallet of dir from an account public key, as printed
+// by xpub, from addresses, or from both
+func NewWatchOnlyKeyRing(
+	dir string,
+	params *share.NetworkParams,
+	accountPublicKey string,
+	addresses []string,
+) (*KeyRing, error) {
+	if accountPublicKey == "" && len(addresses) == 0 {
+		return nil, errors.New(ErrNothingWatch)
+	}
+
+	keyring := newWatchOnlyKeyRing(dir, params)
+	if err := keyring.watch(accountPublicKey, addresses); err != nil {
+		return nil, err
+	}
+
+	return keyring, keyring.save()
+}
+
+// Loads the watch-only wallet of dir
+func LoadWatchOnlyKeyRing(dir string, params *share.NetworkParams) (*KeyRing, error) {
+	keyring := newWatchOnlyKeyRing(dir, params)
+
+	data, err := os.ReadFile(keyring.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file hdWalletFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse wallet file %s: %s", keyring.path, err)
+	}
+
+	if err = keyring.watch(file.AccountPublicKey, file.Addresses); err != nil {
+		return nil, err
+	}
+
+	if keyring.IsHD() {
+		if err = keyring.deriveFileKeys(&file); err != nil {
+			return nil, err
+		}
+	}
+
+	return keyring, nil
+}
+
+// Adds the account of accountPublicKey, if not empty, and addresses to the
+// watched keys and scripts
+func (keyring *KeyRing) watch(accountPublicKey string, addresses []string) error {
+	if accountPublicKey != "" {
+		if !keyring.params.SupportsHD() {
+			return share.ErrHDUnsupported
+		}
+
+		account, err := share.ParseExtendedKey(accountPublicKey)
+		if err != nil {
+			return err
+		}
+
+		if account.IsPrivate() {
+			return errors.New(ErrPrivateXPub)
+		}
+
+		if account.Params().Name != keyring.params.Name {
+			return errors.New(ErrXPubNetwork)
+		}
+		keyring.account = account
+	}
+
+	for _, address := range addresses {
+		lockingScript, err := script.PayToAddress(address)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ErrInvalidAddress, address)
+		}
+
+		if keyring.Owns(lockingScript) {
+			continue
+		}
+
+		if pkHash := script.ExtractPubKeyHash(lockingScript); pkHash != nil {
+			keyring.addHash(pkHash, nil)
+		} else {
+			keyring.watched = append(keyring.watched, lockingScript)
+		}
+		keyring.addresses = append(keyring.addresses, address)
+	}
+
+	return nil
+}
+
+// Reports whether the wallet only watches addresses and holds no private keys
+func (wm *WalletManager) IsWatchOnly() bool {
+	return wm.keyring.IsWatchOnly()
+}
@@ -0,0 +1,147 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/jenlesamuel/magcoin/script"
+	"github.com/jenlesamuel/magcoin/share"
+	"github.com/jenlesamuel/magcoin/transaction"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchOnly(t *testing.T) {
+	t.Run("should derive the addresses of an extended public key without its private keys", func(t *testing.T) {
+		signer := newSeededKeyRing(t, t.TempDir())
+
+		xpub, err := signer.AccountPublicKey()
+		assert.NoError(t, err)
+
+		dir := t.TempDir()
+		watcher, err := NewWatchOnlyKeyRing(dir, share.MainNetParams, xpub, nil)
+		assert.NoError(t, err)
+		assert.True(t, watcher.IsWatchOnly())
+		assert.False(t, watcher.IsLocked())
+
+		// The signer handed out its first receive key as the main key
+		_, err = watcher.NewReceiveAddress()
+		assert.NoError(t, err)
+		address, err := watcher.NewReceiveAddress()
+		assert.NoError(t, err)
+
+		expected, err := signer.NewReceiveAddress()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, address)
+
+		lockingScript, err := script.PayToAddress(address)
+		assert.NoError(t, err)
+
+		reloaded, err := LoadWatchOnlyKeyRing(dir, share.MainNetParams)
+		assert.NoError(t, err)
+		assert.True(t, reloaded.Owns(lockingScript))
+		assert.Nil(t, reloaded.KeyFor(lockingScript))
+		assert.EqualError(t, reloaded.Initialize(bytes.Repeat([]byte{0x01}, 32)), ErrWatchOnly)
+
+		changeScript, err := reloaded.NewChangeScript()
+		assert.NoError(t, err)
+		expectedChange, err := signer.NewChangeScript()
+		assert.NoError(t, err)
+		assert.Equal(t, expectedChange, changeScript)
+	})
+
+	t.Run("should watch addresses of keys and of scripts", func(t *testing.T) {
+		keymanager, err := share.LoadKeyManager(unlockedKeystore(t, t.TempDir()), share.MainNetParams)
+		assert.NoError(t, err)
+
+		keyAddress, err := keymanager.GetAddress()
+		assert.NoError(t, err)
+
+		scriptAddress, err := share.AddressFromScriptHash(bytes.Repeat([]byte{0x07}, 20))
+		assert.NoError(t, err)
+
+		dir := t.TempDir()
+		_, err = NewWatchOnlyKeyRing(dir, share.MainNetParams, "", []string{keyAddress, scriptAddress, keyAddress})
+		assert.NoError(t, err)
+
+		watcher, err := LoadWatchOnlyKeyRing(dir, share.MainNetParams)
+		assert.NoError(t, err)
+		assert.False(t, watcher.IsHD())
+
+		scripts, err := watcher.LockingScripts()
+		assert.NoError(t, err)
+		assert.Len(t, scripts, 2)
+		for _, lockingScript := range scripts {
+			assert.True(t, watcher.Owns(lockingScript))
+		}
+
+		// Change returns to the first watched address without an account key
+		changeScript, err := watcher.NewChangeScript()
+		assert.NoError(t, err)
+		assert.Equal(t, scripts[0], changeScript)
+
+		_, err = watcher.NewReceiveAddress()
+		assert.EqualError(t, err, ErrNoAccountKey)
+	})
+
+	t.Run("should reject keys it cannot watch", func(t *testing.T) {
+		_, err := NewWatchOnlyKeyRing(t.TempDir(), share.MainNetParams, "", nil)
+		assert.EqualError(t, err, ErrNothingWatch)
+
+		master, err := share.NewMasterKey(bytes.Repeat([]byte{0x01}, 32), share.MainNetParams)
+		assert.NoError(t, err)
+
+		_, err = NewWatchOnlyKeyRing(t.TempDir(), share.MainNetParams, master.String(), nil)
+		assert.EqualError(t, err, ErrPrivateXPub)
+
+		_, err = NewWatchOnlyKeyRing(t.TempDir(), share.RegtestParams, master.Neuter().String(), nil)
+		assert.EqualError(t, err, ErrXPubNetwork)
+
+		_, err = NewWatchOnlyKeyRing(t.TempDir(), share.MainNetParams, "", []string{"not an address"})
+		assert.Error(t, err)
+	})
+
+	t.Run("should load watch-only wallets that cannot sign", func(t *testing.T) {
+		dataDir := t.TempDir()
+		registry := newTestRegistry(t, dataDir)
+
+		address, err := registry.Default().keymanager.GetAddress()
+		assert.NoError(t, err)
+
+		watcher, err := registry.CreateWatchOnly("accounting", "", []string{address})
+		assert.NoError(t, err)
+		assert.True(t, watcher.IsWatchOnly())
+		assert.False(t, watcher.IsLocked())
+		assert.EqualError(t, watcher.Unlock("passphrase", 0), ErrWatchOnly)
+
+		_, err = watcher.GetPublicKey()
+		assert.EqualError(t, err, ErrWatchOnly)
+
+		mainScript, err := registry.Default().keyring.MainScript()
+		assert.NoError(t, err)
+
+		trx, err := transaction.NewTransaction(
+			[]*transaction.TrxInput{{
+				OutpointHash:  make([]byte, 32),
+				OutpointIndex: share.IntToBytes(0),
+				Sequence:      share.IntToBytes(int(transaction.DefaultSequence)),
+			}},
+			[]*transaction.TrxOutput{{Amount: share.Int64ToBytes(1000), LockingScript: mainScript}},
+		)
+		assert.NoError(t, err)
+
+		keys := []*ecdsa.PrivateKey{watcher.keyring.KeyFor(mainScript)}
+		assert.EqualError(t, watcher.signInputs(trx, keys, script.PayToPubKeyHashUnlock), ErrWatchOnly)
+
+		restarted := newTestRegistry(t, dataDir)
+		assert.NoError(t, restarted.LoadOnStartup())
+
+		reloaded, err := restarted.Get("accounting")
+		assert.NoError(t, err)
+		assert.True(t, reloaded.IsWatchOnly())
+		assert.True(t, reloaded.keyring.Owns(mainScript))
+
+		_, err = restarted.CreateWatchOnly("accounting", "", []string{address})
+		assert.EqualError(t, err, ErrWalletNameTaken)
+	})
+}